@@ -1,12 +1,15 @@
 package cloud
 
 import (
+	"bufio"
 	"io"
 	"net/http"
 	"os"
 
 	"github.com/jackytck/alti-cli/errors"
 	"github.com/jackytck/alti-cli/file"
+	"github.com/jackytck/alti-cli/limit"
+	"github.com/jackytck/alti-cli/progress"
 )
 
 // PutS3 is a helper func to put to s3.
@@ -17,29 +20,37 @@ func PutS3(localPath, url string) error {
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return errors.ErrS3Error
+		return errors.NetworkError{Code: res.StatusCode, Message: errors.ErrS3Error.Error()}
 	}
 	return nil
 }
 
-// PutFile puts the local file specified in filepath to the remote url
-// via http PUT.
+// PutFile puts the local file or archive member specified in filepath to the
+// remote url via http PUT. It waits for the upload window and is rate limited.
 func PutFile(filepath string, url string) (*http.Response, error) {
-	f, err := os.Open(filepath)
+	limit.WaitWindow()
+	stats, err := file.StatPath(filepath)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	stats, err := f.Stat()
+	f, err := file.OpenPath(filepath)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("PUT", url, f)
-	if err != nil {
+	defer f.Close()
+
+	// sniff from the opened reader, as a member of tar.gz could not be opened
+	// twice at the same time
+	br := bufio.NewReader(f)
+	head, err := br.Peek(512)
+	if err != nil && (err != io.EOF || len(head) == 0) {
 		return nil, err
 	}
-	t, err := file.GuessFileType(filepath)
+	t := file.SniffImageType(head)
+	tr := progress.Begin(filepath, stats.Size())
+	req, err := http.NewRequest("PUT", url, limit.Reader(tr.Reader(br)))
 	if err != nil {
+		tr.End(err)
 		return nil, err
 	}
 	req.Header.Set("Content-Type", t)
@@ -48,8 +59,14 @@ func PutFile(filepath string, url string) (*http.Response, error) {
 	client := &http.Client{}
 	res, err := client.Do(req)
 	if err != nil {
+		tr.End(err)
 		return res, err
 	}
+	if res.StatusCode != http.StatusOK {
+		tr.End(errors.NetworkError{Code: res.StatusCode, Message: "bad status"})
+	} else {
+		tr.End(nil)
+	}
 
 	return res, nil
 }
@@ -76,7 +93,9 @@ func GetFile(filepath string, url string) error {
 	}
 
 	// Writer the body to file
-	_, err = io.Copy(out, resp.Body)
+	tr := progress.Begin(filepath, resp.ContentLength)
+	_, err = io.Copy(out, tr.Reader(resp.Body))
+	tr.End(err)
 	if err != nil {
 		return err
 	}
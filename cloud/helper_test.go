@@ -0,0 +1,90 @@
+package cloud
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackytck/alti-cli/file"
+)
+
+func TestPutFile(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-put-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(d, "a.png")
+	if err := ioutil.WriteFile(plain, img.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var z bytes.Buffer
+	zw := zip.NewWriter(&z)
+	w, _ := zw.Create("flight/a.png")
+	w.Write(img.Bytes())
+	zw.Close()
+	zp := filepath.Join(d, "images.zip")
+	if err := ioutil.WriteFile(zp, z.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tgz bytes.Buffer
+	gw := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "flight/a.png", Mode: 0644, Size: int64(img.Len()), Typeflag: tar.TypeReg})
+	tw.Write(img.Bytes())
+	tw.Close()
+	gw.Close()
+	tp := filepath.Join(d, "images.tar.gz")
+	if err := ioutil.WriteFile(tp, tgz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotType string
+	var gotBody []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotType = r.Header.Get("Content-Type")
+		gotBody, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	for _, p := range []string{plain, file.ArchivePath(zp, "flight/a.png"), file.ArchivePath(tp, "flight/a.png")} {
+		gotType, gotBody = "", nil
+		done := make(chan error, 1)
+		go func() {
+			res, err := PutFile(p, ts.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("PutFile(%q) error = %v", p, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("PutFile(%q) does not return", p)
+		}
+		if gotType != "image/png" || !bytes.Equal(gotBody, img.Bytes()) {
+			t.Errorf("PutFile(%q) put %q of %d bytes, want image/png of %d bytes", p, gotType, len(gotBody), img.Len())
+		}
+	}
+	file.CloseArchives()
+}
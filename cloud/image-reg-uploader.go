@@ -5,12 +5,14 @@ import (
 	"log"
 	"net/http"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/jackytck/alti-cli/db"
 	"github.com/jackytck/alti-cli/errors"
 	"github.com/jackytck/alti-cli/gql"
+	"github.com/jackytck/alti-cli/limit"
 	"github.com/jackytck/alti-cli/service"
 	"github.com/jackytck/alti-cli/types"
 )
@@ -24,7 +26,13 @@ type ImageRegUploader struct {
 	Done    <-chan struct{}
 	Result  chan<- db.Image
 	Verbose bool
-	ossUp   *OSSUploader
+	// OnRegistered is called after an image is registered but before it is
+	// uploaded, e.g. for persisting the progress of a resumable session.
+	OnRegistered func(db.Image)
+	// Retry is the policy of retrying failed mutations and uploads.
+	// The default policy is used if it is nil.
+	Retry *RetryPolicy
+	ossUp *OSSUploader
 }
 
 // WithOSSUploader setups an OSS uploader for current pid and bucket.
@@ -39,8 +47,10 @@ func (iru *ImageRegUploader) WithOSSUploader(pid string) error {
 
 // Digest registers and uploads each image from Images and send back the
 // result to Result until either Images or Done is closed.
+// Each worker pauses outside the upload window.
 func (iru *ImageRegUploader) Digest() {
 	for img := range iru.Images {
+		limit.WaitWindow()
 		select {
 		case iru.Result <- iru.regUpload(img):
 		case <-iru.Done:
@@ -76,66 +86,115 @@ func (iru *ImageRegUploader) Run(n int) int {
 
 func (iru *ImageRegUploader) regUpload(img db.Image) db.Image {
 	var ret db.Image
+	// already uploaded in a previous session
+	if img.Stage == db.StageUploaded {
+		return img
+	}
 	switch iru.Method {
 	case service.DirectUploadMethod:
 		return iru.directUpload(img)
 	case service.S3UploadMethod:
 		fallthrough
 	case service.MinioUploadMethod:
-		return iru.smUpload(iru.Method, img, 5)
+		return iru.smUpload(iru.Method, img)
 	case service.OSSUploadMethod:
 		return iru.ossUpload(img)
 	}
 	return ret
 }
 
+// retry retries fn by the retry policy and logs each retry if verbose.
+// The number of retries is added to img.Retries.
+func (iru *ImageRegUploader) retry(img *db.Image, action string, fn func() error) error {
+	n, err := iru.Retry.Do(fn, func(n int, err error, wait time.Duration) {
+		if iru.Verbose {
+			log.Printf("Retrying (x %d) %s for %q in %s: %v\n", n, action, img.Filename, wait.Round(time.Millisecond), err)
+		}
+	})
+	img.Retries += n
+	return err
+}
+
+// registered notifies OnRegistered, if any.
+func (iru *ImageRegUploader) registered(img db.Image) {
+	if iru.OnRegistered != nil {
+		iru.OnRegistered(img)
+	}
+}
+
+// directUpload registers the url of the image served by the local server, or
+// the url itself if it is absolute, e.g. imported from a url list.
 func (iru *ImageRegUploader) directUpload(img db.Image) db.Image {
-	u := fmt.Sprintf("%s/%s", iru.BaseURL, img.URL)
+	u := img.URL
+	if !isAbsURL(u) {
+		u = fmt.Sprintf("%s/%s", iru.BaseURL, img.URL)
+	}
+	start := time.Now()
 	gqlImg, err := gql.RegisterImageURL(img.PID, u, img.Filename, img.Hash)
+	img.RegisterTime += time.Since(start)
 	if err != nil {
 		img.Error = err.Error()
 		return img
 	}
 	img.IID = gqlImg.ID
 	img.State = gqlImg.State
+	// api server pulls the image by itself
+	img.Stage = db.StageUploaded
 	return img
 }
 
+// uploadPath returns the downscaled copy of the image if any, or its local
+// path.
+func uploadPath(img db.Image) string {
+	if img.UploadPath != "" {
+		return img.UploadPath
+	}
+	return img.LocalPath
+}
+
+// isAbsURL tells if u is an absolute http or https url.
+func isAbsURL(u string) bool {
+	return strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")
+}
+
 // smUpload uploads to either s3 or minio.
 // kind is "s3" or "minio"
-func (iru *ImageRegUploader) smUpload(kind string, img db.Image, retry int) db.Image {
+// If the image was registered in a previous session, its upload url is reused.
+func (iru *ImageRegUploader) smUpload(kind string, img db.Image) db.Image {
 	// a. register image
 	var gqlImg *types.Image
-	var url string
+	url := img.UploadURL
 	var err error
 
-	switch kind {
-	case service.S3UploadMethod:
-		gqlImg, url, err = gql.RegisterImageS3(img.PID, iru.Bucket, img.Filename, img.Filetype, img.Hash)
-	case service.MinioUploadMethod:
-		gqlImg, url, err = gql.RegisterImageMinio(img.PID, iru.Bucket, img.Filename, img.Filetype, img.Hash)
-	}
-	if err != nil {
-		img.Error = err.Error()
-		return img
+	start := time.Now()
+	if img.IID == "" || url == "" {
+		switch kind {
+		case service.S3UploadMethod:
+			gqlImg, url, err = gql.RegisterImageS3(img.PID, iru.Bucket, img.Filename, img.Filetype, img.Hash)
+		case service.MinioUploadMethod:
+			gqlImg, url, err = gql.RegisterImageMinio(img.PID, iru.Bucket, img.Filename, img.Filetype, img.Hash)
+		}
+		if err != nil {
+			img.RegisterTime += time.Since(start)
+			img.Error = err.Error()
+			return img
+		}
+		img.IID = gqlImg.ID
+		img.State = gqlImg.State
+		img.UploadURL = url
+		img.Stage = db.StageRegistered
+		iru.registered(img)
 	}
-	img.IID = gqlImg.ID
-	img.State = gqlImg.State
 
 	// b. signal the start of upload
-	trial := retry
-	for i := 0; i < trial; i++ {
+	err = iru.retry(&img, "mutating state", func() error {
 		state, e := gql.StartImageUpload(img.IID)
-		err = e
 		if e == nil {
 			img.State = state
-			break
-		}
-		if iru.Verbose {
-			log.Printf("Retrying (x %d) mutating state for %q\n", i+1, img.Filename)
 		}
-		time.Sleep(time.Second)
-	}
+		return e
+	})
+	img.RegisterTime += time.Since(start)
 	if err != nil {
 		img.Error = err.Error()
 		return img
@@ -147,7 +206,7 @@ func (iru *ImageRegUploader) smUpload(kind string, img db.Image, retry int) db.I
 		if iru.Verbose {
 			log.Printf("Uploading %q\n", img.Filename)
 		}
-		res, err2 := PutFile(img.LocalPath, url)
+		res, err2 := PutFile(uploadPath(img), url)
 		if err2 != nil {
 			return err2
 		}
@@ -155,27 +214,25 @@ func (iru *ImageRegUploader) smUpload(kind string, img db.Image, retry int) db.I
 		if res.StatusCode != http.StatusOK {
 			switch kind {
 			case service.S3UploadMethod:
-				return errors.ErrS3Error
+				return errors.NetworkError{Code: res.StatusCode, Message: errors.ErrS3Error.Error()}
 			case service.MinioUploadMethod:
-				return errors.ErrMinioError
+				return errors.NetworkError{Code: res.StatusCode, Message: errors.ErrMinioError.Error()}
 			}
 		}
 		return nil
 	}
 
-	for i := 0; i < trial; i++ {
-		err = upload()
-		if err == nil {
-			break
-		}
-		if iru.Verbose {
-			log.Printf("Retrying (x %d) upload to %s for %q\n", i+1, kind, img.Filename)
-		}
-		time.Sleep(time.Second)
-	}
+	start = time.Now()
+	err = iru.retry(&img, "upload to "+kind, upload)
+	img.UploadTime += time.Since(start)
 	if err != nil {
 		img.Error = err.Error()
+		// the pre-signed url may have expired, register again next time
+		img.UploadURL = ""
+		return img
 	}
+	img.Stage = db.StageUploaded
+	img.Uploaded = time.Now()
 
 	return img
 }
@@ -187,49 +244,49 @@ func (iru *ImageRegUploader) ossUpload(img db.Image) db.Image {
 	}
 
 	// a. register oss image
-	gqlImg, err := gql.RegisterImageOSS(img.PID, iru.Bucket, img.Filename, img.Filetype, img.Hash)
-	if err != nil {
-		img.Error = err.Error()
-		return img
+	var err error
+	start := time.Now()
+	if img.IID == "" || img.CloudPath == "" {
+		gqlImg, e := gql.RegisterImageOSS(img.PID, iru.Bucket, img.Filename, img.Filetype, img.Hash)
+		if e != nil {
+			img.RegisterTime += time.Since(start)
+			img.Error = e.Error()
+			return img
+		}
+		img.IID = gqlImg.ID
+		img.State = gqlImg.State
+		img.CloudPath = gqlImg.Filename
+		img.Stage = db.StageRegistered
+		iru.registered(img)
 	}
-	img.IID = gqlImg.ID
-	img.State = gqlImg.State
 
 	// b. signal the start of upload
-	trial := 5
-	for i := 0; i < trial; i++ {
+	err = iru.retry(&img, "mutating state", func() error {
 		state, e := gql.StartImageUpload(img.IID)
-		err = e
 		if e == nil {
 			img.State = state
-			break
-		}
-		if iru.Verbose {
-			log.Printf("Retrying (x %d) mutating state for %q\n", i+1, img.Filename)
 		}
-		time.Sleep(time.Second)
-	}
+		return e
+	})
+	img.RegisterTime += time.Since(start)
 	if err != nil {
 		img.Error = err.Error()
 		return img
 	}
 
 	// c. upload to oss with retry
-	for i := 0; i < trial; i++ {
+	start = time.Now()
+	err = iru.retry(&img, "upload to OSS", func() error {
 		if iru.Verbose {
 			log.Printf("Uploading %q\n", img.Filename)
 		}
-		err = iru.ossUp.PutFile(img.LocalPath, gqlImg.Filename)
-		if err == nil {
-			break
-		}
-		if iru.Verbose {
-			log.Printf("Retrying (x %d) upload to OSS for %q\n", i+1, img.Filename)
-		}
-		time.Sleep(time.Second)
-	}
+		return iru.ossUp.PutFile(uploadPath(img), img.CloudPath)
+	})
+	img.UploadTime += time.Since(start)
 	if err != nil {
 		img.Error = err.Error()
+	} else {
+		img.Uploaded = time.Now()
 	}
 
 	// d. signal the end of upload
@@ -239,6 +296,9 @@ func (iru *ImageRegUploader) ossUpload(img db.Image) db.Image {
 		return img
 	}
 	img.State = state
+	if img.Error == "" {
+		img.Stage = db.StageUploaded
+	}
 
 	return img
 }
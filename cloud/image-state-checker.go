@@ -8,15 +8,19 @@ import (
 
 	"github.com/jackytck/alti-cli/db"
 	"github.com/jackytck/alti-cli/errors"
-	"github.com/jackytck/alti-cli/gql"
 )
 
 // ImageStateChecker check the image states of all images within timeout.
+// States are polled in batch by a project-level ImageStatePoller.
 type ImageStateChecker struct {
 	Images  <-chan db.Image
 	Done    <-chan struct{}
 	Result  chan<- db.Image
 	Timeout time.Duration
+	// Poller polls the image states of the project. If it is nil, one is
+	// created with the default intervals for the pid of the images.
+	Poller     *ImageStatePoller
+	pollerLock sync.Mutex
 }
 
 // Digest checks state of each image from Images and send back the
@@ -56,48 +60,46 @@ func (isc *ImageStateChecker) Run(n int) int {
 	return n
 }
 
-// checkState checks the db image state via api, until state is changed to
-// 'Ready' or 'Invalid', or timeout in this client.
+// poller returns the poller of the project of pid.
+func (isc *ImageStateChecker) poller(pid string) *ImageStatePoller {
+	isc.pollerLock.Lock()
+	defer isc.pollerLock.Unlock()
+	if isc.Poller == nil {
+		isc.Poller = NewImageStatePoller(pid)
+	}
+	return isc.Poller
+}
+
+// checkState waits for the db image state to be changed to 'Ready' or
+// 'Invalid', or timeout in this client.
 func (isc *ImageStateChecker) checkState(img db.Image) db.Image {
 	// may already have error from ImageRegUploader
 	if img.Error != "" {
 		return img
 	}
-	imgCh := make(chan db.Image)
-
-	go func() {
-		defer close(imgCh)
-		i := img
-		for {
-			qImg, err := gql.ProjectImage(img.PID, img.IID)
-			if err != nil {
-				i.Error = err.Error()
-				imgCh <- i
-				return
-			}
-			i.State = qImg.State
-			if qImg.State == "Ready" {
-				imgCh <- i
-				return
-			}
-			if qImg.State == "Invalid" {
-				i.Error = strings.Join(qImg.Error, ";")
-				if i.Error == "" {
-					i.Error = errors.ErrImgInvalid.Error()
-				}
-				imgCh <- i
-				return
-			}
-			time.Sleep(time.Second)
-		}
-	}()
+	p := isc.poller(img.PID)
+	start := time.Now()
+	resC := p.Wait(img.IID)
 
 	ret := img
 	select {
 	case <-time.After(isc.Timeout):
+		p.Cancel(img.IID, resC)
 		ret.Error = errors.ErrClientTimeout.Error()
-	case ret = <-imgCh:
+	case res := <-resC:
+		if res.Err != nil {
+			ret.Error = res.Err.Error()
+			break
+		}
+		ret.State = res.Image.State
+		if ret.State == "Invalid" {
+			ret.Error = strings.Join(res.Image.Error, ";")
+			if ret.Error == "" {
+				ret.Error = errors.ErrImgInvalid.Error()
+			}
+		}
 	}
+	ret.CheckTime += time.Since(start)
 
 	return ret
 }
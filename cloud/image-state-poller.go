@@ -0,0 +1,167 @@
+package cloud
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jackytck/alti-cli/gql"
+	"github.com/jackytck/alti-cli/types"
+)
+
+// Default intervals of polling the image states.
+const (
+	DefaultMinPollInterval = time.Second
+	DefaultMaxPollInterval = time.Second * 15
+)
+
+// maxPollErrors is the number of consecutive failed polls before giving up
+// all of the waiting images.
+const maxPollErrors = 3
+
+// ImageStatePoller polls the states of all images of a project page by page,
+// and fans out the final states to the images waiting for them. The interval
+// doubles from MinInterval up to MaxInterval while nothing has changed, and
+// is reset once any image is done. It polls only while someone is waiting.
+type ImageStatePoller struct {
+	PID         string
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	// Fetch fetches the images of a project keyed by id.
+	// gql.ImageStates is used if it is nil.
+	Fetch func(pid string) (map[string]types.ProjectImage, error)
+
+	mu      sync.Mutex
+	waiters map[string][]chan PollResult
+	running bool
+}
+
+// PollResult is the final state of an image, or the error of polling it.
+type PollResult struct {
+	Image types.ProjectImage
+	Err   error
+}
+
+// NewImageStatePoller returns a new poller of the project with the default intervals.
+func NewImageStatePoller(pid string) *ImageStatePoller {
+	return &ImageStatePoller{
+		PID:         pid,
+		MinInterval: DefaultMinPollInterval,
+		MaxInterval: DefaultMaxPollInterval,
+	}
+}
+
+// Wait waits for the image of iid to become 'Ready' or 'Invalid'.
+// The returned channel receives exactly one result, unless the wait is
+// cancelled by Cancel.
+func (p *ImageStatePoller) Wait(iid string) <-chan PollResult {
+	ch := make(chan PollResult, 1)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.waiters == nil {
+		p.waiters = make(map[string][]chan PollResult)
+	}
+	p.waiters[iid] = append(p.waiters[iid], ch)
+	if !p.running {
+		p.running = true
+		go p.loop()
+	}
+	return ch
+}
+
+// Cancel stops waiting for the image of iid on ch, e.g. after timeout.
+func (p *ImageStatePoller) Cancel(iid string, ch <-chan PollResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ws := p.waiters[iid]
+	for i, w := range ws {
+		if (<-chan PollResult)(w) == ch {
+			ws = append(ws[:i], ws[i+1:]...)
+			break
+		}
+	}
+	if len(ws) == 0 {
+		delete(p.waiters, iid)
+	} else {
+		p.waiters[iid] = ws
+	}
+}
+
+// loop polls until there is no more waiter.
+func (p *ImageStatePoller) loop() {
+	interval := p.MinInterval
+	errCnt := 0
+	for {
+		changed, err := p.poll()
+		switch {
+		case err != nil:
+			errCnt++
+			if errCnt >= maxPollErrors {
+				p.fail(err)
+				errCnt = 0
+			}
+			interval *= 2
+		case changed:
+			errCnt = 0
+			interval = p.MinInterval
+		default:
+			errCnt = 0
+			interval *= 2
+		}
+		if interval > p.MaxInterval {
+			interval = p.MaxInterval
+		}
+		if interval <= 0 {
+			interval = DefaultMinPollInterval
+		}
+
+		p.mu.Lock()
+		if len(p.waiters) == 0 {
+			p.running = false
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+		time.Sleep(interval)
+	}
+}
+
+// poll fetches the states of all images once and notifies the waiters of
+// the images that are done. Return if any image is done.
+func (p *ImageStatePoller) poll() (bool, error) {
+	fetch := p.Fetch
+	if fetch == nil {
+		fetch = gql.ImageStates
+	}
+	imgs, err := fetch(p.PID)
+	if err != nil {
+		return false, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	changed := false
+	for iid, ws := range p.waiters {
+		img, ok := imgs[iid]
+		if !ok || (img.State != "Ready" && img.State != "Invalid") {
+			continue
+		}
+		for _, w := range ws {
+			w <- PollResult{Image: img}
+		}
+		delete(p.waiters, iid)
+		changed = true
+	}
+	return changed, nil
+}
+
+// fail notifies all of the waiters with err.
+func (p *ImageStatePoller) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for iid, ws := range p.waiters {
+		for _, w := range ws {
+			w <- PollResult{Err: err}
+		}
+		delete(p.waiters, iid)
+	}
+}
@@ -0,0 +1,77 @@
+package cloud
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackytck/alti-cli/db"
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/types"
+)
+
+func TestImageStateChecker(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	fetch := func(pid string) (map[string]types.ProjectImage, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		ret := map[string]types.ProjectImage{
+			"a": {ID: "a", State: "Pending"},
+			"b": {ID: "b", State: "Invalid", Error: []string{"too small"}},
+			"c": {ID: "c", State: "Pending"},
+		}
+		if calls >= 2 {
+			ret["a"] = types.ProjectImage{ID: "a", State: "Ready"}
+		}
+		return ret, nil
+	}
+	poller := &ImageStatePoller{
+		PID:         "p",
+		MinInterval: time.Millisecond * 10,
+		MaxInterval: time.Millisecond * 20,
+		Fetch:       fetch,
+	}
+
+	imgs := make(chan db.Image)
+	res := make(chan db.Image)
+	isc := ImageStateChecker{
+		Images:  imgs,
+		Result:  res,
+		Timeout: time.Millisecond * 200,
+		Poller:  poller,
+	}
+	isc.Run(4)
+	go func() {
+		for _, iid := range []string{"a", "b", "c"} {
+			imgs <- db.Image{PID: "p", IID: iid}
+		}
+		imgs <- db.Image{PID: "p", IID: "d", Error: "registration failed"}
+		close(imgs)
+	}()
+
+	got := make(map[string]db.Image)
+	for img := range res {
+		got[img.IID] = img
+	}
+	if img := got["a"]; img.State != "Ready" || img.Error != "" {
+		t.Errorf("a = %+v, want Ready", img)
+	}
+	if img := got["b"]; img.State != "Invalid" || img.Error != "too small" {
+		t.Errorf("b = %+v, want Invalid", img)
+	}
+	if img := got["c"]; img.Error != errors.ErrClientTimeout.Error() {
+		t.Errorf("c = %+v, want timeout", img)
+	}
+	if img := got["d"]; img.Error != "registration failed" {
+		t.Errorf("d = %+v, want its own error", img)
+	}
+
+	// far fewer calls than polling each image per interval
+	mu.Lock()
+	defer mu.Unlock()
+	if calls > 20 {
+		t.Errorf("fetched %d times", calls)
+	}
+}
@@ -21,6 +21,7 @@ type MetaFileRegUploader struct {
 	Bucket    string
 	Timeout   int
 	Verbose   bool
+	Retry     *RetryPolicy // default policy is used if nil
 	checksum  string
 }
 
@@ -96,17 +97,9 @@ func (mru *MetaFileRegUploader) s3Upload() (string, error) {
 	mru.MID = meta.ID
 
 	// b. upload to s3 with retry
-	trial := 5
-	for i := 0; i < trial; i++ {
-		err = PutS3(mru.MetaPath, url)
-		if err == nil {
-			break
-		}
-		if mru.Verbose {
-			log.Printf("Retrying (x %d) upload to S3 for %q\n", i+1, mru.Filename)
-		}
-		time.Sleep(time.Second)
-	}
+	err = mru.retry("S3", func() error {
+		return PutS3(mru.MetaPath, url)
+	})
 	if err != nil {
 		return "", err
 	}
@@ -133,17 +126,9 @@ func (mru *MetaFileRegUploader) minioUpload() (string, error) {
 	mru.MID = meta.ID
 
 	// b. upload to minio with retry
-	trial := 5
-	for i := 0; i < trial; i++ {
-		err = PutS3(mru.MetaPath, url)
-		if err == nil {
-			break
-		}
-		if mru.Verbose {
-			log.Printf("Retrying (x %d) upload to Minio for %q\n", i+1, mru.Filename)
-		}
-		time.Sleep(time.Second)
-	}
+	err = mru.retry("Minio", func() error {
+		return PutS3(mru.MetaPath, url)
+	})
 	if err != nil {
 		return "", err
 	}
@@ -151,6 +136,16 @@ func (mru *MetaFileRegUploader) minioUpload() (string, error) {
 	return mru.checkState()
 }
 
+// retry retries uploading the meta file by the retry policy.
+func (mru *MetaFileRegUploader) retry(kind string, upload func() error) error {
+	_, err := mru.Retry.Do(upload, func(n int, err error, wait time.Duration) {
+		if mru.Verbose {
+			log.Printf("Retrying (x %d) upload to %s for %q in %s: %v\n", n, kind, mru.Filename, wait.Round(time.Millisecond), err)
+		}
+	})
+	return err
+}
+
 // checkState checks if the model state is changed from Pending until timeout.
 func (mru *MetaFileRegUploader) checkState() (string, error) {
 	timeout, err := mru.getTimeout()
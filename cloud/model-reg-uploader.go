@@ -1,6 +1,7 @@
 package cloud
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
@@ -25,12 +26,17 @@ type ModelRegUploader struct {
 	MultipartDir string // dir storing the 7zip multiparts
 	Timeout      int
 	Verbose      bool
-	tmpDir       string // for storing newly created multipart files
+	Retry        *RetryPolicy // default policy is used if nil
+	Force        bool         // upload even if the pre-flight check fails
+	tmpDir       string       // for storing newly created multipart files
 }
 
 // Run starts the registration and uploading process.
 // Return the state of imported model.
 func (mru *ModelRegUploader) Run() (string, error) {
+	if err := mru.preflight(); err != nil {
+		return "", err
+	}
 	switch mru.Method {
 	case service.DirectUploadMethod:
 		return mru.directUpload()
@@ -54,6 +60,36 @@ func (mru *ModelRegUploader) Done() {
 	}
 }
 
+// preflight checks the model zip before uploading, for the issues that would
+// only be rejected by the server after uploading. Multiparts are not checked.
+func (mru *ModelRegUploader) preflight() error {
+	if mru.MultipartDir != "" {
+		return nil
+	}
+	if isZip, err := file.IsZipFile(mru.ModelPath); err != nil || !isZip {
+		return nil
+	}
+	r, err := file.CheckModelZip(mru.ModelPath)
+	if err != nil {
+		return err
+	}
+	if mru.Verbose {
+		log.Printf("Checked %q: %s\n", mru.Filename, r.Summary())
+	}
+	for _, i := range r.Issues {
+		if i.Level == file.IssueError || mru.Verbose {
+			log.Println(i)
+		}
+	}
+	if n := r.Count(file.IssueError); n > 0 {
+		if !mru.Force {
+			return fmt.Errorf("%v: %d errors, see 'alti-cli check model -f %s'", errors.ErrModelInvalid, n, mru.ModelPath)
+		}
+		log.Printf("Uploading the model with %d errors as it is forced\n", n)
+	}
+	return nil
+}
+
 // directUpload registers the model via direct upload method and query its state
 // change until timeout. Return the state of project.
 func (mru *ModelRegUploader) directUpload() (string, error) {
@@ -155,23 +191,15 @@ func (mru *ModelRegUploader) uploadParts(method string, baseDir string, parts []
 		}
 
 		// b. upload to s3 with retry
-		trial := 5
-		for i := 0; i < trial; i++ {
-			err = PutS3(localPath, url)
-			if err == nil {
-				if removePart {
-					os.Remove(localPath)
-				}
-				break
-			}
-			if mru.Verbose {
-				log.Printf("Retrying (x %d) upload to %s for %q\n", i+1, strings.Title(method), p)
-			}
-			time.Sleep(time.Second)
-		}
+		err = mru.retry(method, p, func() error {
+			return PutS3(localPath, url)
+		})
 		if err != nil {
 			return err
 		}
+		if removePart {
+			os.Remove(localPath)
+		}
 	}
 	return nil
 }
@@ -205,17 +233,9 @@ func (mru *ModelRegUploader) smUploadSingle(method string) (string, error) {
 	}
 
 	// b. upload to s3 with retry
-	trial := 5
-	for i := 0; i < trial; i++ {
-		err = PutS3(mru.ModelPath, url)
-		if err == nil {
-			break
-		}
-		if mru.Verbose {
-			log.Printf("Retrying (x %d) upload to %s for %q\n", i+1, strings.Title(method), mru.Filename)
-		}
-		time.Sleep(time.Second)
-	}
+	err = mru.retry(method, mru.Filename, func() error {
+		return PutS3(mru.ModelPath, url)
+	})
 	if err != nil {
 		return "", err
 	}
@@ -228,6 +248,16 @@ func (mru *ModelRegUploader) smUploadSingle(method string) (string, error) {
 	return mru.checkState()
 }
 
+// retry retries uploading a file by the retry policy.
+func (mru *ModelRegUploader) retry(method, filename string, upload func() error) error {
+	_, err := mru.Retry.Do(upload, func(n int, err error, wait time.Duration) {
+		if mru.Verbose {
+			log.Printf("Retrying (x %d) upload to %s for %q in %s: %v\n", n, strings.Title(method), filename, wait.Round(time.Millisecond), err)
+		}
+	})
+	return err
+}
+
 // checkState checks if the model state is changed from Pending until timeout.
 func (mru *ModelRegUploader) checkState() (string, error) {
 	timeout, err := mru.getTimeout()
@@ -2,11 +2,16 @@ package cloud
 
 import (
 	"fmt"
+	"mime"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
+	"github.com/jackytck/alti-cli/limit"
+	"github.com/jackytck/alti-cli/progress"
 	"github.com/jackytck/alti-cli/types"
 )
 
@@ -54,8 +59,11 @@ func (ou *OSSUploader) Refresh() error {
 	return ou.reconnect()
 }
 
-// PutFile puts a file under the project's write-only space in OSS.
-func (ou *OSSUploader) PutFile(filepath, cloudPath string) error {
+// PutFile puts a file or an archive member under the project's write-only
+// space in OSS.
+// It waits for the upload window and is rate limited.
+func (ou *OSSUploader) PutFile(localPath, cloudPath string) error {
+	limit.WaitWindow()
 	err := ou.Refresh()
 	if err != nil {
 		return err
@@ -68,7 +76,24 @@ func (ou *OSSUploader) PutFile(filepath, cloudPath string) error {
 		return errors.ErrNOSTS
 	}
 	key := fmt.Sprintf("%s/%s", ou.PID, cloudPath)
-	return ou.getBucket().PutObjectFromFile(key, filepath)
+
+	stats, err := file.StatPath(localPath)
+	if err != nil {
+		return err
+	}
+	f, err := file.OpenPath(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	opts := []oss.Option{oss.ContentLength(stats.Size())}
+	if t := mime.TypeByExtension(filepath.Ext(localPath)); t != "" {
+		opts = append(opts, oss.ContentType(t))
+	}
+	tr := progress.Begin(localPath, stats.Size())
+	err = ou.getBucket().PutObject(key, limit.Reader(tr.Reader(f)), opts...)
+	tr.End(err)
+	return err
 }
 
 // getCreds gets the sts creds.
@@ -0,0 +1,143 @@
+package cloud
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/jackytck/alti-cli/errors"
+)
+
+// Default values of the retry policy.
+const (
+	DefaultMaxRetries = 5
+	DefaultBaseDelay  = time.Second
+	DefaultMaxDelay   = time.Second * 30
+	DefaultMaxElapsed = time.Minute * 5
+)
+
+// ossRetryCodes are the OSS error codes that are worth retrying, mostly
+// because of an expired STS token, which is refreshed before each put.
+var ossRetryCodes = map[string]bool{
+	"SecurityTokenExpired": true,
+	"InvalidAccessKeyId":   true,
+	"RequestTimeout":       true,
+}
+
+// RetryPolicy decides if and when a failed operation should be retried.
+// The delay grows exponentially from BaseDelay up to MaxDelay, with jitter.
+type RetryPolicy struct {
+	MaxRetries int           // max number of retries after the first attempt
+	BaseDelay  time.Duration // delay before the first retry
+	MaxDelay   time.Duration // cap of each delay
+	MaxElapsed time.Duration // give up once exceeded, zero means no limit
+	// Retryable classifies if an error is worth retrying.
+	// IsRetryable is used if it is nil.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy returns the default retry policy.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries: DefaultMaxRetries,
+		BaseDelay:  DefaultBaseDelay,
+		MaxDelay:   DefaultMaxDelay,
+		MaxElapsed: DefaultMaxElapsed,
+	}
+}
+
+// orDefault returns rp itself, or the default policy if rp is nil.
+func (rp *RetryPolicy) orDefault() *RetryPolicy {
+	if rp == nil {
+		return DefaultRetryPolicy()
+	}
+	return rp
+}
+
+// Backoff returns the delay before the n-th retry, starting from 1.
+// It is chosen randomly between half and the full exponential delay.
+func (rp *RetryPolicy) Backoff(n int) time.Duration {
+	d := rp.BaseDelay
+	for i := 1; i < n && (rp.MaxDelay <= 0 || d < rp.MaxDelay); i++ {
+		d *= 2
+	}
+	if rp.MaxDelay > 0 && d > rp.MaxDelay {
+		d = rp.MaxDelay
+	}
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)))
+}
+
+// Do calls fn until it succeeds, fails permanently, or the policy gives up.
+// onRetry, if not nil, is called before each retry with the retry count,
+// the last error and the delay. Return the number of retries with the last
+// error.
+func (rp *RetryPolicy) Do(fn func() error, onRetry func(n int, err error, wait time.Duration)) (int, error) {
+	rp = rp.orDefault()
+	retryable := rp.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	start := time.Now()
+	for n := 0; ; n++ {
+		err := fn()
+		if err == nil || n >= rp.MaxRetries || !retryable(err) {
+			return n, err
+		}
+		wait := rp.Backoff(n + 1)
+		if rp.MaxElapsed > 0 && time.Since(start)+wait > rp.MaxElapsed {
+			return n, err
+		}
+		if onRetry != nil {
+			onRetry(n+1, err, wait)
+		}
+		time.Sleep(wait)
+	}
+}
+
+// IsRetryable classifies if err is transient, e.g. 5xx, 429, timeouts and
+// expired STS, or permanent, e.g. other 4xx, invalid image and missing file.
+// Unknown errors are regarded as transient.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == io.ErrUnexpectedEOF || err == errors.ErrOffline || err == errors.ErrNOSTS {
+		return true
+	}
+	switch e := err.(type) {
+	case errors.NetworkError:
+		return isRetryableStatus(e.Code)
+	case oss.ServiceError:
+		return ossRetryCodes[e.Code] || isRetryableStatus(e.StatusCode)
+	case errors.UploadError:
+		return e != errors.ErrImgInvalid && e != errors.ErrUploadMethodInvalid &&
+			e != errors.ErrBucketInvalid && e != errors.ErrMetaExisted
+	case errors.FileError:
+		return false
+	case *url.Error:
+		return true
+	case net.Error:
+		return true
+	case *os.PathError:
+		return false
+	}
+	// errors of the gql server, e.g. "graphql: not found"
+	if strings.HasPrefix(err.Error(), "graphql: ") {
+		return false
+	}
+	return true
+}
+
+// isRetryableStatus tells if a http status code is worth retrying.
+func isRetryableStatus(code int) bool {
+	return code == 429 || code >= 500
+}
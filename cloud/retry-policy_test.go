@@ -0,0 +1,84 @@
+package cloud
+
+import (
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/jackytck/alti-cli/errors"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"5xx", errors.NetworkError{Code: 503, Message: "s3"}, true},
+		{"429", errors.NetworkError{Code: 429, Message: "s3"}, true},
+		{"403", errors.NetworkError{Code: 403, Message: "s3"}, false},
+		{"sts expired", oss.ServiceError{Code: "SecurityTokenExpired", StatusCode: 403}, true},
+		{"oss 404", oss.ServiceError{Code: "NoSuchBucket", StatusCode: 404}, false},
+		{"timeout", &url.Error{Op: "Put", URL: "http://a", Err: os.ErrDeadlineExceeded}, true},
+		{"invalid image", errors.ErrImgInvalid, false},
+		{"no file", &os.PathError{Op: "open", Path: "a", Err: os.ErrNotExist}, false},
+		{"gql", errorString("graphql: image not found"), false},
+		{"unknown", errorString("connection reset"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	rp := &RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond * 4}
+
+	// succeed on the third attempt
+	calls := 0
+	n, err := rp.Do(func() error {
+		calls++
+		if calls < 3 {
+			return errors.NetworkError{Code: 500, Message: "s3"}
+		}
+		return nil
+	}, nil)
+	if err != nil || n != 2 {
+		t.Errorf("Do() = %d, %v, want 2, nil", n, err)
+	}
+
+	// give up after max retries
+	n, err = rp.Do(func() error {
+		return errors.ErrOffline
+	}, nil)
+	if err != errors.ErrOffline || n != 3 {
+		t.Errorf("Do() = %d, %v, want 3, %v", n, err, errors.ErrOffline)
+	}
+
+	// no retry for permanent error
+	n, err = rp.Do(func() error {
+		return errors.ErrImgInvalid
+	}, nil)
+	if err != errors.ErrImgInvalid || n != 0 {
+		t.Errorf("Do() = %d, %v, want 0, %v", n, err, errors.ErrImgInvalid)
+	}
+
+	// backoff is capped with jitter
+	for i := 1; i < 10; i++ {
+		if d := rp.Backoff(i); d > rp.MaxDelay || d < rp.BaseDelay/2 {
+			t.Errorf("Backoff(%d) = %v", i, d)
+		}
+	}
+}
+
+type errorString string
+
+func (e errorString) Error() string {
+	return string(e)
+}
@@ -0,0 +1,118 @@
+package cloud
+
+import (
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
+	minio "github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/credentials"
+)
+
+// S3PresignExpiry is the validity of the presigned urls of a S3Source, which
+// is the max allowed by signature v4, so that a session could be resumed.
+const S3PresignExpiry = time.Hour * 24 * 7
+
+// S3Source lists the objects under a prefix of a S3 compatible bucket, e.g.
+// AWS S3 or MinIO, and presigns GET urls of them for the api server to pull.
+type S3Source struct {
+	Bucket string
+	Prefix string
+	client *minio.Client
+}
+
+// ParseS3URL parses an url of the form s3://bucket/prefix.
+// The prefix could be empty.
+func ParseS3URL(raw string) (bucket, prefix string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return "", "", errors.ErrS3URLInvalid
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// NewS3Source creates a source of the s3 url at endpoint, which is a host with
+// an optional port, or an url whose http scheme means insecure.
+// endpoint defaults to AWS_ENDPOINT_URL or AWS S3, and region to AWS_REGION.
+// The credentials are read from the environment, i.e. AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, or from the shared credentials file by profile.
+// An explicit profile takes precedence over the environment.
+func NewS3Source(raw, endpoint, region, profile string) (*S3Source, error) {
+	bucket, prefix, err := ParseS3URL(raw)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	secure := true
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		secure = u.Scheme != "http"
+		endpoint = u.Host
+	}
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	providers := []credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{Profile: profile},
+	}
+	if profile != "" {
+		providers = []credentials.Provider{&credentials.FileAWSCredentials{Profile: profile}}
+	}
+	client, err := minio.NewWithOptions(endpoint, &minio.Options{
+		Creds:  credentials.NewChainCredentials(providers),
+		Secure: secure,
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Source{Bucket: bucket, Prefix: prefix, client: client}, nil
+}
+
+// URL returns the s3 url of the object of key.
+func (s *S3Source) URL(key string) string {
+	return "s3://" + s.Bucket + "/" + key
+}
+
+// Entries lists the objects recursively, skipping the empty ones and those
+// whose keys match skip, and presigns a GET url for each of them.
+// The name of each entry is the s3 url of its object.
+func (s *S3Source) Entries(skip string) ([]file.URLEntry, error) {
+	r, err := regexp.Compile(skip)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	var ret []file.URLEntry
+	for obj := range s.client.ListObjectsV2(s.Bucket, s.Prefix, true, done) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if obj.Size == 0 || strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		if skip != "" && r.MatchString(obj.Key) {
+			continue
+		}
+		u, err := s.client.PresignedGetObject(s.Bucket, obj.Key, S3PresignExpiry, nil)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, file.URLEntry{URL: u.String(), Name: s.URL(obj.Key)})
+	}
+	return ret, nil
+}
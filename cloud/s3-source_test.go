@@ -0,0 +1,75 @@
+package cloud
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseS3URL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		bucket  string
+		prefix  string
+		wantErr bool
+	}{
+		{"s3://bucket", "bucket", "", false},
+		{"s3://bucket/flight/01/", "bucket", "flight/01/", false},
+		{"http://bucket/flight", "", "", true},
+		{"s3:///flight", "", "", true},
+	}
+	for _, tt := range tests {
+		bucket, prefix, err := ParseS3URL(tt.raw)
+		if (err != nil) != tt.wantErr || bucket != tt.bucket || prefix != tt.prefix {
+			t.Errorf("ParseS3URL(%q) = %q, %q, %v, want %q, %q", tt.raw, bucket, prefix, err, tt.bucket, tt.prefix)
+		}
+	}
+}
+
+// listResult is a ListObjectsV2 response of a folder, an image and a thumbnail.
+const listResult = `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+<Name>bucket</Name><Prefix>flight/</Prefix><KeyCount>3</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>
+<Contents><Key>flight/</Key><LastModified>2020-01-01T00:00:00.000Z</LastModified><Size>0</Size></Contents>
+<Contents><Key>flight/a b.jpg</Key><LastModified>2020-01-01T00:00:00.000Z</LastModified><Size>1024</Size></Contents>
+<Contents><Key>flight/thumbs/b.jpg</Key><LastModified>2020-01-01T00:00:00.000Z</LastModified><Size>64</Size></Contents>
+</ListBucketResult>`
+
+func TestS3SourceEntries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bucket/" || r.URL.Query().Get("list-type") != "2" || r.URL.Query().Get("prefix") != "flight/" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=minio/") {
+			http.Error(w, "unsigned request", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(listResult))
+	}))
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "minio")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "minio123")
+	src, err := NewS3Source("s3://bucket/flight/", srv.URL, "us-east-1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := src.Entries("thumbs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Name != "s3://bucket/flight/a b.jpg" {
+		t.Fatalf("Entries() = %v, want only flight/a b.jpg", got)
+	}
+	u, err := url.Parse(got[0].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != strings.TrimPrefix(srv.URL, "http://") || u.Path != "/bucket/flight/a b.jpg" || u.Query().Get("X-Amz-Signature") == "" {
+		t.Errorf("Entries() url = %q, want presigned get of flight/a b.jpg", got[0].URL)
+	}
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jackytck/alti-cli/db"
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
+	"github.com/jackytck/alti-cli/gql"
+	"github.com/jackytck/alti-cli/manifest"
+	"github.com/jackytck/alti-cli/service"
+	"github.com/jackytck/alti-cli/types"
+	"github.com/spf13/cobra"
+)
+
+var manifestPath string
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Reconcile a project with a manifest",
+	Long: `Reconcile a reconstruction project with a yaml manifest idempotently.
+The project is created if missing, and its pid is recorded in a lock file next
+to the manifest, e.g. site.lock of site.yaml. Only the missing images and meta
+files are uploaded, and the task is started only when everything is ready.
+Run it again to continue after any failure.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		start := time.Now()
+		defer func() {
+			if verbose {
+				elapsed := time.Since(start)
+				log.Println("Took", elapsed)
+			}
+		}()
+
+		// pre-checks
+		m, err := manifest.Load(manifestPath)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		checks := append([]service.CheckFn{service.CheckAPIServer()}, manifestChecks(m)...)
+		if err := service.Check(nil, checks...); err != nil {
+			log.Println(err)
+			return
+		}
+
+		// the imports handle ctrl+c by themselves while running
+		defer onInterrupt(func() {
+			fmt.Println()
+			log.Println("Stopped. Apply again to continue.")
+			os.Exit(1)
+		})()
+
+		// 1. project
+		p, err := lockedProject(m)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if p == nil {
+			// the lock must be writable before creating the project
+			if err := m.WriteLock(""); err != nil {
+				log.Println("Lock file could not be written!", err)
+				return
+			}
+			pid, err := gql.CreateProject(m.Project.Name, m.Project.Type, "", m.Project.Visibility)
+			if err != nil {
+				log.Println("Project could not be created!", err)
+				return
+			}
+			if err := m.WriteLock(pid); err != nil {
+				log.Printf("Created project %q with pid %q, but it could not be recorded in %s: %v\n", m.Project.Name, pid, m.LockPath(), err)
+				log.Printf("Write 'pid: %s' to %s before applying again, or the project is created again\n", pid, m.LockPath())
+				return
+			}
+			log.Printf("Created project %q with pid %q, recorded in %s\n", m.Project.Name, pid, m.LockPath())
+			p = &types.Project{ID: pid, Name: m.Project.Name}
+		} else {
+			log.Printf("Found project %q with pid %q\n", p.Name, p.ID)
+		}
+
+		// 2. images, only the missing ones are uploaded
+		for i, src := range m.Images {
+			log.Printf("Importing images (%d/%d) from %s\n", i+1, len(m.Images), src.Name())
+			useImageSource(p.ID, src, m.Upload)
+			importImageCmd.Run(cmd, args)
+		}
+
+		// 3. meta files, skipped if already uploaded
+		for i, f := range m.Meta {
+			log.Printf("Importing meta file (%d/%d): %q\n", i+1, len(m.Meta), f)
+			id = p.ID
+			meta = f
+			dir = ""
+			method = m.Upload.Method
+			bucket = m.Upload.Bucket
+			importMetaCmd.Run(cmd, args)
+		}
+
+		// 4. task
+		if m.Task == "" {
+			log.Println("No task is defined in the manifest, done.")
+			return
+		}
+		p, err = gql.SearchProjectID(p.ID, true)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if p.TaskState != "" && p.TaskState != service.Pending {
+			log.Printf("The task is already %q, done.\n", p.TaskState)
+			return
+		}
+		ready, reason, err := projectReady(p.ID, m.Meta)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if !ready {
+			log.Printf("The task is not started as %s. Apply again later.\n", reason)
+			return
+		}
+		id = p.ID
+		taskType = m.Task
+		startReconCmd.Run(cmd, args)
+	},
+}
+
+// manifestChecks checks the local files of the manifest.
+func manifestChecks(m *manifest.Manifest) []service.CheckFn {
+	var ret []service.CheckFn
+	for _, src := range m.Images {
+		switch {
+		case src.Dir != "":
+			ret = append(ret, service.CheckDirOrArchive(src.Dir))
+		case src.URLs != "":
+			ret = append(ret, service.CheckFile(src.URLs))
+		}
+	}
+	for _, f := range m.Meta {
+		ret = append(ret, service.CheckFile(f), service.CheckFilenames(f, service.ValidMetafileNames))
+	}
+	return ret
+}
+
+// lockedProject returns the project recorded in the lock of the manifest, or
+// nil if it is not created yet or removed.
+func lockedProject(m *manifest.Manifest) (*types.Project, error) {
+	l, err := m.ReadLock()
+	if err != nil {
+		return nil, err
+	}
+	if l.PID == "" {
+		return nil, nil
+	}
+	p, err := gql.SearchProjectID(l.PID, true)
+	if err == errors.ErrProjNotFound {
+		log.Printf("Project %q recorded in %s is not found\n", l.PID, m.LockPath())
+		return nil, nil
+	}
+	return p, err
+}
+
+// useImageSource sets the flags of `import image` for the source, resuming
+// its unfinished session if any.
+func useImageSource(pid string, src manifest.Source, up manifest.Upload) {
+	id = pid
+	dir = src.Dir
+	imageURLs = src.URLs
+	fromS3 = src.From
+	skip = src.Skip
+	s3Endpoint = src.Endpoint
+	s3Region = src.Region
+	s3Profile = src.Profile
+	method = up.Method
+	bucket = up.Bucket
+	resume, _ = db.HasSession(pid, src.Name())
+}
+
+// projectReady tells if every image and meta file of the project is ready,
+// or the reason if not.
+func projectReady(pid string, metas []string) (bool, string, error) {
+	states, err := gql.ImageStates(pid)
+	if err != nil {
+		return false, "", err
+	}
+	if len(states) == 0 {
+		return false, "there is no image", nil
+	}
+	var pending, invalid int
+	for _, img := range states {
+		switch img.State {
+		case service.Ready:
+		case "Invalid":
+			invalid++
+		default:
+			pending++
+		}
+	}
+	if pending > 0 || invalid > 0 {
+		return false, fmt.Sprintf("%d of %d images are pending and %d are invalid", pending, len(states), invalid), nil
+	}
+	for _, f := range metas {
+		sum, err := file.Sha1sum(f)
+		if err != nil {
+			return false, "", err
+		}
+		ok, err := gql.HasMetaFile(pid, sum)
+		if err != nil {
+			return false, "", err
+		}
+		if !ok {
+			return false, fmt.Sprintf("meta file %q is not uploaded", f), nil
+		}
+	}
+	return true, "", nil
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	applyCmd.Flags().StringVarP(&manifestPath, "file", "f", manifestPath, "Path of the yaml manifest of the project")
+	applyCmd.Flags().BoolVarP(&assumeYes, "assumeyes", "y", assumeYes, "Assume yes; assume that the answer to any question which would be asked is yes")
+	applyCmd.Flags().IntVarP(&timeout, "timeout", "t", timeout, "Timeout of checking upload state")
+	applyCmd.Flags().StringVar(&ip, "ip", ip, "IP address of ad-hoc local server for direct upload.")
+	applyCmd.Flags().StringVar(&port, "port", port, "Port of ad-hoc local server for direct upload.")
+	applyCmd.Flags().StringVar(&limitRate, "limit-rate", limitRate, "Max total upload rate of all threads, e.g. 20MB/s")
+	applyCmd.Flags().StringVar(&uploadWindow, "upload-window", uploadWindow, "Daily time window for uploading in local time, e.g. 22:00-06:00")
+	applyCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display more info of operation")
+	applyCmd.Flags().IntVarP(&thread, "thread", "n", thread, "Number of threads to process, default is number of cores x 4")
+	errors.Must(applyCmd.MarkFlagRequired("file"))
+}
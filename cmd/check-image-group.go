@@ -43,6 +43,12 @@ var checkImageGroupCmd = &cobra.Command{
 			return
 		}
 
+		filter, err := walkFilter()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
 		// a. read group.txt
 		group, err := readGroupTxt(groupPath)
 		errors.Must(err)
@@ -53,7 +59,7 @@ var checkImageGroupCmd = &cobra.Command{
 		done := make(chan struct{})
 		defer close(done)
 
-		paths, errc := file.WalkFiles(done, dir, skip)
+		paths, errc := file.WalkFilter(done, dir, filter)
 		result := make(chan file.ImageDigest)
 
 		digester := file.ImageDigester{
@@ -161,6 +167,7 @@ func init() {
 	checkCmd.AddCommand(checkImageGroupCmd)
 	checkImageGroupCmd.Flags().StringVarP(&dir, "dir", "d", dir, "Directory path")
 	checkImageGroupCmd.Flags().StringVarP(&skip, "skip", "s", skip, "Regular expression to skip paths")
+	addFilterFlags(checkImageGroupCmd)
 	checkImageGroupCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display individual image info")
 	checkImageGroupCmd.Flags().BoolVarP(&printTable, "table", "t", printTable, "Output all of the found images in table format")
 	checkImageGroupCmd.Flags().IntVarP(&thread, "thread", "n", thread, "Number of threads to process, default is number of cores x 4")
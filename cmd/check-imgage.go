@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/c2h5oh/datasize"
 	"github.com/jackytck/alti-cli/errors"
 	"github.com/jackytck/alti-cli/file"
 	"github.com/jackytck/alti-cli/gql"
+	"github.com/jackytck/alti-cli/service"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
@@ -19,14 +22,43 @@ var skip string
 var verbose bool
 var printTable bool
 var thread = -1
+var noCache bool
+var quality bool
+var similar bool
+var hamming = 5
+var hashKind = file.HashDHash
+var removeDup bool
+var moveDupTo string
 
 // checkImageCmd represents the checkImage command
 var checkImageCmd = &cobra.Command{
 	Use:   "image",
 	Short: "Check images of given directory recursively",
 	Long: `Compute checksum, find duplicates and compute total giga-pixel
-of all images of a given directory.`,
+of all images of a given directory.
+Images with the same checksum are reported as exact duplicates. With --similar,
+visually similar images are found by their perceptual hashes too.
+The extra images of each cluster could be removed or moved away.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if similar && !file.IsValidHash(hashKind) {
+			log.Printf("Invalid hash %q, must be %q or %q\n", hashKind, file.HashDHash, file.HashPHash)
+			return
+		}
+		if removeDup && moveDupTo != "" {
+			log.Println("Only one of --remove and --move-to could be set")
+			return
+		}
+		if (removeDup || moveDupTo != "") && file.IsArchive(dir) {
+			log.Println("Members of an archive could not be removed or moved")
+			return
+		}
+		filter, err := walkFilter()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer file.CloseArchives()
+
 		start := time.Now()
 		defer func() {
 			if verbose {
@@ -44,22 +76,39 @@ of all images of a given directory.`,
 		done := make(chan struct{})
 		defer close(done)
 
-		paths, errc := file.WalkFiles(done, dir, skip)
+		cache := openDigestCache(noCache)
+		defer saveDigestCache(cache)
+
+		paths, errc := file.WalkFilter(done, dir, filter)
 		result := make(chan file.ImageDigest)
 
 		digester := file.ImageDigester{
 			Root:   dir,
+			Cache:  cache,
 			Done:   done,
 			Paths:  paths,
 			Result: result,
 		}
+		if quality {
+			opts := file.DefaultQualityOptions()
+			digester.Quality = &opts
+		}
+		if similar {
+			digester.Hash = hashKind
+		}
 		threads := digester.Run(thread)
 		if verbose {
 			log.Printf("Working in %d thread(s)...", threads)
 		}
 
+		header := []string{"Filename", "Dimension", "GP", "Size (MB)", "Camera", "Focal (mm)", "Capture time", "GPS", "Checksum"}
+		if quality {
+			header = append(header, "Quality")
+		}
 		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"Filename", "Dimension", "GP", "Size (MB)", "Checksum"})
+		table.SetHeader(header)
+		var metaSum file.MetaSummary
+		var digests []file.ImageDigest
 
 		for r := range result {
 			if r.Error != nil {
@@ -69,20 +118,11 @@ of all images of a given directory.`,
 
 			mb := file.BytesToMB(r.Filesize)
 			if verbose {
-				log.Printf("Path: %q, URL: %q, Filename: %q, Dimension: %d x %d, GP: %.2f, Type: %s, Size: %.2f MB, Checksum: %s\n",
-					r.Path, r.URL, r.Filename, r.Width, r.Height, r.GP, r.Filetype, mb, r.SHA1)
-			}
-
-			if printTable {
-				r := []string{
-					fmt.Sprintf("%q", r.Filename),
-					fmt.Sprintf("%d x %d", r.Width, r.Height),
-					fmt.Sprintf("%.2f", r.GP),
-					fmt.Sprintf("%.2f", mb),
-					r.SHA1,
-				}
-				table.Append(r)
+				log.Printf("Path: %q, URL: %q, Filename: %q, Dimension: %d x %d, GP: %.2f, Type: %s, Size: %.2f MB, Checksum: %s, %s\n",
+					r.Path, r.URL, r.Filename, r.Width, r.Height, r.GP, r.Filetype, mb, r.SHA1, r.Meta)
 			}
+			metaSum.Add(r.Meta)
+			digests = append(digests, r)
 
 			totalGP += r.GP
 			totalImg++
@@ -104,19 +144,221 @@ of all images of a given directory.`,
 			log.Println("No image is found!")
 		}
 
+		for _, w := range metaSum.Warnings() {
+			log.Printf("Warning: %s\n", w)
+		}
+
+		// quality of each image and summary
+		if quality {
+			qualitySum := flagImageQuality(digests)
+			for _, r := range digests {
+				if r.Quality.Flagged() {
+					log.Printf("Quality: %q is %s\n", r.Path, r.Quality)
+				}
+			}
+			log.Printf("Quality: %s\n", qualitySum.String())
+		}
+
 		if printTable {
-			table.SetFooter([]string{fmt.Sprintf("%d image(s)", totalImg), fmt.Sprintf("USD $%.2f", usd), fmt.Sprintf("%.2f GP", totalGP), totalByte.HumanReadable(), `\ (•◡•) /`})
+			for _, r := range digests {
+				row := []string{
+					fmt.Sprintf("%q", r.Filename),
+					fmt.Sprintf("%d x %d", r.Width, r.Height),
+					fmt.Sprintf("%.2f", r.GP),
+					fmt.Sprintf("%.2f", file.BytesToMB(r.Filesize)),
+					r.Meta.Camera(),
+					focalStr(r.Meta),
+					timeStr(r.Meta.CaptureTime),
+					r.Meta.GPS(),
+					r.SHA1,
+				}
+				if quality {
+					q := "ok"
+					if r.Quality.Flagged() {
+						q = strings.Join(r.Quality.Issues, ", ")
+					}
+					row = append(row, q)
+				}
+				table.Append(row)
+			}
+		}
+
+		if printTable {
+			footer := []string{fmt.Sprintf("%d image(s)", totalImg), fmt.Sprintf("USD $%.2f", usd), fmt.Sprintf("%.2f GP", totalGP), totalByte.HumanReadable(), "", "", "", "", `\ (•◡•) /`}
+			if quality {
+				footer = append(footer, "")
+			}
+			table.SetFooter(footer)
 			table.Render()
 		}
+
+		// exact and near duplicates
+		threshold := -1
+		if similar {
+			threshold = hamming
+		}
+		clusters := file.FindDuplicates(digests, threshold)
+		if len(clusters) == 0 {
+			log.Println("No duplicate is found.")
+			return
+		}
+		printDuplicates(clusters)
+
+		if !removeDup && moveDupTo == "" {
+			return
+		}
+		cullDuplicates(clusters, threshold)
 	},
 }
 
 func init() {
 	checkCmd.AddCommand(checkImageCmd)
-	checkImageCmd.Flags().StringVarP(&dir, "dir", "d", dir, "Directory path")
+	checkImageCmd.Flags().StringVarP(&dir, "dir", "d", dir, "Directory path, or a zip, tar or tar.gz archive of images")
 	checkImageCmd.Flags().StringVarP(&skip, "skip", "s", skip, "Regular expression to skip paths")
+	addFilterFlags(checkImageCmd)
 	checkImageCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display individual image info")
 	checkImageCmd.Flags().BoolVarP(&printTable, "table", "t", printTable, "Output all of the found images in table format")
 	checkImageCmd.Flags().IntVarP(&thread, "thread", "n", thread, "Number of threads to process, default is number of cores x 4")
+	checkImageCmd.Flags().BoolVar(&noCache, "no-cache", noCache, "Read every image again instead of using the digest cache")
+	checkImageCmd.Flags().BoolVar(&quality, "quality", quality, "Decode each image fully to flag blurry, over or under exposed, truncated and undersized images")
+	checkImageCmd.Flags().BoolVar(&similar, "similar", similar, "Find visually similar images by perceptual hash too")
+	checkImageCmd.Flags().IntVar(&hamming, "hamming", hamming, "Max hamming distance (0-64) of perceptual hashes for images to be similar")
+	checkImageCmd.Flags().StringVar(&hashKind, "hash", hashKind, "Perceptual hash: 'dhash' or 'phash'")
+	checkImageCmd.Flags().BoolVar(&removeDup, "remove", removeDup, "Remove the extra images of each duplicate cluster")
+	checkImageCmd.Flags().StringVar(&moveDupTo, "move-to", moveDupTo, "Move the extra images of each duplicate cluster to this directory")
+	checkImageCmd.Flags().BoolVarP(&assumeYes, "assumeyes", "y", assumeYes, "Assume yes; assume that the answer to any question which would be asked is yes")
 	errors.Must(checkImageCmd.MarkFlagRequired("dir"))
 }
+
+// focalStr formats the focal length with its 35mm equivalent, if any.
+func focalStr(m file.ImageMeta) string {
+	switch {
+	case m.FocalLength > 0 && m.Focal35mm > 0:
+		return fmt.Sprintf("%.1f (%d)", m.FocalLength, m.Focal35mm)
+	case m.FocalLength > 0:
+		return fmt.Sprintf("%.1f", m.FocalLength)
+	}
+	return ""
+}
+
+// timeStr formats t, empty if it is zero.
+func timeStr(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// flagImageQuality flags the images that are far smaller than the rest of
+// the set, then summarizes the quality of all of them.
+func flagImageQuality(digests []file.ImageDigest) file.QualitySummary {
+	var gps []float64
+	for _, d := range digests {
+		gps = append(gps, d.GP)
+	}
+	min := file.UndersizedGP(gps, file.DefaultQualityOptions().MinSizeRatio)
+
+	var ret file.QualitySummary
+	for _, d := range digests {
+		if d.Quality == nil {
+			continue
+		}
+		if d.GP < min {
+			d.Quality.Flag(file.IssueUndersized)
+		}
+		ret.Add(d.Quality)
+	}
+	return ret
+}
+
+// printDuplicates prints the image to keep and the extras of each cluster,
+// then a summary.
+func printDuplicates(clusters []file.DuplicateCluster) {
+	var extras int
+	var extraGP float64
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Cluster", "Kind", "Action", "Path", "Dimension", "GP", "Distance"})
+	for i, c := range clusters {
+		cid := fmt.Sprintf("%d", i+1)
+		table.Append([]string{cid, c.Kind, "keep", fmt.Sprintf("%q", c.Keep.Path), fmt.Sprintf("%d x %d", c.Keep.Width, c.Keep.Height), fmt.Sprintf("%.2f", c.Keep.GP), ""})
+		for _, d := range c.Extras {
+			dist := ""
+			if d.SHA1 != c.Keep.SHA1 && d.Hash != nil && c.Keep.Hash != nil {
+				dist = fmt.Sprintf("%d", file.HammingDistance(*d.Hash, *c.Keep.Hash))
+			}
+			table.Append([]string{cid, c.Kind, "extra", fmt.Sprintf("%q", d.Path), fmt.Sprintf("%d x %d", d.Width, d.Height), fmt.Sprintf("%.2f", d.GP), dist})
+		}
+		extras += len(c.Extras)
+		extraGP += c.ExtraGP()
+	}
+	table.Render()
+
+	usd, err := gql.CoinsToMoney(extraGP, "USD")
+	errors.Must(err)
+	log.Printf("Found %d duplicate cluster(s) with %d extra image(s), total %.2f GP, USD $%.2f could be saved.\n", len(clusters), extras, extraGP, usd)
+}
+
+// cullDuplicates removes or moves the extra images of each cluster after
+// confirmation. An extra farther than threshold from the kept image is kept.
+func cullDuplicates(clusters []file.DuplicateCluster, threshold int) {
+	var extras int
+	for _, c := range clusters {
+		for _, d := range c.Extras {
+			if c.Near(d, threshold) {
+				extras++
+			} else {
+				log.Printf("Keeping %q, which is farther than %d bits from %q\n", d.Path, threshold, c.Keep.Path)
+			}
+		}
+	}
+	if extras == 0 {
+		log.Println("No extra image to cull.")
+		return
+	}
+	plural := ""
+	if extras > 1 {
+		plural = "s"
+	}
+	action := "remove"
+	if moveDupTo != "" {
+		action = fmt.Sprintf("move to %q", moveDupTo)
+	}
+
+	fmt.Printf("Continue to %s %d extra image%s or not? (Y/N): ", action, extras, plural)
+	var ans string
+	if assumeYes {
+		fmt.Println("Yes")
+	} else {
+		fmt.Scanln(&ans)
+		ans = strings.ToUpper(ans)
+		if ans != "Y" && ans != service.Yes {
+			log.Println("Cancelled.")
+			return
+		}
+	}
+
+	for _, c := range clusters {
+		for _, d := range c.Extras {
+			if !c.Near(d, threshold) {
+				continue
+			}
+			if moveDupTo == "" {
+				if verbose {
+					fmt.Printf("Removing %q\n", d.Path)
+				}
+				errors.Must(os.Remove(d.Path))
+				continue
+			}
+			// keep the relative path from the image dir
+			rel, err := filepath.Rel(dir, d.Path)
+			errors.Must(err)
+			dst := filepath.Join(moveDupTo, rel)
+			if verbose {
+				fmt.Printf("Moving %q to %q\n", d.Path, dst)
+			}
+			errors.Must(file.MoveFile(d.Path, dst))
+		}
+	}
+
+	log.Println("Done")
+}
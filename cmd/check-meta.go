@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
+	"github.com/jackytck/alti-cli/service"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// checkMetaCmd represents the check meta command
+var checkMetaCmd = &cobra.Command{
+	Use:   "meta",
+	Short: "Check meta files before importing",
+	Long: `Parse a meta file, or the meta files of a directory, and report the broken
+rows, the images not found in the image directory, the duplicated entries and
+the coordinates, angles and camera parameters out of range, line by line.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		start := time.Now()
+		defer func() {
+			if verbose {
+				elapsed := time.Since(start)
+				log.Println("Took", elapsed)
+			}
+		}()
+
+		// pre-checks
+		var metas []string
+		if fi, err := os.Stat(meta); err == nil && fi.IsDir() {
+			metas, _ = service.GetMetafilePaths(meta)
+			if len(metas) == 0 {
+				log.Printf("No meta file of %q is found in %q\n", service.ValidMetafileNames, meta)
+				return
+			}
+		} else {
+			if err := service.Check(nil, service.CheckFile(meta), service.CheckFilenames(meta, service.ValidMetafileNames)); err != nil {
+				log.Println(err)
+				return
+			}
+			metas = []string{meta}
+		}
+
+		invalid := 0
+		for _, m := range metas {
+			r, err := validateMeta(m, dir)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			printMetaReport(r)
+			if !r.Valid() {
+				invalid++
+			}
+		}
+		if invalid > 0 {
+			log.Printf("%v: %d of %d meta files are invalid\n", errors.ErrMetaInvalid, invalid, len(metas))
+		}
+	},
+}
+
+// validateMeta validates the meta file against the images under imgDir. If
+// imgDir is empty, only the images of the directory of the meta file are used,
+// not of its subdirectories, e.g. of a meta file in the home directory. The
+// images are not checked if there is none, e.g. they are uploaded from
+// elsewhere.
+func validateMeta(p, imgDir string) (*file.MetaReport, error) {
+	recursive := imgDir != ""
+	if !recursive {
+		imgDir = filepath.Dir(p)
+	}
+	var images []string
+	if filepath.Ext(p) == ".txt" {
+		imgs, err := file.MetaImages(imgDir, recursive)
+		if err != nil {
+			return nil, err
+		}
+		if len(imgs) == 0 {
+			log.Printf("No image is found in %q, the referenced images are not checked\n", imgDir)
+		}
+		images = imgs
+	}
+	return file.ValidateMeta(p, images)
+}
+
+// printMetaReport prints the issues of the meta file in a table.
+func printMetaReport(r *file.MetaReport) {
+	errs, warns := r.Count(file.IssueError), r.Count(file.IssueWarning)
+	if len(r.Issues) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Line", "Level", "Issue"})
+		table.SetAutoWrapText(false)
+		for _, i := range r.Issues {
+			line := "-"
+			if i.Line > 0 {
+				line = strconv.Itoa(i.Line)
+			}
+			table.Append([]string{line, i.Level, i.Message})
+		}
+		table.SetFooter([]string{"", fmt.Sprintf("%d errors", errs), fmt.Sprintf("%d warnings", warns)})
+		table.Render()
+	}
+	state := "valid"
+	if !r.Valid() {
+		state = "invalid"
+	}
+	log.Printf("%q is %s: %d entries, %d errors and %d warnings\n", r.Path, state, r.Entries, errs, warns)
+}
+
+func init() {
+	checkCmd.AddCommand(checkMetaCmd)
+	checkMetaCmd.Flags().StringVarP(&meta, "file", "f", meta, "Path of the meta file, or a directory of meta files")
+	checkMetaCmd.Flags().StringVarP(&dir, "dir", "d", dir, "Directory of the images, walked recursively, default is the directory of the meta file without its subdirectories")
+	checkMetaCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display more info of operation")
+	errors.Must(checkMetaCmd.MarkFlagRequired("file"))
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
+	"github.com/jackytck/alti-cli/service"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// checkModelCmd represents the check model command
+var checkModelCmd = &cobra.Command{
+	Use:   "model",
+	Short: "Check a model zip or model file before importing",
+	Long: `Open a model zip, parse its obj files and the referenced mtl files, and its
+gltf, glb, ply and fbx files, check that every referenced file is in the zip
+with the same case, and report the counts, the bounding box and the issues
+that would be rejected by the server. A model file is packaged as by
+'alti-cli import model' before the check. The same check is run by
+'alti-cli import model'.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		start := time.Now()
+		defer func() {
+			if verbose {
+				elapsed := time.Since(start)
+				log.Println("Took", elapsed)
+			}
+		}()
+
+		// pre-checks
+		if err := service.Check(nil, service.CheckFile(model)); err != nil {
+			log.Println(err)
+			return
+		}
+		zp := model
+		if file.IsModelFile(model) {
+			p, done, err := packageModelFile(model)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			defer done()
+			zp = p
+		}
+		if err := service.CheckZip(zp)(log.Printf); err != nil {
+			log.Println(err)
+			return
+		}
+
+		r, err := file.CheckModelZip(zp)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetAutoWrapText(false)
+		table.Append([]string{"Models", strings.Join(r.Models, "\n")})
+		table.Append([]string{"MTL files", strings.Join(r.MTLs, "\n")})
+		if verbose {
+			table.Append([]string{"Textures", strings.Join(r.Textures, "\n")})
+		} else {
+			table.Append([]string{"Textures", strconv.Itoa(len(r.Textures))})
+		}
+		table.Append([]string{"Vertices", strconv.Itoa(r.Vertices)})
+		table.Append([]string{"Texture coordinates", strconv.Itoa(r.TexCoords)})
+		table.Append([]string{"Normals", strconv.Itoa(r.Normals)})
+		table.Append([]string{"Faces", strconv.Itoa(r.Faces)})
+		table.Append([]string{"Bounding box", fmt.Sprintf("(%.3f, %.3f, %.3f) - (%.3f, %.3f, %.3f)", r.Min[0], r.Min[1], r.Min[2], r.Max[0], r.Max[1], r.Max[2])})
+		sz := r.Size()
+		table.Append([]string{"Size", fmt.Sprintf("%.3f x %.3f x %.3f", sz[0], sz[1], sz[2])})
+		table.Render()
+
+		errs, warns := r.Count(file.IssueError), r.Count(file.IssueWarning)
+		printModelIssues(r.Issues, errs, warns)
+		if !r.Valid() {
+			log.Printf("%v: %q has %d errors and %d warnings\n", errors.ErrModelInvalid, model, errs, warns)
+			return
+		}
+		log.Printf("%q is valid with %d warnings\n", model, warns)
+	},
+}
+
+// printModelIssues prints the issues of a model in a table.
+func printModelIssues(issues file.Issues, errs, warns int) {
+	if len(issues) == 0 {
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"File", "Line", "Level", "Issue"})
+	table.SetAutoWrapText(false)
+	for _, i := range issues {
+		line := "-"
+		if i.Line > 0 {
+			line = strconv.Itoa(i.Line)
+		}
+		table.Append([]string{i.File, line, i.Level, i.Message})
+	}
+	table.SetFooter([]string{"", "", fmt.Sprintf("%d errors", errs), fmt.Sprintf("%d warnings", warns)})
+	table.Render()
+}
+
+func init() {
+	checkCmd.AddCommand(checkModelCmd)
+	checkModelCmd.Flags().StringVarP(&model, "file", "f", model, "File path of the model zip or model file")
+	checkModelCmd.Flags().BoolVar(&toOBJ, "to-obj", toOBJ, "Check a gltf, glb or ply mesh as converted to obj, as 'alti-cli import model' does by default")
+	checkModelCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display every texture")
+	errors.Must(checkModelCmd.MarkFlagRequired("file"))
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
+	"github.com/jackytck/alti-cli/service"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// checkPointCloudCmd represents the check pointcloud command
+var checkPointCloudCmd = &cobra.Command{
+	Use:   "pointcloud",
+	Short: "Check the headers of point clouds before importing",
+	Long: `Parse the headers of a point cloud of las, laz, ply or e57, or of every point
+cloud in a zip, and report the number of points, the bounds, the point format,
+the crs and if the file has as many point records as declared by its header.
+The same check is run by 'alti-cli import model'.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		start := time.Now()
+		defer func() {
+			if verbose {
+				elapsed := time.Since(start)
+				log.Println("Took", elapsed)
+			}
+		}()
+
+		// pre-checks
+		if err := service.Check(nil, service.CheckFile(model)); err != nil {
+			log.Println(err)
+			return
+		}
+
+		rs, err := file.InspectPointClouds(model)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if len(rs) == 0 {
+			log.Printf("No point cloud of las, laz, ply or e57 is found in %q\n", model)
+			return
+		}
+
+		var issues file.Issues
+		errs, warns := 0, 0
+		for _, r := range rs {
+			printPointCloudReport(r)
+			issues = append(issues, r.Issues...)
+			errs += r.Count(file.IssueError)
+			warns += r.Count(file.IssueWarning)
+		}
+		printModelIssues(issues, errs, warns)
+		if errs > 0 {
+			log.Printf("%v: %q has %d errors and %d warnings\n", errors.ErrPointCloudInvalid, model, errs, warns)
+			return
+		}
+		log.Printf("%q is valid with %d warnings\n", model, warns)
+	},
+}
+
+// printPointCloudReport prints the header of a point cloud in a table.
+func printPointCloudReport(r *file.PointCloudReport) {
+	actual := "unknown, compressed"
+	if r.Actual >= 0 {
+		actual = strconv.FormatInt(r.Actual, 10)
+	}
+	bounds, size := "-", "-"
+	if r.HasBounds {
+		bounds = fmt.Sprintf("(%.3f, %.3f, %.3f) - (%.3f, %.3f, %.3f)", r.Min[0], r.Min[1], r.Min[2], r.Max[0], r.Max[1], r.Max[2])
+		size = fmt.Sprintf("%.3f x %.3f x %.3f", r.Max[0]-r.Min[0], r.Max[1]-r.Min[1], r.Max[2]-r.Min[2])
+	}
+	crs := r.CRS
+	if crs == "" {
+		crs = "-"
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAutoWrapText(false)
+	table.Append([]string{"File", r.Name})
+	table.Append([]string{"Format", r.Format + " " + r.Version})
+	table.Append([]string{"Point format", r.PointFormat})
+	table.Append([]string{"Points", strconv.FormatInt(r.Points, 10)})
+	table.Append([]string{"Point records", actual})
+	table.Append([]string{"Bounding box", bounds})
+	table.Append([]string{"Size", size})
+	table.Append([]string{"CRS", crs})
+	table.Render()
+}
+
+// inspectPointClouds inspects the point clouds of the model at p before
+// uploading, logging their summaries and issues. It fails on any error, e.g. a
+// truncated file, unless it is forced.
+func inspectPointClouds(p string) error {
+	rs, err := file.InspectPointClouds(p)
+	if err != nil {
+		return err
+	}
+	errs := 0
+	for _, r := range rs {
+		log.Printf("Inspected %q: %s\n", r.Name, r.Summary())
+		for _, i := range r.Issues {
+			log.Println(i)
+		}
+		errs += r.Count(file.IssueError)
+	}
+	if errs > 0 {
+		if !force {
+			return fmt.Errorf("%v: %d errors, see 'alti-cli check pointcloud -f %s'", errors.ErrPointCloudInvalid, errs, p)
+		}
+		log.Printf("Uploading the point clouds with %d errors as it is forced\n", errs)
+	}
+	return nil
+}
+
+func init() {
+	checkCmd.AddCommand(checkPointCloudCmd)
+	checkPointCloudCmd.Flags().StringVarP(&model, "file", "f", model, "File path of the point cloud, or a zip of point clouds")
+	checkPointCloudCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display more info of operation")
+	errors.Must(checkPointCloudCmd.MarkFlagRequired("file"))
+}
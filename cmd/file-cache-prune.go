@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
+	"github.com/spf13/cobra"
+)
+
+var olderThan time.Duration
+
+// fileCachePruneCmd represents the file cache prune command
+var fileCachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove stale entries from the digest cache",
+	Long:  "Remove the cached digests of removed or modified images, and optionally the ones older than a duration.",
+	Run: func(cmd *cobra.Command, args []string) {
+		p, err := file.DefaultDigestCachePath()
+		errors.Must(err)
+		c, err := file.OpenDigestCache(p)
+		if err != nil {
+			log.Printf("Could not open digest cache %q: %v\n", p, err)
+			return
+		}
+
+		n, err := c.Prune(olderThan)
+		errors.Must(err)
+		log.Printf("Removed %d entries, %d remain.\n", n, c.Len())
+	},
+}
+
+func init() {
+	fileCacheCmd.AddCommand(fileCachePruneCmd)
+	fileCachePruneCmd.Flags().DurationVar(&olderThan, "older-than", olderThan, "Also remove entries cached longer than this duration, e.g. 720h")
+}
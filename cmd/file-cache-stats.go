@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// fileCacheStatsCmd represents the file cache stats command
+var fileCacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show statistics of the digest cache",
+	Long:  "Show the number of cached images, their total size and the number of stale entries.",
+	Run: func(cmd *cobra.Command, args []string) {
+		p, err := file.DefaultDigestCachePath()
+		errors.Must(err)
+		c, err := file.OpenDigestCache(p)
+		if err != nil {
+			log.Printf("Could not open digest cache %q: %v\n", p, err)
+			return
+		}
+
+		s := c.Stats()
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Path", "Entries", "Stale", "Images Size", "Cache Size", "Oldest", "Newest"})
+		oldest, newest := "-", "-"
+		if s.Entries > 0 {
+			oldest = s.Oldest.Format("2006-01-02 15:04:05")
+			newest = s.Newest.Format("2006-01-02 15:04:05")
+		}
+		table.Append([]string{
+			p,
+			fmt.Sprintf("%d", s.Entries),
+			fmt.Sprintf("%d", s.Stale),
+			datasize.ByteSize(s.Bytes).HumanReadable(),
+			datasize.ByteSize(s.FileBytes).HumanReadable(),
+			oldest,
+			newest,
+		})
+		table.Render()
+		if s.Stale > 0 {
+			log.Println("To remove stale entries: 'alti-cli file cache prune'")
+		}
+	},
+}
+
+func init() {
+	fileCacheCmd.AddCommand(fileCacheStatsCmd)
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// fileCacheCmd represents the file cache command
+var fileCacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local image digest cache",
+	Long:  "Root command for inspecting and pruning the local cache of image checksums and dimensions.",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("See alti-cli help file cache")
+	},
+}
+
+func init() {
+	fileCmd.AddCommand(fileCacheCmd)
+}
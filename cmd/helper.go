@@ -1,10 +1,31 @@
 package cmd
 
 import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/jackytck/alti-cli/cloud"
 	"github.com/jackytck/alti-cli/config"
+	"github.com/jackytck/alti-cli/file"
 	"github.com/jackytck/alti-cli/gql"
+	"github.com/jackytck/alti-cli/limit"
+	"github.com/jackytck/alti-cli/progress"
+	"github.com/spf13/cobra"
 )
 
+var include []string
+var exclude []string
+var since string
+var until string
+var cameras []string
+var maxFiles int
+var followSymlinks bool
+
 // LoginHint is shown when user wants to perfom operation that requires user token.
 const LoginHint = "You are not login in!\nLogin with 'alti-cli login' or\nSwith account with 'alti-cli account use XXX'"
 
@@ -21,3 +42,196 @@ func IsSuperuser() bool {
 	active := config.GetActive()
 	return gql.IsSuper(active.Endpoint, active.Key, active.Token)
 }
+
+// openDigestCache opens the default digest cache unless it is disabled.
+// Return nil if it is disabled or could not be opened.
+func openDigestCache(disabled bool) *file.DigestCache {
+	if disabled {
+		return nil
+	}
+	p, err := file.DefaultDigestCachePath()
+	if err != nil {
+		log.Printf("Digest cache is disabled: %v\n", err)
+		return nil
+	}
+	c, err := file.OpenDigestCache(p)
+	if err != nil {
+		log.Printf("Digest cache is disabled: %v\n", err)
+		return nil
+	}
+	return c
+}
+
+// saveDigestCache saves the digest cache, if any.
+func saveDigestCache(c *file.DigestCache) {
+	if c == nil {
+		return
+	}
+	if err := c.Save(); err != nil {
+		log.Printf("Could not save digest cache: %v\n", err)
+	}
+}
+
+// setupUploadLimit applies the global upload rate limit and window from
+// the --limit-rate and --upload-window flags.
+func setupUploadLimit() error {
+	rate, err := limit.ParseRate(limitRate)
+	if err != nil {
+		return err
+	}
+	var w *limit.Window
+	if uploadWindow != "" {
+		if w, err = limit.ParseWindow(uploadWindow); err != nil {
+			return err
+		}
+	}
+	limit.SetRate(rate)
+	limit.SetWindow(w)
+	if verbose && rate > 0 {
+		log.Printf("Upload rate is limited to %s/s\n", datasize.ByteSize(rate).HR())
+	}
+	if verbose && w != nil {
+		log.Printf("Upload window is %s\n", w)
+	}
+	return nil
+}
+
+// retryPolicy builds the retry policy of uploading from the defaults, the
+// retry section of config, then the --retry* flags, in increasing priority.
+func retryPolicy() (*cloud.RetryPolicy, error) {
+	rp := cloud.DefaultRetryPolicy()
+	if r := config.Load().Retry; r != nil {
+		if r.Max != nil {
+			rp.MaxRetries = *r.Max
+		}
+		for _, d := range []struct {
+			s   string
+			dst *time.Duration
+		}{
+			{r.Delay, &rp.BaseDelay},
+			{r.MaxDelay, &rp.MaxDelay},
+			{r.MaxElapsed, &rp.MaxElapsed},
+		} {
+			if d.s == "" {
+				continue
+			}
+			v, err := time.ParseDuration(d.s)
+			if err != nil {
+				return nil, err
+			}
+			*d.dst = v
+		}
+	}
+	if retries >= 0 {
+		rp.MaxRetries = retries
+	}
+	if retryDelay > 0 {
+		rp.BaseDelay = retryDelay
+	}
+	if retryMaxElapsed > 0 {
+		rp.MaxElapsed = retryMaxElapsed
+	}
+	return rp, nil
+}
+
+// startProgress starts rendering the progress of transfers by the --progress
+// flag. A bar is rendered on terminal, otherwise json events are appended to
+// the --progress-file. Return a func for stopping it.
+func startProgress(verb string) func() {
+	if !progress.IsValidMode(progressMode) {
+		log.Printf("Unknown progress mode %q, progress is disabled\n", progressMode)
+		return func() {}
+	}
+	if progressFile == "" {
+		if progressMode == progress.ModeJSON {
+			log.Println("Json progress is written to --progress-file only, progress is disabled")
+		}
+		return progress.Start(progressMode, verb, nil)
+	}
+	f, err := os.OpenFile(progressFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Progress is disabled: %v\n", err)
+		return func() {}
+	}
+	stop := progress.Start(progressMode, verb, f)
+	return func() {
+		stop()
+		f.Close()
+	}
+}
+
+// walkFilter builds the filter of walking a directory from the --skip and the
+// filter flags.
+func walkFilter() (file.Filter, error) {
+	f := file.Filter{
+		Skip:           skip,
+		Include:        include,
+		Exclude:        exclude,
+		Cameras:        cameras,
+		MaxFiles:       maxFiles,
+		FollowSymlinks: followSymlinks,
+	}
+	var err error
+	if f.Since, err = file.ParseFilterTime(since, false); err != nil {
+		return f, err
+	}
+	if f.Until, err = file.ParseFilterTime(until, true); err != nil {
+		return f, err
+	}
+	return f, f.Validate()
+}
+
+// addFilterFlags adds the filter flags of walkFilter, except --skip, to cmd.
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&include, "include", include, "Glob of the paths to include, e.g. 'DCIM/**/*.JPG', could be repeated")
+	cmd.Flags().StringArrayVar(&exclude, "exclude", exclude, "Glob of the paths to exclude, e.g. 'thumbs/', could be repeated")
+	cmd.Flags().StringVar(&since, "since", since, "Only the images captured at or after this local time, e.g. 2020-05-01 or '2020-05-01 13:00'")
+	cmd.Flags().StringVar(&until, "until", until, "Only the images captured at or before this local time, e.g. 2020-05-01 for the whole day")
+	cmd.Flags().StringArrayVar(&cameras, "camera", cameras, "Only the images of the camera model containing this, e.g. FC6310, could be repeated")
+	cmd.Flags().IntVar(&maxFiles, "max-files", maxFiles, "Max number of files to be read, no limit if 0")
+	cmd.Flags().BoolVar(&followSymlinks, "follow-symlinks", followSymlinks, "Follow the symlinks of files and directories")
+}
+
+// interrupts are the handlers of ctrl+c and SIGTERM, of which only the last
+// registered one is called. So a command run by another one, e.g. apply,
+// cleans up its own work without adding another listener.
+var interrupts struct {
+	sync.Mutex
+	once     sync.Once
+	next     int
+	handlers map[int]func()
+}
+
+// onInterrupt calls quit on each ctrl+c or SIGTERM, instead of the handlers
+// registered before, until the returned func is called. The process exits if
+// there is no handler.
+func onInterrupt(quit func()) func() {
+	interrupts.once.Do(func() {
+		interrupts.handlers = make(map[int]func())
+		cc := make(chan os.Signal, 1)
+		signal.Notify(cc, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			for range cc {
+				interrupts.Lock()
+				last, fn := -1, func() { os.Exit(1) }
+				for id, h := range interrupts.handlers {
+					if id > last {
+						last, fn = id, h
+					}
+				}
+				interrupts.Unlock()
+				fn()
+			}
+		}()
+	})
+	interrupts.Lock()
+	defer interrupts.Unlock()
+	id := interrupts.next
+	interrupts.next++
+	interrupts.handlers[id] = quit
+	return func() {
+		interrupts.Lock()
+		delete(interrupts.handlers, id)
+		interrupts.Unlock()
+	}
+}
@@ -0,0 +1,536 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackytck/alti-cli/db"
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
+	"github.com/jackytck/alti-cli/gql"
+	"github.com/jackytck/alti-cli/limit"
+	"github.com/jackytck/alti-cli/manifest"
+	"github.com/jackytck/alti-cli/progress"
+	"github.com/jackytck/alti-cli/service"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var jobsPath string
+var batchConcurrency = 2
+var batchOut string
+
+// importBatchCmd represents the import batch command
+var importBatchCmd = &cobra.Command{
+	Use:   "batch",
+	Short: "Import images into many projects from a jobs file",
+	Long: `Import a directory or an archive of images into each project of a csv
+jobs file, with the columns: source, pid, name, type, visibility, meta, task.
+A project is created by name, type and visibility if pid is empty. The meta
+file is imported and the task is started if given.
+The jobs run in a bounded number of concurrent projects, each in its own
+alti-cli process with its own threads, so a failed job does not abort the
+others. The log and the reports of each job are written to the output
+directory, with a copy of the jobs file with the pids of the new projects.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		start := time.Now()
+		defer func() {
+			if verbose {
+				elapsed := time.Since(start)
+				log.Println("Took", elapsed)
+			}
+		}()
+
+		// pre-checks
+		if err := service.Check(nil, service.CheckAPIServer(), service.CheckFile(jobsPath)); err != nil {
+			log.Println(err)
+			return
+		}
+		// the paths of the jobs are saved as absolute ones in the output directory
+		jp, err := filepath.Abs(jobsPath)
+		errors.Must(err)
+		jobs, err := manifest.ReadJobs(jp)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if batchConcurrency < 1 {
+			batchConcurrency = 1
+		}
+		rate, err := limit.ParseRate(limitRate)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		exe, err := os.Executable()
+		errors.Must(err)
+		if batchOut == "" {
+			batchOut = "alti-batch-" + start.Format("20060102-150405")
+		}
+		errors.Must(file.EnsureDir(batchOut, 0755))
+
+		// confirm
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Job", "Source", "Project", "Meta", "Task"})
+		for i, j := range jobs {
+			proj := j.PID
+			if proj == "" {
+				proj = fmt.Sprintf("new %s %s project %q", j.Visibility, j.Type, j.Name)
+			}
+			table.Append([]string{strconv.Itoa(i + 1), j.Source, proj, j.Meta, j.Task})
+		}
+		table.Render()
+		fmt.Printf("Continue to run %d jobs in %d concurrent projects or not? (Y/N): ", len(jobs), batchConcurrency)
+		if assumeYes {
+			fmt.Println("Yes")
+		} else {
+			var ans string
+			fmt.Scanln(&ans)
+			ans = strings.ToUpper(ans)
+			if ans != "Y" && ans != service.Yes {
+				log.Println("Cancelled.")
+				return
+			}
+		}
+
+		br := batchRunner{
+			exe:       exe,
+			out:       batchOut,
+			imageArgs: batchChildArgs(importImageCmd, childRate(rate, len(jobs))),
+			metaArgs:  batchChildArgs(importMetaCmd, childRate(rate, len(jobs))),
+			jobs:      jobs,
+		}
+		if err := br.saveJobs(); err != nil {
+			log.Println(err)
+			return
+		}
+		results := br.runAll(batchConcurrency)
+		writeBatchSummary(results, batchOut)
+		log.Printf("Logs and reports are written to %s\n", batchOut)
+		log.Printf("To run the failed jobs again, type: 'alti-cli import batch -f %s'\n", br.jobsPath())
+	},
+}
+
+// childRate returns the upload rate of each child process, so that the
+// concurrent ones of n jobs share the total rate, or 0 if unlimited.
+func childRate(rate int64, n int) int64 {
+	if n > batchConcurrency {
+		n = batchConcurrency
+	}
+	if rate <= 0 || n < 1 {
+		return 0
+	}
+	if rate /= int64(n); rate < 1 {
+		rate = 1
+	}
+	return rate
+}
+
+// batchChildArgs returns the flags passed to each child process of the
+// subcommand c, skipping the ones c does not have, e.g. --thread of import
+// meta. The upload rate of each child is rate in bytes per second. The flag
+// variables are read once here, and never in the jobs.
+func batchChildArgs(c *cobra.Command, rate int64) []string {
+	var args []string
+	add := func(name string, value ...string) {
+		if c.Flags().Lookup(name) == nil && c.Root().PersistentFlags().Lookup(name) == nil {
+			return
+		}
+		args = append(append(args, "--"+name), value...)
+	}
+	add("progress", progress.ModeNone)
+	if cfgFile != "" {
+		add("config", cfgFile)
+	}
+	if method != "" {
+		add("method", method)
+	}
+	if bucket != "" {
+		add("bucket", bucket)
+	}
+	if ip != "" {
+		add("ip", ip)
+	}
+	if rate > 0 {
+		add("limit-rate", strconv.FormatInt(rate, 10)+"B")
+	}
+	if uploadWindow != "" {
+		add("upload-window", uploadWindow)
+	}
+	if retries >= 0 {
+		add("retry", strconv.Itoa(retries))
+	}
+	if retryDelay > 0 {
+		add("retry-delay", retryDelay.String())
+	}
+	if retryMaxElapsed > 0 {
+		add("retry-max-elapsed", retryMaxElapsed.String())
+	}
+	if thread > 0 {
+		add("thread", strconv.Itoa(thread))
+	}
+	if timeout > 0 {
+		add("timeout", strconv.Itoa(timeout))
+	}
+	if verbose {
+		add("verbose")
+	}
+	return args
+}
+
+// batchRunner runs the jobs of a batch import. The images and meta file of
+// each job are imported by a child alti-cli process, so that the jobs share
+// no flag variable and could run concurrently.
+type batchRunner struct {
+	exe       string
+	out       string
+	imageArgs []string // flags of the child processes of import image
+	metaArgs  []string // flags of the child processes of import meta
+	// jobs are saved with the pids of the new projects to the output
+	// directory, for running the failed ones again
+	mu   sync.Mutex
+	jobs []manifest.Job
+}
+
+// batchResult is the outcome of a job.
+type batchResult struct {
+	Index      int
+	Job        manifest.Job
+	Report     *db.Report
+	ReportPath string
+	LogPath    string
+	Meta       string
+	Task       string
+	Err        error
+	Took       time.Duration
+}
+
+// OK tells if the job is done without any failed image, meta file or task.
+func (r batchResult) OK() bool {
+	return r.Err == nil && r.Report != nil && r.Report.Summary.Failed == 0 &&
+		r.Report.Summary.Invalid == 0 && r.Report.Summary.Pending == 0
+}
+
+// runAll runs the jobs in n concurrent projects, and returns the results in
+// the order of the jobs.
+func (br *batchRunner) runAll(n int) []batchResult {
+	results := make([]batchResult, len(br.jobs))
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for w := 0; w < n; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				log.Printf("[%d/%d] Started %q\n", i+1, len(results), br.job(i).Title())
+				r := br.run(i)
+				if r.OK() {
+					log.Printf("[%d/%d] Finished %q in %s\n", i+1, len(results), r.Job.Title(), r.Took.Round(time.Second))
+				} else {
+					log.Printf("[%d/%d] Failed %q: %s, see %s\n", i+1, len(results), r.Job.Title(), r.status(), r.LogPath)
+				}
+				results[i] = r
+			}
+		}()
+	}
+	for i := range br.jobs {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+	return results
+}
+
+// job returns the i-th job.
+func (br *batchRunner) job(i int) manifest.Job {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	return br.jobs[i]
+}
+
+// jobsPath returns the path of the copy of the jobs file.
+func (br *batchRunner) jobsPath() string {
+	return filepath.Join(br.out, "jobs.csv")
+}
+
+// setPID records the pid of the new project of the i-th job, and saves the
+// jobs.
+func (br *batchRunner) setPID(i int, pid string) error {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.jobs[i].PID = pid
+	return br.saveJobs()
+}
+
+// saveJobs saves the jobs to the output directory. The caller should hold
+// the lock if the jobs are running.
+func (br *batchRunner) saveJobs() error {
+	f, err := os.Create(br.jobsPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return manifest.WriteJobs(f, br.jobs)
+}
+
+var nonSlug = regexp.MustCompile(`[^\w.-]+`)
+
+// run runs the i-th job.
+func (br *batchRunner) run(i int) (res batchResult) {
+	start := time.Now()
+	j := br.job(i)
+	name := fmt.Sprintf("%02d-%s", i+1, strings.Trim(nonSlug.ReplaceAllString(j.Title(), "_"), "_"))
+	res = batchResult{
+		Index:      i,
+		Job:        j,
+		ReportPath: filepath.Join(br.out, name+".json"),
+		LogPath:    filepath.Join(br.out, name+".log"),
+	}
+	defer func() {
+		res.Took = time.Since(start)
+	}()
+
+	logFile, err := os.Create(res.LogPath)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	defer logFile.Close()
+	logger := log.New(logFile, "", log.LstdFlags)
+	defer func() {
+		if res.Err != nil {
+			logger.Println("Failed:", res.Err)
+		}
+	}()
+
+	// a. pre-checks
+	checks := []service.CheckFn{service.CheckDirOrArchive(j.Source)}
+	if j.Meta != "" {
+		checks = append(checks, service.CheckFile(j.Meta), service.CheckFilenames(j.Meta, service.ValidMetafileNames))
+	}
+	if res.Err = service.Check(logger.Printf, checks...); res.Err != nil {
+		return
+	}
+
+	// b. project
+	if j.PID == "" {
+		pid, err := gql.CreateProject(j.Name, j.Type, "", j.Visibility)
+		if err != nil {
+			res.Err = fmt.Errorf("project could not be created: %v", err)
+			return
+		}
+		logger.Printf("Created project %q with pid %q\n", j.Name, pid)
+		j.PID = pid
+		res.Job.PID = pid
+		if err := br.setPID(i, pid); err != nil {
+			logger.Printf("Could not save the pid: %v\n", err)
+		}
+	}
+	p, err := gql.SearchProjectID(j.PID, true)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	j.PID = p.ID
+	res.Job.PID = p.ID
+
+	// c. images, resuming the unfinished session if any
+	args := []string{"import", "image", "-p", p.ID, "-d", j.Source, "-y", "-r", res.ReportPath, "--report-format", db.ReportJSON}
+	if ok, _ := db.HasSession(p.ID, j.Source); ok {
+		args = append(args, "--resume")
+	}
+	os.Remove(res.ReportPath)
+	if res.Err = br.exec(logFile, append(args, br.imageArgs...)...); res.Err != nil {
+		return
+	}
+	r, err := readBatchReport(res.ReportPath)
+	if err != nil {
+		res.Err = fmt.Errorf("no upload report: %v", err)
+		return
+	}
+	res.Report = r
+	r.Summary.Project = p.Name
+	if html, err := os.Create(strings.TrimSuffix(res.ReportPath, ".json") + ".html"); err == nil {
+		if err := r.WriteHTML(html); err != nil {
+			logger.Printf("Could not write the html report: %v\n", err)
+		}
+		html.Close()
+	}
+
+	// d. meta file, skipped if already imported
+	if j.Meta != "" {
+		res.Meta, res.Err = br.importMeta(logFile, p.ID, j.Meta)
+		if res.Err != nil {
+			return
+		}
+	}
+
+	// e. task, started only if everything is ready
+	if j.Task != "" {
+		res.Task, res.Err = startBatchTask(p.ID, j)
+		logger.Printf("Task %q: %s\n", j.Task, res.Task)
+	}
+	return
+}
+
+// exec runs alti-cli with args, writing its output to w.
+func (br *batchRunner) exec(w *os.File, args ...string) error {
+	fmt.Fprintf(w, "$ alti-cli %s\n", strings.Join(args, " "))
+	c := exec.Command(br.exe, args...)
+	c.Stdout = w
+	c.Stderr = w
+	return c.Run()
+}
+
+// importMeta imports the meta file into the project unless it exists.
+func (br *batchRunner) importMeta(w *os.File, pid, meta string) (string, error) {
+	sum, err := file.Sha1sum(meta)
+	if err != nil {
+		return "", err
+	}
+	if ok, err := gql.HasMetaFile(pid, sum); err == nil && ok {
+		return "existed", nil
+	}
+	args := append([]string{"import", "meta", "-p", pid, "-f", meta}, br.metaArgs...)
+	if err := br.exec(w, args...); err != nil {
+		return "", err
+	}
+	ok, err := gql.HasMetaFile(pid, sum)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("meta file %q could not be imported", meta)
+	}
+	return "imported", nil
+}
+
+// startBatchTask starts the task of the job if the task is not started yet
+// and everything is ready, and returns what is done.
+func startBatchTask(pid string, j manifest.Job) (string, error) {
+	p, err := gql.SearchProjectID(pid, true)
+	if err != nil {
+		return "", err
+	}
+	if p.TaskState != "" && p.TaskState != service.Pending {
+		return "already " + p.TaskState, nil
+	}
+	var metas []string
+	if j.Meta != "" {
+		metas = append(metas, j.Meta)
+	}
+	ready, reason, err := projectReady(pid, metas)
+	if err != nil {
+		return "", err
+	}
+	if !ready {
+		return "not started as " + reason, nil
+	}
+	tt, _, err := gql.QueryTaskType(j.Task)
+	if err != nil {
+		return "", fmt.Errorf("unknown task type %q", j.Task)
+	}
+	t, err := gql.StartReconstruction(pid, tt)
+	if err != nil {
+		return "", err
+	}
+	return "started, " + t.State, nil
+}
+
+// readBatchReport reads the json upload report at p.
+func readBatchReport(p string) (*db.Report, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r db.Report
+	err = json.NewDecoder(f).Decode(&r)
+	return &r, err
+}
+
+// status describes the result.
+func (r batchResult) status() string {
+	switch {
+	case r.Err != nil:
+		return r.Err.Error()
+	case r.OK():
+		return "ok"
+	}
+	s := r.Report.Summary
+	return fmt.Sprintf("%d failed, %d invalid and %d pending images", s.Failed, s.Invalid, s.Pending)
+}
+
+// writeBatchSummary prints the summary of the results, and writes it to
+// summary.csv of the output directory.
+func writeBatchSummary(results []batchResult, out string) {
+	header := []string{"Job", "Project", "PID", "Images", "Ready", "GP", "Meta", "Task", "Took", "Status"}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	var rows [][]string
+	var okCnt int
+	var coins float64
+	for _, r := range results {
+		var images, ready, gp string
+		if r.Report != nil {
+			s := r.Report.Summary
+			images = strconv.Itoa(s.Images)
+			ready = strconv.Itoa(s.Ready)
+			gp = fmt.Sprintf("%.2f", s.GP)
+			coins += s.Coins
+		}
+		if r.OK() {
+			okCnt++
+		}
+		row := []string{strconv.Itoa(r.Index + 1), r.Job.Title(), r.Job.PID, images, ready, gp, r.Meta, r.Task, r.Took.Round(time.Second).String(), r.status()}
+		rows = append(rows, row)
+		table.Append(row)
+	}
+	table.Render()
+
+	usd, err := gql.CoinsToMoney(coins, "USD")
+	if err != nil {
+		log.Printf("Could not convert the cost to USD: %v\n", err)
+	}
+	fmt.Printf("%d of %d jobs succeeded\tGP of ready images: %.2f\tPRO: USD $%.2f\n", okCnt, len(results), coins, usd)
+
+	f, err := os.Create(filepath.Join(out, "summary.csv"))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	errors.Must(w.Write(header))
+	errors.Must(w.WriteAll(rows))
+}
+
+func init() {
+	importCmd.AddCommand(importBatchCmd)
+	importBatchCmd.Flags().StringVarP(&jobsPath, "file", "f", jobsPath, "Path of the csv jobs file")
+	importBatchCmd.Flags().IntVarP(&batchConcurrency, "jobs", "j", batchConcurrency, "Number of projects to import concurrently")
+	importBatchCmd.Flags().StringVarP(&batchOut, "out", "o", batchOut, "Directory of the logs and reports, default is alti-batch-<time>")
+	importBatchCmd.Flags().StringVarP(&method, "method", "m", method, "Desired method of upload: 'direct', 's3' or 'oss'")
+	importBatchCmd.Flags().StringVarP(&bucket, "bucket", "b", bucket, "Desired bucket to upload for method: 's3' or 'oss'")
+	importBatchCmd.Flags().StringVar(&ip, "ip", ip, "IP address of ad-hoc local server for direct upload.")
+	importBatchCmd.Flags().IntVarP(&thread, "thread", "n", thread, "Number of threads of each project, default is number of cores x 4")
+	importBatchCmd.Flags().IntVarP(&timeout, "timeout", "t", timeout, "Timeout of checking upload state in seconds")
+	importBatchCmd.Flags().BoolVarP(&assumeYes, "assumeyes", "y", assumeYes, "Assume yes; assume that the answer to any question which would be asked is yes")
+	importBatchCmd.Flags().IntVar(&retries, "retry", retries, "Max number of retries of each failed upload, default is 5 or from config")
+	importBatchCmd.Flags().DurationVar(&retryDelay, "retry-delay", retryDelay, "Delay before the first retry, doubled for each retry, e.g. 1s")
+	importBatchCmd.Flags().DurationVar(&retryMaxElapsed, "retry-max-elapsed", retryMaxElapsed, "Give up retrying after this duration, e.g. 5m")
+	importBatchCmd.Flags().StringVar(&limitRate, "limit-rate", limitRate, "Max upload rate of each project, e.g. 20MB/s")
+	importBatchCmd.Flags().StringVar(&uploadWindow, "upload-window", uploadWindow, "Daily time window for uploading in local time, e.g. 22:00-06:00")
+	importBatchCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display more info of operation")
+	errors.Must(importBatchCmd.MarkFlagRequired("file"))
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/asdine/storm"
+	"github.com/jackytck/alti-cli/db"
+	"github.com/jackytck/alti-cli/file"
+	"github.com/jackytck/alti-cli/types"
+	"github.com/jackytck/alti-cli/web"
+)
+
+var maxMegapixels float64
+var maxGPTotal float64
+var pngToJPEG bool
+
+// downscaleSum sums up the images to be downscaled or converted.
+type downscaleSum struct {
+	Count    int
+	Original float64 // GP before downscaling
+	Reduced  float64 // GP after downscaling
+}
+
+// downscaleEnabled tells if any image could be downscaled or converted.
+func downscaleEnabled() bool {
+	return maxMegapixels > 0 || maxGPTotal > 0 || pngToJPEG
+}
+
+// planDownscale decides the downscaled dimension of each digested image of the
+// session, so that each has at most --max-megapixels and all the pending ones
+// have at most --max-gp-total, counting the ones registered or planned in a
+// previous session as they are. It returns the GP reduced by this plan, and
+// the sum of all the pending images to be downscaled or converted.
+func planDownscale(localDB *storm.DB) (float64, downscaleSum, error) {
+	var sum downscaleSum
+	var todo []db.Image
+	var fixedGP float64
+	imgc, errc := db.AllImage(localDB)
+	for img := range imgc {
+		if !isPendingImage(img) {
+			continue
+		}
+		if img.Stage == db.StageDigested && img.OriginalGP == 0 && img.UploadPath == "" {
+			todo = append(todo, img)
+		} else {
+			fixedGP += img.GP
+		}
+	}
+	if err := <-errc; err != nil {
+		return 0, sum, err
+	}
+
+	budget := maxGPTotal
+	if budget > 0 {
+		budget -= fixedGP
+		if budget <= 0 {
+			return 0, sum, fmt.Errorf("the %.2f GP of the images registered in the previous session already exceeds --max-gp-total %.2f", fixedGP, maxGPTotal)
+		}
+	}
+	dims := make([][2]int, len(todo))
+	for i, img := range todo {
+		dims[i] = [2]int{img.Width, img.Height}
+	}
+	var saved float64
+	for i, f := range file.DownscaleFactors(dims, maxMegapixels, budget) {
+		img := todo[i]
+		if f >= 1 && !(pngToJPEG && img.Filetype == "PNG") {
+			continue
+		}
+		img.OriginalGP = img.GP
+		img.Width, img.Height = file.ScaledDim(img.Width, img.Height, f)
+		img.GP = file.DimToGigaPixel(img.Width, img.Height)
+		if err := db.SaveImage(localDB, &img); err != nil {
+			return 0, sum, err
+		}
+		saved += img.OriginalGP - img.GP
+	}
+
+	imgc, errc = db.AllImage(localDB)
+	for img := range imgc {
+		if isPendingImage(img) && img.OriginalGP > 0 {
+			sum.Count++
+			sum.Original += img.OriginalGP
+			sum.Reduced += img.GP
+		}
+	}
+	return saved, sum, <-errc
+}
+
+// downscaleImages forwards the images from in, writing the downscaled copy of
+// each planned one to dir first, and adding it to served if not nil. The copy
+// of a previous session is reused if it still exists. The checksum of each
+// copy is kept in cache if not nil, for a later import to tell that the image
+// is already in the project. The images that fail are saved with the error
+// and not forwarded.
+func downscaleImages(done <-chan struct{}, in <-chan db.Image, localDB *storm.DB, dir string, served *web.CacheFiles, cache *file.DigestCache) <-chan db.Image {
+	out := make(chan db.Image)
+	var wg sync.WaitGroup
+	// decoding a full image is memory hungry, so not more than the cores
+	n := runtime.NumCPU()
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for img := range in {
+				if img.OriginalGP > 0 {
+					orig := img
+					var err error
+					img, err = downscaleImage(img, dir, served)
+					if err == nil && cache != nil && img.Hash != orig.Hash {
+						cache.SetDownscaled(orig.LocalPath, orig.Hash, img.Hash)
+					}
+					if err != nil {
+						log.Printf("Could not downscale %q: %v\n", img.LocalPath, err)
+						img.Error = err.Error()
+						saveImage(localDB, &img)
+						continue
+					}
+					saveImage(localDB, &img)
+				}
+				select {
+				case out <- img:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// downscaleImage writes the downscaled copy of img to dir, and updates img to
+// be of the copy.
+func downscaleImage(img db.Image, dir string, served *web.CacheFiles) (db.Image, error) {
+	if img.UploadPath != "" {
+		if _, err := os.Stat(img.UploadPath); err == nil {
+			if served != nil {
+				served.Add(filepath.Base(img.UploadPath), img.UploadPath)
+			}
+			return img, nil
+		}
+	}
+	filetype := file.TypeJPEG
+	if img.Filetype == "PNG" {
+		filetype = file.TypePNG
+	}
+	ext := ".jpg"
+	if file.DownscaleType(filetype, pngToJPEG) == file.TypePNG {
+		ext = ".png"
+	}
+	name := fmt.Sprintf("%s-%dx%d%s", img.Hash, img.Width, img.Height, ext)
+	dst := filepath.Join(dir, name)
+	scan, err := file.Downscale(img.LocalPath, dst, img.Width, img.Height, pngToJPEG)
+	if err != nil {
+		return img, err
+	}
+	if served != nil {
+		served.Add(name, dst)
+	}
+
+	img.UploadPath = dst
+	img.URL = strings.TrimPrefix(web.CachePrefix, "/") + name
+	img.Hash = scan.SHA1
+	img.Filesize = scan.Filesize
+	img.Filetype = types.ConvertToImageType(scan.Filetype)
+	img.Width = scan.Width
+	img.Height = scan.Height
+	img.GP = file.DimToGigaPixel(scan.Width, scan.Height)
+	if e := filepath.Ext(img.Filename); ext == ".jpg" && strings.EqualFold(e, ".png") {
+		img.Filename = strings.TrimSuffix(img.Filename, e) + ext
+	}
+	return img, nil
+}
+
+// downscaleDir returns the directory of the downscaled copies of the session of
+// the pid and source.
+func downscaleDir(pid, source string) (string, error) {
+	p, err := db.SessionPath(pid, source)
+	if err != nil {
+		return "", err
+	}
+	return file.DownscaleDir(strings.TrimSuffix(filepath.Base(p), ".db")), nil
+}
+
+// removeDownscaled removes the downscaled copies of the images of the session,
+// and their directory dir if it is empty.
+func removeDownscaled(localDB *storm.DB, dir string) {
+	imgc, errc := db.AllImage(localDB)
+	for img := range imgc {
+		if img.UploadPath != "" {
+			os.Remove(img.UploadPath)
+		}
+	}
+	if err := <-errc; err != nil {
+		log.Println(err)
+	}
+	os.Remove(dir)
+}
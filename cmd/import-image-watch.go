@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/jackytck/alti-cli/cloud"
+	"github.com/jackytck/alti-cli/db"
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
+	"github.com/jackytck/alti-cli/gql"
+	"github.com/jackytck/alti-cli/types"
+)
+
+var watchMode bool
+var watchSettle = time.Second * 5
+var watchPoll time.Duration
+
+// defaultWatchPoll is the interval of walking the directory if it could not
+// be watched by notification.
+const defaultWatchPoll = time.Second * 5
+
+// imageWatch uploads the images of a directory continuously as they are
+// copied in, e.g. from the SD cards of each flight, until Ctrl+C.
+type imageWatch struct {
+	project  *types.Project
+	filter   file.Filter
+	db       *storm.DB
+	known    map[string]bool
+	digester file.ImageDigester
+	uploader cloud.ImageRegUploader
+	// quit saves the progress and exits, on a second Ctrl+C
+	quit func()
+
+	mu       sync.Mutex
+	found    int
+	existed  int
+	invalid  int
+	uploaded int
+	failed   int
+	gp       float64
+	usdPerGP float64
+}
+
+// run watches, digests and uploads the new images, and the pending ones of a
+// resumed session first, until Ctrl+C. Then it waits for the images in
+// progress and checks their states. Return true if all of them are ready.
+func (w *imageWatch) run() bool {
+	// the first ctrl+c stops watching, the second one quits
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	var stopped bool
+	release := onInterrupt(func() {
+		if stopped {
+			w.quit()
+			return
+		}
+		stopped = true
+		fmt.Println()
+		log.Println("Stopping... waiting for the images in progress, press Ctrl+C again to quit now")
+		close(stop)
+	})
+
+	if usd, err := gql.CoinsToMoney(1, "USD"); err == nil {
+		w.usdPerGP = usd
+	}
+
+	// a. watch and digest
+	interval := watchPoll
+	if interval <= 0 {
+		interval = defaultWatchPoll
+	}
+	dir := w.digester.Root
+	paths, errc := file.WatchFiles(stop, dir, w.filter, watchSettle, interval, watchPoll > 0)
+	result := make(chan file.ImageDigest)
+	w.digester.Done = done
+	w.digester.Paths = skipPaths(done, paths, dir, w.known)
+	w.digester.Result = result
+	w.digester.Run(thread)
+	log.Printf("Watching %s for new images, press Ctrl+C to stop...\n", dir)
+
+	// b. register and upload incrementally
+	images := make(chan db.Image)
+	ruRes := make(chan db.Image)
+	w.uploader.Images = images
+	w.uploader.Done = done
+	w.uploader.Result = ruRes
+	w.uploader.Run(thread)
+	go w.feed(images, result)
+
+	for img := range ruRes {
+		saveImage(w.db, &img)
+		w.mu.Lock()
+		if img.Error != "" {
+			w.failed++
+			log.Printf("Registration failed: %q, Reason: %s\n", img.Filename, img.Error)
+		} else {
+			w.uploaded++
+			log.Printf("Uploaded %q\n", img.Filename)
+		}
+		log.Println(w.tally())
+		w.mu.Unlock()
+	}
+	if err := <-errc; err != nil {
+		log.Println(err)
+	}
+	release()
+
+	// c. check the states of the uploaded ones
+	if w.found == 0 {
+		log.Println("No new image is found!")
+		return true
+	}
+	okCnt, errCnt := checkImageStates(done, w.db, w.found)
+	log.Printf("%d out of %d images are uploaded and ready.", okCnt, w.found)
+	if errCnt > 0 {
+		log.Printf("%d images failed. Please try again later.", errCnt)
+	}
+	return errCnt == 0
+}
+
+// feed sends the pending images of the session, then saves and sends each
+// new image digested from result.
+func (w *imageWatch) feed(images chan<- db.Image, result <-chan file.ImageDigest) {
+	defer close(images)
+
+	var pending []db.Image
+	imgc, errc := db.AllImage(w.db)
+	for img := range imgc {
+		if isPendingImage(img) {
+			pending = append(pending, img)
+		}
+	}
+	errors.Must(<-errc)
+	for _, img := range pending {
+		w.add(img)
+		images <- img
+	}
+
+	for r := range result {
+		if r.Error != nil {
+			log.Printf("Invalid image: %q, Reason: %v", r.Path, r.Error)
+			w.mu.Lock()
+			w.invalid++
+			w.mu.Unlock()
+			continue
+		}
+		if r.Existed {
+			w.mu.Lock()
+			w.existed++
+			w.mu.Unlock()
+			continue
+		}
+		img := db.Image{
+			PID:       w.project.ID,
+			Filename:  r.Filename,
+			Filetype:  types.ConvertToImageType(r.Filetype),
+			URL:       r.URL,
+			LocalPath: r.Path,
+			Hash:      r.SHA1,
+			Filesize:  r.Filesize,
+			Stage:     db.StageDigested,
+			Width:     r.Width,
+			Height:    r.Height,
+			GP:        r.GP,
+		}
+		saveImage(w.db, &img)
+		w.add(img)
+		if verbose {
+			log.Printf("Found %q, Dimension: %d x %d, GP: %.2f\n", r.Path, r.Width, r.Height, r.GP)
+		}
+		images <- img
+	}
+}
+
+// add counts an image to be uploaded.
+func (w *imageWatch) add(img db.Image) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.found++
+	w.gp += img.GP
+}
+
+// tally summarizes the progress and the cost so far.
+func (w *imageWatch) tally() string {
+	s := fmt.Sprintf("Uploaded %d of %d images (%d failed, %d existed, %d invalid), GP: %.2f -> %.2f (+%.2f coins)",
+		w.uploaded, w.found, w.failed, w.existed, w.invalid, w.project.GigaPixel, w.project.GigaPixel+w.gp, w.gp)
+	if w.usdPerGP > 0 {
+		s += fmt.Sprintf(", PRO: USD $%.2f", w.gp*w.usdPerGP)
+	}
+	return s
+}
@@ -1,15 +1,14 @@
 package cmd
 
 import (
-	"encoding/csv"
 	"fmt"
 	"log"
 	"os"
-	"os/signal"
+	"path/filepath"
 	"strings"
-	"syscall"
 	"time"
 
+	"github.com/asdine/storm"
 	"github.com/c2h5oh/datasize"
 	"github.com/jackytck/alti-cli/cloud"
 	"github.com/jackytck/alti-cli/db"
@@ -25,13 +24,38 @@ import (
 var method string
 var bucket string
 var report string
+var reportFormat string
 var assumeYes bool
+var resume bool
+var limitRate string
+var uploadWindow string
+var retries = -1
+var skipLowQuality bool
+var retryDelay time.Duration
+var retryMaxElapsed time.Duration
+var imageURLs string
+var fromS3 string
+var s3Endpoint string
+var s3Region string
+var s3Profile string
 
 // importImageCmd represents the importImage command
 var importImageCmd = &cobra.Command{
 	Use:   "image",
-	Short: "Import images from a directory into a project",
-	Long:  "Check and upload images into a project.",
+	Short: "Import images from a directory, a list of urls or a s3 bucket into a project",
+	Long: `Check and upload images into a project.
+The images could also be read from a zip, tar or tar.gz archive without
+extracting it.
+With --urls, the images are streamed from a list of http or https urls for
+their digests without being stored locally, then the urls are registered
+directly for the api server to pull.
+With --from, the images are listed from a S3 compatible bucket, e.g. AWS S3
+or MinIO, and presigned urls of them are registered in the same way.
+With --watch, the directory is watched for new images, which are uploaded
+as soon as they are copied in completely, until Ctrl+C.
+With --max-megapixels or --max-gp-total, the images are downscaled into a
+temp directory before uploading to reduce the cost, keeping their EXIF and
+XMP, e.g. the gps.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		start := time.Now()
 		defer func() {
@@ -42,14 +66,97 @@ var importImageCmd = &cobra.Command{
 		}()
 
 		// pre-checks general
-		meth, mOK := service.SuggestUploadMethod(method, "image")
-		if err := service.Check(
-			nil,
-			service.CheckAPIServer(),
-			service.CheckUploadMethod("image", meth, ip, port, mOK),
-			service.CheckPID("image", id),
-			service.CheckDir(dir),
-		); err != nil {
+		if countSet(dir, imageURLs, fromS3) > 1 {
+			log.Println("Only one of --dir, --urls and --from could be set")
+			return
+		}
+		if watchMode && dir == "" {
+			log.Println("--watch could only be used with --dir")
+			return
+		}
+		byURL := imageURLs != "" || fromS3 != ""
+		filter, err := walkFilter()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if report != "" {
+			if _, err := db.ReportFormat(report, reportFormat); err != nil {
+				log.Println(err)
+				return
+			}
+		}
+		if downscaleEnabled() && (byURL || watchMode) {
+			log.Println("--max-megapixels, --max-gp-total and --png-to-jpeg could only be used with --dir without --watch")
+			return
+		}
+		var meth string
+		var checks []service.CheckFn
+		source := dir
+		if byURL {
+			// the api server pulls each url by itself
+			checks = []service.CheckFn{
+				service.CheckAPIServer(),
+				service.CheckURLUpload(method),
+				service.CheckPID("image", id),
+			}
+			if imageURLs != "" {
+				checks = append(checks, service.CheckFile(imageURLs))
+			}
+			meth = service.DirectUploadMethod
+			source = imageURLs + fromS3
+		} else {
+			m, mOK := service.SuggestUploadMethod(method, "image")
+			checks = []service.CheckFn{
+				service.CheckAPIServer(),
+				service.CheckUploadMethod("image", m, ip, port, mOK),
+				service.CheckPID("image", id),
+				service.CheckDirOrArchive(dir),
+			}
+			if watchMode {
+				checks[len(checks)-1] = service.CheckDir(dir)
+			}
+			meth = m
+		}
+		if err := service.Check(nil, checks...); err != nil {
+			log.Println(err)
+			return
+		}
+		defer file.CloseArchives()
+		if file.IsSequentialArchive(dir) {
+			log.Println("Members of a gzipped tar could only be read in order, working in 1 thread")
+			thread = 1
+		}
+		var entries []file.URLEntry
+		if imageURLs != "" {
+			es, err := file.ReadURLList(imageURLs)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			entries = es
+		}
+		if fromS3 != "" {
+			src, err := cloud.NewS3Source(fromS3, s3Endpoint, s3Region, s3Profile)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			log.Printf("Listing %s...\n", fromS3)
+			es, err := src.Entries(skip)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			log.Printf("Found %d objects\n", len(es))
+			entries = es
+		}
+		if err := setupUploadLimit(); err != nil {
+			log.Println(err)
+			return
+		}
+		rp, err := retryPolicy()
+		if err != nil {
 			log.Println(err)
 			return
 		}
@@ -57,11 +164,15 @@ var importImageCmd = &cobra.Command{
 		// get pid
 		p, _ := gql.SearchProjectID(id, true)
 
-		// setup direct upload server
+		// setup direct upload server, which also serves the downscaled copies
+		downDir, err := downscaleDir(p.ID, source)
+		errors.Must(err)
+		var served *web.CacheFiles
 		var serDone func()
 		var baseURL string
-		if meth == service.DirectUploadMethod {
-			bu, done, err := web.StartLocalServer(dir, ip, port, false)
+		if meth == service.DirectUploadMethod && !byURL {
+			served = web.NewCacheFiles()
+			bu, done, err := web.StartLocalServerWithCache(dir, served, ip, port, false)
 			errors.Must(err)
 			defer done()
 			serDone = done
@@ -79,71 +190,230 @@ var importImageCmd = &cobra.Command{
 			log.Printf("Bucket %q is chosen", bucket)
 		}
 
-		// stats
-		log.Printf("Checking %s...\n", dir)
-		var totalGP float64
-		var totalImg int
-		var totalByte datasize.ByteSize
-		var existedCnt int
-
-		// setup image digester
-		done := make(chan struct{})
-		defer close(done)
-
-		paths, errc := file.WalkFiles(done, dir, skip)
-		result := make(chan file.ImageDigest)
-
-		digester := file.ImageDigester{
-			Root:   dir,
-			PID:    p.ID,
-			Done:   done,
-			Paths:  paths,
-			Result: result,
-		}
-		threads := digester.Run(thread)
-		if verbose {
-			log.Printf("Working in %d thread(s)...", threads)
-		}
-
-		// setup local temp db
-		dbPath, err := db.OpenPath()
-		if err != nil {
-			panic(err)
+		// setup persistent session db
+		hasSess, err := db.HasSession(p.ID, source)
+		errors.Must(err)
+		if resume && !hasSess {
+			log.Printf("No session could be found for project %q and source %q\n", p.ID, source)
+			return
 		}
-		localDB, err := db.OpenDB(dbPath)
-		if err != nil {
-			panic(err)
+		if !resume && hasSess && !confirmDiscardSession(p.ID, source) {
+			log.Println("Cancelled. Use --resume to continue the session instead.")
+			return
 		}
-		err = localDB.Init(&db.Image{})
-		if err != nil {
-			panic(err)
+		localDB, dbPath, sess, err := db.OpenSession(p.ID, source)
+		errors.Must(err)
+		sess.Method = meth
+		sess.Bucket = bucket
+		sess.Updated = time.Now()
+		errors.Must(localDB.Save(sess))
+
+		// keep the session for resuming unless everything is done
+		finished := false
+		resumeHint := func() {
+			src := fmt.Sprintf("-d %q", dir)
+			if imageURLs != "" {
+				src = fmt.Sprintf("--urls %q", imageURLs)
+			}
+			if fromS3 != "" {
+				src = fmt.Sprintf("--from %q", fromS3)
+			}
+			log.Printf("To resume, type: 'alti-cli import image -p %s %s --resume'\n", id, src)
 		}
 		cleanupDB := func() {
-			err2 := localDB.Close()
-			if err2 != nil {
-				panic(err2)
+			if finished {
+				removeDownscaled(localDB, downDir)
 			}
-			err2 = os.Remove(dbPath)
-			if err2 != nil {
-				panic(err2)
+			errors.Must(db.CloseSession(localDB))
+			if finished {
+				errors.Must(os.Remove(dbPath))
 			}
 		}
 		defer cleanupDB()
 
-		// capture ctrl+c
-		cc := make(chan os.Signal)
-		signal.Notify(cc, os.Interrupt, syscall.SIGTERM)
-		go func() {
-			<-cc
+		// local digest cache
+		cache := openDigestCache(noCache)
+		defer saveDigestCache(cache)
+
+		// capture ctrl+c, the workers stop at their next save once the db is
+		// closed
+		quit := func() {
 			cleanupDB()
+			saveDigestCache(cache)
 			if serDone != nil {
 				serDone()
 			}
 			fmt.Println()
+			log.Println("Progress is saved.")
+			resumeHint()
 			log.Println("Bye!")
 			os.Exit(1)
-		}()
+		}
+		if !watchMode {
+			defer onInterrupt(quit)()
+		}
 
+		// stats
+		log.Printf("Checking %s...\n", source)
+		var totalGP float64
+		var totalImg int
+		var totalByte datasize.ByteSize
+		var existedCnt, invalidCnt int
+
+		// images digested in the previous session, keyed by their paths
+		// relative to dir, or the paths of their url entries
+		key := func(p string) string { return relPath(dir, p) }
+		if byURL {
+			key = func(p string) string { return p }
+		}
+		// urls that are just presigned replace the ones of the previous session
+		fresh := make(map[string]string)
+		for _, e := range entries {
+			fresh[e.Path()] = e.URL
+		}
+		known := make(map[string]bool)
+		if resume {
+			imgc, errc := db.AllImage(localDB)
+			for img := range imgc {
+				known[key(img.LocalPath)] = true
+				switch img.State {
+				case service.Ready:
+					existedCnt++
+				case "Invalid":
+					invalidCnt++
+				default:
+					totalGP += img.GP
+					totalImg++
+					totalByte += datasize.ByteSize(img.Filesize)
+					// retry the failed one, with a fresh url if any
+					u, ok := fresh[img.LocalPath]
+					if img.Error != "" || (ok && u != img.URL) {
+						img.Error = ""
+						if ok {
+							img.URL = u
+						}
+						saveImage(localDB, &img)
+					}
+				}
+			}
+			if err = <-errc; err != nil {
+				panic(err)
+			}
+			log.Printf("Resuming session with %d digested images...\n", len(known))
+		}
+
+		// index the checksums of existing images for duplicate detection
+		existing, err := gql.ImageChecksumSet(p.ID)
+		if err != nil {
+			log.Printf("Could not index the existing images, checking one by one instead: %v\n", err)
+			existing = nil
+		} else if verbose {
+			log.Printf("Indexed %d existing images\n", len(existing))
+		}
+
+		// upload continuously until ctrl+c
+		if watchMode {
+			if quality || skipLowQuality {
+				log.Println("Quality analysis is not supported in watch mode, skipped")
+			}
+			w := imageWatch{
+				project: p,
+				filter:  filter,
+				db:      localDB,
+				known:   known,
+				digester: file.ImageDigester{
+					Root:     dir,
+					PID:      p.ID,
+					Existing: existing,
+					Cache:    cache,
+				},
+				uploader: cloud.ImageRegUploader{
+					Method:  meth,
+					Bucket:  bucket,
+					BaseURL: baseURL,
+					Verbose: verbose,
+					Retry:   rp,
+					OnRegistered: func(img db.Image) {
+						saveImage(localDB, &img)
+					},
+				},
+				quit: quit,
+			}
+			if meth == "oss" {
+				errors.Must(w.uploader.WithOSSUploader(p.ID))
+			}
+			fmt.Printf("Continue to import the images in %q as they are copied in or not? (Y/N): ", dir)
+			if assumeYes {
+				fmt.Println("Yes")
+			} else {
+				var ans string
+				fmt.Scanln(&ans)
+				ans = strings.ToUpper(ans)
+				if ans != "Y" && ans != service.Yes {
+					log.Println("Cancelled.")
+					return
+				}
+			}
+			finished = w.run()
+			if report != "" {
+				writeUploadReport(localDB, *sess, p, start, report)
+			}
+			if !finished {
+				resumeHint()
+			}
+			log.Printf("To inspect more, type: 'alti-cli myproj inspect -p %v'\n", id)
+			return
+		}
+
+		// setup image digester
+		done := make(chan struct{})
+		defer close(done)
+
+		result := make(chan file.ImageDigest)
+		var errc <-chan error
+		var digester file.ImageDigester
+		var threads int
+		if byURL {
+			if quality || skipLowQuality {
+				log.Println("Quality analysis is not supported for urls, skipped")
+			}
+			urlDigester := file.URLDigester{
+				PID:      p.ID,
+				Existing: existing,
+				Done:     done,
+				URLs:     skipURLs(done, entries, known),
+				Result:   result,
+			}
+			threads = urlDigester.Run(thread)
+			walkErr := make(chan error)
+			close(walkErr)
+			errc = walkErr
+		} else {
+			walked, walkErr := file.WalkFilter(done, dir, filter)
+			paths := skipPaths(done, walked, dir, known)
+			digester = file.ImageDigester{
+				Root:     dir,
+				PID:      p.ID,
+				Existing: existing,
+				Cache:    cache,
+				Done:     done,
+				Paths:    paths,
+				Result:   result,
+			}
+			if quality || skipLowQuality {
+				opts := file.DefaultQualityOptions()
+				digester.Quality = &opts
+			}
+			threads = digester.Run(thread)
+			errc = walkErr
+		}
+		if verbose {
+			log.Printf("Working in %d thread(s)...", threads)
+		}
+
+		var metaSum file.MetaSummary
+		var digests []file.ImageDigest // of the new images, for quality analysis
+		var saved []db.Image
 		for r := range result {
 			if r.Error != nil {
 				log.Printf("Invalid image: %q, Reason: %v", r.Path, r.Error)
@@ -152,14 +422,15 @@ var importImageCmd = &cobra.Command{
 
 			mb := file.BytesToMB(r.Filesize)
 			if verbose {
-				log.Printf("Path: %q, URL: %q, Filename: %q, Dimension: %d x %d, GP: %.2f, Type: %s, Size: %.2f MB, Checksum: %s, Existed: %v\n",
-					r.Path, r.URL, r.Filename, r.Width, r.Height, r.GP, r.Filetype, mb, r.SHA1, r.Existed)
+				log.Printf("Path: %q, URL: %q, Filename: %q, Dimension: %d x %d, GP: %.2f, Type: %s, Size: %.2f MB, Checksum: %s, Existed: %v, %s\n",
+					r.Path, r.URL, r.Filename, r.Width, r.Height, r.GP, r.Filetype, mb, r.SHA1, r.Existed, r.Meta)
 			}
 
 			if r.Existed {
 				existedCnt++
 				continue
 			}
+			metaSum.Add(r.Meta)
 
 			totalGP += r.GP
 			totalImg++
@@ -172,13 +443,16 @@ var importImageCmd = &cobra.Command{
 				URL:       r.URL,
 				LocalPath: r.Path,
 				Hash:      r.SHA1,
+				Filesize:  r.Filesize,
+				Stage:     db.StageDigested,
 				Width:     r.Width,
 				Height:    r.Height,
 				GP:        r.GP,
 			}
-			err = localDB.Save(&img)
-			if err != nil {
-				panic(err)
+			saveImage(localDB, &img)
+			if digester.Quality != nil {
+				digests = append(digests, r)
+				saved = append(saved, img)
 			}
 		}
 
@@ -187,21 +461,71 @@ var importImageCmd = &cobra.Command{
 			panic(err)
 		}
 
+		// report the quality, and skip the flagged images if asked
+		if digester.Quality != nil {
+			qualitySum := flagImageQuality(digests)
+			skippedCnt := 0
+			for i, r := range digests {
+				if !r.Quality.Flagged() {
+					continue
+				}
+				log.Printf("Quality: %q is %s\n", r.Path, r.Quality)
+				if skipLowQuality {
+					errors.Must(localDB.DeleteStruct(&saved[i]))
+					totalGP -= r.GP
+					totalImg--
+					totalByte -= datasize.ByteSize(r.Filesize)
+					skippedCnt++
+				}
+			}
+			log.Printf("Quality: %s\n", qualitySum.String())
+			if skippedCnt > 0 {
+				log.Printf("Skipped %d flagged images\n", skippedCnt)
+			}
+		}
+
+		if invalidCnt > 0 {
+			log.Printf("%d images were invalid in the previous session", invalidCnt)
+		}
+		for _, w := range metaSum.Warnings() {
+			log.Printf("Warning: %s\n", w)
+		}
 		if totalImg == 0 {
+			finished = true
 			if existedCnt > 0 {
-				log.Println("No new image is found! All of the images in this directory have been imported.")
+				log.Println("No new image is found! All of the images in this source have been imported.")
 			} else {
 				log.Println("No image is found!")
 			}
+			// an empty report tells that nothing is left to import
+			if report != "" {
+				writeUploadReport(localDB, *sess, p, start, report)
+			}
 			return
 		}
 
+		// plan the downscaling
+		var down downscaleSum
+		if downscaleEnabled() {
+			saved, sum, err := planDownscale(localDB)
+			if err != nil {
+				log.Println(err)
+				resumeHint()
+				return
+			}
+			totalGP -= saved
+			down = sum
+		}
+
 		// ask user to proceed or not
 		var ans string
 		if existedCnt > 0 {
 			log.Printf("%d images already existed in the project", existedCnt)
 		}
 		log.Printf("Found %d images, total %.2f GP, %s", totalImg, totalGP, totalByte.HumanReadable())
+		if down.Count > 0 {
+			log.Printf("Downscaling %d images before upload, GP: %.2f (original) -> %.2f (reduced)", down.Count, down.Original, down.Reduced)
+		}
 		plural := ""
 		if totalImg > 1 {
 			plural = "s"
@@ -211,6 +535,14 @@ var importImageCmd = &cobra.Command{
 			panic(err)
 		}
 		fmt.Printf("After importing (if no duplicate):\nImages #: %d -> %d\tGP: %.2f -> %.2f\tPRO: USD $%.2f\n", p.NumImage, p.NumImage+totalImg, p.GigaPixel, p.GigaPixel+totalGP, usd)
+		if down.Count > 0 {
+			origGP := totalGP + down.Original - down.Reduced
+			origUSD, err := gql.CoinsToMoney(origGP, "USD")
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("Without downscaling:\nImages #: %d -> %d\tGP: %.2f -> %.2f\tPRO: USD $%.2f\n", p.NumImage, p.NumImage+totalImg, p.GigaPixel, p.GigaPixel+origGP, origUSD)
+		}
 		fmt.Printf("Continue to import %d image%s or not? (Y/N): ", totalImg, plural)
 		if assumeYes {
 			fmt.Println("Yes")
@@ -219,21 +551,28 @@ var importImageCmd = &cobra.Command{
 			ans = strings.ToUpper(ans)
 			if ans != "Y" && ans != service.Yes {
 				log.Println("Cancelled.")
+				resumeHint()
 				return
 			}
 		}
 
 		// read from local db, register and upload
 		imgc, errc := db.AllImage(localDB)
+		// the planned images are downscaled just before uploading
+		pending := downscaleImages(done, filterImages(done, imgc, isPendingImage), localDB, downDir, served, cache)
 		ruRes := make(chan db.Image)
 		ruDigester := cloud.ImageRegUploader{
 			Method:  meth,
 			Bucket:  bucket,
 			BaseURL: baseURL,
-			Images:  imgc,
+			Images:  pending,
 			Done:    done,
 			Result:  ruRes,
 			Verbose: verbose,
+			Retry:   rp,
+			OnRegistered: func(img db.Image) {
+				saveImage(localDB, &img)
+			},
 		}
 		if meth == "oss" {
 			err2 := ruDigester.WithOSSUploader(p.ID)
@@ -241,15 +580,20 @@ var importImageCmd = &cobra.Command{
 				panic(err2)
 			}
 		}
+		stopProgress := startProgress("Uploading")
 		ruDigester.Run(thread)
 
 		regFailCnt := 0
+		retryCnt := 0
 		for img := range ruRes {
-			err = localDB.Save(&img)
+			saveImage(localDB, &img)
+			if img.Error != "" {
+				regFailCnt++
+			}
+			retryCnt += img.Retries
 			if verbose {
 				if img.Error != "" {
 					log.Printf("Registration failed: %q\n", img.Error)
-					regFailCnt++
 				} else {
 					if meth == service.DirectUploadMethod {
 						log.Printf("Registered %q\n", img.Filename)
@@ -258,102 +602,258 @@ var importImageCmd = &cobra.Command{
 					}
 				}
 			}
-			if err != nil {
-				panic(err)
-			}
 		}
 
+		stopProgress()
+
 		// check whether the read from local db failed
 		if err = <-errc; err != nil {
 			panic(err)
 		}
+		if retryCnt > 0 {
+			log.Printf("Retried %d times in total.\n", retryCnt)
+		}
 		if regFailCnt == totalImg {
 			log.Println("You run out of luck! All images failed to register!")
+			resumeHint()
 			return
 		}
 
 		// check for image state: Ready / Invalid / Client timeout
-		log.Println("Checking image states....")
-		imgc, errc = db.AllImage(localDB)
-		checkerRes := make(chan db.Image)
-		checker := cloud.ImageStateChecker{
-			Images:  imgc,
-			Done:    done,
-			Result:  checkerRes,
-			Timeout: time.Minute * time.Duration(timeout),
-		}
-		checker.Run(thread)
-
-		var okCnt, errCnt int
-		for img := range checkerRes {
-			err = localDB.Save(&img)
-			if verbose {
-				if img.Error != "" || img.State == "Invalid" {
-					errCnt++
-					log.Printf("Image upload error: %q\n", img.Error)
-				} else {
-					okCnt++
-					log.Printf("Image %q is %q\n", img.Filename, img.State)
-				}
-			}
-			if err != nil {
-				panic(err)
-			}
-		}
-
-		// check whether the read from local db failed
-		if err = <-errc; err != nil {
-			panic(err)
-		}
-
+		okCnt, errCnt := checkImageStates(done, localDB, totalImg)
 		log.Printf("%d out of %d images are uploaded and ready.", okCnt, totalImg)
 		if errCnt > 0 {
 			log.Printf("%d images failed. Please try again later.", errCnt)
+			resumeHint()
+		} else {
+			finished = true
 		}
 		log.Printf("To inspect more, type: 'alti-cli myproj inspect -p %v'\n", id)
 
 		// generate report of uploading
 		if report != "" {
-			log.Println("Generating csv upload report...")
-			out, err := os.Create(report)
-			errors.Must(err)
+			writeUploadReport(localDB, *sess, p, start, report)
+		}
+	},
+}
+
+// checkImageStates waits for each pending image of the session to be Ready
+// or Invalid, or the client to timeout, and returns the number of ok and
+// failed images. n is the number of pending images.
+func checkImageStates(done <-chan struct{}, localDB *storm.DB, n int) (okCnt, errCnt int) {
+	log.Println("Checking image states....")
+	imgc, errc := db.AllImage(localDB)
+	checkerRes := make(chan db.Image)
+	checker := cloud.ImageStateChecker{
+		Images:  filterImages(done, imgc, isPendingImage),
+		Done:    done,
+		Result:  checkerRes,
+		Timeout: time.Minute * time.Duration(timeout),
+	}
+	// waiting is cheap as states are polled in batch, so wait for all at once
+	checker.Run(n)
+
+	for img := range checkerRes {
+		saveImage(localDB, &img)
+		if img.Error != "" || img.State == "Invalid" {
+			errCnt++
+		} else {
+			okCnt++
+		}
+		if verbose {
+			if img.Error != "" || img.State == "Invalid" {
+				log.Printf("Image upload error: %q\n", img.Error)
+			} else {
+				log.Printf("Image %q is %q\n", img.Filename, img.State)
+			}
+		}
+	}
 
-			defer out.Close()
-			writer := csv.NewWriter(out)
+	// check whether the read from local db failed
+	if err := <-errc; err != nil {
+		panic(err)
+	}
+	return okCnt, errCnt
+}
 
-			err = writer.Write([]string{"Filename", "State", "Error"})
-			errors.Must(err)
-			imgc, errc = db.AllImage(localDB)
-			for img := range imgc {
-				err = writer.Write([]string{img.Filename, img.State, img.Error})
-				if err != nil {
-					panic(err)
-				}
+// writeUploadReport writes the report of each image of the session and the
+// summary of this run started at start to the file at p, in the format of
+// --report-format, or by the extension of p.
+func writeUploadReport(localDB *storm.DB, sess db.Session, proj *types.Project, start time.Time, p string) {
+	format, err := db.ReportFormat(p, reportFormat)
+	errors.Must(err)
+	log.Printf("Generating %s upload report...\n", format)
+	r, err := db.BuildReport(localDB, sess, start)
+	errors.Must(err)
+	r.Summary.Project = proj.Name
+	if usd, err := gql.CoinsToMoney(r.Summary.Coins, "USD"); err == nil {
+		r.Summary.USD = usd
+	} else {
+		log.Printf("Could not convert the cost to USD: %v\n", err)
+	}
+
+	out, err := os.Create(p)
+	errors.Must(err)
+	defer out.Close()
+	errors.Must(r.Write(out, format))
+}
+
+// saveImage saves the image in the session db. Once the session is closed by
+// ctrl+c, it blocks the caller until quit exits, instead of panicking before
+// the progress is reported.
+func saveImage(sdb *storm.DB, img *db.Image) {
+	err := db.SaveImage(sdb, img)
+	if err == errors.ErrSessionClosed {
+		select {}
+	}
+	errors.Must(err)
+}
+
+// confirmDiscardSession tells the progress of the unfinished session of the
+// pid and source, and discards it if the user agrees.
+func confirmDiscardSession(pid, source string) bool {
+	sp, err := db.SessionPath(pid, source)
+	errors.Must(err)
+	if s, err := db.SummarizeSession(sp); err == nil {
+		log.Printf("Found an unfinished session of %d images, %d ready and %d failed, last updated at %s\n", s.Total, s.Ready, s.Failed, s.Session.Updated.Format("2006-01-02 15:04:05"))
+	} else {
+		log.Printf("Found an unfinished session: %v\n", err)
+	}
+	fmt.Print("Continue to discard it and start over or not? (Y/N): ")
+	if assumeYes {
+		fmt.Println("Yes")
+	} else {
+		var ans string
+		fmt.Scanln(&ans)
+		ans = strings.ToUpper(ans)
+		if ans != "Y" && ans != service.Yes {
+			return false
+		}
+	}
+	errors.Must(db.DiscardSession(pid, source))
+	return true
+}
+
+// relPath gives the path of p relative to root, or p itself if it could not
+// be determined.
+func relPath(root, p string) string {
+	r, err := filepath.Rel(root, p)
+	if err != nil {
+		return p
+	}
+	return r
+}
+
+// skipPaths forwards the paths from in, skipping the known ones which are
+// keyed by their paths relative to root.
+func skipPaths(done <-chan struct{}, in <-chan string, root string, known map[string]bool) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for p := range in {
+			if known[relPath(root, p)] {
+				continue
 			}
-			writer.Flush()
+			select {
+			case out <- p:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
 
-			// check whether the read from local db failed
-			if err = <-errc; err != nil {
-				panic(err)
+// skipURLs sends the url entries, skipping the known ones which are keyed by
+// their paths.
+func skipURLs(done <-chan struct{}, entries []file.URLEntry, known map[string]bool) <-chan file.URLEntry {
+	out := make(chan file.URLEntry)
+	go func() {
+		defer close(out)
+		for _, e := range entries {
+			if known[e.Path()] {
+				continue
+			}
+			select {
+			case out <- e:
+			case <-done:
+				return
 			}
 		}
-	},
+	}()
+	return out
+}
+
+// countSet counts the non-empty values.
+func countSet(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// filterImages forwards the images from in that satisfy keep.
+func filterImages(done <-chan struct{}, in <-chan db.Image, keep func(db.Image) bool) <-chan db.Image {
+	out := make(chan db.Image)
+	go func() {
+		defer close(out)
+		for img := range in {
+			if !keep(img) {
+				continue
+			}
+			select {
+			case out <- img:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// isPendingImage tells if the image is neither ready nor invalid.
+func isPendingImage(img db.Image) bool {
+	return img.State != service.Ready && img.State != "Invalid"
 }
 
 func init() {
 	importCmd.AddCommand(importImageCmd)
 	importImageCmd.Flags().StringVarP(&id, "id", "p", id, "Project id")
-	importImageCmd.Flags().StringVarP(&dir, "dir", "d", dir, "Directory path")
+	importImageCmd.Flags().StringVarP(&dir, "dir", "d", dir, "Directory path, or a zip, tar or tar.gz archive of images")
+	importImageCmd.Flags().StringVar(&imageURLs, "urls", imageURLs, "Path of a text file of image urls, or a csv file of url and optional checksum columns, instead of --dir")
+	importImageCmd.Flags().StringVar(&fromS3, "from", fromS3, "S3 url of the form s3://bucket/prefix of a S3 compatible bucket, instead of --dir")
+	importImageCmd.Flags().StringVar(&s3Endpoint, "s3-endpoint", s3Endpoint, "Endpoint of the S3 compatible server for --from, e.g. http://localhost:9000, default is AWS_ENDPOINT_URL or AWS S3")
+	importImageCmd.Flags().StringVar(&s3Region, "s3-region", s3Region, "Region of the bucket for --from, default is AWS_REGION or auto detected")
+	importImageCmd.Flags().StringVar(&s3Profile, "s3-profile", s3Profile, "Profile of the AWS shared credentials file for --from, instead of the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+	importImageCmd.Flags().BoolVar(&watchMode, "watch", watchMode, "Keep running and upload the new images of the directory as they are copied in, until Ctrl+C")
+	importImageCmd.Flags().DurationVar(&watchSettle, "watch-settle", watchSettle, "Wait for the size of a new file to stop changing for this duration before uploading it")
+	importImageCmd.Flags().DurationVar(&watchPoll, "watch-poll", watchPoll, "Walk the directory in this interval instead of being notified of new files, e.g. 10s for a network share")
+	importImageCmd.Flags().Float64Var(&maxMegapixels, "max-megapixels", maxMegapixels, "Downscale each image to at most this mega pixels before uploading, e.g. 12")
+	importImageCmd.Flags().Float64Var(&maxGPTotal, "max-gp-total", maxGPTotal, "Downscale the images evenly so that they have at most this giga pixels in total")
+	importImageCmd.Flags().BoolVar(&pngToJPEG, "png-to-jpeg", pngToJPEG, "Convert png images to high quality jpeg before uploading")
 	importImageCmd.Flags().StringVarP(&skip, "skip", "s", skip, "Regular expression to skip paths")
-	importImageCmd.Flags().StringVarP(&report, "report", "r", report, "Path of csv upload report output")
+	addFilterFlags(importImageCmd)
+	importImageCmd.Flags().StringVarP(&report, "report", "r", report, "Path of upload report output")
+	importImageCmd.Flags().StringVar(&reportFormat, "report-format", reportFormat, "Format of the upload report: 'csv', 'json' or 'html', default is by the extension of --report or csv")
 	importImageCmd.Flags().StringVarP(&method, "method", "m", method, "Desired method of upload: 'direct', 's3' or 'oss'")
 	importImageCmd.Flags().IntVarP(&timeout, "timeout", "t", timeout, "Timeout of checking upload state in seconds")
 	importImageCmd.Flags().StringVar(&ip, "ip", ip, "IP address of ad-hoc local server for direct upload.")
 	importImageCmd.Flags().StringVar(&port, "port", port, "Port of ad-hoc local server for direct upload.")
 	importImageCmd.Flags().StringVarP(&bucket, "bucket", "b", bucket, "Desired bucket to upload for method: 's3' or 'oss'")
 	importImageCmd.Flags().BoolVarP(&assumeYes, "assumeyes", "y", assumeYes, "Assume yes; assume that the answer to any question which would be asked is yes")
+	importImageCmd.Flags().BoolVar(&noCache, "no-cache", noCache, "Read every image again instead of using the digest cache")
+	importImageCmd.Flags().BoolVar(&quality, "quality", quality, "Decode each image fully to report blurry, over or under exposed, truncated and undersized images")
+	importImageCmd.Flags().BoolVar(&skipLowQuality, "skip-low-quality", skipLowQuality, "Skip the images flagged by the quality analysis, implies --quality")
+	importImageCmd.Flags().BoolVar(&resume, "resume", resume, "Resume the unfinished session of the same project and directory")
+	importImageCmd.Flags().IntVar(&retries, "retry", retries, "Max number of retries of each failed upload, default is 5 or from config")
+	importImageCmd.Flags().DurationVar(&retryDelay, "retry-delay", retryDelay, "Delay before the first retry, doubled for each retry, e.g. 1s")
+	importImageCmd.Flags().DurationVar(&retryMaxElapsed, "retry-max-elapsed", retryMaxElapsed, "Give up retrying after this duration, e.g. 5m")
+	importImageCmd.Flags().StringVar(&limitRate, "limit-rate", limitRate, "Max total upload rate of all threads, e.g. 20MB/s")
+	importImageCmd.Flags().StringVar(&uploadWindow, "upload-window", uploadWindow, "Daily time window for uploading in local time, e.g. 22:00-06:00")
 	importImageCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display individual image info")
 	importImageCmd.Flags().IntVarP(&thread, "thread", "n", thread, "Number of threads to process, default is number of cores x 4")
 	errors.Must(importImageCmd.MarkFlagRequired("id"))
-	errors.Must(importImageCmd.MarkFlagRequired("dir"))
 }
@@ -4,9 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/signal"
 	"path/filepath"
-	"syscall"
 	"time"
 
 	"github.com/jackytck/alti-cli/cloud"
@@ -18,12 +16,13 @@ import (
 )
 
 var meta string
+var force bool
 
 // importMetaCmd represents the meta command
 var importMetaCmd = &cobra.Command{
 	Use:   "meta",
 	Short: "Import meta file to a project",
-	Long:  "Import meta files to a project. Recognized filenames are: camera.txt, pose.txt and group.txt.",
+	Long:  "Import meta files to a project. Recognized filenames are: camera.txt, pose.txt, group.txt, initial.xms and initial.xms.zip. The file is validated first as 'alti-cli check meta'.",
 	Run: func(cmd *cobra.Command, args []string) {
 		start := time.Now()
 		defer func() {
@@ -47,6 +46,33 @@ var importMetaCmd = &cobra.Command{
 			return
 		}
 
+		// validate the meta file against the images of --dir or its directory
+		mr, err := validateMeta(meta, dir)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if !mr.Valid() || verbose {
+			printMetaReport(mr)
+		}
+		if !mr.Valid() {
+			if !force {
+				log.Printf("%v, fix it or import it anyway with --force\n", errors.ErrMetaInvalid)
+				return
+			}
+			log.Println("Importing the invalid meta file as --force is given")
+		}
+
+		if err := setupUploadLimit(); err != nil {
+			log.Println(err)
+			return
+		}
+		rp, err := retryPolicy()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
 		// get project
 		proj, _ := gql.SearchProjectID(id, true)
 
@@ -84,13 +110,11 @@ var importMetaCmd = &cobra.Command{
 			Bucket:    bucket,
 			Timeout:   timeout,
 			Verbose:   verbose,
+			Retry:     rp,
 		}
 
 		// capture and handle ctrl+c
-		cc := make(chan os.Signal)
-		signal.Notify(cc, os.Interrupt, syscall.SIGTERM)
-		go func() {
-			<-cc
+		defer onInterrupt(func() {
 			fmt.Println()
 			if serDone != nil {
 				serDone()
@@ -98,9 +122,11 @@ var importMetaCmd = &cobra.Command{
 			errors.Must(mru.Done())
 			log.Println("Bye!")
 			os.Exit(1)
-		}()
+		})()
 
+		stopProgress := startProgress("Uploading")
 		state, err := mru.Run()
+		stopProgress()
 		if err != nil {
 			log.Println(err.Error())
 			return
@@ -114,11 +140,18 @@ func init() {
 	importCmd.AddCommand(importMetaCmd)
 	importMetaCmd.Flags().StringVarP(&id, "id", "p", id, "Project id")
 	importMetaCmd.Flags().StringVarP(&meta, "file", "f", model, "File path of meta file.")
+	importMetaCmd.Flags().StringVarP(&dir, "dir", "d", dir, "Directory of the images for checking the meta file, walked recursively, default is the directory of the meta file without its subdirectories")
 	importMetaCmd.Flags().StringVarP(&method, "method", "m", method, "Desired method of upload: 'direct' or 's3' or 'minio'")
 	importMetaCmd.Flags().IntVarP(&timeout, "timeout", "t", timeout, "Timeout of checking direct upload state in seconds")
 	importMetaCmd.Flags().StringVar(&ip, "ip", ip, "IP address of ad-hoc local server for direct upload.")
 	importMetaCmd.Flags().StringVar(&port, "port", port, "Port of ad-hoc local server for direct upload.")
 	importMetaCmd.Flags().StringVarP(&bucket, "bucket", "b", bucket, "Desired bucket to upload for method: 's3'")
+	importMetaCmd.Flags().IntVar(&retries, "retry", retries, "Max number of retries of each failed upload, default is 5 or from config")
+	importMetaCmd.Flags().DurationVar(&retryDelay, "retry-delay", retryDelay, "Delay before the first retry, doubled for each retry, e.g. 1s")
+	importMetaCmd.Flags().DurationVar(&retryMaxElapsed, "retry-max-elapsed", retryMaxElapsed, "Give up retrying after this duration, e.g. 5m")
+	importMetaCmd.Flags().StringVar(&limitRate, "limit-rate", limitRate, "Max upload rate, e.g. 20MB/s")
+	importMetaCmd.Flags().StringVar(&uploadWindow, "upload-window", uploadWindow, "Daily time window for uploading in local time, e.g. 22:00-06:00")
+	importMetaCmd.Flags().BoolVar(&force, "force", force, "Import the meta file even if it is invalid")
 	importMetaCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display more info of operation")
 	errors.Must(importMetaCmd.MarkFlagRequired("id"))
 	errors.Must(importMetaCmd.MarkFlagRequired("file"))
@@ -2,16 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
-	"os/signal"
 	"path/filepath"
-	"regexp"
-	"syscall"
 	"time"
 
 	"github.com/jackytck/alti-cli/cloud"
 	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
 	"github.com/jackytck/alti-cli/gql"
 	"github.com/jackytck/alti-cli/service"
 	"github.com/jackytck/alti-cli/web"
@@ -22,12 +21,19 @@ var model string
 var ip string
 var port string
 var timeout int
+var toOBJ = true
 
 // importModelCmd represents the importModel command
 var importModelCmd = &cobra.Command{
 	Use:   "model",
 	Short: "Import model from a local / remote path into a project",
-	Long:  "Check and upload third party model into a project.",
+	Long: `Check and upload third party model into a project. A model file of obj,
+gltf, glb, ply, fbx, las, laz or e57 is packaged with the files it refers to
+into a zip first. As the server expects a zip of obj, a gltf, glb or ply mesh
+is converted to obj unless --to-obj=false is given. A fbx and a point cloud
+are uploaded as they are. The headers of the point clouds are inspected as by
+'alti-cli check pointcloud', except in a directory of multiparts, which are
+uploaded without being checked.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		start := time.Now()
 		defer func() {
@@ -44,12 +50,20 @@ var importModelCmd = &cobra.Command{
 			service.CheckAPIServer(),
 			service.CheckUploadMethod("model", meth, ip, port, mOK),
 			service.CheckPID("model", id),
-			service.CheckFilename(model, regexp.MustCompile(`^[a-zA-Z0-9\._]*$`)),
 			service.CheckFile(model),
 		); err != nil {
 			log.Println(err)
 			return
 		}
+		if err := setupUploadLimit(); err != nil {
+			log.Println(err)
+			return
+		}
+		rp, err := retryPolicy()
+		if err != nil {
+			log.Println(err)
+			return
+		}
 
 		// determine if single or multipart upload
 		var partsDir string
@@ -58,15 +72,46 @@ var importModelCmd = &cobra.Command{
 			model = ""
 		}
 
+		// package a model file, e.g. a glb, with the files it refers to, after
+		// inspecting a point cloud file as it is rather than in the zip
+		var pkgDone func()
+		if model != "" && file.IsModelFile(model) {
+			if err := inspectPointClouds(model); err != nil {
+				log.Println(err)
+				return
+			}
+			zp, done, err := packageModelFile(model)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			defer done()
+			pkgDone = done
+			model = zp
+		}
+
 		// pre-checks for single upload
 		if model != "" {
 			if err := service.Check(
 				nil,
+				service.CheckFilename(model, file.ModelFilenamePattern),
 				service.CheckZip(model),
 			); err != nil {
 				log.Println(err)
 				return
 			}
+		} else if err := service.CheckFilename(partsDir, file.ModelFilenamePattern)(log.Printf); err != nil {
+			log.Println(err)
+			return
+		}
+
+		// inspect the point clouds of a model zip before spending time on
+		// uploading them
+		if model != "" && pkgDone == nil {
+			if err := inspectPointClouds(model); err != nil {
+				log.Println(err)
+				return
+			}
 		}
 
 		// get project
@@ -107,23 +152,27 @@ var importModelCmd = &cobra.Command{
 			MultipartDir: partsDir,
 			Timeout:      timeout,
 			Verbose:      verbose,
+			Retry:        rp,
+			Force:        force,
 		}
 
 		// capture and handle ctrl+c
-		cc := make(chan os.Signal)
-		signal.Notify(cc, os.Interrupt, syscall.SIGTERM)
-		go func() {
-			<-cc
+		defer onInterrupt(func() {
 			fmt.Println()
 			if serDone != nil {
 				serDone()
 			}
 			mru.Done()
+			if pkgDone != nil {
+				pkgDone()
+			}
 			log.Println("Bye!")
 			os.Exit(1)
-		}()
+		})()
 
+		stopProgress := startProgress("Uploading")
 		state, err := mru.Run()
+		stopProgress()
 		if err != nil {
 			log.Println(err.Error())
 			return
@@ -134,15 +183,42 @@ var importModelCmd = &cobra.Command{
 	},
 }
 
+// packageModelFile packages the model file at p with the files it refers to
+// into a zip in a temporary directory, which is removed by the returned func.
+func packageModelFile(p string) (string, func(), error) {
+	if format, err := file.DetectModelFormat(p); err == nil && format == file.ModelFBX {
+		log.Printf("Warning: %q is a fbx, which is not converted to obj but uploaded as it is\n", p)
+	}
+	tmp, err := ioutil.TempDir("", "alti-cli-model-")
+	if err != nil {
+		return "", nil, err
+	}
+	done := func() { os.RemoveAll(tmp) }
+	zp, err := file.PackageModel(p, tmp, toOBJ)
+	if err != nil {
+		done()
+		return "", nil, err
+	}
+	log.Printf("Packaged %q into %q\n", p, zp)
+	return zp, done, nil
+}
+
 func init() {
 	importCmd.AddCommand(importModelCmd)
 	importModelCmd.Flags().StringVarP(&id, "id", "p", id, "Project id")
-	importModelCmd.Flags().StringVarP(&model, "file", "f", model, "File path of model zip file or directory of multiparts zip.")
+	importModelCmd.Flags().StringVarP(&model, "file", "f", model, "File path of model zip file, model file (obj, gltf, glb, ply, fbx, las, laz, e57) or directory of multiparts zip.")
 	importModelCmd.Flags().StringVarP(&method, "method", "m", method, "Desired method of upload: 'direct' or 's3'")
 	importModelCmd.Flags().IntVarP(&timeout, "timeout", "t", timeout, "Timeout of checking direct upload state in seconds")
 	importModelCmd.Flags().StringVar(&ip, "ip", ip, "IP address of ad-hoc local server for direct upload.")
 	importModelCmd.Flags().StringVar(&port, "port", port, "Port of ad-hoc local server for direct upload.")
 	importModelCmd.Flags().StringVarP(&bucket, "bucket", "b", bucket, "Desired bucket to upload for method: 's3'")
+	importModelCmd.Flags().IntVar(&retries, "retry", retries, "Max number of retries of each failed upload, default is 5 or from config")
+	importModelCmd.Flags().DurationVar(&retryDelay, "retry-delay", retryDelay, "Delay before the first retry, doubled for each retry, e.g. 1s")
+	importModelCmd.Flags().DurationVar(&retryMaxElapsed, "retry-max-elapsed", retryMaxElapsed, "Give up retrying after this duration, e.g. 5m")
+	importModelCmd.Flags().StringVar(&limitRate, "limit-rate", limitRate, "Max upload rate, e.g. 20MB/s")
+	importModelCmd.Flags().StringVar(&uploadWindow, "upload-window", uploadWindow, "Daily time window for uploading in local time, e.g. 22:00-06:00")
+	importModelCmd.Flags().BoolVar(&toOBJ, "to-obj", toOBJ, "Convert a gltf, glb or ply mesh to obj before uploading, as the server expects; --to-obj=false uploads it as it is")
+	importModelCmd.Flags().BoolVar(&force, "force", force, "Import the model even if it fails the check of 'alti-cli check model' or 'alti-cli check pointcloud'")
 	importModelCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display more info of operation")
 	errors.Must(importModelCmd.MarkFlagRequired("id"))
 	errors.Must(importModelCmd.MarkFlagRequired("file"))
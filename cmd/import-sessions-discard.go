@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackytck/alti-cli/db"
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/service"
+	"github.com/spf13/cobra"
+)
+
+// importSessionsDiscardCmd represents the import sessions discard command
+var importSessionsDiscardCmd = &cobra.Command{
+	Use:   "discard",
+	Short: "Discard the unfinished image import sessions",
+	Long:  "Discard the image import sessions of a (partial) project id, optionally limited to a directory.",
+	Run: func(cmd *cobra.Command, args []string) {
+		var absDir string
+		if dir != "" {
+			d, err := filepath.Abs(dir)
+			errors.Must(err)
+			absDir = d
+		}
+
+		// a. find matched sessions
+		paths, err := db.SessionPaths()
+		errors.Must(err)
+		var matched []string
+		for _, p := range paths {
+			s, err := db.SummarizeSession(p)
+			if err != nil {
+				log.Printf("Could not read session %q: %v\n", p, err)
+				continue
+			}
+			if !strings.HasPrefix(s.Session.PID, id) {
+				continue
+			}
+			if absDir != "" && s.Session.Dir != absDir {
+				continue
+			}
+			log.Printf("Found session of %q in %q\n", s.Session.PID, s.Session.Dir)
+			matched = append(matched, p)
+		}
+		total := len(matched)
+		if total == 0 {
+			log.Println("No session is found!")
+			return
+		}
+
+		// b. ask user to proceed or not
+		plural := ""
+		if total > 1 {
+			plural = "s"
+		}
+		fmt.Printf("Continue to discard %d session%s or not? (Y/N): ", total, plural)
+		if assumeYes {
+			fmt.Println("Yes")
+		} else {
+			var ans string
+			fmt.Scanln(&ans)
+			ans = strings.ToUpper(ans)
+			if ans != "Y" && ans != service.Yes {
+				log.Println("Cancelled.")
+				return
+			}
+		}
+
+		// c. remove
+		for _, p := range matched {
+			errors.Must(os.Remove(p))
+		}
+		log.Printf("Discarded %d session%s.\n", total, plural)
+	},
+}
+
+func init() {
+	importSessionsCmd.AddCommand(importSessionsDiscardCmd)
+	importSessionsDiscardCmd.Flags().StringVarP(&id, "id", "p", id, "(Partial) Project id")
+	importSessionsDiscardCmd.Flags().StringVarP(&dir, "dir", "d", dir, "Directory path")
+	importSessionsDiscardCmd.Flags().BoolVarP(&assumeYes, "assumeyes", "y", assumeYes, "Assume yes; assume that the answer to any question which would be asked is yes")
+	errors.Must(importSessionsDiscardCmd.MarkFlagRequired("id"))
+}
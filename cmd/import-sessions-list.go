@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jackytck/alti-cli/db"
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// importSessionsListCmd represents the import sessions list command
+var importSessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the unfinished image import sessions",
+	Long:  "List all of the image import sessions that could be resumed by 'alti-cli import image --resume'.",
+	Run: func(cmd *cobra.Command, args []string) {
+		paths, err := db.SessionPaths()
+		errors.Must(err)
+		if len(paths) == 0 {
+			log.Println("No session is found!")
+			return
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"PID", "Directory", "Method", "Images", "Ready", "Failed", "Last updated"})
+		for _, p := range paths {
+			s, err := db.SummarizeSession(p)
+			if err != nil {
+				log.Printf("Could not read session %q: %v\n", p, err)
+				continue
+			}
+			if verbose {
+				log.Printf("Session: %q\n", s.Path)
+			}
+			table.Append([]string{
+				s.Session.PID,
+				s.Session.Dir,
+				s.Session.Method,
+				fmt.Sprintf("%d", s.Total),
+				fmt.Sprintf("%d", s.Ready),
+				fmt.Sprintf("%d", s.Failed),
+				s.Session.Updated.Format("2006-01-02 15:04:05"),
+			})
+		}
+		table.Render()
+		log.Println("To resume: 'alti-cli import image -p PID -d DIRECTORY --resume'")
+	},
+}
+
+func init() {
+	importSessionsCmd.AddCommand(importSessionsListCmd)
+	importSessionsListCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display more info of operation")
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// importSessionsCmd represents the import sessions command
+var importSessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Manage the unfinished image import sessions",
+	Long:  "Root command for listing and discarding the resumable image import sessions.",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("See alti-cli help import sessions")
+	},
+}
+
+func init() {
+	importCmd.AddCommand(importSessionsCmd)
+}
@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jackytck/alti-cli/cloud"
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/file"
+	"github.com/jackytck/alti-cli/gql"
+	"github.com/jackytck/alti-cli/manifest"
+	"github.com/jackytck/alti-cli/service"
+	"github.com/jackytck/alti-cli/types"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show what apply would change for a manifest",
+	Long: `Show the project to be created, the number of missing images of each
+source, the meta files to be uploaded, the task to be started and the cost,
+without changing anything. The images are digested to tell the missing ones.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		start := time.Now()
+		defer func() {
+			if verbose {
+				elapsed := time.Since(start)
+				log.Println("Took", elapsed)
+			}
+		}()
+
+		// pre-checks
+		m, err := manifest.Load(manifestPath)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		checks := append([]service.CheckFn{service.CheckAPIServer()}, manifestChecks(m)...)
+		if err := service.Check(nil, checks...); err != nil {
+			log.Println(err)
+			return
+		}
+		defer file.CloseArchives()
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Resource", "Action", "Detail"})
+
+		// 1. project
+		p, err := lockedProject(m)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		existing := make(map[string]bool)
+		if p == nil {
+			table.Append([]string{"project " + m.Project.Name, "create", fmt.Sprintf("%s, %s", m.Project.Type, m.Project.Visibility)})
+			p = &types.Project{Name: m.Project.Name}
+		} else {
+			table.Append([]string{"project " + p.Name, "none", fmt.Sprintf("%s, %d images, %.2f GP", p.ID, p.NumImage, p.GigaPixel)})
+			existing, err = gql.ImageChecksumSet(p.ID)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+		}
+
+		// 2. images, by checksum
+		cache := openDigestCache(noCache)
+		defer saveDigestCache(cache)
+		planned := make(map[string]bool)
+		var totalImg int
+		var totalGP float64
+		for _, src := range m.Images {
+			log.Printf("Checking %s...\n", src.Name())
+			n, gp, existed, invalid, err := planImages(src, p.ID, existing, planned, cache)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			action := "upload"
+			if n == 0 {
+				action = "none"
+			}
+			detail := fmt.Sprintf("%d new images, %.2f GP, %d existed, %d invalid", n, gp, existed, invalid)
+			table.Append([]string{"images " + src.Name(), action, detail})
+			totalImg += n
+			totalGP += gp
+		}
+
+		// 3. meta files, by checksum
+		for _, f := range m.Meta {
+			action := "upload"
+			if p.ID != "" {
+				sum, err := file.Sha1sum(f)
+				if err != nil {
+					log.Println(err)
+					return
+				}
+				ok, err := gql.HasMetaFile(p.ID, sum)
+				if err != nil {
+					log.Println(err)
+					return
+				}
+				if ok {
+					action = "none"
+				}
+			}
+			table.Append([]string{"meta " + f, action, ""})
+		}
+
+		// 4. task
+		switch {
+		case m.Task == "":
+		case p.TaskState != "" && p.TaskState != service.Pending:
+			table.Append([]string{"task " + m.Task, "none", "already " + p.TaskState})
+		default:
+			table.Append([]string{"task " + m.Task, "start", "when all images and meta files are ready"})
+		}
+		table.Render()
+
+		// cost
+		usd, err := gql.CoinsToMoney(totalGP, "USD")
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Printf("After applying (if no duplicate):\nImages #: %d -> %d\tGP: %.2f -> %.2f\tPRO: USD $%.2f\n", p.NumImage, p.NumImage+totalImg, p.GigaPixel, p.GigaPixel+totalGP, usd)
+	},
+}
+
+// planImages digests the images of the source, and returns the number and GP
+// of the new ones, which are neither existing nor planned in a previous
+// source, and the number of existed and invalid ones.
+// The checksums of the new ones are added to planned.
+func planImages(src manifest.Source, pid string, existing, planned map[string]bool, cache *file.DigestCache) (n int, gp float64, existed, invalid int, err error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	result := make(chan file.ImageDigest)
+	var errc <-chan error
+	if src.Dir != "" {
+		paths, walkErr := file.WalkFiles(done, src.Dir, src.Skip)
+		digester := file.ImageDigester{
+			Root:     src.Dir,
+			PID:      pid,
+			Existing: existing,
+			Cache:    cache,
+			Done:     done,
+			Paths:    paths,
+			Result:   result,
+		}
+		digester.Run(thread)
+		errc = walkErr
+	} else {
+		var entries []file.URLEntry
+		if src.URLs != "" {
+			entries, err = file.ReadURLList(src.URLs)
+		} else {
+			var s3 *cloud.S3Source
+			s3, err = cloud.NewS3Source(src.From, src.Endpoint, src.Region, src.Profile)
+			if err == nil {
+				entries, err = s3.Entries(src.Skip)
+			}
+		}
+		if err != nil {
+			return
+		}
+		digester := file.URLDigester{
+			PID:      pid,
+			Existing: existing,
+			Done:     done,
+			URLs:     skipURLs(done, entries, nil),
+			Result:   result,
+		}
+		digester.Run(thread)
+		urlErr := make(chan error)
+		close(urlErr)
+		errc = urlErr
+	}
+
+	for r := range result {
+		switch {
+		case r.Error != nil:
+			invalid++
+			if verbose {
+				log.Printf("Invalid image: %q, Reason: %v", r.Path, r.Error)
+			}
+		case r.Existed || planned[r.SHA1]:
+			existed++
+		default:
+			planned[r.SHA1] = true
+			n++
+			gp += r.GP
+		}
+	}
+	err = <-errc
+	return
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	planCmd.Flags().StringVarP(&manifestPath, "file", "f", manifestPath, "Path of the yaml manifest of the project")
+	planCmd.Flags().BoolVar(&noCache, "no-cache", noCache, "Read every image again instead of using the digest cache")
+	planCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display more info of operation")
+	planCmd.Flags().IntVarP(&thread, "thread", "n", thread, "Number of threads to process, default is number of cores x 4")
+	errors.Must(planCmd.MarkFlagRequired("file"))
+}
@@ -78,6 +78,8 @@ var projDownloadCmd = &cobra.Command{
 			}
 		}
 
+		stopProgress := startProgress("Downloading")
+		defer stopProgress()
 		for _, v := range items {
 			log.Printf("Downloading %q...", v.Name)
 			errors.Must(cloud.GetFile(v.Name, v.Link))
@@ -119,6 +119,8 @@ func writeCSV(w *csv.Writer, imgs []types.ProjectImage) (int, error) {
 }
 
 func downloadImages(imgs []types.ProjectImage) error {
+	stopProgress := startProgress("Downloading")
+	defer stopProgress()
 	for _, img := range imgs {
 		if img.State != "Ready" {
 			continue
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jackytck/alti-cli/errors"
 	"github.com/jackytck/alti-cli/file"
 	"github.com/jackytck/alti-cli/service"
 	"github.com/spf13/cobra"
@@ -17,8 +18,12 @@ var inputPath string
 // quickCmd represents the quickCmd command
 var quickCmd = &cobra.Command{
 	Use:   "quick",
-	Short: "Create and upload from directory or model zip",
-	Long:  "Create a reconstruction project from a directory of images or an imported project from a model zip.",
+	Short: "Create and upload from directory, image archive, model zip or model file",
+	Long: `Create a reconstruction project from a directory or an archive of images,
+or an imported project from a model zip or a model file of obj, gltf, glb, ply,
+fbx, las, laz or e57. Archives and model files are told apart by content. A
+gltf, glb or ply mesh is converted to obj unless --to-obj=false is given, as
+'alti-cli import model'.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		start := time.Now()
 		defer func() {
@@ -29,21 +34,66 @@ var quickCmd = &cobra.Command{
 		}()
 
 		// pre-check
-		if err := service.Check(
-			nil,
+		isModel := file.IsModelFile(inputPath)
+		checks := []service.CheckFn{
 			service.CheckAPIServer(),
 			service.CheckFile(inputPath),
-			service.CheckDirOrZip(inputPath),
-		); err != nil {
+		}
+		if !isModel {
+			checks = append(checks, service.CheckDirOrArchive(inputPath))
+		}
+		if err := service.Check(nil, checks...); err != nil {
+			log.Println(err)
+			return
+		}
+		if err := setupUploadLimit(); err != nil {
 			log.Println(err)
 			return
 		}
 
-		// 1. determine project type
-		recon := true
-		isZip, _ := file.IsZipFile(inputPath)
-		if isZip {
-			recon = false
+		// 1. determine project type, an archive could be of images or a model
+		recon := !isModel
+		isArchive := file.IsArchive(inputPath)
+		modelZip := inputPath
+		if isModel {
+			if err := inferModelType(cmd, inputPath); err != nil {
+				log.Println(err)
+				return
+			}
+			zp, done, err := packageModelFile(inputPath)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			defer done()
+			modelZip = zp
+		}
+		if isArchive {
+			isImg, err := file.IsImageArchive(inputPath)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			recon = isImg
+		}
+		if !recon {
+			if err := service.CheckZip(modelZip)(log.Printf); err != nil {
+				log.Println(err)
+				return
+			}
+			// check the model before creating the project
+			r, err := file.CheckModelZip(modelZip)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if !r.Valid() && !force {
+				for _, i := range r.Issues {
+					log.Println(i)
+				}
+				log.Printf("%v, fix it or import it anyway with --force\n", errors.ErrModelInvalid)
+				return
+			}
 		}
 
 		// 2. create project
@@ -51,8 +101,8 @@ var quickCmd = &cobra.Command{
 			// base file name as default project name
 			name = filepath.Base(inputPath)
 
-			if isZip {
-				name = strings.TrimSuffix(name, path.Ext(name))
+			if isArchive || isModel {
+				name = strings.TrimSuffix(strings.TrimSuffix(name, path.Ext(name)), ".tar")
 			}
 		}
 		if recon {
@@ -79,25 +129,56 @@ var quickCmd = &cobra.Command{
 			// 4. start reconstruction task
 			startReconCmd.Run(cmd, args)
 		} else {
-			// imported model (obj zip) project
+			// imported model (model zip or file) project
 			newModelCmd.Run(cmd, args)
 
 			// 3b. import model
 			timeout = 0
 			id = newPID
-			model = inputPath
+			model = modelZip
 			importModelCmd.Run(cmd, args)
 		}
 	},
 }
 
+// inferModelType sets the model type to PTCLOUD for a las, laz, e57 or ply
+// without faces, if it is not given.
+func inferModelType(cmd *cobra.Command, p string) error {
+	if cmd.Flags().Changed("modelType") {
+		return nil
+	}
+	format, err := file.DetectModelFormat(p)
+	if err != nil {
+		return err
+	}
+	isPC := format == file.ModelLAS || format == file.ModelLAZ || format == file.ModelE57
+	if format == file.ModelPLY {
+		if isPC, err = file.IsPLYPointCloud(p); err != nil {
+			return err
+		}
+	}
+	if isPC {
+		modelType = "PTCLOUD"
+		log.Printf("%q is a point cloud, it is imported as %q\n", p, modelType)
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(quickCmd)
-	quickCmd.Flags().StringVarP(&inputPath, "input", "i", inputPath, "Directory path or model zip file")
+	quickCmd.Flags().StringVarP(&inputPath, "input", "i", inputPath, "Directory path, image archive (zip, tar, tar.gz), model zip or model file (obj, gltf, glb, ply, fbx, las, laz, e57)")
 	quickCmd.Flags().StringVarP(&name, "name", "n", name, "Project name")
 	quickCmd.Flags().StringVarP(&projType, "projectType", "p", projType, "free, pro")
 	quickCmd.Flags().StringVarP(&method, "method", "m", method, "Desired method of upload: 'direct', 's3' or 'oss'")
 	quickCmd.Flags().StringVarP(&modelType, "modelType", "t", modelType, "CAD, PHOTOGRAMMETRY, PTCLOUD")
+	quickCmd.Flags().BoolVar(&toOBJ, "to-obj", toOBJ, "Convert a gltf, glb or ply mesh to obj before uploading, as the server expects; --to-obj=false uploads it as it is")
 	quickCmd.Flags().StringVarP(&skip, "skip", "s", skip, "Regular expression to skip paths")
+	addFilterFlags(quickCmd)
+	quickCmd.Flags().IntVar(&retries, "retry", retries, "Max number of retries of each failed upload, default is 5 or from config")
+	quickCmd.Flags().DurationVar(&retryDelay, "retry-delay", retryDelay, "Delay before the first retry, doubled for each retry, e.g. 1s")
+	quickCmd.Flags().DurationVar(&retryMaxElapsed, "retry-max-elapsed", retryMaxElapsed, "Give up retrying after this duration, e.g. 5m")
+	quickCmd.Flags().StringVar(&limitRate, "limit-rate", limitRate, "Max total upload rate of all threads, e.g. 20MB/s")
+	quickCmd.Flags().StringVar(&uploadWindow, "upload-window", uploadWindow, "Daily time window for uploading in local time, e.g. 22:00-06:00")
+	quickCmd.Flags().BoolVar(&force, "force", force, "Import the meta files and the model even if they are invalid")
 	quickCmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "Display more info of operation")
 }
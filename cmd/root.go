@@ -6,12 +6,15 @@ import (
 	"path/filepath"
 
 	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/progress"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var cfgFile string
+var progressMode string
+var progressFile string
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -39,6 +42,8 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.altizure/config)")
+	rootCmd.PersistentFlags().StringVar(&progressMode, "progress", progress.ModeAuto, "Progress of uploads and downloads: 'auto', 'bar', 'json' or 'none'")
+	rootCmd.PersistentFlags().StringVar(&progressFile, "progress-file", "", "File or named pipe to append the json progress events to, apart from the output on stdout")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -68,6 +68,10 @@ func Load() Config {
 	// a. from env
 	ec, ok := FromEnv()
 	if ok {
+		var r Retry
+		if viper.UnmarshalKey("retry", &r) == nil {
+			ec.Retry = &r
+		}
 		return ec
 	}
 
@@ -94,6 +98,16 @@ func GetConfigDir() (string, error) {
 type Config struct {
 	Scopes map[string]Scope `yaml:"scopes"`
 	Active string           `yaml:"active"` // active profile id
+	Retry  *Retry           `yaml:"retry,omitempty"`
+}
+
+// Retry represents the retry policy of uploading. Empty fields are unset.
+// Durations are in the format of time.ParseDuration, e.g. "500ms" or "5m".
+type Retry struct {
+	Max        *int   `yaml:"max,omitempty"`        // max number of retries
+	Delay      string `yaml:"delay,omitempty"`      // delay before the first retry
+	MaxDelay   string `yaml:"maxdelay,omitempty"`   // cap of each delay
+	MaxElapsed string `yaml:"maxelapsed,omitempty"` // give up once exceeded
 }
 
 // GetActive returns the active endpoint and profile of current config.
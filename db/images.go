@@ -1,5 +1,17 @@
 package db
 
+import "time"
+
+// Stages of an image in the import pipeline.
+const (
+	// StageDigested is the stage after an image is checked locally.
+	StageDigested = "Digested"
+	// StageRegistered is the stage after an image is registered in the api server.
+	StageRegistered = "Registered"
+	// StageUploaded is the stage after an image is uploaded to the cloud.
+	StageUploaded = "Uploaded"
+)
+
 // Image represents an image in the db.
 type Image struct {
 	SID       int `storm:"id,increment"`
@@ -9,11 +21,29 @@ type Image struct {
 	Filename  string `storm:"index"`
 	Filetype  string
 	URL       string
-	LocalPath string
+	LocalPath string `storm:"index"`
 	Hash      string
+	Filesize  int64
 	State     string
+	Stage     string
+	UploadURL string // pre-signed url for s3 or minio
+	CloudPath string // object name for oss
 	Width     int
 	Height    int
 	GP        float64
+	Retries   int // number of retries of mutations and uploads
 	Error     string
+	// OriginalGP is the GP before downscaling, non-zero if it is to be
+	// downscaled, then Width, Height and GP are of the downscaled copy.
+	OriginalGP float64
+	// UploadPath is the downscaled copy to be uploaded instead of LocalPath.
+	UploadPath string
+	// time spent on registering, uploading and waiting for the state, in all
+	// sessions
+	RegisterTime time.Duration
+	UploadTime   time.Duration
+	CheckTime    time.Duration
+	// Uploaded is when the bytes of the image were last put by the client,
+	// zero if not, e.g. registered by direct upload or by url.
+	Uploaded time.Time
 }
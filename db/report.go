@@ -0,0 +1,312 @@
+package db
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+)
+
+// Formats of the upload report.
+const (
+	ReportCSV  = "csv"
+	ReportJSON = "json"
+	ReportHTML = "html"
+)
+
+// ReportFormat returns the format of the report at p, which is format if it
+// is set, or else inferred from the extension of p, and csv by default.
+func ReportFormat(p, format string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(p)), ".")
+		if format != ReportJSON && format != ReportHTML {
+			format = ReportCSV
+		}
+	}
+	switch format {
+	case ReportCSV, ReportJSON, ReportHTML:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid report format %q, should be %q, %q or %q", format, ReportCSV, ReportJSON, ReportHTML)
+}
+
+// Report is the upload report of an import session.
+type Report struct {
+	Summary ReportSummary `json:"summary"`
+	Images  []ReportImage `json:"images"`
+}
+
+// ReportSummary sums up an import session.
+type ReportSummary struct {
+	PID        string    `json:"pid"`
+	Project    string    `json:"project"`
+	Source     string    `json:"source"`
+	Method     string    `json:"method"`
+	Bucket     string    `json:"bucket,omitempty"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+	Seconds    float64   `json:"seconds"`
+	Images     int       `json:"images"`
+	Ready      int       `json:"ready"`
+	Invalid    int       `json:"invalid"`
+	Failed     int       `json:"failed"`
+	Pending    int       `json:"pending"`
+	GP         float64   `json:"gp"`
+	OriginalGP float64   `json:"originalGP"`
+	Bytes      int64     `json:"bytes"`
+	// BytesPerSecond is the throughput of the bytes put by the client in this
+	// run, not of the images uploaded before or registered by url.
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	Retries        int     `json:"retries"`
+	// Coins is the GP of the ready images, i.e. 1 coin per GP.
+	Coins    float64         `json:"coins"`
+	USD      float64         `json:"usd"`
+	Failures []ReportFailure `json:"failures"`
+}
+
+// ReportFailure is the number of images failed by the same reason.
+type ReportFailure struct {
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+// ReportImage is the report of an image of the session.
+type ReportImage struct {
+	Filename        string  `json:"filename"`
+	LocalPath       string  `json:"localPath"`
+	SHA1            string  `json:"sha1"`
+	GP              float64 `json:"gp"`
+	OriginalGP      float64 `json:"originalGP"`
+	Bytes           int64   `json:"bytes"`
+	Method          string  `json:"method"`
+	Bucket          string  `json:"bucket,omitempty"`
+	Retries         int     `json:"retries"`
+	RegisterSeconds float64 `json:"registerSeconds"`
+	UploadSeconds   float64 `json:"uploadSeconds"`
+	CheckSeconds    float64 `json:"checkSeconds"`
+	Stage           string  `json:"stage"`
+	State           string  `json:"state"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// urlPattern matches the urls in error messages, e.g. presigned ones, which
+// differ for each image.
+var urlPattern = regexp.MustCompile(`https?://[^\s"']+`)
+
+// NewReport builds the report of the images of the session of a run from
+// started to finished.
+func NewReport(sess Session, images []Image, started, finished time.Time) *Report {
+	r := Report{
+		Summary: ReportSummary{
+			PID:      sess.PID,
+			Source:   sess.Dir,
+			Method:   sess.Method,
+			Bucket:   sess.Bucket,
+			Started:  started,
+			Finished: finished,
+			Seconds:  finished.Sub(started).Seconds(),
+			Failures: []ReportFailure{},
+		},
+		Images: []ReportImage{},
+	}
+	s := &r.Summary
+	failures := make(map[string]int)
+	var uploaded int64
+	for _, img := range images {
+		orig := img.OriginalGP
+		if orig == 0 {
+			orig = img.GP
+		}
+		r.Images = append(r.Images, ReportImage{
+			Filename:        img.Filename,
+			LocalPath:       img.LocalPath,
+			SHA1:            img.Hash,
+			GP:              img.GP,
+			OriginalGP:      orig,
+			Bytes:           img.Filesize,
+			Method:          sess.Method,
+			Bucket:          sess.Bucket,
+			Retries:         img.Retries,
+			RegisterSeconds: img.RegisterTime.Seconds(),
+			UploadSeconds:   img.UploadTime.Seconds(),
+			CheckSeconds:    img.CheckTime.Seconds(),
+			Stage:           img.Stage,
+			State:           img.State,
+			Error:           img.Error,
+		})
+		s.Images++
+		s.GP += img.GP
+		s.OriginalGP += orig
+		s.Bytes += img.Filesize
+		s.Retries += img.Retries
+		if !img.Uploaded.IsZero() && !img.Uploaded.Before(started) {
+			uploaded += img.Filesize
+		}
+		switch {
+		case img.State == "Ready":
+			s.Ready++
+			s.Coins += img.GP
+		case img.State == "Invalid":
+			s.Invalid++
+			failures[urlPattern.ReplaceAllString(img.Error, "<url>")]++
+		case img.Error != "":
+			s.Failed++
+			failures[urlPattern.ReplaceAllString(img.Error, "<url>")]++
+		default:
+			s.Pending++
+		}
+	}
+	if s.Seconds > 0 {
+		s.BytesPerSecond = float64(uploaded) / s.Seconds
+	}
+	for reason, n := range failures {
+		s.Failures = append(s.Failures, ReportFailure{Reason: reason, Count: n})
+	}
+	sort.Slice(s.Failures, func(i, j int) bool {
+		a, b := s.Failures[i], s.Failures[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Reason < b.Reason
+	})
+	return &r
+}
+
+// BuildReport builds the report of all of the images of the session db.
+func BuildReport(sdb *storm.DB, sess Session, started time.Time) (*Report, error) {
+	var images []Image
+	imgc, errc := AllImage(sdb)
+	for img := range imgc {
+		images = append(images, img)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return NewReport(sess, images, started, time.Now()), nil
+}
+
+// Write writes the report in format.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case ReportJSON:
+		return r.WriteJSON(w)
+	case ReportHTML:
+		return r.WriteHTML(w)
+	}
+	return r.WriteCSV(w)
+}
+
+// WriteCSV writes the images of the report as csv, the summary is left out.
+// The first columns are kept as Filename, State and Error, the others follow.
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"Filename", "State", "Error", "Retries", "GP", "Original GP", "Local Path", "SHA1", "Bytes", "Method", "Bucket", "Register (s)", "Upload (s)", "Check (s)"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	f := func(v float64, prec int) string {
+		return strconv.FormatFloat(v, 'f', prec, 64)
+	}
+	for _, img := range r.Images {
+		row := []string{
+			img.Filename, img.State, img.Error, strconv.Itoa(img.Retries),
+			f(img.GP, 4), f(img.OriginalGP, 4), img.LocalPath, img.SHA1,
+			strconv.FormatInt(img.Bytes, 10), img.Method, img.Bucket,
+			f(img.RegisterSeconds, 3), f(img.UploadSeconds, 3), f(img.CheckSeconds, 3),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSON writes the report as indented json.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteHTML writes the report as a self-contained html page, without any
+// external script or style, e.g. for attaching to a ticket.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"mb": func(b int64) string {
+		return fmt.Sprintf("%.2f MB", float64(b)/1024/1024)
+	},
+	"mbps": func(b float64) string {
+		return fmt.Sprintf("%.2f MB/s", b/1024/1024)
+	},
+	"gp": func(v float64) string {
+		return fmt.Sprintf("%.4f", v)
+	},
+	"sec": func(v float64) string {
+		return fmt.Sprintf("%.2fs", v)
+	},
+	"time": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05 MST")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Upload report of {{.Summary.Project}} {{.Summary.PID}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.5em; }
+h2 { font-size: 1.2em; margin-top: 2em; }
+table { border-collapse: collapse; font-size: 0.9em; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; }
+th { background: #f4f4f4; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+tr.Ready td.state { color: #1a7f37; }
+tr.Invalid td.state, tr.failed td.state, td.error { color: #cf222e; }
+.summary th { width: 12em; }
+</style>
+</head>
+<body>
+<h1>Upload report of {{.Summary.Project}}</h1>
+{{with .Summary}}
+<table class="summary">
+<tr><th>Project id</th><td>{{.PID}}</td></tr>
+<tr><th>Source</th><td>{{.Source}}</td></tr>
+<tr><th>Method</th><td>{{.Method}}{{if .Bucket}} ({{.Bucket}}){{end}}</td></tr>
+<tr><th>Started</th><td>{{time .Started}}</td></tr>
+<tr><th>Finished</th><td>{{time .Finished}} ({{sec .Seconds}})</td></tr>
+<tr><th>Images</th><td>{{.Images}}: {{.Ready}} ready, {{.Invalid}} invalid, {{.Failed}} failed, {{.Pending}} pending</td></tr>
+<tr><th>GP</th><td>{{gp .GP}}{{if ne .GP .OriginalGP}} (original {{gp .OriginalGP}}){{end}}</td></tr>
+<tr><th>Size</th><td>{{mb .Bytes}}</td></tr>
+<tr><th>Throughput</th><td>{{mbps .BytesPerSecond}}</td></tr>
+<tr><th>Retries</th><td>{{.Retries}}</td></tr>
+<tr><th>Cost</th><td>{{printf "%.2f" .Coins}} coins, USD ${{printf "%.2f" .USD}}</td></tr>
+</table>
+{{if .Failures}}
+<h2>Failures</h2>
+<table>
+<tr><th>Reason</th><th>Images</th></tr>
+{{range .Failures}}<tr><td class="error">{{.Reason}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+<h2>Images</h2>
+<table>
+<tr><th>Filename</th><th>Local path</th><th>SHA1</th><th>GP</th><th>Original GP</th><th>Size</th><th>Retries</th><th>Register</th><th>Upload</th><th>Check</th><th>State</th><th>Error</th></tr>
+{{range .Images}}<tr class="{{if .State}}{{.State}}{{end}}{{if .Error}} failed{{end}}"><td>{{.Filename}}</td><td>{{.LocalPath}}</td><td><code>{{.SHA1}}</code></td><td class="num">{{gp .GP}}</td><td class="num">{{gp .OriginalGP}}</td><td class="num">{{mb .Bytes}}</td><td class="num">{{.Retries}}</td><td class="num">{{sec .RegisterSeconds}}</td><td class="num">{{sec .UploadSeconds}}</td><td class="num">{{sec .CheckSeconds}}</td><td class="state">{{.State}}</td><td class="error">{{.Error}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewReport(t *testing.T) {
+	sess := Session{PID: "5d37e", Dir: "/data/site-a", Method: "s3", Bucket: "s3-us-west-1"}
+	started := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	// only 1.jpg is put in this run, 2.jpg was put by a previous run
+	images := []Image{
+		{Filename: "1.jpg", Hash: "a1", GP: 0.012, OriginalGP: 0.02, Filesize: 4 << 20, Stage: StageUploaded, State: "Ready", Retries: 1, UploadTime: time.Second, Uploaded: started.Add(time.Second)},
+		{Filename: "2.jpg", Hash: "b2", GP: 0.02, Filesize: 4 << 20, Stage: StageUploaded, State: "Invalid", Error: "bad exif", Uploaded: started.Add(-time.Hour)},
+		{Filename: "3.jpg", Hash: "c3", GP: 0.02, Filesize: 4 << 20, Stage: StageRegistered, Error: `Put "https://s3.example.com/3.jpg?X-Amz-Signature=1": timeout`},
+		{Filename: "4.jpg", Hash: "d4", GP: 0.02, Filesize: 4 << 20, Stage: StageRegistered, Error: `Put "https://s3.example.com/4.jpg?X-Amz-Signature=2": timeout`},
+		{Filename: "5.jpg", Hash: "e5", GP: 0.02, Filesize: 4 << 20, Stage: StageDigested},
+	}
+	r := NewReport(sess, images, started, started.Add(time.Second*4))
+
+	s := r.Summary
+	if s.Images != 5 || s.Ready != 1 || s.Invalid != 1 || s.Failed != 2 || s.Pending != 1 || s.Retries != 1 {
+		t.Errorf("NewReport() summary = %+v", s)
+	}
+	if s.Coins != 0.012 || s.OriginalGP != 0.1 || s.BytesPerSecond != 1<<20 {
+		t.Errorf("NewReport() coins = %v, original gp = %v, throughput = %v", s.Coins, s.OriginalGP, s.BytesPerSecond)
+	}
+	want := []ReportFailure{{`Put "<url>": timeout`, 2}, {"bad exif", 1}}
+	if len(s.Failures) != 2 || s.Failures[0] != want[0] || s.Failures[1] != want[1] {
+		t.Errorf("NewReport() failures = %v, want %v", s.Failures, want)
+	}
+	if img := r.Images[1]; img.OriginalGP != img.GP || img.Method != "s3" || img.Bucket != sess.Bucket {
+		t.Errorf("NewReport() image = %+v", img)
+	}
+
+	for _, format := range []string{ReportCSV, ReportJSON, ReportHTML} {
+		var buf bytes.Buffer
+		if err := r.Write(&buf, format); err != nil {
+			t.Fatalf("Write(%s) error: %v", format, err)
+		}
+		out := buf.String()
+		switch format {
+		case ReportCSV:
+			if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 6 || !strings.HasPrefix(lines[0], "Filename,State,Error,Retries,GP,Original GP") {
+				t.Errorf("WriteCSV() = %s", out)
+			}
+		case ReportJSON:
+			var got Report
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil || got.Summary.Ready != 1 || len(got.Images) != 5 {
+				t.Errorf("WriteJSON() = %s, error: %v", out, err)
+			}
+		case ReportHTML:
+			if !strings.Contains(out, "<td>1.jpg</td>") || strings.Contains(out, "<script") || strings.Contains(out, "<link") {
+				t.Errorf("WriteHTML() = %s", out)
+			}
+		}
+	}
+}
+
+func TestReportFormat(t *testing.T) {
+	tests := []struct {
+		p, format, want string
+		wantErr         bool
+	}{
+		{"upload.csv", "", ReportCSV, false},
+		{"upload.JSON", "", ReportJSON, false},
+		{"upload.html", "", ReportHTML, false},
+		{"upload.txt", "", ReportCSV, false},
+		{"upload.txt", "json", ReportJSON, false},
+		{"upload.txt", "xml", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ReportFormat(tt.p, tt.format)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ReportFormat(%q, %q) = %q, %v, want %q", tt.p, tt.format, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/jackytck/alti-cli/config"
+	"github.com/jackytck/alti-cli/errors"
+)
+
+// Session represents a resumable image import session, keyed by the project
+// id and the absolute path of the source directory.
+type Session struct {
+	ID      int `storm:"id,increment"`
+	PID     string
+	Dir     string
+	Method  string
+	Bucket  string
+	Created time.Time
+	Updated time.Time
+}
+
+// SessionDir returns the directory under the config directory for storing
+// all of the import sessions.
+func SessionDir() (string, error) {
+	confDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(confDir, "sessions"), nil
+}
+
+// SessionPath infers the path of the session db of the given pid and
+// source directory. The same pid and directory always give the same path.
+func SessionPath(pid, dir string) (string, error) {
+	sessDir, err := SessionDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.Sum([]byte(pid + "\n" + abs))
+	return path.Join(sessDir, hex.EncodeToString(h[:])[:16]+".db"), nil
+}
+
+// OpenSession opens or creates the session db of the given pid and source
+// directory. Return the db, its path and the session record.
+func OpenSession(pid, dir string) (*storm.DB, string, *Session, error) {
+	sessDir, err := SessionDir()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err = os.MkdirAll(sessDir, 0755); err != nil {
+		return nil, "", nil, err
+	}
+	p, err := SessionPath(pid, dir)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	sdb, err := OpenDB(p)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err = sdb.Init(&Image{}); err != nil {
+		sdb.Close()
+		return nil, "", nil, err
+	}
+	if err = sdb.Init(&Session{}); err != nil {
+		sdb.Close()
+		return nil, "", nil, err
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		sdb.Close()
+		return nil, "", nil, err
+	}
+	var s Session
+	err = sdb.One("PID", pid, &s)
+	if err == storm.ErrNotFound {
+		now := time.Now()
+		s = Session{PID: pid, Dir: abs, Created: now, Updated: now}
+		err = sdb.Save(&s)
+	}
+	if err != nil {
+		sdb.Close()
+		return nil, "", nil, err
+	}
+	return sdb, p, &s, nil
+}
+
+// closedSessions are the session dbs closed by CloseSession, guarded against
+// the images being saved by the workers meanwhile.
+var closedSessions = struct {
+	sync.RWMutex
+	dbs map[*storm.DB]bool
+}{dbs: make(map[*storm.DB]bool)}
+
+// CloseSession closes the session db after the images being saved. Saving an
+// image afterwards returns ErrSessionClosed instead of a bolt error.
+func CloseSession(sdb *storm.DB) error {
+	closedSessions.Lock()
+	defer closedSessions.Unlock()
+	if closedSessions.dbs[sdb] {
+		return nil
+	}
+	closedSessions.dbs[sdb] = true
+	return sdb.Close()
+}
+
+// SaveImage saves the image in the session db and refreshes the updated time
+// of the session in the same transaction.
+func SaveImage(sdb *storm.DB, img *Image) error {
+	closedSessions.RLock()
+	defer closedSessions.RUnlock()
+	if closedSessions.dbs[sdb] {
+		return errors.ErrSessionClosed
+	}
+	tx, err := sdb.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := tx.Save(img); err != nil {
+		return err
+	}
+	var sessions []Session
+	if err := tx.All(&sessions); err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range sessions {
+		if err := tx.UpdateField(&sessions[i], "Updated", now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// HasSession tells if there is an unfinished session of the given pid and
+// source directory.
+func HasSession(pid, dir string) (bool, error) {
+	p, err := SessionPath(pid, dir)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// DiscardSession removes the session db of the given pid and source directory.
+func DiscardSession(pid, dir string) error {
+	p, err := SessionPath(pid, dir)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+// SessionPaths returns the paths of all of the stored session dbs.
+func SessionPaths() ([]string, error) {
+	sessDir, err := SessionDir()
+	if err != nil {
+		return nil, err
+	}
+	ret, err := filepath.Glob(path.Join(sessDir, "*.db"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// SessionSummary summarizes a stored session db.
+type SessionSummary struct {
+	Path    string
+	Session Session
+	Total   int
+	Ready   int
+	Failed  int
+}
+
+// SummarizeSession reads the session record and counts its images by state.
+func SummarizeSession(p string) (*SessionSummary, error) {
+	sdb, err := OpenDB(p)
+	if err != nil {
+		return nil, err
+	}
+	defer sdb.Close()
+
+	ret := SessionSummary{Path: p}
+	var sessions []Session
+	if err = sdb.All(&sessions); err != nil {
+		return nil, err
+	}
+	if len(sessions) > 0 {
+		ret.Session = sessions[0]
+	}
+
+	imgc, errc := AllImage(sdb)
+	for img := range imgc {
+		ret.Total++
+		switch {
+		case img.State == "Ready":
+			ret.Ready++
+		case img.Error != "" || img.State == "Invalid":
+			ret.Failed++
+		}
+	}
+	if err = <-errc; err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
@@ -13,6 +13,14 @@ const (
 	ErrErrorCodeInvalid AppError = "app: invalid error code"
 	// ErrInvalidInput is returned when the input value is invalid.
 	ErrInvalidInput AppError = "app: invalid input"
+	// ErrManifestInvalid is returned when a project manifest is invalid.
+	ErrManifestInvalid AppError = "app: invalid manifest"
+	// ErrJobsInvalid is returned when a jobs file of batch import is invalid.
+	ErrJobsInvalid AppError = "app: invalid jobs file"
+	// ErrTimeInvalid is returned when a time filter could not be parsed.
+	ErrTimeInvalid AppError = "app: invalid time, should be YYYY-MM-DD or YYYY-MM-DD HH:MM:SS"
+	// ErrSessionClosed is returned when an image is saved after its session is closed, e.g. by ctrl+c.
+	ErrSessionClosed AppError = "app: import session is closed"
 	// ErrProfileNotFound is returned when the queried profile is not found.
 	ErrProfileNotFound ConfigError = "config: profile not found"
 	// ErrProfileNotRemovable is returned when the default profile is chosen to be removed.
@@ -47,16 +55,35 @@ const (
 	ErrFileNotDir FileError = "file: not directory"
 	// ErrFileNotDirOrZip is returned when a file is not a directory and not a zip file.
 	ErrFileNotDirOrZip FileError = "file: not directory or zip"
+	// ErrFileNotDirOrArchive is returned when a file is not a directory and not an archive.
+	ErrFileNotDirOrArchive FileError = "file: not directory or archive"
 	// ErrFilesize is returned when the filesize of a file could not be determined.
 	ErrFilesize FileError = "file: unknown filesize"
 	// ErrFileImageDim is returned when the dimension of an image could not be determined.
 	ErrFileImageDim FileError = "file: unknown image dimension"
+	// ErrFileImageFormat is returned when an image is not in a format supported by altizure.
+	ErrFileImageFormat FileError = "file: unsupported image format, only jpeg, png, tiff and webp are supported"
+	// ErrFileImageDecode is returned when the pixels of an image could not be decoded, e.g. planar bigtiff.
+	ErrFileImageDecode FileError = "file: image pixels could not be decoded"
 	// ErrFileChecksum is returned when the checksum of a file could not be computed.
 	ErrFileChecksum FileError = "file: unknown checksum"
+	// ErrURLInvalid is returned when an image url is not a valid http or https url.
+	ErrURLInvalid FileError = "file: invalid image url"
+	// ErrURLFetch is returned when an image url could not be fetched with ok status code.
+	ErrURLFetch FileError = "file: could not fetch image url"
+	// ErrS3URLInvalid is returned when a source is not of the form s3://bucket/prefix.
+	ErrS3URLInvalid FileError = "file: invalid s3 url, should be s3://bucket/prefix"
 	// ErrMetaFilenameInvalid is returned when the filename of meta file is invalid.
 	ErrMetaFilenameInvalid FileError = "file: invalid meta filename"
+	// ErrMetaInvalid is returned when a meta file has any line-level error.
+	ErrMetaInvalid FileError = "file: invalid meta file"
 	// ErrModelFilenameInvalid is returned when the filename of model file is invalid.
 	ErrModelFilenameInvalid FileError = "file: invalid model filename"
+	// ErrModelInvalid is returned when a model zip would be rejected by the server.
+	ErrModelInvalid FileError = "file: invalid model"
+	// ErrPointCloudInvalid is returned when a point cloud is truncated or has
+	// an invalid header.
+	ErrPointCloudInvalid FileError = "file: invalid point cloud"
 	// ErrImgReg is returned when an image could not be registered for uploading.
 	ErrImgReg UploadError = "upload: cannot register upload image"
 	// ErrImgInvalid is returned when an image is regarded as invalid by the server.
@@ -77,6 +104,10 @@ const (
 	ErrNOSTS UploadError = "upload: oss sts error"
 	// ErrOSSUploaderNotFound is returned when an OSS uploader is not found.
 	ErrOSSUploaderNotFound UploadError = "upload: oss uploader not found"
+	// ErrRateInvalid is returned when the provided upload rate could not be parsed.
+	ErrRateInvalid UploadError = "upload: invalid rate limit"
+	// ErrWindowInvalid is returned when the provided upload window could not be parsed.
+	ErrWindowInvalid UploadError = "upload: invalid upload window"
 	// ErrImgMutateState is returned when the image state could not be mutated.
 	ErrImgMutateState UploadError = "upload: cannot not mutate image state"
 	// ErrModelMutateState is returned when the model state could not be mutated.
@@ -0,0 +1,480 @@
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ArchiveSep separates the path of an archive and the name of its member in
+// a virtual path, e.g. "flight.zip!/DCIM/0001.JPG".
+const ArchiveSep = "!/"
+
+// Kinds of archive.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+)
+
+// ArchiveMember is a regular file in an archive.
+type ArchiveMember struct {
+	Name string // cleaned slash separated name
+	Info os.FileInfo
+}
+
+// archiveReader lists and opens the members of an archive.
+type archiveReader interface {
+	members() []ArchiveMember
+	stat(name string) (os.FileInfo, bool)
+	open(name string) (io.ReadCloser, error)
+	close() error
+}
+
+// archives caches the opened archives by path.
+var archives = struct {
+	sync.Mutex
+	m map[string]archiveReader
+}{m: make(map[string]archiveReader)}
+
+// ArchiveKind sniffs the kind of archive of the file at p from its content.
+// Return empty string if it is not an archive.
+func ArchiveKind(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head, err := readHead(f, 512)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return ArchiveZip, nil
+	case isTarHeader(head):
+		return ArchiveTar, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", nil
+		}
+		defer gz.Close()
+		if inner, _ := readHead(gz, 512); isTarHeader(inner) {
+			return ArchiveTarGz, nil
+		}
+	}
+	return "", nil
+}
+
+// IsArchive tells if p is a zip, tar or gzipped tar file.
+func IsArchive(p string) bool {
+	fi, err := os.Stat(p)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	kind, _ := ArchiveKind(p)
+	return kind != ""
+}
+
+// IsSequentialArchive tells if the members of the archive at p could only be
+// read in order, i.e. a gzipped tar. Reading its members out of order is slow.
+func IsSequentialArchive(p string) bool {
+	kind, _ := ArchiveKind(p)
+	return kind == ArchiveTarGz
+}
+
+// modelExts are the extensions of the model files that tell a model archive
+// from an image archive.
+var modelExts = map[string]bool{
+	".obj": true, ".fbx": true, ".dae": true, ".3ds": true, ".stl": true,
+	".ply": true, ".las": true, ".laz": true, ".e57": true, ".xyz": true, ".pts": true,
+	".gltf": true, ".glb": true, ".osgb": true,
+}
+
+// IsImageArchive tells if the archive at p is of images rather than of a
+// model, i.e. it has no model file and at least one of its members is an image.
+func IsImageArchive(p string) (bool, error) {
+	members, err := ArchiveMembers(p)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range members {
+		if modelExts[strings.ToLower(path.Ext(m.Name))] {
+			return false, nil
+		}
+	}
+	for _, m := range members {
+		t, err := GuessFileType(ArchivePath(p, m.Name))
+		if err == nil && IsSupportedImageType(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ArchivePath returns the virtual path of a member of an archive.
+func ArchivePath(archive, member string) string {
+	return archive + ArchiveSep + member
+}
+
+// SplitArchivePath splits a virtual path into the path of the archive and
+// the name of its member. It is not a virtual path if the part before the
+// separator is not a regular file, e.g. a directory named "wow!".
+func SplitArchivePath(p string) (string, string, bool) {
+	i := strings.Index(p, ArchiveSep)
+	if i < 0 {
+		return "", "", false
+	}
+	if fi, err := os.Stat(p[:i]); err != nil || !fi.Mode().IsRegular() {
+		return "", "", false
+	}
+	return p[:i], p[i+len(ArchiveSep):], true
+}
+
+// ArchiveMembers lists the regular files of the archive at p in order.
+func ArchiveMembers(p string) ([]ArchiveMember, error) {
+	a, err := openArchive(p)
+	if err != nil {
+		return nil, err
+	}
+	return a.members(), nil
+}
+
+// OpenPath opens the file at p, or the member of an archive if p is a
+// virtual path.
+func OpenPath(p string) (io.ReadCloser, error) {
+	ap, name, ok := SplitArchivePath(p)
+	if !ok {
+		return os.Open(p)
+	}
+	a, err := openArchive(ap)
+	if err != nil {
+		return nil, err
+	}
+	return a.open(name)
+}
+
+// StatPath returns the file info of the file at p, or of the member of an
+// archive if p is a virtual path.
+func StatPath(p string) (os.FileInfo, error) {
+	ap, name, ok := SplitArchivePath(p)
+	if !ok {
+		return os.Stat(p)
+	}
+	a, err := openArchive(ap)
+	if err != nil {
+		return nil, err
+	}
+	info, ok := a.stat(name)
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+	}
+	return info, nil
+}
+
+// CloseArchives closes all of the opened archives.
+func CloseArchives() {
+	archives.Lock()
+	defer archives.Unlock()
+	for p, a := range archives.m {
+		a.close()
+		delete(archives.m, p)
+	}
+}
+
+// openArchive opens and indexes the archive at p, or returns the cached one.
+func openArchive(p string) (archiveReader, error) {
+	archives.Lock()
+	defer archives.Unlock()
+	if a, ok := archives.m[p]; ok {
+		return a, nil
+	}
+	kind, err := ArchiveKind(p)
+	if err != nil {
+		return nil, err
+	}
+	var a archiveReader
+	switch kind {
+	case ArchiveZip:
+		a, err = newZipArchive(p)
+	case ArchiveTar:
+		a, err = newTarArchive(p)
+	case ArchiveTarGz:
+		a, err = newTarGzArchive(p)
+	default:
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrInvalid}
+	}
+	if err != nil {
+		return nil, err
+	}
+	archives.m[p] = a
+	return a, nil
+}
+
+// readHead reads at most n leading bytes.
+func readHead(r io.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	m, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return buf[:m], nil
+}
+
+// isTarHeader tells if b starts with a posix or gnu tar header.
+func isTarHeader(b []byte) bool {
+	return len(b) >= 262 && string(b[257:262]) == "ustar"
+}
+
+// cleanMemberName normalizes the name of a member, e.g. "./a//b" to "a/b".
+func cleanMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// memberIndex indexes the regular files of an archive by their names.
+type memberIndex struct {
+	list  []ArchiveMember
+	infos map[string]os.FileInfo
+}
+
+func (mi *memberIndex) add(name string, info os.FileInfo) {
+	if mi.infos == nil {
+		mi.infos = make(map[string]os.FileInfo)
+	}
+	name = cleanMemberName(name)
+	mi.list = append(mi.list, ArchiveMember{Name: name, Info: info})
+	mi.infos[name] = info
+}
+
+func (mi *memberIndex) members() []ArchiveMember {
+	return mi.list
+}
+
+func (mi *memberIndex) stat(name string) (os.FileInfo, bool) {
+	info, ok := mi.infos[cleanMemberName(name)]
+	return info, ok
+}
+
+// notFound is the error of opening a member that does not exist.
+func notFound(name string) error {
+	return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+// zipArchive reads members of a zip at random.
+type zipArchive struct {
+	memberIndex
+	r     *zip.ReadCloser
+	files map[string]*zip.File
+}
+
+func newZipArchive(p string) (*zipArchive, error) {
+	r, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, err
+	}
+	ret := &zipArchive{r: r, files: make(map[string]*zip.File)}
+	for _, f := range r.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		ret.add(f.Name, f.FileInfo())
+		ret.files[cleanMemberName(f.Name)] = f
+	}
+	return ret, nil
+}
+
+func (za *zipArchive) open(name string) (io.ReadCloser, error) {
+	f, ok := za.files[cleanMemberName(name)]
+	if !ok {
+		return nil, notFound(name)
+	}
+	return f.Open()
+}
+
+func (za *zipArchive) close() error {
+	return za.r.Close()
+}
+
+// tarArchive reads members of an uncompressed tar at random by their offsets.
+type tarArchive struct {
+	memberIndex
+	f       *os.File
+	offsets map[string]int64
+}
+
+func newTarArchive(p string) (*tarArchive, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	ret := &tarArchive{f: f, offsets: make(map[string]int64)}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		// the underlying file is at the start of the member after its header
+		off, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		ret.add(hdr.Name, hdr.FileInfo())
+		ret.offsets[cleanMemberName(hdr.Name)] = off
+	}
+	return ret, nil
+}
+
+func (ta *tarArchive) open(name string) (io.ReadCloser, error) {
+	name = cleanMemberName(name)
+	off, ok := ta.offsets[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	return ioutil.NopCloser(io.NewSectionReader(ta.f, off, ta.infos[name].Size())), nil
+}
+
+func (ta *tarArchive) close() error {
+	return ta.f.Close()
+}
+
+// tarGzArchive reads members of a gzipped tar in order with a single cursor.
+// The cursor is rewound to the start if an earlier member is opened. Only one
+// member could be read at a time.
+type tarGzArchive struct {
+	memberIndex
+	path  string
+	order map[string]int
+
+	mu   sync.Mutex
+	f    *os.File
+	tr   *tar.Reader
+	next int // index of the next regular member of the cursor
+}
+
+func newTarGzArchive(p string) (*tarGzArchive, error) {
+	ret := &tarGzArchive{path: p, order: make(map[string]int)}
+	if err := ret.rewind(); err != nil {
+		return nil, err
+	}
+	err := ret.index()
+	ret.f.Close()
+	ret.f, ret.tr = nil, nil
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// index lists the regular members by reading through the archive once.
+func (ta *tarGzArchive) index() error {
+	for {
+		hdr, err := ta.tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		ta.order[cleanMemberName(hdr.Name)] = len(ta.list)
+		ta.add(hdr.Name, hdr.FileInfo())
+	}
+}
+
+// rewind reopens the cursor at the start of the archive.
+func (ta *tarGzArchive) rewind() error {
+	if ta.f != nil {
+		ta.f.Close()
+	}
+	f, err := os.Open(ta.path)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	ta.f, ta.tr, ta.next = f, tar.NewReader(gz), 0
+	return nil
+}
+
+func (ta *tarGzArchive) open(name string) (io.ReadCloser, error) {
+	idx, ok := ta.order[cleanMemberName(name)]
+	if !ok {
+		return nil, notFound(name)
+	}
+	ta.mu.Lock()
+	if ta.tr == nil || idx < ta.next {
+		if err := ta.rewind(); err != nil {
+			ta.mu.Unlock()
+			return nil, err
+		}
+	}
+	for {
+		hdr, err := ta.tr.Next()
+		if err != nil {
+			ta.tr = nil
+			ta.mu.Unlock()
+			if err == io.EOF {
+				err = notFound(name)
+			}
+			return nil, err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		i := ta.next
+		ta.next++
+		if i == idx {
+			return &lockedReader{r: ta.tr, unlock: ta.mu.Unlock}, nil
+		}
+	}
+}
+
+func (ta *tarGzArchive) close() error {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	if ta.f != nil {
+		return ta.f.Close()
+	}
+	return nil
+}
+
+// lockedReader reads from r and unlocks once it is closed.
+type lockedReader struct {
+	r      io.Reader
+	unlock func()
+	once   sync.Once
+}
+
+func (lr *lockedReader) Read(p []byte) (int, error) {
+	return lr.r.Read(p)
+}
+
+func (lr *lockedReader) Close() error {
+	lr.once.Do(lr.unlock)
+	return nil
+}
@@ -0,0 +1,121 @@
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archiveFiles are the members of the test archives, from names to contents.
+var archiveFiles = []struct {
+	name string
+	path string
+}{
+	{"DCIM/a.jpg", testImgDir + "nat.jpg"},
+	{"notes.txt", "test/data/other/log.txt"},
+	{"DCIM/b.png", testImgDir + "nat.png"},
+}
+
+// writeTestArchive writes the archive files to p as zip, tar or tar.gz.
+func writeTestArchive(t *testing.T, p, kind string) {
+	t.Helper()
+	out, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	var w io.Writer = out
+	if kind == ArchiveTarGz {
+		gz := gzip.NewWriter(out)
+		defer gz.Close()
+		w = gz
+	}
+	if kind == ArchiveZip {
+		zw := zip.NewWriter(w)
+		defer zw.Close()
+		for _, f := range archiveFiles {
+			b, err := ioutil.ReadFile(f.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw, err := zw.Create(f.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write(b)
+		}
+		return
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for _, f := range archiveFiles {
+		b, err := ioutil.ReadFile(f.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw.WriteHeader(&tar.Header{Name: "./" + f.name, Mode: 0644, Size: int64(len(b)), Typeflag: tar.TypeReg})
+		tw.Write(b)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	defer CloseArchives()
+
+	for _, kind := range []string{ArchiveZip, ArchiveTar, ArchiveTarGz} {
+		t.Run(kind, func(t *testing.T) {
+			p := filepath.Join(d, "flight."+kind)
+			writeTestArchive(t, p, kind)
+
+			if got, _ := ArchiveKind(p); got != kind {
+				t.Fatalf("ArchiveKind() = %q, want %q", got, kind)
+			}
+			if ok, err := IsImageArchive(p); !ok || err != nil {
+				t.Errorf("IsImageArchive() = %v, %v, want true", ok, err)
+			}
+
+			// walk in order
+			done := make(chan struct{})
+			defer close(done)
+			paths, errc := WalkFiles(done, p, `\.txt$`)
+			var got []string
+			for vp := range paths {
+				got = append(got, vp)
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			want := []string{ArchivePath(p, "DCIM/a.jpg"), ArchivePath(p, "DCIM/b.png")}
+			if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+				t.Fatalf("WalkFiles() = %q, want %q", got, want)
+			}
+
+			// read out of order, same as the extracted files
+			for _, i := range []int{2, 0, 2} {
+				f := archiveFiles[i]
+				scan, err := ScanImage(ArchivePath(p, f.name), false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sum, _ := Sha1sum(f.path)
+				size, _ := Filesize(f.path)
+				if scan.SHA1 != sum || scan.Filesize != size {
+					t.Errorf("ScanImage(%q) = %s %d, want %s %d", f.name, scan.SHA1, scan.Filesize, sum, size)
+				}
+			}
+			if _, err := OpenPath(ArchivePath(p, "none.jpg")); !os.IsNotExist(err) {
+				t.Errorf("OpenPath() of missing member error = %v, want not exist", err)
+			}
+		})
+	}
+}
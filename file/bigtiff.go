@@ -0,0 +1,317 @@
+package file
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+
+	"github.com/jackytck/alti-cli/errors"
+	"golang.org/x/image/tiff/lzw"
+)
+
+// Tags of tiff read by the bigtiff decoder.
+const (
+	tagWidth           = 256
+	tagHeight          = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagPlanarConfig    = 284
+	tagPredictor       = 317
+	tagTileWidth       = 322
+	tagTileLength      = 323
+	tagTileOffsets     = 324
+	tagTileByteCounts  = 325
+	tagExtraSamples    = 338
+	tagSampleFormat    = 339
+)
+
+// maxBigTIFFPixels is the max number of pixels of a bigtiff to be decoded.
+const maxBigTIFFPixels = 1 << 30
+
+// bigTIFF is the first ifd of a bigtiff read into memory.
+type bigTIFF struct {
+	order binary.ByteOrder
+	buf   []byte
+	tags  map[uint16][]uint64
+}
+
+// decodeBigTIFF decodes the first image of a bigtiff of 8 or 16-bit unsigned
+// gray or rgb samples, interleaved in strips or tiles, without compression or
+// compressed by lzw or deflate. As image/tiff does, the whole file is read
+// into memory. Other layouts, e.g. planar or float samples, return
+// ErrFileImageDecode.
+func decodeBigTIFF(r io.Reader) (image.Image, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	t, err := readBigTIFF(buf)
+	if err != nil {
+		return nil, err
+	}
+	return t.decode()
+}
+
+// readBigTIFF reads the tags of the first ifd of the bigtiff in buf.
+func readBigTIFF(buf []byte) (*bigTIFF, error) {
+	if len(buf) < 16 || !isTIFF(buf) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	t := &bigTIFF{order: binary.LittleEndian, buf: buf, tags: make(map[uint16][]uint64)}
+	if buf[0] == 'M' {
+		t.order = binary.BigEndian
+	}
+	if t.order.Uint16(buf[2:]) != 43 || t.order.Uint16(buf[4:]) != 8 {
+		return nil, errors.ErrFileImageDecode
+	}
+	off := t.order.Uint64(buf[8:])
+	if off > uint64(len(buf)) || uint64(len(buf))-off < 8 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	n := t.order.Uint64(buf[off:])
+	if n > maxTIFFEntries || uint64(len(buf))-off-8 < n*20 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	for i := uint64(0); i < n; i++ {
+		e := buf[off+8+i*20:]
+		vals, err := t.values(e[:20])
+		if err != nil {
+			return nil, err
+		}
+		t.tags[t.order.Uint16(e)] = vals
+	}
+	return t, nil
+}
+
+// values reads the byte, short, long or long8 values of an ifd entry. Other
+// types are read as nothing.
+func (t *bigTIFF) values(e []byte) ([]uint64, error) {
+	var size uint64
+	switch t.order.Uint16(e[2:]) {
+	case 1:
+		size = 1
+	case 3:
+		size = 2
+	case 4:
+		size = 4
+	case 16:
+		size = 8
+	default:
+		return nil, nil
+	}
+	n := t.order.Uint64(e[4:])
+	if n > uint64(len(t.buf))/size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := e[12:20]
+	if n*size > 8 {
+		off := t.order.Uint64(e[12:])
+		if off > uint64(len(t.buf)) || uint64(len(t.buf))-off < n*size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data = t.buf[off:]
+	}
+	ret := make([]uint64, n)
+	for i := range ret {
+		b := data[uint64(i)*size:]
+		switch size {
+		case 1:
+			ret[i] = uint64(b[0])
+		case 2:
+			ret[i] = uint64(t.order.Uint16(b))
+		case 4:
+			ret[i] = uint64(t.order.Uint32(b))
+		default:
+			ret[i] = t.order.Uint64(b)
+		}
+	}
+	return ret, nil
+}
+
+// tag returns the first value of the tag, or def if it is absent.
+func (t *bigTIFF) tag(tag uint16, def uint64) uint64 {
+	if v := t.tags[tag]; len(v) > 0 {
+		return v[0]
+	}
+	return def
+}
+
+func (t *bigTIFF) decode() (image.Image, error) {
+	w, h := t.tag(tagWidth, 0), t.tag(tagHeight, 0)
+	if w == 0 || h == 0 {
+		return nil, errors.ErrFileImageDim
+	}
+	if w > maxBigTIFFPixels || h > maxBigTIFFPixels/w {
+		return nil, errors.ErrFileImageDecode
+	}
+	spp := t.tag(tagSamplesPerPixel, 1)
+	bps := t.tag(tagBitsPerSample, 1)
+	for _, b := range t.tags[tagBitsPerSample] {
+		if b != bps {
+			return nil, errors.ErrFileImageDecode
+		}
+	}
+	photometric := t.tag(tagPhotometric, 1)
+	if spp == 0 || spp > 16 || (bps != 8 && bps != 16) ||
+		t.tag(tagPlanarConfig, 1) != 1 || t.tag(tagSampleFormat, 1) != 1 ||
+		photometric > 2 || (photometric == 2 && spp < 3) {
+		return nil, errors.ErrFileImageDecode
+	}
+	compression := t.tag(tagCompression, 1)
+	predictor := t.tag(tagPredictor, 1)
+	if (compression != 1 && compression != 5 && compression != 8 && compression != 32946) || predictor > 2 {
+		return nil, errors.ErrFileImageDecode
+	}
+	alpha := photometric == 2 && spp >= 4 && t.tag(tagExtraSamples, 0) != 0
+
+	// strips are the tiles of the full width
+	segW, segH := w, t.tag(tagRowsPerStrip, h)
+	offsets, counts := t.tags[tagStripOffsets], t.tags[tagStripByteCounts]
+	_, tiled := t.tags[tagTileWidth]
+	if tiled {
+		segW, segH = t.tag(tagTileWidth, 0), t.tag(tagTileLength, 0)
+		offsets, counts = t.tags[tagTileOffsets], t.tags[tagTileByteCounts]
+	}
+	if segW == 0 || segH == 0 || segW > maxBigTIFFPixels/segH || len(offsets) == 0 || len(offsets) != len(counts) {
+		return nil, errors.ErrFileImageDecode
+	}
+	if segH > h {
+		segH = h
+	}
+	across := (w + segW - 1) / segW
+	down := (h + segH - 1) / segH
+	if uint64(len(offsets)) < across*down {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	rect := image.Rect(0, 0, int(w), int(h))
+	var img image.Image
+	var set func(x, y int, px []byte)
+	bytesPS := int(bps / 8)
+	sample := func(px []byte, i int) uint16 {
+		if bytesPS == 1 {
+			return uint16(px[i])
+		}
+		return t.order.Uint16(px[2*i:])
+	}
+	gray := func(px []byte) uint16 {
+		v := sample(px, 0)
+		if photometric == 0 {
+			v = uint16(1<<bps-1) - v
+		}
+		return v
+	}
+	switch {
+	case photometric == 2 && bps == 8:
+		m := image.NewNRGBA(rect)
+		img = m
+		set = func(x, y int, px []byte) {
+			c := color.NRGBA{px[0], px[1], px[2], 0xff}
+			if alpha {
+				c.A = px[3]
+			}
+			m.SetNRGBA(x, y, c)
+		}
+	case photometric == 2:
+		m := image.NewNRGBA64(rect)
+		img = m
+		set = func(x, y int, px []byte) {
+			c := color.NRGBA64{sample(px, 0), sample(px, 1), sample(px, 2), 0xffff}
+			if alpha {
+				c.A = sample(px, 3)
+			}
+			m.SetNRGBA64(x, y, c)
+		}
+	case bps == 8:
+		m := image.NewGray(rect)
+		img = m
+		set = func(x, y int, px []byte) { m.SetGray(x, y, color.Gray{uint8(gray(px))}) }
+	default:
+		m := image.NewGray16(rect)
+		img = m
+		set = func(x, y int, px []byte) { m.SetGray16(x, y, color.Gray16{gray(px)}) }
+	}
+
+	pxSize := int(spp) * bytesPS
+	rowSize := int(segW) * pxSize
+	for i := uint64(0); i < across*down; i++ {
+		x0, y0 := int(i%across*segW), int(i/across*segH)
+		// the last strip may have fewer rows, unlike a tile
+		rows := int(segH)
+		if !tiled && y0+rows > int(h) {
+			rows = int(h) - y0
+		}
+		data, err := t.segment(offsets[i], counts[i], compression, rowSize*rows)
+		if err != nil {
+			return nil, err
+		}
+		if predictor == 2 {
+			t.undoPredictor(data, rowSize, pxSize, bytesPS)
+		}
+		for y := 0; y < rows && y0+y < int(h); y++ {
+			row := data[y*rowSize:]
+			for x := 0; x < int(segW) && x0+x < int(w); x++ {
+				set(x0+x, y0+y, row[x*pxSize:])
+			}
+		}
+	}
+	return img, nil
+}
+
+// segment returns the size bytes of the strip or tile at off of count bytes,
+// decompressed.
+func (t *bigTIFF) segment(off, count, compression uint64, size int) ([]byte, error) {
+	if off > uint64(len(t.buf)) || uint64(len(t.buf))-off < count {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := t.buf[off : off+count]
+	var r io.Reader
+	switch compression {
+	case 5:
+		lr := lzw.NewReader(bytes.NewReader(data), lzw.MSB, 8)
+		defer lr.Close()
+		r = lr
+	case 8, 32946:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		if len(data) < size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return data[:size], nil
+	}
+	ret := make([]byte, size)
+	if _, err := io.ReadFull(r, ret); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return ret, nil
+}
+
+// undoPredictor adds up the horizontal differences of each sample in each row
+// of the segment.
+func (t *bigTIFF) undoPredictor(data []byte, rowSize, pxSize, bytesPS int) {
+	for y := 0; y+rowSize <= len(data); y += rowSize {
+		row := data[y : y+rowSize]
+		for i := pxSize; i+bytesPS <= len(row); i += bytesPS {
+			if bytesPS == 1 {
+				row[i] += row[i-pxSize]
+				continue
+			}
+			v := t.order.Uint16(row[i:]) + t.order.Uint16(row[i-pxSize:])
+			t.order.PutUint16(row[i:], v)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package file
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jackytck/alti-cli/config"
+)
+
+// DigestCacheFilename is the filename of the digest cache under the config directory.
+const DigestCacheFilename = "digest-cache.gob"
+
+// CacheEntry is the cached digest of an image file, valid as long as the size
+// and modification time of the file are unchanged.
+type CacheEntry struct {
+	Size     int64
+	ModTime  time.Time
+	Filetype string
+	Width    int
+	Height   int
+	GP       float64
+	SHA1     string
+	Meta     *ImageMeta // nil if cached before metadata was parsed
+	Cached   time.Time
+	// Downscaled is the checksum of the last downscaled copy of the file
+	// for uploading, which is what the project has instead of SHA1.
+	Downscaled string
+}
+
+// CacheStats summarizes a digest cache.
+type CacheStats struct {
+	Entries   int
+	Bytes     int64 // total size of the cached files
+	Stale     int   // entries of removed or modified files
+	FileBytes int64 // size of the cache file itself
+	Oldest    time.Time
+	Newest    time.Time
+}
+
+// DigestCache maps the absolute path, size and modification time of image
+// files to their digests, so that unchanged files need not be read again.
+// It is safe for concurrent use.
+type DigestCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]CacheEntry
+	dirty   bool
+}
+
+// DefaultDigestCachePath returns the path of the digest cache under the config directory.
+func DefaultDigestCachePath() (string, error) {
+	confDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(confDir, DigestCacheFilename), nil
+}
+
+// OpenDigestCache loads the digest cache from p. A new empty cache is
+// returned if p does not exist.
+func OpenDigestCache(p string) (*DigestCache, error) {
+	entries, err := loadCacheEntries(p)
+	if err != nil {
+		return nil, err
+	}
+	return &DigestCache{path: p, entries: entries}, nil
+}
+
+// loadCacheEntries decodes the cache entries from p.
+func loadCacheEntries(p string) (map[string]CacheEntry, error) {
+	ret := make(map[string]CacheEntry)
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(&ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Get returns the cached digest of the file at p with the given file info,
+// if the file is unchanged since cached.
+func (c *DigestCache) Get(p string, info os.FileInfo) (ImageScan, bool) {
+	var ret ImageScan
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return ret, false
+	}
+	c.mu.Lock()
+	e, ok := c.entries[abs]
+	c.mu.Unlock()
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) || e.Meta == nil {
+		return ret, false
+	}
+	ret = ImageScan{
+		IsImage:  true,
+		Filetype: e.Filetype,
+		Filesize: e.Size,
+		Width:    e.Width,
+		Height:   e.Height,
+		SHA1:     e.SHA1,
+		Meta:     *e.Meta,
+	}
+	return ret, true
+}
+
+// Put caches the digest of the file at p with the file info taken before
+// it was scanned.
+func (c *DigestCache) Put(p string, info os.FileInfo, scan ImageScan) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return
+	}
+	e := CacheEntry{
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Filetype: scan.Filetype,
+		Width:    scan.Width,
+		Height:   scan.Height,
+		GP:       DimToGigaPixel(scan.Width, scan.Height),
+		SHA1:     scan.SHA1,
+		Meta:     &scan.Meta,
+		Cached:   time.Now(),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[abs] = e
+	c.dirty = true
+}
+
+// SetDownscaled records sum as the checksum of the downscaled copy of the
+// cached file at p of checksum sha1.
+func (c *DigestCache) SetDownscaled(p, sha1, sum string) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[abs]
+	if !ok || e.SHA1 != sha1 || e.Downscaled == sum {
+		return
+	}
+	e.Downscaled = sum
+	c.entries[abs] = e
+	c.dirty = true
+}
+
+// Downscaled returns the checksum of the downscaled copy of the cached file at
+// p of checksum sha1, or empty if it has none.
+func (c *DigestCache) Downscaled(p, sha1 string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[abs]; ok && e.SHA1 == sha1 {
+		return e.Downscaled
+	}
+	return ""
+}
+
+// Len returns the number of cached entries.
+func (c *DigestCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Save writes the cache back to its path if it is changed. Entries written by
+// other processes in the meantime are merged.
+func (c *DigestCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	onDisk, err := loadCacheEntries(c.path)
+	if err != nil {
+		// corrupted cache is simply overwritten
+		onDisk = make(map[string]CacheEntry)
+	}
+	for k, v := range c.entries {
+		onDisk[k] = v
+	}
+	if err := c.write(onDisk); err != nil {
+		return err
+	}
+	c.entries = onDisk
+	c.dirty = false
+	return nil
+}
+
+// write replaces the cache file atomically with entries.
+func (c *DigestCache) write(entries map[string]CacheEntry) error {
+	dir := filepath.Dir(c.path)
+	if err := EnsureDir(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, DigestCacheFilename+".")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(tmp).Encode(entries); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// isStale tells if the file of the entry is removed or modified.
+func isStale(p string, e CacheEntry) bool {
+	info, err := StatPath(p)
+	if err != nil {
+		return true
+	}
+	return info.Size() != e.Size || !info.ModTime().Equal(e.ModTime)
+}
+
+// Stats summarizes the cache. Each cached file is checked for staleness.
+func (c *DigestCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret CacheStats
+	for p, e := range c.entries {
+		ret.Entries++
+		ret.Bytes += e.Size
+		if isStale(p, e) {
+			ret.Stale++
+		}
+		if ret.Oldest.IsZero() || e.Cached.Before(ret.Oldest) {
+			ret.Oldest = e.Cached
+		}
+		if e.Cached.After(ret.Newest) {
+			ret.Newest = e.Cached
+		}
+	}
+	if info, err := os.Stat(c.path); err == nil {
+		ret.FileBytes = info.Size()
+	}
+	return ret
+}
+
+// Prune removes the entries of removed or modified files, and the entries
+// cached longer than olderThan if it is positive. The cache file is rewritten.
+// Return the number of removed entries.
+func (c *DigestCache) Prune(olderThan time.Duration) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for p, e := range c.entries {
+		if isStale(p, e) || (olderThan > 0 && time.Since(e.Cached) > olderThan) {
+			delete(c.entries, p)
+			n++
+		}
+	}
+	if err := c.write(c.entries); err != nil {
+		return 0, err
+	}
+	c.dirty = false
+	return n, nil
+}
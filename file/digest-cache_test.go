@@ -0,0 +1,66 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDigestCache(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	img := filepath.Join(tmpDir, "img.bin")
+	if err = ioutil.WriteFile(img, []byte("not really an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(img)
+	scan := ImageScan{IsImage: true, Filetype: "image/jpeg", Filesize: info.Size(), Width: 4032, Height: 3024, SHA1: "abc"}
+
+	cachePath := filepath.Join(tmpDir, DigestCacheFilename)
+	c, err := OpenDigestCache(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(img, info); ok {
+		t.Error("Get() hit on empty cache")
+	}
+	c.Put(img, info, scan)
+	if err = c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		c2, err := OpenDigestCache(cachePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := c2.Get(img, info)
+		if !ok || got != scan {
+			t.Errorf("Get() = %+v, %v, want %+v, true", got, ok, scan)
+		}
+		if s := c2.Stats(); s.Entries != 1 || s.Stale != 0 || s.Bytes != info.Size() {
+			t.Errorf("Stats() = %+v", s)
+		}
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		later := info.ModTime().Add(time.Minute)
+		if err := os.Chtimes(img, later, later); err != nil {
+			t.Fatal(err)
+		}
+		info2, _ := os.Stat(img)
+		if _, ok := c.Get(img, info2); ok {
+			t.Error("Get() hit on modified file")
+		}
+		n, err := c.Prune(0)
+		if err != nil || n != 1 || c.Len() != 0 {
+			t.Errorf("Prune() = %d, %v, len %d, want 1, nil, len 0", n, err, c.Len())
+		}
+	})
+}
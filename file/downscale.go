@@ -0,0 +1,438 @@
+package file
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// DownscaleQuality is the quality of the jpeg of a downscaled image.
+const DownscaleQuality = 95
+
+// headers of the APP1 segments of jpeg
+const (
+	exifHeader = "Exif\x00\x00"
+	xmpHeader  = "http://ns.adobe.com/xap/1.0/\x00"
+	xmpKeyword = "XML:com.adobe.xmp"
+)
+
+// DownscaleDir returns the directory of the downscaled copies of the images of
+// the session, which is under the temp directory. Each session has its own
+// directory, so that it could be cleaned up without touching the copies of
+// the others.
+func DownscaleDir(session string) string {
+	return filepath.Join(os.TempDir(), "alti-cli-downscale", session)
+}
+
+// DownscaleFactors returns the linear scale factor of each image of dims,
+// i.e. width and height, so that each of them has at most maxMP mega pixels,
+// and all of them have at most maxGP giga pixels in total. Non-positive
+// limits are ignored. Each factor is at most 1.
+func DownscaleFactors(dims [][2]int, maxMP, maxGP float64) []float64 {
+	ret := make([]float64, len(dims))
+	var total float64
+	for i, d := range dims {
+		ret[i] = 1
+		mp := float64(d[0]) * float64(d[1]) / 1e6
+		if maxMP > 0 && mp > maxMP {
+			ret[i] = math.Sqrt(maxMP / mp)
+		}
+		total += mp / 1e3 * ret[i] * ret[i]
+	}
+	if maxGP > 0 && total > maxGP {
+		k := math.Sqrt(maxGP / total)
+		for i := range ret {
+			ret[i] *= k
+		}
+	}
+	return ret
+}
+
+// ScaledDim scales the dimension by f, rounded down so that the limits are
+// not exceeded, and at least 1.
+func ScaledDim(w, h int, f float64) (int, int) {
+	if f >= 1 {
+		return w, h
+	}
+	sw := int(float64(w) * f)
+	sh := int(float64(h) * f)
+	if sw < 1 {
+		sw = 1
+	}
+	if sh < 1 {
+		sh = 1
+	}
+	return sw, sh
+}
+
+// DownscaleType returns the type of the downscaled copy of an image of
+// filetype. Png stays png unless toJPEG, and the others become jpeg.
+func DownscaleType(filetype string, toJPEG bool) string {
+	if filetype == TypePNG && !toJPEG {
+		return TypePNG
+	}
+	return TypeJPEG
+}
+
+// Downscale resamples the image at src to w x h, which is in its stored
+// orientation, applies its EXIF orientation, and writes it to dst as jpeg or
+// png by DownscaleType. The EXIF and XMP of jpeg, png and webp are preserved,
+// with the orientation reset and the dimension updated, so that the gps
+// survives. Return the scan of dst.
+func Downscale(src, dst string, w, h int, toJPEG bool) (ImageScan, error) {
+	f, err := OpenPath(src)
+	if err != nil {
+		return ImageScan{}, err
+	}
+	b, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return ImageScan{}, err
+	}
+
+	// a. decode, resample and orient
+	filetype := SniffImageType(b)
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return ImageScan{}, err
+	}
+	head := b
+	if len(head) > metaHeadSize {
+		head = head[:metaHeadSize]
+	}
+	meta := ParseImageMeta(head)
+	scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	upright := orient(scaled, meta.Orientation)
+	ow, oh := upright.Bounds().Dx(), upright.Bounds().Dy()
+
+	// b. encode with the metadata
+	exifData, xmpData := extractMetadata(b, filetype)
+	if exifData != nil {
+		exifData = append([]byte(nil), exifData...)
+		patchExif(exifData, ow, oh)
+	}
+	if xmpData != nil {
+		xmpData = patchXMP(xmpData, ow, oh)
+	}
+	var buf bytes.Buffer
+	var out []byte
+	if DownscaleType(filetype, toJPEG) == TypePNG {
+		if err := png.Encode(&buf, upright); err != nil {
+			return ImageScan{}, err
+		}
+		out = insertPNGMetadata(buf.Bytes(), exifData, xmpData)
+	} else {
+		if err := jpeg.Encode(&buf, upright, &jpeg.Options{Quality: DownscaleQuality}); err != nil {
+			return ImageScan{}, err
+		}
+		out = insertJPEGMetadata(buf.Bytes(), exifData, xmpData)
+	}
+
+	// c. write atomically
+	if err := EnsureDir(filepath.Dir(dst), 0755); err != nil {
+		return ImageScan{}, err
+	}
+	tmp := dst + ".tmp"
+	if err := ioutil.WriteFile(tmp, out, 0644); err != nil {
+		return ImageScan{}, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return ImageScan{}, err
+	}
+	return ScanImage(dst, false)
+}
+
+// orient transforms img by the EXIF orientation o so that it is upright.
+func orient(img *image.RGBA, o int) *image.RGBA {
+	if o < 2 || o > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2: // mirror horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirror vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 cw
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90 ccw
+				dx, dy = y, w-1-x
+			}
+			si := img.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], img.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// extractMetadata returns the raw EXIF, i.e. a tiff structure, and the XMP
+// packet of a jpeg, png or webp, nil if not found.
+func extractMetadata(b []byte, filetype string) (exifData, xmpData []byte) {
+	switch filetype {
+	case TypeJPEG:
+		for i := 2; i+4 <= len(b); {
+			if b[i] != 0xFF {
+				break
+			}
+			marker := b[i+1]
+			if marker == 0xFF || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD8) {
+				i++
+				if marker != 0xFF {
+					i++
+				}
+				continue
+			}
+			if marker == 0xDA || marker == 0xD9 {
+				break
+			}
+			l := int(binary.BigEndian.Uint16(b[i+2:]))
+			if l < 2 || i+2+l > len(b) {
+				break
+			}
+			seg := b[i+4 : i+2+l]
+			if marker == 0xE1 {
+				if bytes.HasPrefix(seg, []byte(exifHeader)) && exifData == nil {
+					exifData = seg[len(exifHeader):]
+				} else if bytes.HasPrefix(seg, []byte(xmpHeader)) && xmpData == nil {
+					xmpData = seg[len(xmpHeader):]
+				}
+			}
+			i += 2 + l
+		}
+	case TypePNG:
+		for i := 8; i+12 <= len(b); {
+			l := int(binary.BigEndian.Uint32(b[i:]))
+			if l < 0 || i+12+l > len(b) {
+				break
+			}
+			data := b[i+8 : i+8+l]
+			switch string(b[i+4 : i+8]) {
+			case "eXIf":
+				exifData = data
+			case "iTXt":
+				if kw, text := parseITXt(data); kw == xmpKeyword {
+					xmpData = text
+				}
+			}
+			i += 12 + l
+		}
+	case TypeWebP:
+		for i := 12; i+8 <= len(b); {
+			l := int(binary.LittleEndian.Uint32(b[i+4:]))
+			if l < 0 || i+8+l > len(b) {
+				break
+			}
+			data := b[i+8 : i+8+l]
+			switch string(b[i : i+4]) {
+			case "EXIF":
+				exifData = bytes.TrimPrefix(data, []byte(exifHeader))
+			case "XMP ":
+				xmpData = data
+			}
+			i += 8 + l + l%2
+		}
+	}
+	return exifData, xmpData
+}
+
+// parseITXt parses the keyword and the text of an iTXt chunk of png.
+func parseITXt(data []byte) (string, []byte) {
+	parts := bytes.SplitN(data, []byte{0}, 2)
+	if len(parts) != 2 || len(parts[1]) < 2 {
+		return "", nil
+	}
+	kw := string(parts[0])
+	compressed := parts[1][0] == 1
+	// skip the language tag and the translated keyword
+	rest := bytes.SplitN(parts[1][2:], []byte{0}, 3)
+	if len(rest) != 3 {
+		return kw, nil
+	}
+	text := rest[2]
+	if compressed {
+		r, err := zlib.NewReader(bytes.NewReader(text))
+		if err != nil {
+			return kw, nil
+		}
+		defer r.Close()
+		if text, err = ioutil.ReadAll(r); err != nil {
+			return kw, nil
+		}
+	}
+	return kw, text
+}
+
+// insertJPEGMetadata inserts the EXIF and XMP as APP1 segments right after
+// the SOI of the jpeg. Those too large for a segment are dropped.
+func insertJPEGMetadata(b, exifData, xmpData []byte) []byte {
+	var segs []byte
+	app1 := func(header string, data []byte) {
+		l := 2 + len(header) + len(data)
+		if data == nil || l > 0xFFFF {
+			return
+		}
+		segs = append(segs, 0xFF, 0xE1, byte(l>>8), byte(l))
+		segs = append(segs, header...)
+		segs = append(segs, data...)
+	}
+	app1(exifHeader, exifData)
+	app1(xmpHeader, xmpData)
+	if segs == nil || len(b) < 2 {
+		return b
+	}
+	out := make([]byte, 0, len(b)+len(segs))
+	out = append(out, b[:2]...)
+	out = append(out, segs...)
+	return append(out, b[2:]...)
+}
+
+// insertPNGMetadata inserts the EXIF and XMP as eXIf and iTXt chunks right
+// after the IHDR of the png.
+func insertPNGMetadata(b, exifData, xmpData []byte) []byte {
+	// signature + IHDR
+	const ihdrEnd = 8 + 12 + 13
+	if len(b) < ihdrEnd {
+		return b
+	}
+	var chunks []byte
+	chunk := func(typ string, data []byte) {
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(data)))
+		chunks = append(chunks, l[:]...)
+		body := append([]byte(typ), data...)
+		chunks = append(chunks, body...)
+		var crc [4]byte
+		binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(body))
+		chunks = append(chunks, crc[:]...)
+	}
+	if exifData != nil {
+		chunk("eXIf", exifData)
+	}
+	if xmpData != nil {
+		// keyword, no compression, empty language and translated keyword
+		data := append([]byte(xmpKeyword), 0, 0, 0, 0, 0)
+		chunk("iTXt", append(data, xmpData...))
+	}
+	if chunks == nil {
+		return b
+	}
+	out := make([]byte, 0, len(b)+len(chunks))
+	out = append(out, b[:ihdrEnd]...)
+	out = append(out, chunks...)
+	return append(out, b[ihdrEnd:]...)
+}
+
+// EXIF tags to be patched
+const (
+	tagOrientation     = 0x0112
+	tagExifIFD         = 0x8769
+	tagPixelXDimension = 0xA002
+	tagPixelYDimension = 0xA003
+)
+
+// patchExif resets the orientation of the raw EXIF to 1, and sets its pixel
+// dimension to w x h in place.
+func patchExif(b []byte, w, h int) {
+	if len(b) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+	set := func(typ uint16, val []byte, v int) {
+		switch typ {
+		case 3: // short
+			order.PutUint16(val, uint16(v))
+		case 4: // long
+			order.PutUint32(val, uint32(v))
+		}
+	}
+	// walk calls fn with the tag, type and 4 bytes value of each entry of the
+	// ifd at off
+	walk := func(off int, fn func(tag, typ uint16, val []byte)) {
+		if off < 8 || off+2 > len(b) {
+			return
+		}
+		n := int(order.Uint16(b[off:]))
+		for i := 0; i < n && i < maxTIFFEntries; i++ {
+			e := off + 2 + i*12
+			if e+12 > len(b) {
+				return
+			}
+			fn(order.Uint16(b[e:]), order.Uint16(b[e+2:]), b[e+8:e+12])
+		}
+	}
+
+	exifIFD := 0
+	walk(int(order.Uint32(b[4:])), func(tag, typ uint16, val []byte) {
+		switch tag {
+		case tagOrientation:
+			set(typ, val, 1)
+		case tagExifIFD:
+			exifIFD = int(order.Uint32(val))
+		}
+	})
+	walk(exifIFD, func(tag, typ uint16, val []byte) {
+		switch tag {
+		case tagPixelXDimension:
+			set(typ, val, w)
+		case tagPixelYDimension:
+			set(typ, val, h)
+		}
+	})
+}
+
+// xmpDims matches the orientation and pixel dimension of XMP, as attributes
+// or elements.
+var xmpDims = regexp.MustCompile(`((?:tiff:Orientation|exif:PixelXDimension|exif:PixelYDimension)(?:="|>))\d+`)
+
+// patchXMP resets the orientation of the XMP to 1, and sets its pixel
+// dimension to w x h.
+func patchXMP(x []byte, w, h int) []byte {
+	return xmpDims.ReplaceAllFunc(x, func(m []byte) []byte {
+		prefix := xmpDims.FindSubmatch(m)[1]
+		v := "1"
+		switch {
+		case bytes.HasPrefix(prefix, []byte("exif:PixelX")):
+			v = strconv.Itoa(w)
+		case bytes.HasPrefix(prefix, []byte("exif:PixelY")):
+			v = strconv.Itoa(h)
+		}
+		return append(append([]byte(nil), prefix...), v...)
+	})
+}
@@ -0,0 +1,128 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownscaleFactors(t *testing.T) {
+	dims := [][2]int{{8000, 5000}, {4000, 3000}}
+	tests := []struct {
+		name  string
+		maxMP float64
+		maxGP float64
+		want  []float64
+	}{
+		{"none", 0, 0, []float64{1, 1}},
+		{"max mp", 20, 0, []float64{math.Sqrt(0.5), 1}},
+		{"max gp", 0, 0.026, []float64{math.Sqrt(0.5), math.Sqrt(0.5)}},
+		{"both", 20, 0.016, []float64{math.Sqrt(0.5) * math.Sqrt(0.5), math.Sqrt(0.5)}},
+	}
+	for _, tt := range tests {
+		got := DownscaleFactors(dims, tt.maxMP, tt.maxGP)
+		for i := range got {
+			if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+				t.Errorf("%s: DownscaleFactors() = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+	if w, h := ScaledDim(8000, 5000, math.Sqrt(0.5)); w*h > 20e6 || w != 5656 {
+		t.Errorf("ScaledDim() = %d x %d, want at most 20 MP", w, h)
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, image.NewGray(image.Rect(0, 0, 40, 20)), nil); err != nil {
+		t.Fatal(err)
+	}
+	raw := enc.Bytes()
+	img := append([]byte{0xFF, 0xD8}, exifSegment()...)
+	img = append(img, raw[2:]...)
+
+	dir, err := ioutil.TempDir("", "alti-cli-downscale-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "dji.jpg")
+	if err := ioutil.WriteFile(src, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	scan, err := Downscale(src, filepath.Join(dir, "out", "dji.jpg"), 20, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scan.Filetype != TypeJPEG || scan.Width != 20 || scan.Height != 10 {
+		t.Errorf("Downscale() = %s %d x %d, want jpeg 20 x 10", scan.Filetype, scan.Width, scan.Height)
+	}
+	if m := scan.Meta; !m.HasGPS || m.Camera() != "DJI FC6310" || math.Abs(m.Latitude-22.5) > 1e-9 {
+		t.Errorf("Downscale() meta = %s, want the gps preserved", m)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 2 x 1, red then blue
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	img.Set(0, 0, red)
+	img.Set(1, 0, blue)
+
+	// rotated 90 cw, red on top
+	got := orient(img, 6)
+	if got.Bounds().Dx() != 1 || got.Bounds().Dy() != 2 || got.RGBAAt(0, 0) != red || got.RGBAAt(0, 1) != blue {
+		t.Errorf("orient(6) = %v %v", got.Bounds(), got.Pix)
+	}
+	// mirrored, blue on the left
+	got = orient(img, 2)
+	if got.RGBAAt(0, 0) != blue || got.RGBAAt(1, 0) != red {
+		t.Errorf("orient(2) = %v", got.Pix)
+	}
+}
+
+func TestPatchExif(t *testing.T) {
+	le := binary.LittleEndian
+	// ifd0 of orientation 6 and exif ifd pointer, exif ifd of pixel dimension
+	b := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	entry := func(tag, typ uint16, v uint32) []byte {
+		e := make([]byte, 12)
+		le.PutUint16(e, tag)
+		le.PutUint16(e[2:], typ)
+		le.PutUint32(e[4:], 1)
+		le.PutUint32(e[8:], v)
+		return e
+	}
+	b = append(b, 2, 0)
+	b = append(b, entry(tagOrientation, 3, 6)...)
+	b = append(b, entry(tagExifIFD, 4, 8+2+24+4)...)
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, 2, 0)
+	b = append(b, entry(tagPixelXDimension, 4, 4000)...)
+	b = append(b, entry(tagPixelYDimension, 3, 3000)...)
+	b = append(b, 0, 0, 0, 0)
+
+	patchExif(b, 1500, 2000)
+	if o := le.Uint16(b[8+2+8:]); o != 1 {
+		t.Errorf("orientation = %d, want 1", o)
+	}
+	exif := 8 + 2 + 24 + 4 + 2
+	if w, h := le.Uint32(b[exif+8:]), le.Uint16(b[exif+12+8:]); w != 1500 || h != 2000 {
+		t.Errorf("pixel dimension = %d x %d, want 1500 x 2000", w, h)
+	}
+
+	x := patchXMP([]byte(`<rdf:Description tiff:Orientation="6" exif:PixelXDimension="4000"><exif:PixelYDimension>3000</exif:PixelYDimension>`), 1500, 2000)
+	if want := `<rdf:Description tiff:Orientation="1" exif:PixelXDimension="1500"><exif:PixelYDimension>2000</exif:PixelYDimension>`; string(x) != want {
+		t.Errorf("patchXMP() = %s, want %s", x, want)
+	}
+}
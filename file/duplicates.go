@@ -0,0 +1,133 @@
+package file
+
+import "sort"
+
+// Kinds of duplicate cluster.
+const (
+	DuplicateExact   = "exact"
+	DuplicateSimilar = "similar"
+)
+
+// DuplicateCluster is a group of identical or visually similar images.
+// Keep is the image with the largest giga-pixel, the rest are Extras.
+type DuplicateCluster struct {
+	Kind     string
+	Keep     ImageDigest
+	Extras   []ImageDigest
+	Distance int // max hamming distance to the kept image, 0 if exact
+}
+
+// ExtraGP returns the total giga-pixel of the extra images.
+func (c DuplicateCluster) ExtraGP() float64 {
+	var ret float64
+	for _, d := range c.Extras {
+		ret += d.GP
+	}
+	return ret
+}
+
+// Near reports if the image d is the kept image or within threshold bits of
+// it, i.e. an extra that is safe to cull.
+func (c DuplicateCluster) Near(d ImageDigest, threshold int) bool {
+	if d.SHA1 == c.Keep.SHA1 {
+		return true
+	}
+	return threshold >= 0 && d.Hash != nil && c.Keep.Hash != nil && HammingDistance(*d.Hash, *c.Keep.Hash) <= threshold
+}
+
+// FindDuplicates groups the images with the same checksum. If threshold is
+// not negative, images whose perceptual hashes differ by at most threshold
+// bits from the kept image of a group are grouped too, so a chain of similar
+// images is not grouped as a whole. Only groups of more than one image are
+// returned.
+func FindDuplicates(digests []ImageDigest, threshold int) []DuplicateCluster {
+	ds := make([]ImageDigest, len(digests))
+	copy(ds, digests)
+	sort.Slice(ds, func(i, j int) bool { return ds[i].Path < ds[j].Path })
+
+	// exact duplicates, grouped by checksum
+	var groups [][]ImageDigest
+	bySHA1 := make(map[string]int)
+	for _, d := range ds {
+		if d.SHA1 == "" {
+			continue
+		}
+		if g, ok := bySHA1[d.SHA1]; ok {
+			groups[g] = append(groups[g], d)
+			continue
+		}
+		bySHA1[d.SHA1] = len(groups)
+		groups = append(groups, []ImageDigest{d})
+	}
+
+	// near duplicates join the first leader within threshold, the leaders are
+	// taken from the largest giga-pixel so that each leads as the kept image
+	leader := make([]int, len(groups))
+	for g := range leader {
+		leader[g] = g
+	}
+	if threshold >= 0 {
+		order := make([]int, len(groups))
+		for g := range order {
+			order[g] = g
+		}
+		sort.SliceStable(order, func(i, j int) bool { return groups[order[i]][0].GP > groups[order[j]][0].GP })
+		var leaders []int
+		for _, g := range order {
+			h := groups[g][0].Hash
+			if h == nil {
+				continue
+			}
+			for _, l := range leaders {
+				if HammingDistance(*groups[l][0].Hash, *h) <= threshold {
+					leader[g] = l
+					break
+				}
+			}
+			if leader[g] == g {
+				leaders = append(leaders, g)
+			}
+		}
+	}
+
+	members := make(map[int][]ImageDigest)
+	var roots []int
+	for g, l := range leader {
+		if _, ok := members[l]; !ok {
+			roots = append(roots, l)
+		}
+		members[l] = append(members[l], groups[g]...)
+	}
+
+	var ret []DuplicateCluster
+	for _, l := range roots {
+		if m := members[l]; len(m) > 1 {
+			ret = append(ret, newDuplicateCluster(groups[l][0], m))
+		}
+	}
+	return ret
+}
+
+// newDuplicateCluster keeps the image keep of the group.
+func newDuplicateCluster(keep ImageDigest, group []ImageDigest) DuplicateCluster {
+	ret := DuplicateCluster{
+		Kind: DuplicateExact,
+		Keep: keep,
+	}
+	for _, d := range group {
+		if d.Path == keep.Path {
+			continue
+		}
+		ret.Extras = append(ret.Extras, d)
+		if d.SHA1 == ret.Keep.SHA1 {
+			continue
+		}
+		ret.Kind = DuplicateSimilar
+		if d.Hash != nil && ret.Keep.Hash != nil {
+			if dist := HammingDistance(*d.Hash, *ret.Keep.Hash); dist > ret.Distance {
+				ret.Distance = dist
+			}
+		}
+	}
+	return ret
+}
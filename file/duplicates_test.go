@@ -0,0 +1,90 @@
+package file
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// blockImage draws 16 x 12 blocks of pseudo random gray levels, with the
+// brightness shifted by delta.
+func blockImage(w, h, seed int, delta float64) image.Image {
+	var levels [16 * 12]float64
+	r := seed
+	for i := range levels {
+		r = (r*1103515245 + 12345) & 0x7fffffff
+		levels[i] = float64(r % 200)
+	}
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := levels[(y*12/h)*16+x*16/w]
+			img.SetGray(x, y, color.Gray{Y: uint8(math.Min(v+delta, 255))})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	a := blockImage(640, 480, 1, 0)
+	b := blockImage(320, 240, 1, 20)
+	c := blockImage(640, 480, 2, 0)
+
+	for _, kind := range []string{HashDHash, HashPHash} {
+		ha, hb, hc := PerceptualHash(a, kind), PerceptualHash(b, kind), PerceptualHash(c, kind)
+		if d := HammingDistance(ha, hb); d > 5 {
+			t.Errorf("%s: distance of resized and brightened image = %d, want <= 5", kind, d)
+		}
+		if d := HammingDistance(ha, hc); d <= 5 {
+			t.Errorf("%s: distance of different image = %d, want > 5", kind, d)
+		}
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	hash := func(h uint64) *uint64 { return &h }
+	ds := []ImageDigest{
+		{Path: "d/1.jpg", SHA1: "a", GP: 0.01, Hash: hash(0xff00)},
+		{Path: "d/2.jpg", SHA1: "a", GP: 0.01, Hash: hash(0xff00)},
+		{Path: "d/3.jpg", SHA1: "b", GP: 0.02, Hash: hash(0xff01)},
+		{Path: "d/4.jpg", SHA1: "c", GP: 0.01, Hash: hash(0x00ff)},
+		{Path: "d/5.jpg", SHA1: "d", GP: 0.01, Hash: hash(0x00ff)},
+	}
+
+	exact := FindDuplicates(ds, -1)
+	if len(exact) != 1 || exact[0].Kind != DuplicateExact || exact[0].Keep.Path != "d/1.jpg" || len(exact[0].Extras) != 1 {
+		t.Errorf("FindDuplicates(-1) = %+v, want one exact cluster keeping d/1.jpg", exact)
+	}
+
+	near := FindDuplicates(ds, 2)
+	if len(near) != 2 {
+		t.Fatalf("FindDuplicates(2) got %d clusters, want 2", len(near))
+	}
+	if c := near[0]; c.Kind != DuplicateSimilar || c.Keep.Path != "d/3.jpg" || len(c.Extras) != 2 || c.Distance != 1 {
+		t.Errorf("FindDuplicates(2)[0] = %+v, want similar cluster keeping d/3.jpg", c)
+	}
+	if c := near[1]; c.Keep.Path != "d/4.jpg" || len(c.Extras) != 1 || c.ExtraGP() != 0.01 {
+		t.Errorf("FindDuplicates(2)[1] = %+v, want cluster keeping d/4.jpg", c)
+	}
+
+	// a chain of neighbours 2 bits apart is not one cluster
+	chain := []ImageDigest{
+		{Path: "s/1.jpg", SHA1: "1", GP: 0.01, Hash: hash(0x00)},
+		{Path: "s/2.jpg", SHA1: "2", GP: 0.01, Hash: hash(0x03)},
+		{Path: "s/3.jpg", SHA1: "3", GP: 0.01, Hash: hash(0x0f)},
+		{Path: "s/4.jpg", SHA1: "4", GP: 0.01, Hash: hash(0x3f)},
+	}
+	cs := FindDuplicates(chain, 2)
+	if len(cs) != 2 {
+		t.Fatalf("FindDuplicates(chain) got %d clusters, want 2", len(cs))
+	}
+	for i, keep := range []string{"s/1.jpg", "s/3.jpg"} {
+		if c := cs[i]; c.Keep.Path != keep || len(c.Extras) != 1 || c.Distance > 2 || !c.Near(c.Extras[0], 2) {
+			t.Errorf("FindDuplicates(chain)[%d] = %+v, want cluster keeping %s within 2 bits", i, c, keep)
+		}
+	}
+	if c := cs[0]; c.Near(chain[3], 2) {
+		t.Errorf("Near(%+v) = true, want false", chain[3])
+	}
+}
@@ -0,0 +1,142 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// fbxRefKey is the property of the textures and videos of fbx that refers
+// to a file relative to the fbx.
+const fbxRefKey = "RelativeFilename"
+
+// fbxOverlap is the number of bytes kept between the chunks of a scan, which
+// is the max length of a reference found across chunks.
+const fbxOverlap = 4096
+
+// fbxInfo is the version and the relative references of a fbx.
+type fbxInfo struct {
+	Binary  bool
+	Version int // e.g. 7400 for 7.4
+	Refs    []string
+}
+
+// readFBX scans a binary or ascii fbx for its version and the relative
+// filenames of its textures, without parsing its nodes. The references are
+// slash separated.
+func readFBX(r io.Reader) (*fbxInfo, error) {
+	head := make([]byte, 27)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("invalid fbx: %v", err)
+	}
+	head = head[:n]
+	info := &fbxInfo{}
+	if bytes.HasPrefix(head, []byte(fbxBinaryMagic)) {
+		if n < 27 {
+			return nil, fmt.Errorf("fbx header is truncated")
+		}
+		info.Binary = true
+		info.Version = int(binary.LittleEndian.Uint32(head[23:]))
+	}
+
+	seen := make(map[string]bool)
+	buf := append([]byte{}, head...)
+	chunk := make([]byte, 1<<20)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		buf = append(buf, chunk[:n]...)
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return nil, err
+		}
+		if !info.Binary && info.Version == 0 {
+			info.Version = fbxASCIIVersion(buf)
+		}
+		for _, ref := range fbxRefs(buf, info.Binary) {
+			ref = strings.Replace(ref, `\`, "/", -1)
+			if ref != "" && !seen[ref] {
+				seen[ref] = true
+				info.Refs = append(info.Refs, ref)
+			}
+		}
+		if eof {
+			break
+		}
+		if len(buf) > fbxOverlap {
+			buf = append(buf[:0], buf[len(buf)-fbxOverlap:]...)
+		}
+	}
+	if !info.Binary && info.Version == 0 {
+		return nil, fmt.Errorf("not a fbx, neither binary nor with a '; FBX' header")
+	}
+	return info, nil
+}
+
+// fbxASCIIVersion returns the version of the header comment of an ascii fbx,
+// e.g. 7400 of "; FBX 7.4.0 project file", or 0.
+func fbxASCIIVersion(b []byte) int {
+	l := string(b)
+	if i := strings.IndexByte(l, '\n'); i >= 0 {
+		l = l[:i]
+	}
+	toks := strings.Fields(strings.TrimPrefix(strings.TrimSpace(l), ";"))
+	if len(toks) < 2 || toks[0] != "FBX" {
+		return 0
+	}
+	parts := strings.SplitN(toks[1], ".", 3)
+	v := 0
+	for i, m := range []int{1000, 100, 1} {
+		if i >= len(parts) {
+			break
+		}
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0
+		}
+		v += n * m
+	}
+	return v
+}
+
+// fbxRefs returns the values of the reference properties found in b. In a
+// binary fbx, the name of the node is followed by a string property of type
+// 'S' and its length, or else by a quoted string in an ascii fbx. Those cut
+// by the end of b are skipped.
+func fbxRefs(b []byte, bin bool) []string {
+	var ret []string
+	key := []byte(fbxRefKey)
+	for off := 0; ; {
+		i := bytes.Index(b[off:], key)
+		if i < 0 {
+			return ret
+		}
+		rest := b[off+i+len(key):]
+		off += i + len(key)
+		if bin {
+			if len(rest) < 5 || rest[0] != 'S' {
+				continue
+			}
+			n := int(binary.LittleEndian.Uint32(rest[1:]))
+			if n > fbxOverlap || 5+n > len(rest) {
+				continue
+			}
+			ret = append(ret, string(rest[5:5+n]))
+			continue
+		}
+		// e.g. RelativeFilename: "tex\wall.jpg"
+		if len(rest) > fbxOverlap {
+			rest = rest[:fbxOverlap]
+		}
+		s := strings.TrimLeft(string(rest), ": \t")
+		if !strings.HasPrefix(s, `"`) {
+			continue
+		}
+		if j := strings.IndexAny(s[1:], "\"\n"); j >= 0 && s[1+j] == '"' {
+			ret = append(ret, s[1:1+j])
+		}
+	}
+}
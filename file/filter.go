@@ -0,0 +1,472 @@
+package file
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	altiErrors "github.com/jackytck/alti-cli/errors"
+)
+
+// IgnoreFile is the name of the file of gitignore patterns at the root of a
+// directory of images.
+const IgnoreFile = ".altiignore"
+
+// junkNames are the files and directories created by the OS, which are
+// skipped like the hidden ones.
+var junkNames = map[string]bool{
+	"thumbs.db":                 true,
+	"ehthumbs.db":               true,
+	"desktop.ini":               true,
+	"__macosx":                  true,
+	"$recycle.bin":              true,
+	"system volume information": true,
+	"icon\r":                    true,
+}
+
+// errMaxFiles stops a walk when enough files are sent.
+var errMaxFiles = errors.New("max files reached")
+
+// Filter selects the files of a directory tree or an archive. The hidden and
+// OS junk files, e.g. .DS_Store and Thumbs.db, are always skipped.
+type Filter struct {
+	// Skip is a regular expression to skip paths.
+	Skip string
+	// Include and Exclude are globs of the gitignore syntax, matched case
+	// insensitively against the path relative to the root. If Include is
+	// set, only the matching files are selected.
+	Include []string
+	Exclude []string
+	// Since and Until is the range of the EXIF capture time, inclusive.
+	Since time.Time
+	Until time.Time
+	// Cameras selects the images whose camera contains any of them, case
+	// insensitively, e.g. FC6310.
+	Cameras []string
+	// MaxFiles is the max number of files selected, no limit if 0.
+	MaxFiles int
+	// FollowSymlinks follows the symlinks of files and directories, each
+	// directory is walked once to avoid loops.
+	FollowSymlinks bool
+}
+
+// HasMeta tells if the EXIF of each file has to be read to select it.
+func (f Filter) HasMeta() bool {
+	return !f.Since.IsZero() || !f.Until.IsZero() || len(f.Cameras) > 0
+}
+
+// ParseFilterTime parses s of the form YYYY-MM-DD, YYYY-MM-DD HH:MM,
+// YYYY-MM-DD HH:MM:SS or RFC3339 in local time, as the EXIF capture time.
+// A date only is the end of the day if end is set, e.g. for Until.
+func ParseFilterTime(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		if end {
+			t = t.Add(time.Hour*24 - time.Nanosecond)
+		}
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%v: %q", altiErrors.ErrTimeInvalid, s)
+}
+
+// globRule is a compiled gitignore pattern.
+type globRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// compileGlob compiles a pattern of the gitignore syntax. Return nil for a
+// blank line or a comment.
+func compileGlob(line string) (*globRule, error) {
+	p := strings.TrimRight(line, " \t\r")
+	if p == "" || strings.HasPrefix(p, "#") {
+		return nil, nil
+	}
+	var r globRule
+	if strings.HasPrefix(p, "!") {
+		r.negate = true
+		p = p[1:]
+	}
+	p = strings.TrimPrefix(p, `\`)
+	if strings.HasSuffix(p, "/") {
+		r.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	// a pattern with a slash is relative to the root, or else it matches
+	// at any level
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case strings.HasPrefix(p[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(p[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := p[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(p):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(p[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, err
+	}
+	r.re = re
+	return &r, nil
+}
+
+// globRules is a list of gitignore patterns, the last matching one wins.
+type globRules []*globRule
+
+// parseGlobs compiles the patterns of the gitignore syntax read from r.
+func parseGlobs(r io.Reader) (globRules, error) {
+	var ret globRules
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		g, err := compileGlob(s.Text())
+		if err != nil {
+			return nil, err
+		}
+		if g != nil {
+			ret = append(ret, g)
+		}
+	}
+	return ret, s.Err()
+}
+
+// match tells if the relative path rel, which is a directory if isDir, is
+// matched, i.e. ignored for an ignore list.
+func (gs globRules) match(rel string, isDir bool) bool {
+	ret := false
+	for _, g := range gs {
+		if g.dirOnly && !isDir {
+			continue
+		}
+		if g.re.MatchString(rel) {
+			ret = !g.negate
+		}
+	}
+	return ret
+}
+
+// matchTree tells if rel or any of its parent directories is matched, as the
+// files of an ignored directory are ignored too.
+func (gs globRules) matchTree(rel string, isDir bool) bool {
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if gs.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return gs.match(rel, isDir)
+}
+
+// fileFilter is a compiled Filter for a root.
+type fileFilter struct {
+	Filter
+	root    string
+	skip    *regexp.Regexp
+	ignore  globRules // of the ignore file and Exclude
+	include globRules
+	count   int
+}
+
+// Validate checks the skip pattern and the globs.
+func (f Filter) Validate() error {
+	_, err := f.compile("")
+	return err
+}
+
+// compile compiles the filter for the root, reading its ignore file if any.
+func (f Filter) compile(root string) (*fileFilter, error) {
+	ff := fileFilter{Filter: f, root: root}
+	if f.Skip != "" {
+		r, err := regexp.Compile(f.Skip)
+		if err != nil {
+			return nil, err
+		}
+		ff.skip = r
+	}
+	if root != "" && !IsArchive(root) {
+		r, err := os.Open(filepath.Join(root, IgnoreFile))
+		if err == nil {
+			ff.ignore, err = parseGlobs(r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, p := range f.Exclude {
+		g, err := compileGlob(p)
+		if err != nil {
+			return nil, err
+		}
+		if g != nil {
+			ff.ignore = append(ff.ignore, g)
+		}
+	}
+	for _, p := range f.Include {
+		g, err := compileGlob(p)
+		if err != nil {
+			return nil, err
+		}
+		if g != nil {
+			ff.include = append(ff.include, g)
+		}
+	}
+	return &ff, nil
+}
+
+// rel returns the slash separated path of p relative to the root.
+func (ff *fileFilter) rel(p string) string {
+	r, err := filepath.Rel(ff.root, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(r)
+}
+
+// isJunk tells if any part of rel is hidden or OS junk.
+func isJunk(rel string) bool {
+	for _, s := range strings.Split(rel, "/") {
+		if s == "." || s == ".." {
+			continue
+		}
+		if strings.HasPrefix(s, ".") || junkNames[strings.ToLower(s)] {
+			return true
+		}
+	}
+	return false
+}
+
+// skipDir tells if the whole directory at p is skipped.
+func (ff *fileFilter) skipDir(p string) bool {
+	if p == ff.root {
+		return false
+	}
+	rel := ff.rel(p)
+	return isJunk(rel) || ff.ignore.match(rel, true)
+}
+
+// matchPath tells if the file at p is selected by its path. rel is its slash
+// separated path relative to the root.
+func (ff *fileFilter) matchPath(p, rel string) bool {
+	if isJunk(rel) || ff.ignore.matchTree(rel, false) {
+		return false
+	}
+	if len(ff.include) > 0 && !ff.include.match(rel, false) {
+		return false
+	}
+	return ff.skip == nil || !ff.skip.MatchString(p)
+}
+
+// matchMeta tells if the image at p is selected by its EXIF. A file whose
+// EXIF could not be read is not selected.
+func (ff *fileFilter) matchMeta(p string) bool {
+	if !ff.HasMeta() {
+		return true
+	}
+	r, err := OpenPath(p)
+	if err != nil {
+		return false
+	}
+	head := make([]byte, metaHeadSize)
+	n, _ := io.ReadFull(r, head)
+	r.Close()
+	m := ParseImageMeta(head[:n])
+	return ff.matchImageMeta(m)
+}
+
+// matchImageMeta tells if the meta is within the time range and of the
+// cameras.
+func (ff *fileFilter) matchImageMeta(m ImageMeta) bool {
+	if !ff.Since.IsZero() || !ff.Until.IsZero() {
+		t := m.CaptureTime
+		if t.IsZero() || (!ff.Since.IsZero() && t.Before(ff.Since)) || (!ff.Until.IsZero() && t.After(ff.Until)) {
+			return false
+		}
+	}
+	if len(ff.Cameras) == 0 {
+		return true
+	}
+	cam := strings.ToLower(m.Camera())
+	for _, c := range ff.Cameras {
+		if cam != "" && strings.Contains(cam, strings.ToLower(c)) {
+			return true
+		}
+	}
+	return false
+}
+
+// accept tells if the file at p is selected, counting it for MaxFiles.
+// Return errMaxFiles if enough files are selected.
+func (ff *fileFilter) accept(p string, rel string) (bool, error) {
+	if ff.MaxFiles > 0 && ff.count >= ff.MaxFiles {
+		return false, errMaxFiles
+	}
+	if !ff.matchPath(p, rel) || !ff.matchMeta(p) {
+		return false, nil
+	}
+	ff.count++
+	return true, nil
+}
+
+// walk calls fn for each regular file of the directory tree at dir in lexical
+// order, skipping the directories by skipDir. Symlinks are followed if
+// FollowSymlinks, and each real directory is walked once.
+func (ff *fileFilter) walk(dir string, visited map[string]bool, fn func(string) error) error {
+	if ff.FollowSymlinks {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if visited[real] {
+			return nil
+		}
+		visited[real] = true
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !ff.FollowSymlinks {
+				continue
+			}
+			if info, err = os.Stat(p); err != nil {
+				// dangling link
+				continue
+			}
+		}
+		switch {
+		case info.IsDir():
+			if ff.skipDir(p) {
+				continue
+			}
+			if err := ff.walk(p, visited, fn); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WalkFilter starts a goroutine to walk the directory tree or the archive at
+// root, and send the path of each regular file selected by f on the string
+// channel. It sends the result of the walk on the error channel. If done is
+// closed, WalkFilter abandons its work.
+func WalkFilter(done <-chan struct{}, root string, f Filter) (<-chan string, <-chan error) {
+	paths := make(chan string)
+	errc := make(chan error, 1)
+
+	ff, err := f.compile(root)
+	if err != nil {
+		errc <- err
+		close(paths)
+		return paths, errc
+	}
+
+	send := func(p, rel string) error {
+		ok, err := ff.accept(p, rel)
+		if !ok || err != nil {
+			return err
+		}
+		select {
+		case paths <- p:
+		case <-done:
+			return errors.New("walk canceled")
+		}
+		return nil
+	}
+
+	go func() {
+		// Close the paths channel after the walk returns.
+		defer close(paths)
+		var err error
+		info, statErr := os.Stat(root)
+		switch {
+		case IsArchive(root):
+			err = walkArchive(root, func(m ArchiveMember) error {
+				return send(ArchivePath(root, m.Name), strings.TrimPrefix(filepath.ToSlash(m.Name), "/"))
+			})
+		case statErr != nil:
+			err = statErr
+		case !info.IsDir():
+			err = send(root, filepath.Base(root))
+		default:
+			err = ff.walk(root, make(map[string]bool), func(p string) error {
+				return send(p, ff.rel(p))
+			})
+		}
+		if err == errMaxFiles {
+			err = nil
+		}
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return paths, errc
+}
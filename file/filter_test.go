@@ -0,0 +1,153 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobRules(t *testing.T) {
+	rules, err := parseGlobs(strings.NewReader(`
+# comment
+*.txt
+thumbs/
+/raw
+**/tmp/*.jpg
+!keep.txt
+DCIM/**/draft_?.jpg
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"notes.txt", false, true},
+		{"a/b/NOTES.TXT", false, true},
+		{"a/keep.txt", false, false},
+		{"thumbs", true, true},
+		{"a/thumbs", true, true},
+		{"thumbs", false, false},
+		{"raw", true, true},
+		{"a/raw", true, false},
+		{"x/y/tmp/1.jpg", false, true},
+		{"tmp/1.jpg", false, true},
+		{"DCIM/100/draft_1.jpg", false, true},
+		{"DCIM/100/draft_10.jpg", false, false},
+		{"DCIM/100/0001.jpg", false, false},
+	}
+	for _, tt := range tests {
+		if got := rules.match(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("match(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+	if !rules.matchTree("a/thumbs/1.jpg", false) {
+		t.Errorf("matchTree() = false, want the files of an ignored directory ignored")
+	}
+}
+
+func TestWalkFilter(t *testing.T) {
+	root, err := ioutil.TempDir("", "alti-cli-filter-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, p := range []string{
+		"a/1.JPG", "a/2.jpg", "a/3.png", "a/.DS_Store", "a/Thumbs.db",
+		".hidden/4.jpg", "thumbs/5.jpg", "b/6.jpg", "b/7.jpg",
+	} {
+		p = filepath.Join(root, p)
+		if err := EnsureDir(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, IgnoreFile), []byte("thumbs/\n*.png\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a loop back to the root and a link to b
+	if err := os.Symlink(root, filepath.Join(root, "b", "loop")); err != nil {
+		t.Skip("symlink is not supported", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "b"), filepath.Join(root, "c")); err != nil {
+		t.Fatal(err)
+	}
+
+	walk := func(f Filter) []string {
+		done := make(chan struct{})
+		defer close(done)
+		paths, errc := WalkFilter(done, root, f)
+		var got []string
+		for p := range paths {
+			got = append(got, filepath.ToSlash(strings.TrimPrefix(p, root+string(filepath.Separator))))
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("WalkFilter() error: %v", err)
+		}
+		return got
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"default", Filter{}, []string{"a/1.JPG", "a/2.jpg", "b/6.jpg", "b/7.jpg"}},
+		{"include", Filter{Include: []string{"*.jpg"}, Exclude: []string{"b/7.jpg"}}, []string{"a/1.JPG", "a/2.jpg", "b/6.jpg"}},
+		{"skip and max", Filter{Skip: `2\.jpg$`, MaxFiles: 2}, []string{"a/1.JPG", "b/6.jpg"}},
+		{"follow symlinks", Filter{FollowSymlinks: true}, []string{"a/1.JPG", "a/2.jpg", "b/6.jpg", "b/7.jpg"}},
+	}
+	for _, tt := range tests {
+		if got := walk(tt.filter); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: WalkFilter() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	// c is walked as b is excluded
+	want := []string{"a/1.JPG", "a/2.jpg", "c/6.jpg", "c/7.jpg"}
+	if got := walk(Filter{FollowSymlinks: true, Exclude: []string{"b/"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkFilter() = %q, want %q", got, want)
+	}
+}
+
+func TestFilterMeta(t *testing.T) {
+	since, err := ParseFilterTime("2020-05-01", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	until, err := ParseFilterTime("2020-05-01", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseFilterTime("05/01/2020", false); err == nil {
+		t.Errorf("ParseFilterTime() error = nil, want invalid time")
+	}
+	ff, err := Filter{Since: since, Until: until, Cameras: []string{"fc6310"}}.compile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+		return t
+	}
+	tests := []struct {
+		meta ImageMeta
+		want bool
+	}{
+		{ImageMeta{Make: "DJI", Model: "FC6310", CaptureTime: at("2020-05-01 23:59:59")}, true},
+		{ImageMeta{Make: "DJI", Model: "FC6310", CaptureTime: at("2020-05-02 00:00:00")}, false},
+		{ImageMeta{Make: "DJI", Model: "FC220", CaptureTime: at("2020-05-01 12:00:00")}, false},
+		{ImageMeta{Make: "DJI", Model: "FC6310"}, false},
+	}
+	for _, tt := range tests {
+		if got := ff.matchImageMeta(tt.meta); got != tt.want {
+			t.Errorf("matchImageMeta(%s) = %v, want %v", tt.meta, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,657 @@
+package file
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"strings"
+)
+
+// Chunk types of glb.
+const (
+	glbJSON = 0x4E4F534A
+	glbBIN  = 0x004E4942
+)
+
+// gltf is the part of a glTF 2.0 document needed for packaging, checking and
+// converting it.
+type gltf struct {
+	Asset struct {
+		Version string `json:"version"`
+	} `json:"asset"`
+	ExtensionsRequired []string `json:"extensionsRequired"`
+	Scene              *int     `json:"scene"`
+	Scenes             []struct {
+		Nodes []int `json:"nodes"`
+	} `json:"scenes"`
+	Nodes []struct {
+		Mesh        *int      `json:"mesh"`
+		Children    []int     `json:"children"`
+		Matrix      []float64 `json:"matrix"`
+		Translation []float64 `json:"translation"`
+		Rotation    []float64 `json:"rotation"`
+		Scale       []float64 `json:"scale"`
+	} `json:"nodes"`
+	Meshes []struct {
+		Primitives []gltfPrimitive `json:"primitives"`
+	} `json:"meshes"`
+	Accessors []struct {
+		BufferView    *int      `json:"bufferView"`
+		ByteOffset    int       `json:"byteOffset"`
+		ComponentType int       `json:"componentType"`
+		Normalized    bool      `json:"normalized"`
+		Count         int       `json:"count"`
+		Type          string    `json:"type"`
+		Min           []float64 `json:"min"`
+		Max           []float64 `json:"max"`
+	} `json:"accessors"`
+	BufferViews []struct {
+		Buffer     int `json:"buffer"`
+		ByteOffset int `json:"byteOffset"`
+		ByteLength int `json:"byteLength"`
+		ByteStride int `json:"byteStride"`
+	} `json:"bufferViews"`
+	Buffers []struct {
+		URI        string `json:"uri"`
+		ByteLength int    `json:"byteLength"`
+	} `json:"buffers"`
+	Images []struct {
+		URI        string `json:"uri"`
+		MimeType   string `json:"mimeType"`
+		BufferView *int   `json:"bufferView"`
+	} `json:"images"`
+	Textures []struct {
+		Source *int `json:"source"`
+	} `json:"textures"`
+	Materials []struct {
+		Name                 string `json:"name"`
+		PbrMetallicRoughness *struct {
+			BaseColorFactor  []float64 `json:"baseColorFactor"`
+			BaseColorTexture *struct {
+				Index int `json:"index"`
+			} `json:"baseColorTexture"`
+		} `json:"pbrMetallicRoughness"`
+	} `json:"materials"`
+}
+
+// gltfPrimitive is a primitive of a glTF mesh.
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    *int           `json:"indices"`
+	Material   *int           `json:"material"`
+	Mode       *int           `json:"mode"`
+}
+
+// mode returns the topology of the primitive, triangles by default.
+func (p gltfPrimitive) mode() int {
+	if p.Mode == nil {
+		return 4
+	}
+	return *p.Mode
+}
+
+// gltfComponentSizes are the sizes of the component types.
+var gltfComponentSizes = map[int]int{5120: 1, 5121: 1, 5122: 2, 5123: 2, 5125: 4, 5126: 4}
+
+// gltfTypeSizes are the number of components of the accessor types.
+var gltfTypeSizes = map[string]int{"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4, "MAT2": 4, "MAT3": 9, "MAT4": 16}
+
+// readGLTF reads a glTF document from r, which is a glb if glb is true. The
+// binary chunk of a glb is returned if readBin is true, or else its length
+// only.
+func readGLTF(r io.Reader, glb, readBin bool) (*gltf, []byte, int, error) {
+	var doc gltf
+	if !glb {
+		if err := json.NewDecoder(r).Decode(&doc); err != nil {
+			return nil, nil, 0, fmt.Errorf("invalid gltf json: %v", err)
+		}
+		return &doc, nil, 0, nil
+	}
+
+	var header [3]uint32
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, nil, 0, fmt.Errorf("invalid glb header: %v", err)
+	}
+	if header[1] != 2 {
+		return nil, nil, 0, fmt.Errorf("glb version %d is not supported, should be 2", header[1])
+	}
+	var bin []byte
+	binLen := -1
+	for i := 0; ; i++ {
+		var chunk [2]uint32
+		err := binary.Read(r, binary.LittleEndian, &chunk)
+		if err == io.EOF && i > 0 {
+			break
+		}
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("invalid glb chunk: %v", err)
+		}
+		switch {
+		case i == 0 && chunk[1] != glbJSON:
+			return nil, nil, 0, fmt.Errorf("first glb chunk is not json")
+		case i == 0:
+			data := make([]byte, chunk[0])
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, nil, 0, fmt.Errorf("invalid glb json chunk: %v", err)
+			}
+			if err := json.Unmarshal(data, &doc); err != nil {
+				return nil, nil, 0, fmt.Errorf("invalid gltf json: %v", err)
+			}
+			continue
+		case chunk[1] == glbBIN && readBin:
+			bin = make([]byte, chunk[0])
+			n, err := io.ReadFull(r, bin)
+			bin = bin[:n]
+			binLen = n
+			if err != nil {
+				return &doc, bin, binLen, nil
+			}
+			continue
+		}
+		n, err := io.Copy(ioutil.Discard, io.LimitReader(r, int64(chunk[0])))
+		if chunk[1] == glbBIN {
+			binLen = int(n)
+		}
+		if err != nil || n < int64(chunk[0]) {
+			break
+		}
+	}
+	return &doc, bin, binLen, nil
+}
+
+// isDataURI tells if the uri embeds its data.
+func isDataURI(uri string) bool {
+	return strings.HasPrefix(uri, "data:")
+}
+
+// decodeDataURI decodes the base64 data of the uri.
+func decodeDataURI(uri string) ([]byte, error) {
+	i := strings.Index(uri, ",")
+	if i < 0 || !strings.Contains(uri[:i], ";base64") {
+		return nil, fmt.Errorf("data uri is not base64")
+	}
+	return base64.StdEncoding.DecodeString(uri[i+1:])
+}
+
+// uriPath returns the relative path of the uri of an external file.
+func uriPath(uri string) string {
+	if p, err := url.PathUnescape(uri); err == nil {
+		return p
+	}
+	return uri
+}
+
+// refs returns the relative paths of the external buffers and images.
+func (doc *gltf) refs() []string {
+	var ret []string
+	for _, b := range doc.Buffers {
+		if b.URI != "" && !isDataURI(b.URI) {
+			ret = append(ret, uriPath(b.URI))
+		}
+	}
+	for _, img := range doc.Images {
+		if img.URI != "" && !isDataURI(img.URI) {
+			ret = append(ret, uriPath(img.URI))
+		}
+	}
+	return ret
+}
+
+// unsupported returns the required extensions that could not be decoded,
+// e.g. draco compression.
+func (doc *gltf) unsupported() []string {
+	var ret []string
+	for _, e := range doc.ExtensionsRequired {
+		switch e {
+		case "KHR_materials_unlit", "KHR_texture_transform", "KHR_materials_emissive_strength":
+		default:
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// check checks the references between the parts of the document, given the
+// lengths of the buffers, or -1 if a buffer is missing. It returns the error
+// messages.
+func (doc *gltf) check(bufLens []int) []string {
+	var errs []string
+	if !strings.HasPrefix(doc.Asset.Version, "2") {
+		errs = append(errs, fmt.Sprintf("glTF version %q is not supported, should be 2.0", doc.Asset.Version))
+		return errs
+	}
+	for i, b := range doc.Buffers {
+		if bufLens[i] >= 0 && bufLens[i] < b.ByteLength {
+			errs = append(errs, fmt.Sprintf("buffer %d is truncated, %d of %d bytes", i, bufLens[i], b.ByteLength))
+		}
+	}
+	for i, v := range doc.BufferViews {
+		if v.Buffer < 0 || v.Buffer >= len(doc.Buffers) {
+			errs = append(errs, fmt.Sprintf("bufferView %d refers to undefined buffer %d", i, v.Buffer))
+		} else if v.ByteOffset+v.ByteLength > doc.Buffers[v.Buffer].ByteLength {
+			errs = append(errs, fmt.Sprintf("bufferView %d is outside of buffer %d", i, v.Buffer))
+		}
+	}
+	for i, a := range doc.Accessors {
+		cs, ok1 := gltfComponentSizes[a.ComponentType]
+		ts, ok2 := gltfTypeSizes[a.Type]
+		switch {
+		case !ok1 || !ok2:
+			errs = append(errs, fmt.Sprintf("accessor %d has invalid type %s of %d", i, a.Type, a.ComponentType))
+		case a.BufferView == nil:
+		case *a.BufferView < 0 || *a.BufferView >= len(doc.BufferViews):
+			errs = append(errs, fmt.Sprintf("accessor %d refers to undefined bufferView %d", i, *a.BufferView))
+		default:
+			v := doc.BufferViews[*a.BufferView]
+			stride := v.ByteStride
+			if stride == 0 {
+				stride = cs * ts
+			}
+			if a.Count > 0 && a.ByteOffset+stride*(a.Count-1)+cs*ts > v.ByteLength {
+				errs = append(errs, fmt.Sprintf("accessor %d is outside of bufferView %d", i, *a.BufferView))
+			}
+		}
+	}
+	acc := func(i int) bool { return i >= 0 && i < len(doc.Accessors) }
+	for i, m := range doc.Meshes {
+		for j, p := range m.Primitives {
+			pos, ok := p.Attributes["POSITION"]
+			switch {
+			case !ok:
+				errs = append(errs, fmt.Sprintf("primitive %d of mesh %d has no POSITION", j, i))
+			case !acc(pos):
+				errs = append(errs, fmt.Sprintf("primitive %d of mesh %d refers to undefined accessor %d", j, i, pos))
+			}
+			if p.Indices != nil && !acc(*p.Indices) {
+				errs = append(errs, fmt.Sprintf("primitive %d of mesh %d refers to undefined accessor %d", j, i, *p.Indices))
+			}
+		}
+	}
+	for i, img := range doc.Images {
+		if img.URI == "" && (img.BufferView == nil || *img.BufferView < 0 || *img.BufferView >= len(doc.BufferViews)) {
+			errs = append(errs, fmt.Sprintf("image %d has neither uri nor bufferView", i))
+		}
+	}
+	for i, t := range doc.Textures {
+		if t.Source != nil && (*t.Source < 0 || *t.Source >= len(doc.Images)) {
+			errs = append(errs, fmt.Sprintf("texture %d refers to undefined image %d", i, *t.Source))
+		}
+	}
+	for i, n := range doc.Nodes {
+		if n.Mesh != nil && (*n.Mesh < 0 || *n.Mesh >= len(doc.Meshes)) {
+			errs = append(errs, fmt.Sprintf("node %d refers to undefined mesh %d", i, *n.Mesh))
+		}
+		for _, c := range n.Children {
+			if c < 0 || c >= len(doc.Nodes) || c == i {
+				errs = append(errs, fmt.Sprintf("node %d has invalid child %d", i, c))
+			}
+		}
+	}
+	if len(doc.Meshes) == 0 {
+		errs = append(errs, "no mesh is found")
+	}
+	return errs
+}
+
+// gltfInstance is a mesh placed by the world matrix of a node.
+type gltfInstance struct {
+	mesh   int
+	matrix mat4
+}
+
+// instances returns the meshes of the default scene placed by their nodes,
+// or of every root node if there is no scene. It should be called after
+// check.
+func (doc *gltf) instances() []gltfInstance {
+	var roots []int
+	switch {
+	case doc.Scene != nil && *doc.Scene >= 0 && *doc.Scene < len(doc.Scenes):
+		roots = doc.Scenes[*doc.Scene].Nodes
+	case len(doc.Scenes) > 0:
+		roots = doc.Scenes[0].Nodes
+	default:
+		child := make(map[int]bool)
+		for _, n := range doc.Nodes {
+			for _, c := range n.Children {
+				child[c] = true
+			}
+		}
+		for i := range doc.Nodes {
+			if !child[i] {
+				roots = append(roots, i)
+			}
+		}
+	}
+	if len(doc.Nodes) == 0 {
+		// meshes without nodes
+		var ret []gltfInstance
+		for i := range doc.Meshes {
+			ret = append(ret, gltfInstance{i, identity()})
+		}
+		return ret
+	}
+
+	var ret []gltfInstance
+	visited := make(map[int]bool)
+	var walk func(i int, parent mat4)
+	walk = func(i int, parent mat4) {
+		if i < 0 || i >= len(doc.Nodes) || visited[i] {
+			return
+		}
+		visited[i] = true
+		n := doc.Nodes[i]
+		m := parent.mul(nodeMatrix(n.Matrix, n.Translation, n.Rotation, n.Scale))
+		if n.Mesh != nil && *n.Mesh >= 0 && *n.Mesh < len(doc.Meshes) {
+			ret = append(ret, gltfInstance{*n.Mesh, m})
+		}
+		for _, c := range n.Children {
+			walk(c, m)
+		}
+	}
+	for _, r := range roots {
+		walk(r, identity())
+	}
+	return ret
+}
+
+// counts returns the number of vertices and triangles of the instances.
+func (doc *gltf) counts(insts []gltfInstance) (int, int) {
+	var nv, nf int
+	for _, in := range insts {
+		for _, p := range doc.Meshes[in.mesh].Primitives {
+			pos := doc.Accessors[p.Attributes["POSITION"]].Count
+			n := pos
+			if p.Indices != nil {
+				n = doc.Accessors[*p.Indices].Count
+			}
+			nv += pos
+			switch p.mode() {
+			case 4:
+				nf += n / 3
+			case 5, 6:
+				if n > 2 {
+					nf += n - 2
+				}
+			}
+		}
+	}
+	return nv, nf
+}
+
+// bounds returns the bounding box of the instances by the min and max of
+// their positions.
+func (doc *gltf) bounds(insts []gltfInstance) ([3]float64, [3]float64, bool) {
+	min := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	max := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	ok := false
+	for _, in := range insts {
+		for _, p := range doc.Meshes[in.mesh].Primitives {
+			a := doc.Accessors[p.Attributes["POSITION"]]
+			if len(a.Min) < 3 || len(a.Max) < 3 {
+				continue
+			}
+			for c := 0; c < 8; c++ {
+				corner := [3]float64{a.Min[0], a.Min[1], a.Min[2]}
+				for k := 0; k < 3; k++ {
+					if c&(1<<uint(k)) != 0 {
+						corner[k] = a.Max[k]
+					}
+				}
+				v := in.matrix.apply(corner, 1)
+				for k := 0; k < 3; k++ {
+					min[k] = math.Min(min[k], v[k])
+					max[k] = math.Max(max[k], v[k])
+				}
+				ok = true
+			}
+		}
+	}
+	return min, max, ok
+}
+
+// gltfData is a glTF document with its buffers loaded.
+type gltfData struct {
+	*gltf
+	buffers [][]byte
+}
+
+// loadGLTF loads the buffers of the document, by load for the external ones.
+func loadGLTF(doc *gltf, bin []byte, load func(p string) ([]byte, error)) (*gltfData, error) {
+	d := &gltfData{gltf: doc}
+	for i, b := range doc.Buffers {
+		var data []byte
+		var err error
+		switch {
+		case b.URI == "" && i == 0:
+			data = bin
+		case isDataURI(b.URI):
+			data, err = decodeDataURI(b.URI)
+		default:
+			data, err = load(uriPath(b.URI))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("buffer %d: %v", i, err)
+		}
+		if len(data) < b.ByteLength {
+			return nil, fmt.Errorf("buffer %d is truncated, %d of %d bytes", i, len(data), b.ByteLength)
+		}
+		d.buffers = append(d.buffers, data)
+	}
+	return d, nil
+}
+
+// view returns the bytes of the buffer view.
+func (d *gltfData) view(i int) []byte {
+	v := d.BufferViews[i]
+	return d.buffers[v.Buffer][v.ByteOffset : v.ByteOffset+v.ByteLength]
+}
+
+// floats reads the accessor as count x components floats.
+func (d *gltfData) floats(i int) ([][]float64, error) {
+	a := d.Accessors[i]
+	cs, ts := gltfComponentSizes[a.ComponentType], gltfTypeSizes[a.Type]
+	ret := make([][]float64, a.Count)
+	if a.BufferView == nil {
+		// all zeros without sparse
+		for j := range ret {
+			ret[j] = make([]float64, ts)
+		}
+		return ret, nil
+	}
+	data := d.view(*a.BufferView)
+	stride := d.BufferViews[*a.BufferView].ByteStride
+	if stride == 0 {
+		stride = cs * ts
+	}
+	for j := range ret {
+		ret[j] = make([]float64, ts)
+		for k := 0; k < ts; k++ {
+			off := a.ByteOffset + j*stride + k*cs
+			if off+cs > len(data) {
+				return nil, fmt.Errorf("accessor %d is outside of its bufferView", i)
+			}
+			ret[j][k] = gltfComponent(data[off:], a.ComponentType, a.Normalized)
+		}
+	}
+	return ret, nil
+}
+
+// indices reads the indices of the primitive, or the sequence of its
+// positions if it is not indexed.
+func (d *gltfData) indices(p gltfPrimitive) ([]int, error) {
+	if p.Indices == nil {
+		n := d.Accessors[p.Attributes["POSITION"]].Count
+		ret := make([]int, n)
+		for i := range ret {
+			ret[i] = i
+		}
+		return ret, nil
+	}
+	fs, err := d.floats(*p.Indices)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]int, len(fs))
+	for i, f := range fs {
+		ret[i] = int(f[0])
+	}
+	return ret, nil
+}
+
+// gltfComponent decodes a component of the type.
+func gltfComponent(b []byte, t int, normalized bool) float64 {
+	le := binary.LittleEndian
+	switch t {
+	case 5120:
+		v := float64(int8(b[0]))
+		if normalized {
+			return math.Max(v/127, -1)
+		}
+		return v
+	case 5121:
+		if normalized {
+			return float64(b[0]) / 255
+		}
+		return float64(b[0])
+	case 5122:
+		v := float64(int16(le.Uint16(b)))
+		if normalized {
+			return math.Max(v/32767, -1)
+		}
+		return v
+	case 5123:
+		if normalized {
+			return float64(le.Uint16(b)) / 65535
+		}
+		return float64(le.Uint16(b))
+	case 5125:
+		return float64(le.Uint32(b))
+	}
+	return float64(math.Float32frombits(le.Uint32(b)))
+}
+
+// image returns the bytes and the extension of the image.
+func (d *gltfData) image(i int, load func(p string) ([]byte, error)) ([]byte, string, error) {
+	img := d.Images[i]
+	ext := ".png"
+	switch {
+	case img.MimeType == "image/jpeg":
+		ext = ".jpg"
+	case img.MimeType != "" && img.MimeType != "image/png":
+		ext = "." + strings.TrimPrefix(img.MimeType, "image/")
+	}
+	switch {
+	case img.BufferView != nil:
+		return d.view(*img.BufferView), ext, nil
+	case isDataURI(img.URI):
+		data, err := decodeDataURI(img.URI)
+		if strings.HasPrefix(img.URI, "data:image/jpeg") {
+			ext = ".jpg"
+		}
+		return data, ext, err
+	}
+	data, err := load(uriPath(img.URI))
+	if e := strings.ToLower(pathExt(img.URI)); e != "" {
+		ext = e
+	}
+	return data, ext, err
+}
+
+// pathExt returns the extension of a slash separated path.
+func pathExt(p string) string {
+	if i := strings.LastIndexAny(p, "./"); i >= 0 && p[i] == '.' {
+		return p[i:]
+	}
+	return ""
+}
+
+// mat4 is a column major 4x4 matrix, as glTF.
+type mat4 [16]float64
+
+func identity() mat4 {
+	return mat4{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+}
+
+// mul returns m x n.
+func (m mat4) mul(n mat4) mat4 {
+	var r mat4
+	for c := 0; c < 4; c++ {
+		for row := 0; row < 4; row++ {
+			var s float64
+			for k := 0; k < 4; k++ {
+				s += m[k*4+row] * n[c*4+k]
+			}
+			r[c*4+row] = s
+		}
+	}
+	return r
+}
+
+// apply transforms v with w, which is 1 for a point and 0 for a direction.
+func (m mat4) apply(v [3]float64, w float64) [3]float64 {
+	var r [3]float64
+	for row := 0; row < 3; row++ {
+		r[row] = m[row]*v[0] + m[4+row]*v[1] + m[8+row]*v[2] + m[12+row]*w
+	}
+	return r
+}
+
+// normalMatrix returns the inverse transpose of the upper 3x3 of m, for
+// transforming normals, as a mat4 without translation.
+func (m mat4) normalMatrix() mat4 {
+	a := func(r, c int) float64 { return m[c*4+r] }
+	det := a(0, 0)*(a(1, 1)*a(2, 2)-a(1, 2)*a(2, 1)) -
+		a(0, 1)*(a(1, 0)*a(2, 2)-a(1, 2)*a(2, 0)) +
+		a(0, 2)*(a(1, 0)*a(2, 1)-a(1, 1)*a(2, 0))
+	if det == 0 {
+		return identity()
+	}
+	// the inverse transpose is the cofactor matrix over the determinant
+	var n mat4
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			r1, r2 := (r+1)%3, (r+2)%3
+			c1, c2 := (c+1)%3, (c+2)%3
+			n[c*4+r] = (a(r1, c1)*a(r2, c2) - a(r1, c2)*a(r2, c1)) / det
+		}
+	}
+	n[15] = 1
+	return n
+}
+
+// nodeMatrix returns the local matrix of a node by its matrix, or by its
+// translation, rotation and scale.
+func nodeMatrix(matrix, t, r, s []float64) mat4 {
+	if len(matrix) == 16 {
+		var m mat4
+		copy(m[:], matrix)
+		return m
+	}
+	m := identity()
+	if len(s) == 3 {
+		sm := identity()
+		sm[0], sm[5], sm[10] = s[0], s[1], s[2]
+		m = sm
+	}
+	if len(r) == 4 {
+		x, y, z, w := r[0], r[1], r[2], r[3]
+		rm := mat4{
+			1 - 2*(y*y+z*z), 2 * (x*y + z*w), 2 * (x*z - y*w), 0,
+			2 * (x*y - z*w), 1 - 2*(x*x+z*z), 2 * (y*z + x*w), 0,
+			2 * (x*z + y*w), 2 * (y*z - x*w), 1 - 2*(x*x+y*y), 0,
+			0, 0, 0, 1,
+		}
+		m = rm.mul(m)
+	}
+	if len(t) == 3 {
+		tm := identity()
+		tm[12], tm[13], tm[14] = t[0], t[1], t[2]
+		m = tm.mul(m)
+	}
+	return m
+}
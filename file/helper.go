@@ -5,19 +5,15 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/hex"
-	"errors"
 	"fmt"
-	"image"
 	"io/ioutil"
 	"log"
 	"math"
-	"regexp"
 
-	// for image.DecodeConfig
+	// for image.Decode and image.DecodeConfig
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -36,24 +32,25 @@ func max(x, y int) int {
 }
 
 // GetImageSize decodes the width and height of an image.
+// Return zero width and height if it is not an image.
 func GetImageSize(img string) (int, int, error) {
-	valid, err := IsImageFile(img)
-	if err != nil {
-		return 0, 0, err
-	}
-	if !valid {
-		return 0, 0, nil
-	}
-	f, err := os.Open(img)
+	f, err := OpenPath(img)
 	if err != nil {
 		return 0, 0, err
 	}
 	defer f.Close()
-	i, _, err := image.DecodeConfig(f)
-	if err != nil {
+
+	// sniff and decode from the same buffered reader
+	br := bufio.NewReader(f)
+	head, err := br.Peek(512)
+	if err != nil && (err != io.EOF || len(head) == 0) {
 		return 0, 0, err
 	}
-	return i.Width, i.Height, nil
+	t := SniffImageType(head)
+	if !strings.Contains(t, "image/") {
+		return 0, 0, nil
+	}
+	return DecodeImageConfig(br, t)
 }
 
 // GetBase64String reads the file and return its bytes as base64 string.
@@ -111,19 +108,21 @@ func IsZipFile(f string) (bool, error) {
 	return false, nil
 }
 
-// GuessFileType guesses the type of file.
+// GuessFileType guesses the type of file, tiff included.
 func GuessFileType(file string) (string, error) {
-	buff := make([]byte, 512)
-	f, err := os.Open(file)
+	f, err := OpenPath(file)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
-	_, err = f.Read(buff)
+	buff, err := readHead(f, 512)
 	if err != nil {
 		return "", err
 	}
-	return http.DetectContentType(buff), nil
+	if len(buff) == 0 {
+		return "", io.EOF
+	}
+	return SniffImageType(buff), nil
 }
 
 // Sha1sum computes the sha1sum of the file.
@@ -167,42 +166,25 @@ func BytesToMB(bytes int64) float64 {
 // walk on the error channel. If done is closed, walkFiles abandons its work.
 // skip is a regular expression pattern used for skipping paths. Would not skip
 // if it is an empty string.
+// If root is a zip, tar or gzipped tar, the virtual path of each of its
+// regular members is sent instead.
+// It is WalkFilter with a Filter of skip only.
 func WalkFiles(done <-chan struct{}, root string, skip string) (<-chan string, <-chan error) {
-	paths := make(chan string)
-	errc := make(chan error, 1)
+	return WalkFilter(done, root, Filter{Skip: skip})
+}
 
-	r, err := regexp.Compile(skip)
+// walkArchive calls fn for each regular member of the archive at p in order.
+func walkArchive(p string, fn func(ArchiveMember) error) error {
+	members, err := ArchiveMembers(p)
 	if err != nil {
-		errc <- err
-		return paths, errc
+		return err
 	}
-
-	onWalk := func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+	for _, m := range members {
+		if err := fn(m); err != nil {
 			return err
 		}
-		if !info.Mode().IsRegular() {
-			return nil
-		}
-		if skip != "" && r.MatchString(path) {
-			return nil
-		}
-		select {
-		case paths <- path:
-		case <-done:
-			return errors.New("walk canceled")
-		}
-		return nil
 	}
-
-	go func() {
-		// Close the paths channel after Walk returns.
-		defer close(paths)
-		// No select needed for this send, since errc is buffered.
-		errc <- filepath.Walk(root, onWalk)
-	}()
-
-	return paths, errc
+	return nil
 }
 
 // SplitFile splits the file into parts and put it in the outDir.
@@ -317,6 +299,41 @@ func EnsureDir(p string, perm os.FileMode) error {
 	return nil
 }
 
+// MoveFile moves the file at src to dst, creating the parent dir of dst.
+// The file is copied then removed if it could not be renamed, e.g. across
+// devices.
+func MoveFile(src, dst string) error {
+	if IsFileExist(dst) {
+		return os.ErrExist
+	}
+	if err := EnsureDir(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
 // ReadFile reads a text file from path.
 func ReadFile(path string) ([]string, error) {
 	var ret []string
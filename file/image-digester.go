@@ -1,6 +1,7 @@
 package file
 
 import (
+	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -22,16 +23,30 @@ type ImageDigest struct {
 	Height   int
 	GP       float64
 	SHA1     string
-	Existed  bool // existed in altizure or not
+	Meta     ImageMeta
+	Quality  *ImageQuality // nil if not analyzed
+	Hash     *uint64       // perceptual hash, nil if not computed
+	Existed  bool          // existed in altizure or not
 	Error    error
 }
 
 // ImageDigester reads path names from paths.
 // If light work is set, only set `IsImage`, `Path`, `URL` and `Filename`.
+// If Existing is set, it is used as the set of checksums of the images already
+// in the project. Otherwise, the api server is asked for each image.
+// If Cache is set, unchanged files are not read again, and an image whose
+// downscaled copy is in the project is existed too.
+// If Quality is set, each image is decoded fully to analyze its quality.
+// If Hash is set, each image is decoded fully to compute its perceptual hash
+// of that kind.
 type ImageDigester struct {
 	Root      string
 	PID       string
 	LightWork bool
+	Existing  map[string]bool
+	Cache     *DigestCache
+	Quality   *QualityOptions
+	Hash      string
 	Done      <-chan struct{}
 	Paths     <-chan string
 	Result    chan<- ImageDigest
@@ -42,7 +57,7 @@ type ImageDigester struct {
 func (id *ImageDigester) Digest() {
 	for path := range id.Paths {
 		select {
-		case id.Result <- work(id.PID, id.Root, path, id.LightWork):
+		case id.Result <- id.work(path):
 		case <-id.Done:
 			return
 		}
@@ -74,75 +89,117 @@ func (id *ImageDigester) Run(n int) int {
 	return n
 }
 
+// scan scans the image file, or reads its digest from cache if the file is
+// unchanged.
+func (id *ImageDigester) scan(p string) (ImageScan, error) {
+	if id.Cache == nil || id.LightWork {
+		return ScanImage(p, id.LightWork)
+	}
+	info, err := StatPath(p)
+	if err != nil {
+		return ImageScan{}, err
+	}
+	if scan, ok := id.Cache.Get(p, info); ok {
+		return scan, nil
+	}
+	scan, err := ScanImage(p, false)
+	if err == nil && scan.IsImage {
+		id.Cache.Put(p, info, scan)
+	}
+	return scan, err
+}
+
 // work checks the specified image file
-// and get its name, size, width, height, gp and sha1.
-func work(pid, r, p string, light bool) ImageDigest {
+// and get its name, size, width, height, gp, sha1 and metadata in a single read.
+func (id *ImageDigester) work(p string) ImageDigest {
+	light := id.LightWork
+	url := p[len(id.Root):]
+	if _, member, ok := SplitArchivePath(p); ok {
+		url = "/" + member
+	}
 	ret := ImageDigest{
 		Path: p,
-		URL:  strings.Replace(p[len(r):], " ", "%20", -1),
+		URL:  strings.Replace(url, " ", "%20", -1),
 	}
 
-	// a. is image?
-	isImg, err := IsImageFile(p)
+	// a. read the file once for type, size, dimension and checksum
+	scan, err := id.scan(p)
 	if err != nil {
-		ret.Error = err
+		switch {
+		case !scan.IsImage:
+			ret.Error = err
+		case err == errors.ErrFileImageFormat:
+			ret.Filetype = scan.Filetype
+			ret.Error = err
+		case scan.Width == 0:
+			ret.Error = errors.ErrFileImageDim
+		default:
+			ret.Error = errors.ErrFileChecksum
+		}
 		return ret
 	}
-	if !isImg {
+
+	// b. is image?
+	if !scan.IsImage {
 		ret.IsImage = false
 		ret.Error = errors.ErrFileNotImage
 		return ret
 	}
 	ret.IsImage = true
 
-	// b. filename
+	// c. filename
 	ret.Filename = filepath.Base(p)
 
 	if light {
 		return ret
 	}
 
-	// c. filetype
-	t, err := GuessFileType(p)
-	if err != nil {
-		ret.Error = err
-		return ret
-	}
-	ret.Filetype = t
-
-	// d. filesize
-	bytes, err := Filesize(p)
-	if err != nil {
-		ret.Error = errors.ErrFilesize
-		return ret
-	}
-	ret.Filesize = bytes
-
-	// e. image dimension
-	w, h, err := GetImageSize(p)
-	if err != nil {
-		ret.Error = errors.ErrFileImageDim
-		return ret
+	// d. filetype, filesize and dimension
+	ret.Filetype = scan.Filetype
+	ret.Filesize = scan.Filesize
+	ret.Width = scan.Width
+	ret.Height = scan.Height
+
+	// e. gp usage
+	ret.GP = DimToGigaPixel(scan.Width, scan.Height)
+
+	// f. checksum and metadata
+	ret.SHA1 = scan.SHA1
+	ret.Meta = scan.Meta
+
+	// g. decode once to analyze quality and compute perceptual hash
+	if id.Quality != nil || id.Hash != "" {
+		img, decodeErr := DecodeImage(p)
+		if decodeErr != nil && os.IsNotExist(decodeErr) {
+			ret.Error = decodeErr
+			return ret
+		}
+		if id.Quality != nil {
+			ret.Quality = AnalyzeDecoded(img, decodeErr, *id.Quality)
+		}
+		if id.Hash != "" && decodeErr == nil {
+			h := PerceptualHash(img, id.Hash)
+			ret.Hash = &h
+		}
 	}
-	ret.Width = w
-	ret.Height = h
-
-	// f. gp usage
-	ret.GP = DimToGigaPixel(w, h)
 
-	// g. checksum
-	sha1, err := Sha1sum(p)
-	if err != nil {
-		ret.Error = errors.ErrFileChecksum
-		return ret
+	// h. check if already uploaded, as it is or downscaled
+	sums := []string{scan.SHA1}
+	if id.Cache != nil {
+		if d := id.Cache.Downscaled(p, scan.SHA1); d != "" {
+			sums = append(sums, d)
+		}
 	}
-	ret.SHA1 = sha1
-
-	// h. check if already uploaded
-	ret.Existed, err = gql.HasImage(pid, sha1)
-	if err != nil {
-		ret.Error = err
-		return ret
+	for _, sum := range sums {
+		if id.Existing != nil {
+			ret.Existed = id.Existing[sum]
+		} else if ret.Existed, err = gql.HasImage(id.PID, sum); err != nil {
+			ret.Error = err
+			return ret
+		}
+		if ret.Existed {
+			break
+		}
 	}
 
 	return ret
@@ -0,0 +1,106 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageDigesterExisting(t *testing.T) {
+	sum, err := Sha1sum(testImgDir + "nat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	paths, errc := WalkFiles(done, testImgDir, "")
+	result := make(chan ImageDigest)
+	digester := ImageDigester{
+		Root:     testImgDir,
+		PID:      "not-queried",
+		Existing: map[string]bool{sum: true},
+		Done:     done,
+		Paths:    paths,
+		Result:   result,
+	}
+	digester.Run(2)
+
+	got := make(map[string]bool)
+	for r := range result {
+		if r.Error != nil {
+			t.Errorf("ImageDigester() error: %v", r.Error)
+		}
+		got[r.Filename] = r.Existed
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("WalkFiles() error: %v", err)
+	}
+	want := map[string]bool{"nat.jpg": true, "nat.png": false, "nat-small.jpg": false}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("ImageDigester() Existed of %q = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestImageDigesterDownscaled(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-digest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	cache, err := OpenDigestCache(filepath.Join(d, DigestCacheFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := func(existing map[string]bool) map[string]bool {
+		done := make(chan struct{})
+		defer close(done)
+		paths, errc := WalkFiles(done, testImgDir, "")
+		result := make(chan ImageDigest)
+		digester := ImageDigester{
+			Root:     testImgDir,
+			PID:      "not-queried",
+			Existing: existing,
+			Cache:    cache,
+			Done:     done,
+			Paths:    paths,
+			Result:   result,
+		}
+		digester.Run(2)
+		got := make(map[string]bool)
+		for r := range result {
+			if r.Error != nil {
+				t.Errorf("ImageDigester() error: %v", r.Error)
+			}
+			got[r.Filename] = r.Existed
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("WalkFiles() error: %v", err)
+		}
+		return got
+	}
+
+	// the copy of nat.png is in the project instead of itself
+	existing := map[string]bool{"copy": true}
+	if got := digest(existing); got["nat.png"] {
+		t.Errorf("ImageDigester() Existed of nat.png = true before it is downscaled")
+	}
+	p := filepath.Join(testImgDir, "nat.png")
+	sum, err := Sha1sum(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.SetDownscaled(p, "other", "copy")
+	if s := cache.Downscaled(p, sum); s != "" {
+		t.Errorf("Downscaled() = %q of a mismatched checksum, want empty", s)
+	}
+	cache.SetDownscaled(p, sum, "copy")
+	got := digest(existing)
+	if !got["nat.png"] || got["nat.jpg"] {
+		t.Errorf("ImageDigester() Existed = %v, want only nat.png", got)
+	}
+}
@@ -0,0 +1,174 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/jackytck/alti-cli/errors"
+
+	// for image.Decode and image.DecodeConfig
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Supported image types.
+const (
+	TypeJPEG = "image/jpeg"
+	TypePNG  = "image/png"
+	TypeTIFF = "image/tiff"
+	TypeWebP = "image/webp"
+)
+
+// maxTIFFEntries is the max number of entries of an ifd to be read.
+const maxTIFFEntries = 4096
+
+func init() {
+	// bigtiff is not decoded by image/tiff
+	image.RegisterFormat("bigtiff", "II+\x00", decodeBigTIFF, tiffConfig)
+	image.RegisterFormat("bigtiff", "MM\x00+", decodeBigTIFF, tiffConfig)
+}
+
+// SniffImageType sniffs the content type from the head of a file.
+// Unlike http.DetectContentType, tiff and bigtiff are recognized.
+func SniffImageType(head []byte) string {
+	if isTIFF(head) {
+		return TypeTIFF
+	}
+	return http.DetectContentType(head)
+}
+
+// IsSupportedImageType tells if the content type is an image type supported
+// by altizure.
+func IsSupportedImageType(filetype string) bool {
+	switch filetype {
+	case TypeJPEG, TypePNG, TypeTIFF, TypeWebP:
+		return true
+	}
+	return false
+}
+
+// DecodeImageConfig decodes the dimension of an image of the sniffed type.
+// Only the header is read for tiff, as the ifd of tiff is not decoded by
+// image.DecodeConfig if it has more than 4 samples per pixel.
+func DecodeImageConfig(r io.Reader, filetype string) (int, int, error) {
+	if !IsSupportedImageType(filetype) {
+		return 0, 0, errors.ErrFileImageFormat
+	}
+	if filetype == TypeTIFF {
+		cfg, err := tiffConfig(r)
+		return cfg.Width, cfg.Height, err
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	return cfg.Width, cfg.Height, err
+}
+
+// isTIFF tells if head starts with the magic of little or big endian, classic
+// or big tiff.
+func isTIFF(head []byte) bool {
+	for _, m := range []string{"II*\x00", "MM\x00*", "II+\x00", "MM\x00+"} {
+		if bytes.HasPrefix(head, []byte(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// tiffConfig reads the width and height from the first ifd of a classic or
+// big tiff.
+func tiffConfig(r io.Reader) (image.Config, error) {
+	var ret image.Config
+	var head [16]byte
+	if _, err := io.ReadFull(r, head[:8]); err != nil {
+		return ret, err
+	}
+	if !isTIFF(head[:]) {
+		return ret, errors.ErrFileImageDim
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if head[0] == 'M' {
+		order = binary.BigEndian
+	}
+	big := order.Uint16(head[2:]) == 43
+
+	// a. offset of the first ifd
+	var read, offset uint64
+	if big {
+		if _, err := io.ReadFull(r, head[8:]); err != nil {
+			return ret, err
+		}
+		read, offset = 16, order.Uint64(head[8:])
+	} else {
+		read, offset = 8, uint64(order.Uint32(head[4:]))
+	}
+	if offset < read {
+		return ret, errors.ErrFileImageDim
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(offset-read)); err != nil {
+		return ret, err
+	}
+
+	// b. number of entries
+	countSize, entrySize := 2, 12
+	if big {
+		countSize, entrySize = 8, 20
+	}
+	buf := make([]byte, countSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return ret, err
+	}
+	var n uint64
+	if big {
+		n = order.Uint64(buf)
+	} else {
+		n = uint64(order.Uint16(buf))
+	}
+	if n > maxTIFFEntries {
+		return ret, errors.ErrFileImageDim
+	}
+
+	// c. image width and length tags
+	entry := make([]byte, entrySize)
+	for i := uint64(0); i < n; i++ {
+		if _, err := io.ReadFull(r, entry); err != nil {
+			return ret, err
+		}
+		tag := order.Uint16(entry)
+		if tag != 256 && tag != 257 {
+			continue
+		}
+		value := tiffValue(order, entry, big)
+		if tag == 256 {
+			ret.Width = value
+		} else {
+			ret.Height = value
+		}
+	}
+	if ret.Width <= 0 || ret.Height <= 0 {
+		return ret, errors.ErrFileImageDim
+	}
+	return ret, nil
+}
+
+// tiffValue reads the short, long or long8 value of an ifd entry.
+func tiffValue(order binary.ByteOrder, entry []byte, big bool) int {
+	typ := order.Uint16(entry[2:])
+	v := entry[8:]
+	if big {
+		v = entry[12:]
+	}
+	switch typ {
+	case 3: // short
+		return int(order.Uint16(v))
+	case 4: // long
+		return int(order.Uint32(v))
+	case 16: // long8
+		if big {
+			return int(order.Uint64(v))
+		}
+	}
+	return 0
+}
@@ -0,0 +1,231 @@
+package file
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jackytck/alti-cli/errors"
+	"golang.org/x/image/tiff"
+)
+
+// bigTIFFHeader builds a big endian bigtiff with an ifd of width and length only.
+func bigTIFFHeader(w, h uint64) []byte {
+	var buf bytes.Buffer
+	o := binary.BigEndian
+	buf.WriteString("MM\x00+")
+	binary.Write(&buf, o, uint16(8))
+	binary.Write(&buf, o, uint16(0))
+	binary.Write(&buf, o, uint64(16))
+	binary.Write(&buf, o, uint64(2))
+	for i, v := range []uint64{w, h} {
+		binary.Write(&buf, o, uint16(256+i))
+		binary.Write(&buf, o, uint16(16))
+		binary.Write(&buf, o, uint64(1))
+		binary.Write(&buf, o, v)
+	}
+	return buf.Bytes()
+}
+
+// encodeBigTIFF builds a bigtiff of the tags and the strips or tiles, whose
+// offsets and byte counts are filled in the tags of offsetTag and countTag.
+func encodeBigTIFF(o binary.ByteOrder, tags map[uint16][]uint64, offsetTag, countTag uint16, segs [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(map[bool]string{true: "II+\x00", false: "MM\x00+"}[o == binary.LittleEndian])
+	binary.Write(&buf, o, []uint16{8, 0})
+	binary.Write(&buf, o, uint64(0))
+	var offsets, counts []uint64
+	for _, s := range segs {
+		offsets = append(offsets, uint64(buf.Len()))
+		counts = append(counts, uint64(len(s)))
+		buf.Write(s)
+	}
+	tags[offsetTag], tags[countTag] = offsets, counts
+
+	// arrays of more than one value are written before the ifd
+	var ids []int
+	arrays := make(map[uint16]uint64)
+	for id, v := range tags {
+		ids = append(ids, int(id))
+		if len(v) > 1 {
+			arrays[id] = uint64(buf.Len())
+			binary.Write(&buf, o, v)
+		}
+	}
+	sort.Ints(ids)
+	b := buf.Bytes()
+	o.PutUint64(b[8:], uint64(len(b)))
+	binary.Write(&buf, o, uint64(len(ids)))
+	for _, id := range ids {
+		v := tags[uint16(id)]
+		binary.Write(&buf, o, []uint16{uint16(id), 16})
+		binary.Write(&buf, o, uint64(len(v)))
+		if off, ok := arrays[uint16(id)]; ok {
+			binary.Write(&buf, o, off)
+		} else {
+			binary.Write(&buf, o, v[0])
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeBigTIFF(t *testing.T) {
+	// 16-bit gray in strips of 2 rows, the last of which has 1 row
+	gray := image.NewGray16(image.Rect(0, 0, 3, 3))
+	var strips [][]byte
+	for y := 0; y < 3; y += 2 {
+		var s bytes.Buffer
+		for yy := y; yy < y+2 && yy < 3; yy++ {
+			for x := 0; x < 3; x++ {
+				v := uint16(1000*yy + 300*x)
+				gray.SetGray16(x, yy, color.Gray16{Y: v})
+				binary.Write(&s, binary.LittleEndian, v)
+			}
+		}
+		strips = append(strips, s.Bytes())
+	}
+	grayTags := map[uint16][]uint64{tagWidth: {3}, tagHeight: {3}, tagBitsPerSample: {16}, tagPhotometric: {1}, tagRowsPerStrip: {2}}
+
+	// 8-bit rgb in 2x2 tiles, padded, deflated with the horizontal predictor
+	rgb := image.NewNRGBA(image.Rect(0, 0, 3, 3))
+	var tiles [][]byte
+	for ty := 0; ty < 3; ty += 2 {
+		for tx := 0; tx < 3; tx += 2 {
+			var raw []byte
+			for y := ty; y < ty+2; y++ {
+				var prev [3]byte
+				for x := tx; x < tx+2; x++ {
+					px := [3]byte{byte(10 * x), byte(20 * y), byte(x + y)}
+					if x < 3 && y < 3 {
+						rgb.SetNRGBA(x, y, color.NRGBA{px[0], px[1], px[2], 0xff})
+					}
+					for i := range px {
+						raw = append(raw, px[i]-prev[i])
+					}
+					prev = px
+				}
+			}
+			var z bytes.Buffer
+			zw := zlib.NewWriter(&z)
+			zw.Write(raw)
+			zw.Close()
+			tiles = append(tiles, z.Bytes())
+		}
+	}
+	rgbTags := func() map[uint16][]uint64 {
+		return map[uint16][]uint64{tagWidth: {3}, tagHeight: {3}, tagBitsPerSample: {8, 8, 8}, tagCompression: {8}, tagPhotometric: {2},
+			tagSamplesPerPixel: {3}, tagPredictor: {2}, tagTileWidth: {2}, tagTileLength: {2}}
+	}
+	planar := rgbTags()
+	planar[tagPlanarConfig] = []uint64{2}
+	truncated := encodeBigTIFF(binary.LittleEndian, grayTags, tagStripOffsets, tagStripByteCounts, strips)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    image.Image
+		wantErr error
+	}{
+		{"gray16 strips", encodeBigTIFF(binary.LittleEndian, grayTags, tagStripOffsets, tagStripByteCounts, strips), gray, nil},
+		{"rgb deflate tiles", encodeBigTIFF(binary.BigEndian, rgbTags(), tagTileOffsets, tagTileByteCounts, tiles), rgb, nil},
+		{"planar", encodeBigTIFF(binary.BigEndian, planar, tagTileOffsets, tagTileByteCounts, tiles), nil, errors.ErrFileImageDecode},
+		{"truncated", truncated[:20], nil, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, format, err := image.Decode(bytes.NewReader(tt.data))
+			if err != tt.wantErr {
+				t.Fatalf("image.Decode() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if format != "bigtiff" || !reflect.DeepEqual(img, tt.want) {
+				t.Errorf("image.Decode() = %q %+v, want %+v", format, img, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeImageConfig(t *testing.T) {
+	gray16 := image.NewGray16(image.Rect(0, 0, 40, 30))
+	gray16.SetGray16(3, 4, color.Gray16{Y: 60000})
+	var classic bytes.Buffer
+	if err := tiff.Encode(&classic, gray16, nil); err != nil {
+		t.Fatal(err)
+	}
+	var g bytes.Buffer
+	if err := gif.Encode(&g, gray16, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		typ     string
+		w, h    int
+		wantErr error
+	}{
+		{"16-bit tiff", classic.Bytes(), TypeTIFF, 40, 30, nil},
+		{"bigtiff", bigTIFFHeader(9000, 6000), TypeTIFF, 9000, 6000, nil},
+		{"gif", g.Bytes(), "image/gif", 0, 0, errors.ErrFileImageFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ := SniffImageType(tt.data)
+			if typ != tt.typ {
+				t.Errorf("SniffImageType() = %q, want %q", typ, tt.typ)
+			}
+			w, h, err := DecodeImageConfig(bytes.NewReader(tt.data), typ)
+			if err != tt.wantErr {
+				t.Fatalf("DecodeImageConfig() error = %v, want %v", err, tt.wantErr)
+			}
+			if w != tt.w || h != tt.h {
+				t.Errorf("DecodeImageConfig() = %d x %d, want %d x %d", w, h, tt.w, tt.h)
+			}
+		})
+	}
+}
+
+func TestScanImageFormat(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-format-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	big := filepath.Join(d, "big.tif")
+	if err := ioutil.WriteFile(big, bigTIFFHeader(9000, 6000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	scan, err := ScanImage(big, false)
+	if err != nil || scan.Filetype != TypeTIFF || scan.Width != 9000 || scan.SHA1 == "" {
+		t.Errorf("ScanImage(bigtiff) = %+v, %v", scan, err)
+	}
+	if _, err := DecodeImage(big); err != errors.ErrFileImageDecode {
+		t.Errorf("DecodeImage(bigtiff without strips) error = %v, want %v", err, errors.ErrFileImageDecode)
+	}
+
+	var g bytes.Buffer
+	if err := gif.Encode(&g, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	anim := filepath.Join(d, "anim.gif")
+	if err := ioutil.WriteFile(anim, g.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	scan, err = ScanImage(anim, false)
+	if err != errors.ErrFileImageFormat || !scan.IsImage {
+		t.Errorf("ScanImage(gif) = %+v, %v, want %v", scan, err, errors.ErrFileImageFormat)
+	}
+}
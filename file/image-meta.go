@@ -0,0 +1,267 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// metaHeadSize is the max number of leading bytes of an image kept for
+// parsing its EXIF and XMP, which are stored near the beginning.
+const metaHeadSize = 1 << 18
+
+// ImageMeta is the EXIF and XMP metadata of an image that matters for
+// photogrammetry.
+type ImageMeta struct {
+	Make        string
+	Model       string
+	FocalLength float64 // in mm
+	Focal35mm   int     // 35mm equivalent focal length in mm
+	CaptureTime time.Time
+	Orientation int // EXIF orientation, 1 to 8
+	HasGPS      bool
+	Latitude    float64
+	Longitude   float64
+	Altitude    float64 // in meter above sea level
+	// DJI drone specific, from XMP
+	RelativeAltitude float64 // in meter above the take-off point
+	GimbalYaw        float64
+	GimbalPitch      float64
+	GimbalRoll       float64
+	HasGimbal        bool
+}
+
+// Camera returns the make and model of the camera, empty if unknown.
+func (m ImageMeta) Camera() string {
+	mk := strings.TrimSpace(m.Make)
+	md := strings.TrimSpace(m.Model)
+	if strings.HasPrefix(strings.ToLower(md), strings.ToLower(mk)) {
+		return md
+	}
+	return strings.TrimSpace(mk + " " + md)
+}
+
+// GPS formats the gps position, empty if unknown.
+func (m ImageMeta) GPS() string {
+	if !m.HasGPS {
+		return ""
+	}
+	return fmt.Sprintf("%.6f, %.6f, %.1fm", m.Latitude, m.Longitude, m.Altitude)
+}
+
+// String describes the metadata in a single line.
+func (m ImageMeta) String() string {
+	var fs []string
+	if c := m.Camera(); c != "" {
+		fs = append(fs, "Camera: "+c)
+	}
+	if m.FocalLength > 0 {
+		fs = append(fs, fmt.Sprintf("Focal: %.1fmm", m.FocalLength))
+	}
+	if !m.CaptureTime.IsZero() {
+		fs = append(fs, "Time: "+m.CaptureTime.Format("2006-01-02 15:04:05"))
+	}
+	if m.HasGPS {
+		fs = append(fs, "GPS: "+m.GPS())
+	} else {
+		fs = append(fs, "GPS: none")
+	}
+	if m.HasGimbal {
+		fs = append(fs, fmt.Sprintf("Gimbal: %.1f/%.1f/%.1f", m.GimbalYaw, m.GimbalPitch, m.GimbalRoll))
+	}
+	if m.Orientation > 1 {
+		fs = append(fs, fmt.Sprintf("Orientation: %d", m.Orientation))
+	}
+	return strings.Join(fs, ", ")
+}
+
+// ParseImageMeta parses the EXIF and XMP from the leading bytes of an image.
+// Missing or broken metadata is ignored.
+func ParseImageMeta(head []byte) ImageMeta {
+	var ret ImageMeta
+	parseExif(head, &ret)
+	parseXMP(head, &ret)
+	return ret
+}
+
+// parseExif parses the EXIF tags.
+func parseExif(head []byte, m *ImageMeta) {
+	x, err := exif.Decode(bytes.NewReader(head))
+	if x == nil || (err != nil && exif.IsCriticalError(err)) {
+		return
+	}
+	str := func(n exif.FieldName) string {
+		t, err := x.Get(n)
+		if err != nil {
+			return ""
+		}
+		s, err := t.StringVal()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(strings.Trim(s, "\x00"))
+	}
+	rat := func(n exif.FieldName) (float64, bool) {
+		t, err := x.Get(n)
+		if err != nil {
+			return 0, false
+		}
+		num, den, err := t.Rat2(0)
+		if err != nil || den == 0 {
+			return 0, false
+		}
+		return float64(num) / float64(den), true
+	}
+	integer := func(n exif.FieldName) int {
+		t, err := x.Get(n)
+		if err != nil {
+			return 0
+		}
+		v, err := t.Int(0)
+		if err != nil {
+			return 0
+		}
+		return v
+	}
+
+	m.Make = str(exif.Make)
+	m.Model = str(exif.Model)
+	m.FocalLength, _ = rat(exif.FocalLength)
+	m.Focal35mm = integer(exif.FocalLengthIn35mmFilm)
+	m.Orientation = integer(exif.Orientation)
+	if t, err := x.DateTime(); err == nil {
+		m.CaptureTime = t
+	}
+	if lat, lon, err := x.LatLong(); err == nil && !(lat == 0 && lon == 0) {
+		m.HasGPS = true
+		m.Latitude = lat
+		m.Longitude = lon
+		if alt, ok := rat(exif.GPSAltitude); ok {
+			if t, err := x.Get(exif.GPSAltitudeRef); err == nil {
+				if ref, err := t.Int(0); err == nil && ref == 1 {
+					alt = -alt
+				}
+			}
+			m.Altitude = alt
+		}
+	}
+}
+
+// xmpAttr matches the attributes of XMP, e.g. drone-dji:GpsLatitude="22.3".
+var xmpAttr = regexp.MustCompile(`([\w-]+:\w+)="([^"]*)"`)
+
+// xmpElem matches the simple elements of XMP, e.g. <tiff:Make>DJI</tiff:Make>.
+var xmpElem = regexp.MustCompile(`<([\w-]+:\w+)>([^<]*)</`)
+
+// parseXMP parses the XMP packet, mainly for the DJI drone tags. EXIF takes
+// precedence, except the more precise DJI gps.
+func parseXMP(head []byte, m *ImageMeta) {
+	start := bytes.Index(head, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return
+	}
+	end := bytes.Index(head[start:], []byte("</x:xmpmeta>"))
+	if end < 0 {
+		return
+	}
+	packet := head[start : start+end]
+
+	tags := make(map[string]string)
+	for _, re := range []*regexp.Regexp{xmpAttr, xmpElem} {
+		for _, s := range re.FindAllSubmatch(packet, -1) {
+			tags[string(s[1])] = strings.TrimSpace(string(s[2]))
+		}
+	}
+	float := func(keys ...string) (float64, bool) {
+		for _, k := range keys {
+			if v, ok := tags[k]; ok {
+				f, err := strconv.ParseFloat(v, 64)
+				if err == nil {
+					return f, true
+				}
+			}
+		}
+		return 0, false
+	}
+
+	if m.Make == "" {
+		m.Make = tags["tiff:Make"]
+	}
+	if m.Model == "" {
+		m.Model = tags["tiff:Model"]
+	}
+
+	// some dji firmwares misspell longitude
+	lat, okLat := float("drone-dji:GpsLatitude", "drone-dji:Latitude")
+	lon, okLon := float("drone-dji:GpsLongitude", "drone-dji:GpsLongtitude", "drone-dji:Longitude")
+	if okLat && okLon && !(lat == 0 && lon == 0) {
+		m.HasGPS = true
+		m.Latitude = lat
+		m.Longitude = lon
+	}
+	if alt, ok := float("drone-dji:AbsoluteAltitude"); ok {
+		m.Altitude = alt
+	}
+	if alt, ok := float("drone-dji:RelativeAltitude"); ok {
+		m.RelativeAltitude = alt
+	}
+	yaw, okYaw := float("drone-dji:GimbalYawDegree")
+	pitch, okPitch := float("drone-dji:GimbalPitchDegree")
+	roll, okRoll := float("drone-dji:GimbalRollDegree")
+	if okYaw || okPitch || okRoll {
+		m.HasGimbal = true
+		m.GimbalYaw = yaw
+		m.GimbalPitch = pitch
+		m.GimbalRoll = roll
+	}
+}
+
+// MetaSummary summarizes the metadata of a set of images for warning about
+// the sets that reconstruct poorly. It is not safe for concurrent use.
+type MetaSummary struct {
+	Images  int
+	WithGPS int
+	Cameras map[string]int // number of images of each camera
+}
+
+// Add adds the metadata of an image.
+func (s *MetaSummary) Add(m ImageMeta) {
+	if s.Cameras == nil {
+		s.Cameras = make(map[string]int)
+	}
+	s.Images++
+	if m.HasGPS {
+		s.WithGPS++
+	}
+	c := m.Camera()
+	if c == "" {
+		c = "Unknown"
+	}
+	s.Cameras[c]++
+}
+
+// Warnings returns the warnings of missing gps and mixed cameras.
+func (s *MetaSummary) Warnings() []string {
+	var ret []string
+	if s.Images == 0 {
+		return ret
+	}
+	if s.WithGPS < s.Images {
+		ret = append(ret, fmt.Sprintf("%d out of %d images have no GPS", s.Images-s.WithGPS, s.Images))
+	}
+	if len(s.Cameras) > 1 {
+		var cs []string
+		for c, n := range s.Cameras {
+			cs = append(cs, fmt.Sprintf("%s (%d)", c, n))
+		}
+		sort.Strings(cs)
+		ret = append(ret, fmt.Sprintf("Images are taken by %d cameras: %s", len(s.Cameras), strings.Join(cs, ", ")))
+	}
+	return ret
+}
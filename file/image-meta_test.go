@@ -0,0 +1,171 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tiffEntry is an IFD entry of type ASCII (2), BYTE (1), LONG (4) or RATIONAL (5).
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	ascii string
+	val   uint32   // BYTE or LONG
+	rats  []uint32 // pairs of numerator and denominator
+}
+
+// exifSegment builds the APP1 segment of a little-endian EXIF with Make,
+// Model and GPS.
+func exifSegment() []byte {
+	le := binary.LittleEndian
+	ifd0 := []tiffEntry{
+		{tag: 0x010F, typ: 2, ascii: "DJI"},
+		{tag: 0x0110, typ: 2, ascii: "FC6310"},
+		{tag: 0x8825, typ: 4}, // gps ifd pointer, set below
+	}
+	gps := []tiffEntry{
+		{tag: 1, typ: 2, ascii: "N"},
+		{tag: 2, typ: 5, rats: []uint32{22, 1, 30, 1, 0, 1}},
+		{tag: 3, typ: 2, ascii: "E"},
+		{tag: 4, typ: 5, rats: []uint32{114, 1, 15, 1, 0, 1}},
+		{tag: 5, typ: 1, val: 0},
+		{tag: 6, typ: 5, rats: []uint32{100, 1}},
+	}
+
+	// writeIFD writes the ifd at off with its out-of-line data right after it.
+	var buf []byte
+	writeIFD := func(off int, es []tiffEntry) int {
+		data := off + 2 + len(es)*12 + 4
+		b := make([]byte, data-off)
+		le.PutUint16(b, uint16(len(es)))
+		var extra []byte
+		for i, e := range es {
+			p := b[2+i*12:]
+			le.PutUint16(p, e.tag)
+			le.PutUint16(p[2:], e.typ)
+			var raw []byte
+			switch e.typ {
+			case 2:
+				raw = append([]byte(e.ascii), 0)
+				le.PutUint32(p[4:], uint32(len(raw)))
+			case 5:
+				raw = make([]byte, len(e.rats)*4)
+				for j, r := range e.rats {
+					le.PutUint32(raw[j*4:], r)
+				}
+				le.PutUint32(p[4:], uint32(len(e.rats)/2))
+			default:
+				le.PutUint32(p[4:], 1)
+				le.PutUint32(p[8:], e.val)
+			}
+			if len(raw) > 4 {
+				le.PutUint32(p[8:], uint32(data+len(extra)))
+				extra = append(extra, raw...)
+			} else {
+				copy(p[8:], raw)
+			}
+		}
+		buf = append(buf, b...)
+		buf = append(buf, extra...)
+		return off + len(b) + len(extra)
+	}
+
+	buf = []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	// gps ifd follows ifd0, which has 7 bytes of data for the model
+	ifd0[2].val = uint32(8 + 2 + len(ifd0)*12 + 4 + 7)
+	writeIFD(8, ifd0)
+	writeIFD(len(buf), gps)
+
+	payload := append([]byte("Exif\x00\x00"), buf...)
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func TestScanImageMeta(t *testing.T) {
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, image.NewGray(image.Rect(0, 0, 16, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	raw := enc.Bytes()
+	img := append([]byte{0xFF, 0xD8}, exifSegment()...)
+	img = append(img, raw[2:]...)
+
+	dir, err := ioutil.TempDir("", "meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "dji.jpg")
+	if err := ioutil.WriteFile(p, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	scan, err := ScanImage(p, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := scan.Meta
+	if scan.Width != 16 || scan.Height != 8 {
+		t.Errorf("dimension = %d x %d, want 16 x 8", scan.Width, scan.Height)
+	}
+	if m.Camera() != "DJI FC6310" {
+		t.Errorf("Camera() = %q, want %q", m.Camera(), "DJI FC6310")
+	}
+	if !m.HasGPS || math.Abs(m.Latitude-22.5) > 1e-9 || math.Abs(m.Longitude-114.25) > 1e-9 || m.Altitude != 100 {
+		t.Errorf("GPS = %v %v %v %v", m.HasGPS, m.Latitude, m.Longitude, m.Altitude)
+	}
+}
+
+func TestParseImageMetaXMP(t *testing.T) {
+	head := []byte(`junk<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description
+		tiff:Make="DJI" tiff:Model="FC6310"
+		drone-dji:AbsoluteAltitude="+123.45"
+		drone-dji:RelativeAltitude="+60.10"
+		drone-dji:GpsLatitude="22.336"
+		drone-dji:GpsLongtitude="114.263"
+		drone-dji:GimbalYawDegree="-90.5"
+		drone-dji:GimbalPitchDegree="-45.0"
+		drone-dji:GimbalRollDegree="0.00"/></rdf:RDF></x:xmpmeta>junk`)
+	m := ParseImageMeta(head)
+	if m.Camera() != "DJI FC6310" {
+		t.Errorf("Camera() = %q", m.Camera())
+	}
+	if !m.HasGPS || m.Latitude != 22.336 || m.Longitude != 114.263 || m.Altitude != 123.45 || m.RelativeAltitude != 60.1 {
+		t.Errorf("GPS = %+v", m)
+	}
+	if !m.HasGimbal || m.GimbalYaw != -90.5 || m.GimbalPitch != -45 {
+		t.Errorf("Gimbal = %+v", m)
+	}
+
+	if m := ParseImageMeta([]byte("no metadata")); m.HasGPS || m.Camera() != "" {
+		t.Errorf("ParseImageMeta() = %+v, want empty", m)
+	}
+}
+
+func TestMetaSummary(t *testing.T) {
+	var s MetaSummary
+	s.Add(ImageMeta{Make: "DJI", Model: "FC6310", HasGPS: true})
+	s.Add(ImageMeta{Make: "DJI", Model: "FC6310", HasGPS: true})
+	if ws := s.Warnings(); len(ws) != 0 {
+		t.Errorf("Warnings() = %v, want none", ws)
+	}
+	s.Add(ImageMeta{Make: "Canon", Model: "Canon EOS 5D"})
+	ws := s.Warnings()
+	if len(ws) != 2 {
+		t.Fatalf("Warnings() = %v, want 2", ws)
+	}
+	if want := "1 out of 3 images have no GPS"; ws[0] != want {
+		t.Errorf("Warnings()[0] = %q, want %q", ws[0], want)
+	}
+	if want := "Images are taken by 2 cameras: Canon EOS 5D (1), DJI FC6310 (2)"; ws[1] != want {
+		t.Errorf("Warnings()[1] = %q, want %q", ws[1], want)
+	}
+}
@@ -0,0 +1,272 @@
+package file
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jackytck/alti-cli/errors"
+)
+
+// Issues of image quality.
+const (
+	IssueBlurry       = "blurry"
+	IssueOverexposed  = "overexposed"
+	IssueUnderexposed = "underexposed"
+	IssueTruncated    = "truncated"
+	IssueUndersized   = "undersized"
+)
+
+// qualityMaxSide is the max side of the downsampled image for analysis.
+const qualityMaxSide = 1024
+
+// Thresholds of the clipped pixel values in 8-bit grayscale.
+const (
+	darkLevel   = 5
+	brightLevel = 250
+)
+
+// QualityOptions are the thresholds for flagging an image.
+type QualityOptions struct {
+	MinSharpness float64 // min variance of laplacian, lower is blurry
+	MaxClipped   float64 // max fraction of nearly black or white pixels
+	MinSizeRatio float64 // min ratio of gp to the median gp of the set
+}
+
+// DefaultQualityOptions returns the default thresholds.
+func DefaultQualityOptions() QualityOptions {
+	return QualityOptions{
+		MinSharpness: 100,
+		MaxClipped:   0.2,
+		MinSizeRatio: 0.5,
+	}
+}
+
+// ImageQuality is the result of analyzing the quality of an image.
+type ImageQuality struct {
+	Sharpness float64 // variance of laplacian of the downsampled grayscale
+	Dark      float64 // fraction of nearly black pixels
+	Bright    float64 // fraction of nearly white pixels
+	Issues    []string
+	Error     error // error of decoding the whole image, if any
+}
+
+// Flagged tells if the image has any issue.
+func (q *ImageQuality) Flagged() bool {
+	return q != nil && len(q.Issues) > 0
+}
+
+// Flag adds an issue.
+func (q *ImageQuality) Flag(issue string) {
+	q.Issues = append(q.Issues, issue)
+}
+
+func (q *ImageQuality) String() string {
+	if q == nil {
+		return ""
+	}
+	if len(q.Issues) == 0 {
+		return "ok"
+	}
+	var ds []string
+	for _, i := range q.Issues {
+		switch i {
+		case IssueBlurry:
+			ds = append(ds, fmt.Sprintf("%s (sharpness %.1f)", i, q.Sharpness))
+		case IssueOverexposed:
+			ds = append(ds, fmt.Sprintf("%s (%.0f%% clipped)", i, q.Bright*100))
+		case IssueUnderexposed:
+			ds = append(ds, fmt.Sprintf("%s (%.0f%% clipped)", i, q.Dark*100))
+		case IssueTruncated:
+			ds = append(ds, fmt.Sprintf("%s (%v)", i, q.Error))
+		default:
+			ds = append(ds, i)
+		}
+	}
+	return strings.Join(ds, ", ")
+}
+
+// AnalyzeImage decodes the whole image at p and analyzes its sharpness and
+// exposure. An image failing to decode is flagged as truncated.
+func AnalyzeImage(p string, opts QualityOptions) (*ImageQuality, error) {
+	img, err := DecodeImage(p)
+	if err != nil && os.IsNotExist(err) {
+		return nil, err
+	}
+	return AnalyzeDecoded(img, err, opts), nil
+}
+
+// DecodeImage decodes the whole image at p.
+func DecodeImage(p string) (image.Image, error) {
+	f, err := OpenPath(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(bufio.NewReaderSize(f, scanBufferSize))
+	return img, err
+}
+
+// AnalyzeDecoded analyzes the sharpness and exposure of a decoded image.
+// If decodeErr is not nil, the image is flagged as truncated, unless its
+// pixels could not be decoded at all.
+func AnalyzeDecoded(img image.Image, decodeErr error, opts QualityOptions) *ImageQuality {
+	ret := ImageQuality{}
+	if decodeErr == errors.ErrFileImageDecode {
+		// not analyzable, but not broken either
+		ret.Error = decodeErr
+		return &ret
+	}
+	if decodeErr != nil {
+		ret.Error = decodeErr
+		ret.Flag(IssueTruncated)
+		return &ret
+	}
+
+	gray, w, h := downsampleGray(img, qualityMaxSide)
+	ret.Sharpness = laplacianVariance(gray, w, h)
+	ret.Dark, ret.Bright = clipped(gray)
+
+	if ret.Sharpness < opts.MinSharpness {
+		ret.Flag(IssueBlurry)
+	}
+	if ret.Bright > opts.MaxClipped {
+		ret.Flag(IssueOverexposed)
+	}
+	if ret.Dark > opts.MaxClipped {
+		ret.Flag(IssueUnderexposed)
+	}
+	return &ret
+}
+
+// downsampleGray converts img to grayscale and shrinks it by box averaging,
+// so that its longer side is at most maxSide.
+func downsampleGray(img image.Image, maxSide int) ([]float64, int, int) {
+	b := img.Bounds()
+	f := 1
+	for b.Dx()/f > maxSide || b.Dy()/f > maxSide {
+		f++
+	}
+	w, h := b.Dx()/f, b.Dy()/f
+	ret := make([]float64, w*h)
+
+	// luma of the pixel at x, y, fast path for jpeg
+	luma := func(x, y int) float64 {
+		c := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+		return float64(c.Y)
+	}
+	if yc, ok := img.(*image.YCbCr); ok {
+		luma = func(x, y int) float64 {
+			return float64(yc.Y[yc.YOffset(x, y)])
+		}
+	}
+
+	n := float64(f * f)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum float64
+			for dy := 0; dy < f; dy++ {
+				for dx := 0; dx < f; dx++ {
+					sum += luma(b.Min.X+x*f+dx, b.Min.Y+y*f+dy)
+				}
+			}
+			ret[y*w+x] = sum / n
+		}
+	}
+	return ret, w, h
+}
+
+// laplacianVariance returns the variance of the 4-neighbour laplacian.
+func laplacianVariance(pix []float64, w, h int) float64 {
+	if w < 3 || h < 3 {
+		return 0
+	}
+	var sum, sumSq float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			l := pix[i-w] + pix[i+w] + pix[i-1] + pix[i+1] - 4*pix[i]
+			sum += l
+			sumSq += l * l
+		}
+	}
+	n := float64((w - 2) * (h - 2))
+	mean := sum / n
+	return math.Max(sumSq/n-mean*mean, 0)
+}
+
+// clipped returns the fractions of nearly black and nearly white pixels.
+func clipped(pix []float64) (float64, float64) {
+	if len(pix) == 0 {
+		return 0, 0
+	}
+	var dark, bright int
+	for _, p := range pix {
+		switch {
+		case p <= darkLevel:
+			dark++
+		case p >= brightLevel:
+			bright++
+		}
+	}
+	n := float64(len(pix))
+	return float64(dark) / n, float64(bright) / n
+}
+
+// UndersizedGP returns the gp below which an image is regarded as far
+// smaller than the rest of the set, i.e. ratio of the median gp.
+func UndersizedGP(gps []float64, ratio float64) float64 {
+	if len(gps) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), gps...)
+	sort.Float64s(s)
+	m := s[len(s)/2]
+	if len(s)%2 == 0 {
+		m = (s[len(s)/2-1] + m) / 2
+	}
+	return m * ratio
+}
+
+// QualitySummary counts the images of each quality issue.
+type QualitySummary struct {
+	Images  int
+	Flagged int
+	Issues  map[string]int
+}
+
+// Add adds the quality of an image.
+func (s *QualitySummary) Add(q *ImageQuality) {
+	if q == nil {
+		return
+	}
+	if s.Issues == nil {
+		s.Issues = make(map[string]int)
+	}
+	s.Images++
+	if q.Flagged() {
+		s.Flagged++
+	}
+	for _, i := range q.Issues {
+		s.Issues[i]++
+	}
+}
+
+func (s *QualitySummary) String() string {
+	var is []string
+	for _, i := range []string{IssueBlurry, IssueOverexposed, IssueUnderexposed, IssueTruncated, IssueUndersized} {
+		if n := s.Issues[i]; n > 0 {
+			is = append(is, fmt.Sprintf("%d %s", n, i))
+		}
+	}
+	ret := fmt.Sprintf("%d out of %d images are flagged", s.Flagged, s.Images)
+	if len(is) > 0 {
+		ret += ": " + strings.Join(is, ", ")
+	}
+	return ret
+}
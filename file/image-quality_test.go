@@ -0,0 +1,86 @@
+package file
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quality")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// write encodes img as png, truncated to n bytes if n is positive
+	write := func(name string, img image.Image, n int) string {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		if n > 0 {
+			b = b[:n]
+		}
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	fill := func(f func(x, y int) uint8) image.Image {
+		img := image.NewGray(image.Rect(0, 0, 64, 64))
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				img.SetGray(x, y, color.Gray{Y: f(x, y)})
+			}
+		}
+		return img
+	}
+	checker := fill(func(x, y int) uint8 { return uint8(64 + 128*((x/2+y/2)%2)) })
+	flat := fill(func(x, y int) uint8 { return 128 })
+	white := fill(func(x, y int) uint8 { return 255 - uint8((x/2+y/2)%2)*150 })
+	black := fill(func(x, y int) uint8 { return uint8((x/2+y/2)%2) * 150 })
+
+	tests := []struct {
+		name string
+		p    string
+		want []string
+	}{
+		{"sharp", write("sharp.png", checker, 0), nil},
+		{"blurry", write("blurry.png", flat, 0), []string{IssueBlurry}},
+		{"overexposed", write("white.png", white, 0), []string{IssueOverexposed}},
+		{"underexposed", write("black.png", black, 0), []string{IssueUnderexposed}},
+		{"truncated", write("truncated.png", checker, 100), []string{IssueTruncated}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := AnalyzeImage(tt.p, DefaultQualityOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(q.Issues, tt.want) {
+				t.Errorf("AnalyzeImage() issues = %v, want %v, %+v", q.Issues, tt.want, q)
+			}
+		})
+	}
+}
+
+func TestUndersizedGP(t *testing.T) {
+	if got := UndersizedGP(nil, 0.5); got != 0 {
+		t.Errorf("UndersizedGP() = %v, want 0", got)
+	}
+	if got := UndersizedGP([]float64{0.02, 0.02, 0.001}, 0.5); got != 0.01 {
+		t.Errorf("UndersizedGP() = %v, want 0.01", got)
+	}
+	if got := UndersizedGP([]float64{0.01, 0.02, 0.03, 0.04}, 0.5); got != 0.0125 {
+		t.Errorf("UndersizedGP() = %v, want 0.0125", got)
+	}
+}
@@ -0,0 +1,114 @@
+package file
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"strings"
+
+	"github.com/jackytck/alti-cli/errors"
+)
+
+// scanBufferSize is the size of buffer for reading an image file.
+const scanBufferSize = 1 << 16
+
+// ImageScan is the result of scanning an image file in a single pass.
+type ImageScan struct {
+	IsImage  bool
+	Filetype string
+	Filesize int64 // in bytes
+	Width    int
+	Height   int
+	SHA1     string
+	Meta     ImageMeta
+}
+
+// ScanImage reads the file once to sniff its type, decode its dimension,
+// parse its EXIF and XMP, and compute its sha1 checksum.
+// If sniffOnly is set, only the type is sniffed.
+// For non-image file, only `IsImage`, `Filetype` and `Filesize` are set.
+// p could be a virtual path of an archive member.
+func ScanImage(p string, sniffOnly bool) (ImageScan, error) {
+	stat, err := StatPath(p)
+	if err != nil {
+		return ImageScan{}, err
+	}
+	f, err := OpenPath(p)
+	if err != nil {
+		return ImageScan{}, err
+	}
+	defer f.Close()
+
+	ret, err := ScanReader(f, sniffOnly, true)
+	ret.Filesize = stat.Size()
+	return ret, err
+}
+
+// ScanReader is the same as ScanImage but reads from r, e.g. a http body.
+// If hash is not set, only the leading bytes needed for the dimension and
+// metadata are read, and `SHA1` and `Filesize` are left empty. Otherwise,
+// `Filesize` is the number of bytes read.
+func ScanReader(r io.Reader, sniffOnly, hash bool) (ImageScan, error) {
+	var ret ImageScan
+
+	// a. sniff type from the header
+	br := bufio.NewReaderSize(r, scanBufferSize)
+	head, err := br.Peek(512)
+	if err != nil && (err != io.EOF || len(head) == 0) {
+		return ret, err
+	}
+	ret.Filetype = SniffImageType(head)
+	ret.IsImage = strings.Contains(ret.Filetype, "image/")
+	if !ret.IsImage || sniffOnly {
+		return ret, nil
+	}
+	if !IsSupportedImageType(ret.Filetype) {
+		return ret, errors.ErrFileImageFormat
+	}
+
+	// b. decode dimension while hashing and keeping the head of the bytes being read
+	h := sha1.New()
+	hw := &headWriter{max: metaHeadSize}
+	tee := io.TeeReader(br, io.MultiWriter(h, hw))
+	ret.Width, ret.Height, err = DecodeImageConfig(tee, ret.Filetype)
+	if err != nil {
+		return ret, err
+	}
+
+	// c. hash the rest, metadata may be after the dimension, e.g. in png
+	if hash {
+		if _, err := io.Copy(io.MultiWriter(h, hw), br); err != nil {
+			return ret, err
+		}
+		ret.SHA1 = hex.EncodeToString(h.Sum(nil))
+		ret.Filesize = hw.total
+	} else if n := hw.max - len(hw.buf); n > 0 {
+		if _, err := io.CopyN(hw, br, int64(n)); err != nil && err != io.EOF {
+			return ret, err
+		}
+	}
+
+	// d. parse exif and xmp
+	ret.Meta = ParseImageMeta(hw.buf)
+
+	return ret, nil
+}
+
+// headWriter keeps the first max bytes written to it and discards the rest.
+type headWriter struct {
+	buf   []byte
+	max   int
+	total int64 // number of bytes written
+}
+
+func (hw *headWriter) Write(p []byte) (int, error) {
+	hw.total += int64(len(p))
+	if n := hw.max - len(hw.buf); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		hw.buf = append(hw.buf, p[:n]...)
+	}
+	return len(p), nil
+}
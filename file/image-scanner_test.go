@@ -0,0 +1,150 @@
+package file
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanImage(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		sniffOnly bool
+		isImage   bool
+		wantErr   bool
+	}{
+		{"non-existing", "nat", false, false, true},
+		{"jpg", testImgDir + "nat.jpg", false, true, false},
+		{"png", testImgDir + "nat.png", false, true, false},
+		{"jpg sniff only", testImgDir + "nat.jpg", true, true, false},
+		{"non-image", "test/data/other/log.txt", false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ScanImage(tt.file, tt.sniffOnly)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ScanImage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.IsImage != tt.isImage {
+				t.Errorf("ScanImage() IsImage = %v, want %v", got.IsImage, tt.isImage)
+			}
+
+			// must agree with the multi-pass helpers
+			size, _ := Filesize(tt.file)
+			if got.Filesize != size {
+				t.Errorf("ScanImage() Filesize = %d, want %d", got.Filesize, size)
+			}
+			if !tt.isImage || tt.sniffOnly {
+				if got.SHA1 != "" || got.Width != 0 {
+					t.Errorf("ScanImage() should only sniff, got %+v", got)
+				}
+				return
+			}
+			ft, _ := GuessFileType(tt.file)
+			if got.Filetype != ft {
+				t.Errorf("ScanImage() Filetype = %q, want %q", got.Filetype, ft)
+			}
+			w, h, _ := GetImageSize(tt.file)
+			sum, _ := Sha1sum(tt.file)
+			if got.Width != w || got.Height != h || got.SHA1 != sum {
+				t.Errorf("ScanImage() = %d x %d %s, want %d x %d %s", got.Width, got.Height, got.SHA1, w, h, sum)
+			}
+		})
+	}
+}
+
+// benchImgDir creates n jpeg images in a temp dir for benchmarking.
+// Return the dir and total bytes of images.
+func benchImgDir(b *testing.B, n int) (string, int64) {
+	b.Helper()
+	d, err := ioutil.TempDir("", "alti-cli-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var im draw.Image = image.NewRGBA(image.Rectangle{Max: image.Point{X: testImgWidth, Y: testImgHeight}})
+	im = fibGradient(im)
+
+	var total int64
+	for i := 0; i < n; i++ {
+		p := filepath.Join(d, fmt.Sprintf("img-%03d.jpg", i))
+		f, err := os.Create(p)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err = jpeg.Encode(f, im, &jpeg.Options{Quality: 95}); err != nil {
+			b.Fatal(err)
+		}
+		stat, _ := f.Stat()
+		total += stat.Size()
+		f.Close()
+	}
+	return d, total
+}
+
+// digestDir digests all of the files in dir with the given worker.
+func digestDir(b *testing.B, dir string, work func(string) error) {
+	done := make(chan struct{})
+	defer close(done)
+	paths, errc := WalkFiles(done, dir, "")
+	for p := range paths {
+		if err := work(p); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := <-errc; err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkDigestMultiPass opens each image once for each of type, size,
+// dimension and checksum.
+func BenchmarkDigestMultiPass(b *testing.B) {
+	dir, total := benchImgDir(b, 50)
+	defer os.RemoveAll(dir)
+
+	b.SetBytes(total)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		digestDir(b, dir, func(p string) error {
+			if _, err := IsImageFile(p); err != nil {
+				return err
+			}
+			if _, err := GuessFileType(p); err != nil {
+				return err
+			}
+			if _, err := Filesize(p); err != nil {
+				return err
+			}
+			if _, _, err := GetImageSize(p); err != nil {
+				return err
+			}
+			_, err := Sha1sum(p)
+			return err
+		})
+	}
+}
+
+// BenchmarkDigestSinglePass reads each image once by ScanImage.
+func BenchmarkDigestSinglePass(b *testing.B) {
+	dir, total := benchImgDir(b, 50)
+	defer os.RemoveAll(dir)
+
+	b.SetBytes(total)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		digestDir(b, dir, func(p string) error {
+			_, err := ScanImage(p, false)
+			return err
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package file
+
+import "fmt"
+
+// Levels of issues of meta and model files. A file with any error is invalid,
+// while a warning is something likely wrong.
+const (
+	IssueError   = "error"
+	IssueWarning = "warning"
+)
+
+// Issue is an issue of a file at a line, of the whole file if Line is 0, e.g.
+// of a meta file or a member of a model zip. File is empty if the issue is of
+// the checked file itself.
+type Issue struct {
+	File    string
+	Line    int
+	Level   string
+	Message string
+}
+
+func (i Issue) String() string {
+	switch {
+	case i.File == "" && i.Line == 0:
+		return fmt.Sprintf("%s: %s", i.Level, i.Message)
+	case i.File == "":
+		return fmt.Sprintf("line %d: %s: %s", i.Line, i.Level, i.Message)
+	case i.Line == 0:
+		return fmt.Sprintf("%s: %s: %s", i.File, i.Level, i.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", i.File, i.Line, i.Level, i.Message)
+}
+
+// Issues are the issues found by a check, embedded in its report.
+type Issues []Issue
+
+// Valid tells if there is no error.
+func (is Issues) Valid() bool {
+	return is.Count(IssueError) == 0
+}
+
+// Count returns the number of issues of level.
+func (is Issues) Count(level string) int {
+	n := 0
+	for _, i := range is {
+		if i.Level == level {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,430 @@
+package file
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackytck/alti-cli/text"
+)
+
+// MetaImageExts are the extensions of the images referenced by meta files.
+var MetaImageExts = []string{".jpg", ".jpeg", ".png", ".tif", ".tiff", ".webp"}
+
+// MetaReport is the result of validating a meta file.
+type MetaReport struct {
+	Path    string
+	Entries int // number of images or xml elements defined
+	Issues      // of the lines, the meta file is valid if there is no error
+}
+
+func (r *MetaReport) add(line int, level, format string, a ...interface{}) {
+	r.Issues = append(r.Issues, Issue{"", line, level, fmt.Sprintf(format, a...)})
+}
+
+// ValidateMeta parses the meta file at p by its filename, which is one of:
+//
+//	group.txt:  <image> <group>
+//	pose.txt:   <image> <latitude> <longitude> <altitude> [<yaw> <pitch> <roll>]
+//	camera.txt: <image> <width> <height> <fx> <fy> <cx> <cy> [<k1> <k2> <k3> <p1> <p2>]
+//	initial.xms and initial.xms.zip: xml
+//
+// The columns are separated by spaces, tabs or commas, and the lines starting
+// with # are comments. Latitude and longitude are WGS84 degrees, altitude is
+// in meters, angles are in degrees and the camera parameters are in pixels.
+// images are the relative paths of the images of the project, which are
+// checked against the referenced images unless it is empty.
+func ValidateMeta(p string, images []string) (*MetaReport, error) {
+	r := &MetaReport{Path: p}
+	name := filepath.Base(p)
+	if name == "initial.xms" || name == "initial.xms.zip" {
+		return r, validateXMS(p, r)
+	}
+
+	var cols []int
+	check := func(int, []string) {}
+	finish := func() {}
+	switch name {
+	case "group.txt":
+		cols = []int{2}
+	case "pose.txt":
+		cols = []int{4, 7}
+		pc := poseChecker{r: r}
+		check = pc.check
+		finish = pc.finish
+	case "camera.txt":
+		cols = []int{7, 12}
+		check = func(line int, toks []string) { checkCamera(r, line, toks) }
+	default:
+		return nil, fmt.Errorf("unknown meta file %q", name)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	refs := newImageRefs(images)
+	seen := make(map[string]int)
+	groups := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		l := scanner.Text()
+		if line == 1 {
+			l = strings.TrimPrefix(l, "\ufeff")
+		}
+		if !utf8.ValidString(l) {
+			r.add(line, IssueError, "not utf-8 text")
+			continue
+		}
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		toks := strings.FieldsFunc(l, func(c rune) bool {
+			return c == ' ' || c == '\t' || c == ','
+		})
+		if !containsInt(cols, len(toks)) {
+			r.add(line, IssueError, "%d columns, should be %s", len(toks), joinInts(cols, " or "))
+			continue
+		}
+		img := filepath.ToSlash(toks[0])
+		if prev, ok := seen[strings.ToLower(img)]; ok {
+			r.add(line, IssueError, "image %q is already defined on line %d", img, prev)
+			continue
+		}
+		seen[strings.ToLower(img)] = line
+		r.Entries++
+		if msg := refs.check(img); msg != "" {
+			r.add(line, IssueError, "%s", msg)
+		}
+		if name == "group.txt" {
+			groups[toks[1]]++
+		}
+		check(line, toks)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	finish()
+	if r.Entries == 0 {
+		r.add(0, IssueError, "no image is defined")
+	}
+	var singles []string
+	for g, n := range groups {
+		if n == 1 {
+			singles = append(singles, g)
+		}
+	}
+	if len(singles) > 0 && len(singles) < len(groups) {
+		sort.Strings(singles)
+		r.add(0, IssueWarning, "groups %q have only 1 image", singles)
+	}
+	if missing := refs.missing(seen); missing > 0 && r.Entries > 0 {
+		r.add(0, IssueWarning, "%d of %d images are not defined", missing, len(images))
+	}
+	return r, nil
+}
+
+// MetaImages returns the relative paths of the images under dir, by their
+// extensions, for resolving the images referenced by meta files. The
+// subdirectories are walked only if recursive.
+func MetaImages(dir string, recursive bool) ([]string, error) {
+	if !recursive {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		var ret []string
+		for _, fi := range fis {
+			ext := strings.ToLower(filepath.Ext(fi.Name()))
+			if _, ok := text.Contains(MetaImageExts, ext); ok && fi.Mode().IsRegular() {
+				ret = append(ret, fi.Name())
+			}
+		}
+		return ret, nil
+	}
+	done := make(chan struct{})
+	defer close(done)
+	paths, errc := WalkFilter(done, dir, Filter{})
+	var ret []string
+	for p := range paths {
+		ext := strings.ToLower(filepath.Ext(p))
+		if _, ok := text.Contains(MetaImageExts, ext); !ok {
+			continue
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, filepath.ToSlash(rel))
+	}
+	return ret, <-errc
+}
+
+// imageRefs resolves the referenced images by relative path or filename.
+type imageRefs struct {
+	paths map[string]bool
+	names map[string][]string // lower case filename to paths
+}
+
+func newImageRefs(images []string) imageRefs {
+	refs := imageRefs{paths: make(map[string]bool), names: make(map[string][]string)}
+	for _, p := range images {
+		p = filepath.ToSlash(p)
+		refs.paths[p] = true
+		n := strings.ToLower(filepath.Base(p))
+		refs.names[n] = append(refs.names[n], p)
+	}
+	return refs
+}
+
+// check returns the message if the image could not be resolved.
+func (refs imageRefs) check(img string) string {
+	if len(refs.paths) == 0 || refs.paths[img] {
+		return ""
+	}
+	cands := refs.names[strings.ToLower(filepath.Base(img))]
+	for _, c := range cands {
+		if filepath.Base(c) == filepath.Base(img) {
+			if len(cands) > 1 {
+				return fmt.Sprintf("image %q is ambiguous: %q", img, cands)
+			}
+			return ""
+		}
+	}
+	if len(cands) > 0 {
+		return fmt.Sprintf("image %q is not found, but %q differs in case", img, cands[0])
+	}
+	return fmt.Sprintf("image %q is not found", img)
+}
+
+// missing returns the number of images not defined in seen, which is keyed by
+// lower case references.
+func (refs imageRefs) missing(seen map[string]int) int {
+	if len(refs.paths) == 0 {
+		return 0
+	}
+	names := make(map[string]bool)
+	for s := range seen {
+		names[filepath.Base(s)] = true
+	}
+	n := 0
+	for p := range refs.paths {
+		if _, ok := seen[strings.ToLower(p)]; !ok && !names[strings.ToLower(filepath.Base(p))] {
+			n++
+		}
+	}
+	return n
+}
+
+// parseFloats parses the columns as floats, adding an error for each invalid
+// one.
+func parseFloats(r *MetaReport, line int, toks []string, names []string) ([]float64, bool) {
+	ok := true
+	var ret []float64
+	for i, t := range toks {
+		v, err := strconv.ParseFloat(t, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			r.add(line, IssueError, "%s %q is not a number", names[i], t)
+			ok = false
+		}
+		ret = append(ret, v)
+	}
+	return ret, ok
+}
+
+// poseChecker checks the rows of pose.txt, and the whole file when finished.
+type poseChecker struct {
+	r       *MetaReport
+	rows    int
+	same    bool
+	first   [3]float64
+	radians int // rows with all angles within 2 pi
+	angles  int // rows with angles
+}
+
+func (pc *poseChecker) check(line int, toks []string) {
+	r := pc.r
+	v, ok := parseFloats(r, line, toks[1:], []string{"latitude", "longitude", "altitude", "yaw", "pitch", "roll"})
+	if !ok {
+		return
+	}
+	lat, lon, alt := v[0], v[1], v[2]
+	switch {
+	case math.Abs(lat) > 180 && math.Abs(lon) > 180:
+		r.add(line, IssueError, "latitude %s and longitude %s look like projected coordinates, should be WGS84 degrees", num(lat), num(lon))
+		return
+	case math.Abs(lat) > 90 && math.Abs(lat) <= 180 && math.Abs(lon) <= 90:
+		r.add(line, IssueError, "latitude %s is out of range, are latitude and longitude swapped?", num(lat))
+	case math.Abs(lat) > 90:
+		r.add(line, IssueError, "latitude %s is out of range [-90, 90]", num(lat))
+	case math.Abs(lon) > 180:
+		r.add(line, IssueError, "longitude %s is out of range [-180, 180]", num(lon))
+	}
+	switch {
+	case alt > 9000:
+		r.add(line, IssueWarning, "altitude %s is too high, is it in feet or millimeters instead of meters?", num(alt))
+	case alt < -500:
+		r.add(line, IssueWarning, "altitude %s is too low", num(alt))
+	}
+	if len(v) == 6 {
+		pc.angles++
+		rad := true
+		for i, a := range v[3:] {
+			if math.Abs(a) > 360 {
+				r.add(line, IssueError, "%s %v is out of range [-360, 360]", []string{"yaw", "pitch", "roll"}[i], a)
+			}
+			if math.Abs(a) > 2*math.Pi {
+				rad = false
+			}
+		}
+		if rad {
+			pc.radians++
+		}
+	}
+	pos := [3]float64{lat, lon, alt}
+	if pc.rows == 0 {
+		pc.first = pos
+		pc.same = true
+	} else if pos != pc.first {
+		pc.same = false
+	}
+	pc.rows++
+}
+
+func (pc *poseChecker) finish() {
+	if pc.rows > 1 && pc.same {
+		pc.r.add(0, IssueWarning, "all of the %d images are at the same position", pc.rows)
+	}
+	if pc.angles > 1 && pc.radians == pc.angles {
+		pc.r.add(0, IssueWarning, "all of the angles are within 2 pi, are they in radians instead of degrees?")
+	}
+}
+
+// checkCamera checks a row of camera.txt.
+func checkCamera(r *MetaReport, line int, toks []string) {
+	v, ok := parseFloats(r, line, toks[1:], []string{"width", "height", "fx", "fy", "cx", "cy", "k1", "k2", "k3", "p1", "p2"})
+	if !ok {
+		return
+	}
+	w, h := v[0], v[1]
+	if w <= 0 || h <= 0 || w != math.Trunc(w) || h != math.Trunc(h) {
+		r.add(line, IssueError, "width %v and height %v should be positive integers", w, h)
+		return
+	}
+	fx, fy, cx, cy := v[2], v[3], v[4], v[5]
+	if fx <= 0 || fy <= 0 {
+		r.add(line, IssueError, "focal length %v, %v should be positive", fx, fy)
+	} else if fx < 0.1*math.Max(w, h) || fy < 0.1*math.Max(w, h) {
+		r.add(line, IssueWarning, "focal length %v, %v is too short, is it in millimeters instead of pixels?", fx, fy)
+	}
+	if cx < 0 || cx > w || cy < 0 || cy > h {
+		r.add(line, IssueError, "principal point (%v, %v) is outside of the %vx%v image", cx, cy, w, h)
+	}
+	for i, k := range v[6:] {
+		if math.Abs(k) > 10 {
+			r.add(line, IssueWarning, "distortion %s %v is too large", []string{"k1", "k2", "k3", "p1", "p2"}[i], k)
+		}
+	}
+}
+
+// validateXMS checks if initial.xms, or the only xms of initial.xms.zip, is
+// well-formed xml.
+func validateXMS(p string, r *MetaReport) error {
+	if strings.HasSuffix(p, ".zip") {
+		zr, err := zip.OpenReader(p)
+		if err != nil {
+			r.add(0, IssueError, "not a zip: %v", err)
+			return nil
+		}
+		defer zr.Close()
+		var xms []*zip.File
+		for _, f := range zr.File {
+			if strings.EqualFold(filepath.Ext(f.Name), ".xms") {
+				xms = append(xms, f)
+			}
+		}
+		if len(xms) != 1 {
+			r.add(0, IssueError, "%d xms files are found in the zip, should be 1", len(xms))
+			return nil
+		}
+		rc, err := xms[0].Open()
+		if err != nil {
+			r.add(0, IssueError, "%s: %v", xms[0].Name, err)
+			return nil
+		}
+		defer rc.Close()
+		checkXML(rc, r)
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	checkXML(f, r)
+	return nil
+}
+
+// checkXML checks if the xml of rd is well-formed with a root element, and
+// counts its elements.
+func checkXML(rd io.Reader, r *MetaReport) {
+	dec := xml.NewDecoder(rd)
+	root := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if se, ok := err.(*xml.SyntaxError); ok {
+			r.add(se.Line, IssueError, "invalid xml: %s", se.Msg)
+			return
+		}
+		if err != nil {
+			r.add(0, IssueError, "invalid xml: %v", err)
+			return
+		}
+		if _, ok := tok.(xml.StartElement); ok {
+			root = true
+			r.Entries++
+		}
+	}
+	if !root {
+		r.add(0, IssueError, "no xml element is found")
+	}
+}
+
+// num formats v without exponent, e.g. projected coordinates.
+func num(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func containsInt(a []int, v int) bool {
+	for _, x := range a {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func joinInts(a []int, sep string) string {
+	var s []string
+	for _, x := range a {
+		s = append(s, strconv.Itoa(x))
+	}
+	return strings.Join(s, sep)
+}
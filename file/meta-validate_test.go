@@ -0,0 +1,94 @@
+package file
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateMeta(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-meta-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	images := []string{"DJI_0001.JPG", "DJI_0002.JPG", "a/DJI_0003.JPG"}
+	tests := []struct {
+		name    string
+		content string
+		errs    []string // the issues, in order
+		entries int
+	}{
+		{"group.txt", "# image group\nDJI_0001.JPG 1\nDJI_0002.JPG,1\n\na/DJI_0003.JPG\t2\n", []string{"warning: groups [\"2\"]"}, 3},
+		{"group.txt", "DJI_0001.JPG 1\ndji_0001.jpg 1\nDJI_0002.JPG\nDJI_0009.JPG 1\ndji_0003.JPG 1\n", []string{
+			`line 2: error: image "dji_0001.jpg" is already defined on line 1`,
+			"line 3: error: 1 columns, should be 2",
+			`line 4: error: image "DJI_0009.JPG" is not found`,
+			`line 5: error: image "dji_0003.JPG" is not found, but "a/DJI_0003.JPG" differs in case`,
+			"warning: 1 of 3 images are not defined",
+		}, 3},
+		{"pose.txt", "DJI_0001.JPG 22.3 114.1 120.5\nDJI_0002.JPG 114.1 22.3 120\na/DJI_0003.JPG 834000.1 2470000.2 120\n", []string{
+			"line 2: error: latitude 114.1 is out of range, are latitude and longitude swapped?",
+			"line 3: error: latitude 834000.1 and longitude 2470000.2 look like projected coordinates",
+		}, 3},
+		{"pose.txt", "DJI_0001.JPG 22.3 114.1 39370 0.1 -1.5 3.1\nDJI_0002.JPG 22.3 114.1 N/A 0.2 -1.5 3.1\na/DJI_0003.JPG 22.3 114.1 120 0.3 -1.5 3\n", []string{
+			"line 1: warning: altitude 39370 is too high, is it in feet or millimeters instead of meters?",
+			`line 2: error: altitude "N/A" is not a number`,
+			"warning: all of the angles are within 2 pi, are they in radians instead of degrees?",
+		}, 3},
+		{"camera.txt", "DJI_0001.JPG 4000 3000 3500 3500 2000 1500\nDJI_0002.JPG 4000 3000 8.8 8.8 2000 1500 0.01 0 0 0 0\na/DJI_0003.JPG 4000 3000 3500 3500 3000 3500\n", []string{
+			"line 2: warning: focal length 8.8, 8.8 is too short, is it in millimeters instead of pixels?",
+			"line 3: error: principal point (3000, 3500) is outside of the 4000x3000 image",
+		}, 3},
+		{"initial.xms", "<?xml version=\"1.0\"?>\n<block>\n<photo id=\"1\"/>\n</blok>\n", []string{"line 4: error: invalid xml: element <block> closed by </blok>"}, 2},
+		{"initial.xms", "<block><photo/></block>", nil, 2},
+	}
+	for _, tt := range tests {
+		p := filepath.Join(d, tt.name)
+		if err := ioutil.WriteFile(p, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		r, err := ValidateMeta(p, images)
+		if err != nil {
+			t.Fatalf("ValidateMeta(%q) error: %v", tt.content, err)
+		}
+		var got []string
+		for _, i := range r.Issues {
+			got = append(got, i.String())
+		}
+		if len(got) != len(tt.errs) || r.Entries != tt.entries {
+			t.Errorf("ValidateMeta(%q) = %d entries, %q, want %d entries, %q", tt.content, r.Entries, got, tt.entries, tt.errs)
+			continue
+		}
+		for i := range got {
+			if !strings.HasPrefix(got[i], tt.errs[i]) {
+				t.Errorf("ValidateMeta(%q) issue %d = %q, want %q", tt.content, i, got[i], tt.errs[i])
+			}
+		}
+		if valid := !strings.Contains(strings.Join(tt.errs, "\n"), "error:"); r.Valid() != valid {
+			t.Errorf("ValidateMeta(%q).Valid() = %v, want %v", tt.content, r.Valid(), valid)
+		}
+	}
+
+	// zip of xms
+	p := filepath.Join(d, "initial.xms.zip")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("block/initial.xms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("<block><photo/></block>"))
+	zw.Close()
+	f.Close()
+	if r, err := ValidateMeta(p, nil); err != nil || !r.Valid() || r.Entries != 2 {
+		t.Errorf("ValidateMeta(zip) = %+v, %v, want valid", r, err)
+	}
+}
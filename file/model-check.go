@@ -0,0 +1,584 @@
+package file
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackytck/alti-cli/text"
+)
+
+// ModelFilenamePattern is the pattern of the filename of a model zip accepted
+// by the server.
+var ModelFilenamePattern = regexp.MustCompile(`^[a-zA-Z0-9\._]*$`)
+
+// windowsAbsPath matches an absolute windows path, e.g. C:\tex\a.jpg or an
+// unc path.
+var windowsAbsPath = regexp.MustCompile(`^([a-zA-Z]:[\\/]|\\\\)`)
+
+// mtlMapKeys are the statements of mtl files without the map_ prefix that
+// refer to a texture.
+var mtlMapKeys = map[string]bool{"bump": true, "disp": true, "decal": true, "refl": true, "norm": true}
+
+// textureExts are the extensions of the textures supported by the server.
+var textureExts = []string{".jpg", ".jpeg", ".png"}
+
+// mtlOptionArgs are the max number of arguments of the options of the
+// texture statements of mtl files.
+var mtlOptionArgs = map[string]int{
+	"-blendu": 1, "-blendv": 1, "-boost": 1, "-cc": 1, "-clamp": 1, "-imfchan": 1,
+	"-mm": 2, "-o": 3, "-s": 3, "-t": 3, "-texres": 1, "-bm": 1, "-type": 1,
+}
+
+// ModelReport is the result of checking a model zip.
+type ModelReport struct {
+	Path      string
+	Models    []string // members of the model files
+	MTLs      []string // members of the referenced mtl files
+	Textures  []string // members of the referenced textures
+	Vertices  int
+	TexCoords int
+	Normals   int
+	Faces     int
+	Min       [3]float64 // bounding box
+	Max       [3]float64
+	Issues    // of the members, the model zip is valid if there is no error
+}
+
+// Size returns the size of the bounding box.
+func (r *ModelReport) Size() [3]float64 {
+	var s [3]float64
+	if r.Vertices > 0 {
+		for i := range s {
+			s[i] = r.Max[i] - r.Min[i]
+		}
+	}
+	return s
+}
+
+// Summary sums up the counts and the bounding box.
+func (r *ModelReport) Summary() string {
+	sz := r.Size()
+	return fmt.Sprintf("%d models, %d mtl files, %d textures, %d vertices, %d faces, size %.3f x %.3f x %.3f",
+		len(r.Models), len(r.MTLs), len(r.Textures), r.Vertices, r.Faces, sz[0], sz[1], sz[2])
+}
+
+func (r *ModelReport) add(f string, line int, level, format string, a ...interface{}) {
+	r.Issues = append(r.Issues, Issue{f, line, level, fmt.Sprintf(format, a...)})
+}
+
+// modelZip indexes the members of a model zip.
+type modelZip struct {
+	files map[string]*zip.File
+	lower map[string][]string // lower case name to names
+	r     *ModelReport
+}
+
+// CheckModelZip opens the model zip at p, parses its obj files with the
+// referenced mtl files, and its gltf, glb, ply and fbx files, and checks that
+// every referenced file is in the zip with the same case. The issues that the
+// server is known to reject are errors: a filename of other than letters,
+// digits, dots and underscores, no model, non utf-8 or backslashed names,
+// encrypted members, absolute or unresolved references, meshes without faces,
+// truncated ply and inconsistent glTF.
+func CheckModelZip(p string) (*ModelReport, error) {
+	r := &ModelReport{Path: p}
+	if name := filepath.Base(p); !ModelFilenamePattern.MatchString(name) {
+		r.add("", 0, IssueError, "filename %q should only have letters, digits, dots and underscores", name)
+	}
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	mz := modelZip{files: make(map[string]*zip.File), lower: make(map[string][]string), r: r}
+	var objs, others []string
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name := f.Name
+		switch {
+		case !utf8.ValidString(name):
+			r.add(strconv.Quote(name), 0, IssueError, "name is not utf-8, zip it again with utf-8 names")
+			continue
+		case strings.Contains(name, `\`):
+			r.add(name, 0, IssueError, "name has backslashes, zip it again with a tool using /")
+		case f.Flags&0x1 != 0:
+			r.add(name, 0, IssueError, "member is encrypted")
+		}
+		mz.files[name] = f
+		l := strings.ToLower(name)
+		mz.lower[l] = append(mz.lower[l], name)
+		switch ext := path.Ext(l); {
+		case ext == ".obj":
+			objs = append(objs, name)
+		case modelExts[ext]:
+			others = append(others, name)
+		case ext == ".zip" || ext == ".rar" || ext == ".7z":
+			r.add(name, 0, IssueWarning, "nested archive is not extracted")
+		}
+	}
+	sort.Strings(objs)
+	sort.Strings(others)
+	r.Models = append(objs, others...)
+	if len(r.Models) == 0 {
+		r.add("", 0, IssueError, "no model file is found")
+		return r, nil
+	}
+
+	r.Min = [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	r.Max = [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	mtls := make(map[string]bool)
+	materials := make(map[string]bool)
+	used := make(map[string]int) // material to the first line used
+	usedIn := make(map[string]string)
+	for _, o := range objs {
+		libs, err := mz.checkOBJ(o, used, usedIn)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range libs {
+			if mtls[m] {
+				continue
+			}
+			mtls[m] = true
+			r.MTLs = append(r.MTLs, m)
+			if err := mz.checkMTL(m, materials); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, m := range others {
+		var err error
+		switch ext := strings.ToLower(path.Ext(m)); ext {
+		case ".gltf", ".glb":
+			err = mz.checkGLTF(m, ext == ".glb")
+		case ".ply":
+			err = mz.checkPLY(m)
+		case ".fbx":
+			err = mz.checkFBX(m)
+		case ".las", ".laz", ".e57":
+			// inspected by InspectPointClouds
+		default:
+			r.add(m, 0, IssueWarning, "model is not checked, only obj, gltf, glb, ply, fbx, las, laz and e57 files are")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Vertices == 0 || math.IsInf(r.Min[0], 1) {
+		r.Min, r.Max = [3]float64{}, [3]float64{}
+	}
+	var undefined []string
+	for m := range used {
+		if !materials[m] {
+			undefined = append(undefined, m)
+		}
+	}
+	sort.Strings(undefined)
+	for _, m := range undefined {
+		r.add(usedIn[m], used[m], IssueWarning, "material %q is not defined in any mtl file", m)
+	}
+	sort.Strings(r.Textures)
+	return r, nil
+}
+
+// resolve resolves the reference ref of the member from, returning the
+// member referred, or adding an issue at line if it could not be resolved.
+func (mz modelZip) resolve(from string, line int, ref, kind string) (string, bool) {
+	r := mz.r
+	switch {
+	case windowsAbsPath.MatchString(ref):
+		r.add(from, line, IssueError, "%s %q is an absolute windows path, should be relative%s", kind, ref, mz.suggest(from, ref))
+		return "", false
+	case strings.HasPrefix(ref, "/"):
+		r.add(from, line, IssueError, "%s %q is an absolute path, should be relative%s", kind, ref, mz.suggest(from, ref))
+		return "", false
+	case strings.Contains(ref, `\`):
+		r.add(from, line, IssueError, "%s %q has backslashes, should be /", kind, ref)
+		return "", false
+	}
+	p := path.Join(path.Dir(from), ref)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		r.add(from, line, IssueError, "%s %q is outside of the zip", kind, ref)
+		return "", false
+	}
+	if _, ok := mz.files[p]; ok {
+		return p, true
+	}
+	if cands := mz.lower[strings.ToLower(p)]; len(cands) > 0 {
+		r.add(from, line, IssueError, "%s %q is not found, but %q differs in case", kind, ref, cands[0])
+		return "", false
+	}
+	r.add(from, line, IssueError, "%s %q is not found", kind, ref)
+	return "", false
+}
+
+// suggest suggests the relative path of the member of the same filename as
+// the absolute ref.
+func (mz modelZip) suggest(from, ref string) string {
+	base := strings.ToLower(path.Base(strings.Replace(ref, `\`, "/", -1)))
+	for l, names := range mz.lower {
+		if path.Base(l) == base {
+			rel, err := filepath.Rel(path.Dir(from), names[0])
+			if err == nil {
+				return fmt.Sprintf(", e.g. %q", filepath.ToSlash(rel))
+			}
+		}
+	}
+	return ""
+}
+
+// lines calls fn with the line number and the fields of each non-empty and
+// non-comment line of the member.
+func (mz modelZip) lines(name string, fn func(line int, toks []string, rest string)) error {
+	rc, err := mz.files[name].Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	br := bufio.NewReaderSize(rc, 64*1024)
+	for line := 1; ; line++ {
+		l, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if s := strings.TrimSpace(l); s != "" && s[0] != '#' {
+			for strings.HasSuffix(s, `\`) && err == nil {
+				// continued line
+				var next string
+				next, err = br.ReadString('\n')
+				s = strings.TrimSpace(strings.TrimSuffix(s, `\`) + " " + next)
+				line++
+			}
+			toks := strings.Fields(s)
+			fn(line, toks, strings.TrimSpace(strings.TrimPrefix(s, toks[0])))
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// checkOBJ parses the obj member, counting its geometry and checking its
+// references. It returns the referenced mtl members.
+func (mz modelZip) checkOBJ(name string, used map[string]int, usedIn map[string]string) ([]string, error) {
+	r := mz.r
+	var libs []string
+	var nv, nvt, nvn, faces int
+	maxRef := [3]int{}
+	maxLine := [3]int{}
+	badFaces := 0
+	err := mz.lines(name, func(line int, toks []string, rest string) {
+		switch toks[0] {
+		case "v":
+			if len(toks) < 4 {
+				r.add(name, line, IssueError, "vertex should have 3 coordinates")
+				return
+			}
+			nv++
+			for i := 0; i < 3; i++ {
+				c, err := strconv.ParseFloat(toks[i+1], 64)
+				if err != nil || math.IsNaN(c) || math.IsInf(c, 0) {
+					r.add(name, line, IssueError, "vertex coordinate %q is not a number", toks[i+1])
+					return
+				}
+				r.Min[i] = math.Min(r.Min[i], c)
+				r.Max[i] = math.Max(r.Max[i], c)
+			}
+		case "vt":
+			nvt++
+		case "vn":
+			nvn++
+		case "f":
+			if len(toks) < 4 {
+				if badFaces == 0 {
+					r.add(name, line, IssueError, "face should have at least 3 vertices")
+				}
+				badFaces++
+				return
+			}
+			faces++
+			counts := [3]int{nv, nvt, nvn}
+			for _, t := range toks[1:] {
+				for i, s := range strings.SplitN(t, "/", 3) {
+					if s == "" {
+						continue
+					}
+					idx, err := strconv.Atoi(s)
+					if err != nil || idx == 0 {
+						r.add(name, line, IssueError, "face vertex %q is invalid", t)
+						return
+					}
+					if idx < 0 {
+						// relative to the current count
+						if -idx > counts[i] {
+							r.add(name, line, IssueError, "face vertex %q refers to an undefined vertex", t)
+							return
+						}
+						continue
+					}
+					if idx > maxRef[i] {
+						maxRef[i] = idx
+						maxLine[i] = line
+					}
+				}
+			}
+		case "mtllib":
+			// a filename may have spaces, or it is a list of filenames
+			refs := []string{rest}
+			if _, ok := mz.files[path.Join(path.Dir(name), rest)]; !ok {
+				refs = toks[1:]
+			}
+			for _, ref := range refs {
+				if m, ok := mz.resolve(name, line, ref, "mtl"); ok {
+					libs = append(libs, m)
+				}
+			}
+		case "usemtl":
+			if _, ok := used[rest]; !ok {
+				used[rest] = line
+				usedIn[rest] = name
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if badFaces > 1 {
+		r.add(name, 0, IssueError, "%d faces have less than 3 vertices", badFaces)
+	}
+	for i, n := range [3]int{nv, nvt, nvn} {
+		if maxRef[i] > n {
+			r.add(name, maxLine[i], IssueError, "face refers to %s %d, but only %d are defined", []string{"vertex", "texture coordinate", "normal"}[i], maxRef[i], n)
+		}
+	}
+	if faces == 0 {
+		r.add(name, 0, IssueError, "no face is found, point clouds should be imported as PTCLOUD")
+	}
+	if nvt > 0 && len(libs) == 0 {
+		r.add(name, 0, IssueWarning, "texture coordinates are defined without any mtllib")
+	}
+	r.Vertices += nv
+	r.TexCoords += nvt
+	r.Normals += nvn
+	r.Faces += faces
+	return libs, nil
+}
+
+// checkMTL parses the mtl member, adding the defined materials and the
+// referenced textures.
+func (mz modelZip) checkMTL(name string, materials map[string]bool) error {
+	r := mz.r
+	return mz.lines(name, func(line int, toks []string, rest string) {
+		key := strings.ToLower(toks[0])
+		switch {
+		case key == "newmtl":
+			materials[rest] = true
+		case strings.HasPrefix(key, "map_") || mtlMapKeys[key]:
+			ref := mtlTexture(toks[1:])
+			if ref == "" {
+				r.add(name, line, IssueError, "%s has no texture", toks[0])
+				return
+			}
+			if t, ok := mz.resolve(name, line, ref, "texture"); ok {
+				mz.texture(name, line, ref, t)
+			}
+		}
+	})
+}
+
+// texture adds the texture member t referred by ref at the line of the
+// member from, if it is not added yet.
+func (mz modelZip) texture(from string, line int, ref, t string) {
+	r := mz.r
+	if _, ok := text.Contains(r.Textures, t); ok {
+		return
+	}
+	r.Textures = append(r.Textures, t)
+	if _, ok := text.Contains(textureExts, strings.ToLower(path.Ext(t))); !ok {
+		r.add(from, line, IssueWarning, "texture %q may not be supported, should be jpg or png", ref)
+	}
+}
+
+// bound extends the bounding box of the report by min and max.
+func (r *ModelReport) bound(min, max [3]float64) {
+	for i := range min {
+		r.Min[i] = math.Min(r.Min[i], min[i])
+		r.Max[i] = math.Max(r.Max[i], max[i])
+	}
+}
+
+// checkGLTF parses the glTF or glb member, checking the references between
+// its parts and to its buffers and images, and counting its triangles placed
+// by the nodes of its scene.
+func (mz modelZip) checkGLTF(name string, glb bool) error {
+	r := mz.r
+	rc, err := mz.files[name].Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	doc, _, binLen, err := readGLTF(rc, glb, false)
+	if err != nil {
+		r.add(name, 0, IssueError, "%v", err)
+		return nil
+	}
+	for _, e := range doc.unsupported() {
+		r.add(name, 0, IssueWarning, "required extension %q is not checked and may not be supported", e)
+	}
+
+	lens := make([]int, len(doc.Buffers))
+	for i, b := range doc.Buffers {
+		lens[i] = -1
+		switch {
+		case b.URI == "" && glb && i == 0:
+			if binLen < 0 {
+				r.add(name, 0, IssueError, "buffer 0 refers to the binary chunk, but it is missing")
+			}
+			lens[i] = binLen
+		case b.URI == "":
+			r.add(name, 0, IssueError, "buffer %d has no uri", i)
+		case isDataURI(b.URI):
+			data, err := decodeDataURI(b.URI)
+			if err != nil {
+				r.add(name, 0, IssueError, "buffer %d: %v", i, err)
+				continue
+			}
+			lens[i] = len(data)
+		default:
+			if m, ok := mz.resolve(name, 0, uriPath(b.URI), "buffer"); ok {
+				lens[i] = int(mz.files[m].UncompressedSize64)
+			}
+		}
+	}
+	for _, img := range doc.Images {
+		if img.URI == "" || isDataURI(img.URI) {
+			continue
+		}
+		ref := uriPath(img.URI)
+		if t, ok := mz.resolve(name, 0, ref, "image"); ok {
+			mz.texture(name, 0, ref, t)
+		}
+	}
+	errs := doc.check(lens)
+	for _, e := range errs {
+		r.add(name, 0, IssueError, "%s", e)
+	}
+	if len(errs) > 0 {
+		return nil
+	}
+
+	insts := doc.instances()
+	nv, nf := doc.counts(insts)
+	r.Vertices += nv
+	r.Faces += nf
+	if min, max, ok := doc.bounds(insts); ok {
+		r.bound(min, max)
+	} else if nv > 0 {
+		r.add(name, 0, IssueWarning, "positions have no min and max, the bounding box is unknown")
+	}
+	if nf == 0 {
+		r.add(name, 0, IssueError, "no triangle is found in the default scene, point clouds should be imported as PTCLOUD")
+	}
+	return nil
+}
+
+// checkPLY parses the ply member, checking its header, its texture and that
+// its data has as many rows as declared by its header.
+func (mz modelZip) checkPLY(name string) error {
+	r := mz.r
+	f := mz.files[name]
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	br := bufio.NewReaderSize(rc, 64*1024)
+	h, err := readPLYHeader(br)
+	if err != nil {
+		r.add(name, 0, IssueError, "%v", err)
+		return nil
+	}
+	for _, ref := range h.textures() {
+		if t, ok := mz.resolve(name, 0, ref, "texture"); ok {
+			mz.texture(name, 0, ref, t)
+		}
+	}
+
+	s := readPLYStats(h, br)
+	switch ds := h.dataSize(); {
+	case s.Err != nil:
+		r.add(name, 0, IssueError, "file is truncated or corrupted, %v", s.Err)
+	case ds >= 0 && int64(f.UncompressedSize64) > h.Size+ds:
+		r.add(name, 0, IssueWarning, "%d bytes after the data are ignored", int64(f.UncompressedSize64)-h.Size-ds)
+	}
+	r.Vertices += int(s.Vertices)
+	r.Faces += int(s.Faces)
+	if s.Vertices > 0 {
+		r.bound(s.Min, s.Max)
+	}
+	if s.Faces == 0 && s.Err == nil {
+		r.add(name, 0, IssueWarning, "no face is found, point clouds should be imported as PTCLOUD")
+	}
+	return nil
+}
+
+// checkFBX scans the fbx member for its version and its textures. A missing
+// texture is a warning, as it may be embedded.
+func (mz modelZip) checkFBX(name string) error {
+	r := mz.r
+	rc, err := mz.files[name].Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	info, err := readFBX(rc)
+	if err != nil {
+		r.add(name, 0, IssueError, "%v", err)
+		return nil
+	}
+	if info.Version < 7100 {
+		r.add(name, 0, IssueWarning, "fbx version %d is older than 7.1 and may not be supported", info.Version)
+	}
+	for _, ref := range info.Refs {
+		t := path.Join(path.Dir(name), ref)
+		if _, ok := localRef(".", ref); ok {
+			if _, ok := mz.files[t]; ok {
+				mz.texture(name, 0, ref, t)
+				continue
+			}
+		}
+		r.add(name, 0, IssueWarning, "texture %q is not found, unless it is embedded", ref)
+	}
+	return nil
+}
+
+// mtlTexture returns the filename of the arguments of a texture statement
+// after the options.
+func mtlTexture(args []string) string {
+	for i := 0; i < len(args); i++ {
+		n, ok := mtlOptionArgs[args[i]]
+		if !ok {
+			return strings.Join(args[i:], " ")
+		}
+		for ; n > 0 && i+1 < len(args)-1; n-- {
+			if _, err := strconv.ParseFloat(args[i+1], 64); err != nil && args[i+1] != "on" && args[i+1] != "off" && len(args[i+1]) != 1 {
+				break
+			}
+			i++
+		}
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+package file
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeZip writes the members to a zip at p.
+func writeZip(t *testing.T, p string, members map[string]string) {
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range members {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckModelZip(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-model-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	obj := `# cube
+mtllib house.mtl
+v 0 0 0
+v 2 0 0
+v 2 3 0
+v 0 3 1.5
+vt 0 0
+vt 1 1
+usemtl wall
+f 1/1 2/2 3/1
+f -4/1 -2/2 -1/1
+`
+	mtl := `newmtl wall
+map_Kd -s 1 1 1 tex/Wall.jpg
+bump -bm 0.5 tex/bump.png
+`
+	p := filepath.Join(d, "house.zip")
+	writeZip(t, p, map[string]string{
+		"house/house.obj":      obj,
+		"house/house.mtl":      mtl,
+		"house/tex/Wall.jpg":   "jpg",
+		"house/tex/bump.png":   "png",
+		"house/tex/unused.jpg": "jpg",
+	})
+	r, err := CheckModelZip(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Valid() || len(r.Issues) != 0 {
+		t.Errorf("CheckModelZip() issues = %v, want none", r.Issues)
+	}
+	if r.Vertices != 4 || r.TexCoords != 2 || r.Faces != 2 || r.Max != [3]float64{2, 3, 1.5} || r.Min != [3]float64{} {
+		t.Errorf("CheckModelZip() = %+v", r)
+	}
+	if want := []string{"house/tex/Wall.jpg", "house/tex/bump.png"}; !reflect.DeepEqual(r.Textures, want) {
+		t.Errorf("CheckModelZip() textures = %q, want %q", r.Textures, want)
+	}
+
+	p = filepath.Join(d, "bad-house.zip")
+	writeZip(t, p, map[string]string{
+		"house.obj":    strings.Replace(obj, "mtllib house.mtl", "mtllib house.mtl\nmtllib missing.mtl", 1) + "f 1 2 9\nf 1 2\n",
+		"house.mtl":    "newmtl roof\nmap_Kd tex/wall.jpg\nmap_Ka C:\\Users\\me\\tex\\bump.png\nmap_Ks ../roof.tga\n",
+		"tex/Wall.jpg": "jpg",
+		"tex/bump.png": "png",
+	})
+	r, err = CheckModelZip(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, i := range r.Issues {
+		got = append(got, i.String())
+	}
+	want := []string{
+		`error: filename "bad-house.zip" should only have letters, digits, dots and underscores`,
+		`house.obj:3: error: mtl "missing.mtl" is not found`,
+		`house.obj:14: error: face should have at least 3 vertices`,
+		`house.obj:13: error: face refers to vertex 9, but only 4 are defined`,
+		`house.mtl:2: error: texture "tex/wall.jpg" is not found, but "tex/Wall.jpg" differs in case`,
+		`house.mtl:3: error: texture "C:\\Users\\me\\tex\\bump.png" is an absolute windows path, should be relative, e.g. "tex/bump.png"`,
+		`house.mtl:4: error: texture "../roof.tga" is outside of the zip`,
+		`house.obj:10: warning: material "wall" is not defined in any mtl file`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CheckModelZip() issues =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	p = filepath.Join(d, "points.zip")
+	writeZip(t, p, map[string]string{"points.obj": "v 0 0 0\nv 1 1 1\n"})
+	if r, err := CheckModelZip(p); err != nil || r.Valid() || r.Vertices != 2 {
+		t.Errorf("CheckModelZip(points) = %+v, %v, want invalid", r, err)
+	}
+}
+
+func TestMTLTexture(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"a.jpg", "a.jpg"},
+		{"my texture.jpg", "my texture.jpg"},
+		{"-s 1 1 1 -o 0 0 a.jpg", "a.jpg"},
+		{"-s 2 a.jpg", "a.jpg"},
+		{"-clamp on -imfchan r 1.png", "1.png"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := mtlTexture(strings.Fields(tt.args)); got != tt.want {
+			t.Errorf("mtlTexture(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Formats of model files.
+const (
+	ModelOBJ  = "obj"
+	ModelGLTF = "gltf"
+	ModelGLB  = "glb"
+	ModelPLY  = "ply"
+	ModelFBX  = "fbx"
+	ModelLAS  = "las"
+	ModelLAZ  = "laz"
+	ModelE57  = "e57"
+)
+
+// fbxBinaryMagic is the head of a binary fbx.
+const fbxBinaryMagic = "Kaydara FBX Binary  \x00"
+
+// DetectModelFormat detects the format of the model file at p by its head,
+// or by its extension for obj. It returns an empty string if p is not a
+// model file of any of the formats, e.g. a zip.
+func DetectModelFormat(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return modelFormat(filepath.Ext(p), head[:n]), nil
+}
+
+// modelFormat tells the format of a model file by its extension and head.
+func modelFormat(ext string, head []byte) string {
+	ext = strings.ToLower(ext)
+	switch {
+	case bytes.HasPrefix(head, []byte("glTF")):
+		return ModelGLB
+	case bytes.HasPrefix(head, []byte("ply\n")), bytes.HasPrefix(head, []byte("ply\r\n")):
+		return ModelPLY
+	case bytes.HasPrefix(head, []byte(fbxBinaryMagic)):
+		return ModelFBX
+	case bytes.HasPrefix(head, []byte("LASF")):
+		// laszip sets the high bits of the point format
+		if ext == ".laz" || (len(head) > 104 && head[104]&0x80 != 0) {
+			return ModelLAZ
+		}
+		return ModelLAS
+	case bytes.HasPrefix(head, []byte("ASTM-E57")):
+		return ModelE57
+	case ext == ".fbx" && bytes.HasPrefix(bytes.TrimSpace(head), []byte(";")):
+		// ascii fbx starts with a comment
+		return ModelFBX
+	case ext == ".gltf" && bytes.HasPrefix(bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))), []byte("{")):
+		return ModelGLTF
+	case ext == ".obj":
+		return ModelOBJ
+	}
+	return ""
+}
+
+// IsModelFile tells if p is a model file of any of the formats, rather than
+// a zip of model files.
+func IsModelFile(p string) bool {
+	fi, err := os.Stat(p)
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	format, err := DetectModelFormat(p)
+	return err == nil && format != ""
+}
@@ -0,0 +1,634 @@
+package file
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PackageModel packages the model file at p with the files it refers to, e.g.
+// the buffers and images of a glTF or the mtl files of an obj, into a zip in
+// dir, which is the layout accepted by the server. If toOBJ is true, glTF,
+// glb and ply meshes are converted to obj with a mtl and the textures. The
+// missing references are left out for CheckModelZip to report. It returns the
+// path of the zip, which is named after the model to match
+// ModelFilenamePattern.
+func PackageModel(p, dir string, toOBJ bool) (string, error) {
+	format, err := DetectModelFormat(p)
+	if err != nil {
+		return "", err
+	}
+	if format == "" {
+		return "", fmt.Errorf("%q is not an obj, gltf, glb, ply, fbx, las, laz or e57", p)
+	}
+	base := modelBaseName(p)
+	zp := filepath.Join(dir, base+".zip")
+	f, err := os.Create(zp)
+	if err != nil {
+		return "", err
+	}
+	zw := zip.NewWriter(f)
+	err = packageModel(zw, p, format, base, toOBJ)
+	if e := zw.Close(); err == nil {
+		err = e
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(zp)
+		return "", err
+	}
+	return zp, nil
+}
+
+// modelBaseName returns the filename of the model without its extension, with
+// the characters not allowed by ModelFilenamePattern replaced by underscores.
+func modelBaseName(p string) string {
+	name := filepath.Base(p)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.Map(func(r rune) rune {
+		if r < 128 && ModelFilenamePattern.MatchString(string(r)) {
+			return r
+		}
+		return '_'
+	}, name)
+	if name == "" {
+		return "model"
+	}
+	return name
+}
+
+func packageModel(zw *zip.Writer, p, format, base string, toOBJ bool) error {
+	src := filepath.Dir(p)
+	load := func(ref string) ([]byte, error) {
+		fp, ok := localRef(src, ref)
+		if !ok {
+			return nil, fmt.Errorf("%q is outside of %q", ref, src)
+		}
+		return ioutil.ReadFile(fp)
+	}
+	if toOBJ {
+		switch format {
+		case ModelGLTF, ModelGLB:
+			return gltfToOBJ(zw, p, format == ModelGLB, base, load)
+		case ModelPLY:
+			// point clouds are packaged as they are
+			if ok, err := plyToOBJ(zw, p, base, load); ok || err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := zipFile(zw, p, filepath.Base(p)); err != nil {
+		return err
+	}
+	refs, err := modelRefs(p, format)
+	if err != nil {
+		return err
+	}
+	added := map[string]bool{filepath.Base(p): true}
+	for _, ref := range refs {
+		fp, ok := localRef(src, ref)
+		name := path.Clean(ref)
+		if !ok || added[name] {
+			continue
+		}
+		if fi, err := os.Stat(fp); err != nil || fi.IsDir() {
+			continue
+		}
+		added[name] = true
+		if err := zipFile(zw, fp, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localRef returns the local path of the relative reference of a model in
+// dir, or false if it is absolute or outside of dir.
+func localRef(dir, ref string) (string, bool) {
+	if windowsAbsPath.MatchString(ref) || strings.HasPrefix(ref, "/") {
+		return "", false
+	}
+	p := path.Clean(ref)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", false
+	}
+	return filepath.Join(dir, filepath.FromSlash(p)), true
+}
+
+// zipFile writes the file at p to the zip as name.
+func zipFile(zw *zip.Writer, p, name string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fh, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	fh.Name = name
+	fh.Method = zip.Deflate
+	w, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// modelRefs returns the slash separated relative references of the model
+// file at p of format: the buffers and images of a glTF, the textures of a
+// ply or a fbx, or the mtl files and their textures of an obj. Point clouds
+// of las, laz and e57 refer to nothing.
+func modelRefs(p, format string) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch format {
+	case ModelGLTF, ModelGLB:
+		doc, _, _, err := readGLTF(f, format == ModelGLB, false)
+		if err != nil {
+			return nil, err
+		}
+		return doc.refs(), nil
+	case ModelPLY:
+		h, err := readPLYHeader(bufio.NewReader(f))
+		if err != nil {
+			return nil, err
+		}
+		return h.textures(), nil
+	case ModelFBX:
+		info, err := readFBX(f)
+		if err != nil {
+			return nil, err
+		}
+		return info.Refs, nil
+	case ModelLAS, ModelLAZ, ModelE57:
+		return nil, nil
+	}
+
+	// obj with its mtl files and their textures
+	var refs []string
+	dir := filepath.Dir(p)
+	err = scanLines(f, func(toks []string, rest string) {
+		if toks[0] != "mtllib" {
+			return
+		}
+		libs := []string{rest}
+		if fp, ok := localRef(dir, rest); !ok || !isFile(fp) {
+			libs = toks[1:]
+		}
+		for _, lib := range libs {
+			refs = append(refs, lib)
+			fp, ok := localRef(dir, lib)
+			if !ok {
+				continue
+			}
+			mf, err := os.Open(fp)
+			if err != nil {
+				continue
+			}
+			scanLines(mf, func(toks []string, rest string) {
+				key := strings.ToLower(toks[0])
+				if strings.HasPrefix(key, "map_") || mtlMapKeys[key] {
+					if t := mtlTexture(toks[1:]); t != "" {
+						refs = append(refs, path.Join(path.Dir(lib), t))
+					}
+				}
+			})
+			mf.Close()
+		}
+	})
+	return refs, err
+}
+
+// isFile tells if p is a regular file.
+func isFile(p string) bool {
+	fi, err := os.Stat(p)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// scanLines calls fn with the fields of each non-empty and non-comment line
+// of r.
+func scanLines(r io.Reader, fn func(toks []string, rest string)) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || s[0] == '#' {
+			continue
+		}
+		toks := strings.Fields(s)
+		fn(toks, strings.TrimSpace(strings.TrimPrefix(s, toks[0])))
+	}
+	return sc.Err()
+}
+
+// objFloat formats a coordinate of obj.
+func objFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', 8, 64)
+}
+
+// objFace formats a face of obj with the 1-based indices of the vertices, and
+// of the texture coordinates and the normals if they are positive.
+func objFace(v, vt, vn []int) string {
+	var b strings.Builder
+	b.WriteString("f")
+	for i := range v {
+		switch {
+		case vt[i] > 0 && vn[i] > 0:
+			fmt.Fprintf(&b, " %d/%d/%d", v[i], vt[i], vn[i])
+		case vt[i] > 0:
+			fmt.Fprintf(&b, " %d/%d", v[i], vt[i])
+		case vn[i] > 0:
+			fmt.Fprintf(&b, " %d//%d", v[i], vn[i])
+		default:
+			fmt.Fprintf(&b, " %d", v[i])
+		}
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// triangles returns the triangles of the indices of a primitive of mode
+// triangles, triangle strip or triangle fan.
+func triangles(mode int, idx []int) [][3]int {
+	var ret [][3]int
+	switch mode {
+	case 4:
+		for i := 0; i+2 < len(idx); i += 3 {
+			ret = append(ret, [3]int{idx[i], idx[i+1], idx[i+2]})
+		}
+	case 5:
+		for i := 0; i+2 < len(idx); i++ {
+			if i%2 == 0 {
+				ret = append(ret, [3]int{idx[i], idx[i+1], idx[i+2]})
+			} else {
+				ret = append(ret, [3]int{idx[i+1], idx[i], idx[i+2]})
+			}
+		}
+	case 6:
+		for i := 1; i+1 < len(idx); i++ {
+			ret = append(ret, [3]int{idx[0], idx[i], idx[i+1]})
+		}
+	}
+	return ret
+}
+
+// gltfToOBJ converts the glTF or glb at p to an obj, a mtl and the base color
+// textures in the zip. The meshes are placed by their nodes, and only the
+// triangle primitives are converted.
+func gltfToOBJ(zw *zip.Writer, p string, glb bool, base string, load func(string) ([]byte, error)) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	doc, bin, _, err := readGLTF(f, glb, true)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if ext := doc.unsupported(); len(ext) > 0 {
+		return fmt.Errorf("required extensions %q are not supported for converting", ext)
+	}
+	d, err := loadGLTF(doc, bin, load)
+	if err != nil {
+		return err
+	}
+	var lens []int
+	for _, b := range d.buffers {
+		lens = append(lens, len(b))
+	}
+	if errs := doc.check(lens); len(errs) > 0 {
+		return fmt.Errorf("invalid gltf: %s", strings.Join(errs, ", "))
+	}
+
+	w, err := zw.Create(base + ".obj")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# converted from %s\nmtllib %s.mtl\n", filepath.Base(p), base)
+	used := make(map[int]bool)
+	var nv, nvt, nvn int
+	for _, in := range doc.instances() {
+		nm := in.matrix.normalMatrix()
+		for pi, prim := range doc.Meshes[in.mesh].Primitives {
+			mode := prim.mode()
+			if mode < 4 {
+				// points and lines
+				continue
+			}
+			pos, err := d.floats(prim.Attributes["POSITION"])
+			if err != nil {
+				return err
+			}
+			var uvs, nrms [][]float64
+			if i, ok := prim.Attributes["TEXCOORD_0"]; ok && i >= 0 && i < len(doc.Accessors) {
+				if uvs, err = d.floats(i); err != nil {
+					return err
+				}
+			}
+			if i, ok := prim.Attributes["NORMAL"]; ok && i >= 0 && i < len(doc.Accessors) {
+				if nrms, err = d.floats(i); err != nil {
+					return err
+				}
+			}
+			if len(uvs) != len(pos) {
+				uvs = nil
+			}
+			if len(nrms) != len(pos) {
+				nrms = nil
+			}
+			idx, err := d.indices(prim)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(bw, "g mesh%d_%d\n", in.mesh, pi)
+			for _, v := range pos {
+				c := in.matrix.apply([3]float64{v[0], v[1], v[2]}, 1)
+				fmt.Fprintf(bw, "v %s %s %s\n", objFloat(c[0]), objFloat(c[1]), objFloat(c[2]))
+			}
+			for _, uv := range uvs {
+				// glTF has its origin at the top left
+				fmt.Fprintf(bw, "vt %s %s\n", objFloat(uv[0]), objFloat(1-uv[1]))
+			}
+			for _, n := range nrms {
+				c := nm.apply([3]float64{n[0], n[1], n[2]}, 0)
+				l := math.Sqrt(c[0]*c[0] + c[1]*c[1] + c[2]*c[2])
+				if l > 0 {
+					c = [3]float64{c[0] / l, c[1] / l, c[2] / l}
+				}
+				fmt.Fprintf(bw, "vn %s %s %s\n", objFloat(c[0]), objFloat(c[1]), objFloat(c[2]))
+			}
+			if m := prim.Material; m != nil && *m >= 0 && *m < len(doc.Materials) {
+				used[*m] = true
+				fmt.Fprintf(bw, "usemtl %s\n", gltfMaterialName(doc, *m))
+			}
+			v, vt, vn := make([]int, 3), make([]int, 3), make([]int, 3)
+			for _, t := range triangles(mode, idx) {
+				for k, i := range t {
+					if i < 0 || i >= len(pos) {
+						return fmt.Errorf("primitive %d of mesh %d refers to vertex %d, but only %d are defined", pi, in.mesh, i, len(pos))
+					}
+					v[k], vt[k], vn[k] = nv+i+1, 0, 0
+					if uvs != nil {
+						vt[k] = nvt + i + 1
+					}
+					if nrms != nil {
+						vn[k] = nvn + i + 1
+					}
+				}
+				bw.WriteString(objFace(v, vt, vn))
+			}
+			nv += len(pos)
+			nvt += len(uvs)
+			nvn += len(nrms)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return gltfToMTL(zw, d, base, used, load)
+}
+
+// gltfMaterialName returns the name of the material in mtl.
+func gltfMaterialName(doc *gltf, i int) string {
+	name := fmt.Sprintf("material%d", i)
+	if n := doc.Materials[i].Name; n != "" && !strings.ContainsAny(n, " \t") {
+		name += "_" + n
+	}
+	return name
+}
+
+// gltfToMTL writes the mtl of the used materials by their base colors, with
+// the base color textures extracted beside.
+func gltfToMTL(zw *zip.Writer, d *gltfData, base string, used map[int]bool, load func(string) ([]byte, error)) error {
+	var mats []int
+	for m := range used {
+		mats = append(mats, m)
+	}
+	sort.Ints(mats)
+
+	// the images are written before the mtl, as a zip is written sequentially
+	var mtl bytes.Buffer
+	images := make(map[int]string)
+	for _, m := range mats {
+		kd := []float64{1, 1, 1, 1}
+		var tex string
+		if pbr := d.Materials[m].PbrMetallicRoughness; pbr != nil {
+			if len(pbr.BaseColorFactor) == 4 {
+				kd = pbr.BaseColorFactor
+			}
+			if t := pbr.BaseColorTexture; t != nil && t.Index >= 0 && t.Index < len(d.Textures) && d.Textures[t.Index].Source != nil {
+				img := *d.Textures[t.Index].Source
+				name, ok := images[img]
+				if !ok {
+					data, ext, err := d.image(img, load)
+					if err != nil {
+						return fmt.Errorf("image %d: %v", img, err)
+					}
+					name = fmt.Sprintf("textures/%s_%d%s", base, img, ext)
+					iw, err := zw.Create(name)
+					if err != nil {
+						return err
+					}
+					if _, err := iw.Write(data); err != nil {
+						return err
+					}
+					images[img] = name
+				}
+				tex = name
+			}
+		}
+		fmt.Fprintf(&mtl, "newmtl %s\nKd %s %s %s\nd %s\n", gltfMaterialName(d.gltf, m), objFloat(kd[0]), objFloat(kd[1]), objFloat(kd[2]), objFloat(kd[3]))
+		if tex != "" {
+			fmt.Fprintf(&mtl, "map_Kd %s\n", tex)
+		}
+	}
+	w, err := zw.Create(base + ".mtl")
+	if err != nil {
+		return err
+	}
+	_, err = mtl.WriteTo(w)
+	return err
+}
+
+// plyToOBJ converts the ply mesh at p to an obj in the zip, with the vertex
+// colors, the normals and the texture coordinates, and a mtl of its texture
+// if any. It returns false without writing anything if the ply has no face,
+// i.e. it is a point cloud.
+func plyToOBJ(zw *zip.Writer, p, base string, load func(string) ([]byte, error)) (bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	br := bufio.NewReaderSize(f, 64*1024)
+	h, err := readPLYHeader(br)
+	if err != nil {
+		return false, err
+	}
+	if fe := h.element("face"); fe == nil || fe.Count == 0 {
+		return false, nil
+	}
+	for _, e := range h.Elements {
+		if e.Name == "face" {
+			return false, fmt.Errorf("ply faces before vertices are not supported for converting")
+		}
+		if e.Name == "vertex" {
+			break
+		}
+	}
+
+	w, err := zw.Create(base + ".obj")
+	if err != nil {
+		return true, err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# converted from %s\n", filepath.Base(p))
+	textures := h.textures()
+	if len(textures) > 0 {
+		fmt.Fprintf(bw, "mtllib %s.mtl\n", base)
+	}
+	pr := newPLYReader(h, br)
+	var nv, nvt int
+	var hasUV, hasNormal bool
+	for _, e := range h.Elements {
+		vals := newRow(e)
+		switch e.Name {
+		case "vertex":
+			xyz := []int{e.prop("x"), e.prop("y"), e.prop("z")}
+			rgb := []int{e.prop("red", "r", "diffuse_red"), e.prop("green", "g", "diffuse_green"), e.prop("blue", "b", "diffuse_blue")}
+			nrm := []int{e.prop("nx"), e.prop("ny"), e.prop("nz")}
+			uv := []int{e.prop("u", "s", "texture_u"), e.prop("v", "t", "texture_v")}
+			hasColor := rgb[0] >= 0 && rgb[1] >= 0 && rgb[2] >= 0
+			hasNormal = nrm[0] >= 0 && nrm[1] >= 0 && nrm[2] >= 0
+			hasUV = uv[0] >= 0 && uv[1] >= 0
+			scale := 1.0
+			if hasColor && plySizes[e.Props[rgb[0]].Type] == 1 {
+				scale = 255
+			}
+			for i := int64(0); i < e.Count; i++ {
+				if err := pr.row(e, vals); err != nil {
+					return true, fmt.Errorf("row %d of vertex: %v", i+1, err)
+				}
+				bw.WriteString("v")
+				for _, j := range xyz {
+					bw.WriteString(" " + objFloat(vals[j][0]))
+				}
+				if hasColor {
+					for _, j := range rgb {
+						bw.WriteString(" " + objFloat(vals[j][0]/scale))
+					}
+				}
+				bw.WriteString("\n")
+				if hasNormal {
+					fmt.Fprintf(bw, "vn %s %s %s\n", objFloat(vals[nrm[0]][0]), objFloat(vals[nrm[1]][0]), objFloat(vals[nrm[2]][0]))
+				}
+				if hasUV {
+					fmt.Fprintf(bw, "vt %s %s\n", objFloat(vals[uv[0]][0]), objFloat(vals[uv[1]][0]))
+				}
+			}
+			nv = int(e.Count)
+			if hasUV {
+				nvt = nv
+			}
+		case "face":
+			vi := e.prop("vertex_indices", "vertex_index")
+			if vi < 0 {
+				return true, fmt.Errorf("ply face has no vertex_indices")
+			}
+			ti := e.prop("texcoord")
+			if len(textures) > 0 {
+				bw.WriteString("usemtl textured\n")
+			}
+			for i := int64(0); i < e.Count; i++ {
+				if err := pr.row(e, vals); err != nil {
+					return true, fmt.Errorf("row %d of face: %v", i+1, err)
+				}
+				n := len(vals[vi])
+				if n < 3 {
+					continue
+				}
+				v, vt, vn := make([]int, n), make([]int, n), make([]int, n)
+				// per face texture coordinates
+				perFace := ti >= 0 && len(vals[ti]) == 2*n
+				for k, x := range vals[vi] {
+					j := int(x)
+					if j < 0 || j >= nv {
+						return true, fmt.Errorf("row %d of face refers to vertex %d, but only %d are defined", i+1, j, nv)
+					}
+					v[k] = j + 1
+					switch {
+					case perFace:
+						fmt.Fprintf(bw, "vt %s %s\n", objFloat(vals[ti][2*k]), objFloat(vals[ti][2*k+1]))
+						nvt++
+						vt[k] = nvt
+					case hasUV:
+						vt[k] = j + 1
+					}
+					if hasNormal {
+						vn[k] = j + 1
+					}
+				}
+				bw.WriteString(objFace(v, vt, vn))
+			}
+		default:
+			for i := int64(0); i < e.Count; i++ {
+				if err := pr.row(e, vals); err != nil {
+					return true, fmt.Errorf("row %d of %s: %v", i+1, e.Name, err)
+				}
+			}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return true, err
+	}
+	if len(textures) == 0 {
+		return true, nil
+	}
+
+	// the texture is left out if it is missing, for the check to report
+	tex := path.Clean(textures[0])
+	w, err = zw.Create(base + ".mtl")
+	if err != nil {
+		return true, err
+	}
+	if _, err := fmt.Fprintf(w, "newmtl textured\nKd 1 1 1\nmap_Kd %s\n", tex); err != nil {
+		return true, err
+	}
+	if _, ok := localRef(".", tex); !ok {
+		return true, nil
+	}
+	if data, err := load(tex); err == nil {
+		iw, err := zw.Create(tex)
+		if err != nil {
+			return true, err
+		}
+		if _, err := iw.Write(data); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
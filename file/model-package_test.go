@@ -0,0 +1,202 @@
+package file
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testGLTF returns a glTF of a triangle moved by its node, with an embedded
+// buffer and an external image.
+func testGLTF() string {
+	var buf bytes.Buffer
+	for _, f := range []float32{0, 0, 0, 1, 0, 0, 0, 2, 0} {
+		binary.Write(&buf, binary.LittleEndian, math.Float32bits(f))
+	}
+	binary.Write(&buf, binary.LittleEndian, []uint16{0, 1, 2, 0})
+	uri := "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	return `{
+  "asset": {"version": "2.0"},
+  "scene": 0,
+  "scenes": [{"nodes": [0]}],
+  "nodes": [{"mesh": 0, "translation": [0, 0, 5]}],
+  "meshes": [{"primitives": [{"attributes": {"POSITION": 0}, "indices": 1, "material": 0}]}],
+  "materials": [{"name": "red", "pbrMetallicRoughness": {"baseColorFactor": [1, 0, 0, 1], "baseColorTexture": {"index": 0}}}],
+  "textures": [{"source": 0}],
+  "images": [{"uri": "tex/color%20map.png"}],
+  "accessors": [
+    {"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3", "min": [0, 0, 0], "max": [1, 2, 0]},
+    {"bufferView": 1, "componentType": 5123, "count": 3, "type": "SCALAR"}
+  ],
+  "bufferViews": [{"buffer": 0, "byteLength": 36}, {"buffer": 0, "byteOffset": 36, "byteLength": 6}],
+  "buffers": [{"uri": "` + uri + `", "byteLength": 44}]
+}`
+}
+
+const testPLY = `ply
+format ascii 1.0
+comment TextureFile tex/color map.png
+element vertex 4
+property float x
+property float y
+property float z
+property uchar red
+property uchar green
+property uchar blue
+element face 2
+property list uchar int vertex_indices
+property list uchar float texcoord
+end_header
+0 0 0 255 0 0
+1 0 0 0 255 0
+1 1 0 0 0 255
+0 1 -1 255 255 255
+3 0 1 2 6 0 0 1 0 1 1
+3 0 2 3 6 0 0 1 1 0 1
+`
+
+func TestPackageModel(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-package-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	files := map[string]string{
+		"my model.gltf":     testGLTF(),
+		"scan.ply":          testPLY,
+		"tex/color map.png": "png",
+	}
+	for name, content := range files {
+		p := filepath.Join(d, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		model    string
+		toOBJ    bool
+		zip      string
+		models   []string
+		textures []string
+		vertices int
+		faces    int
+		min, max [3]float64
+	}{
+		{"my model.gltf", false, "my_model.zip", []string{"my model.gltf"}, []string{"tex/color map.png"}, 3, 1, [3]float64{0, 0, 5}, [3]float64{1, 2, 5}},
+		{"my model.gltf", true, "my_model.zip", []string{"my_model.obj"}, []string{"textures/my_model_0.png"}, 3, 1, [3]float64{0, 0, 5}, [3]float64{1, 2, 5}},
+		{"scan.ply", false, "scan.zip", []string{"scan.ply"}, []string{"tex/color map.png"}, 4, 2, [3]float64{0, 0, -1}, [3]float64{1, 1, 0}},
+		{"scan.ply", true, "scan.zip", []string{"scan.obj"}, []string{"tex/color map.png"}, 4, 2, [3]float64{0, 0, -1}, [3]float64{1, 1, 0}},
+	}
+	for _, tt := range tests {
+		out, err := ioutil.TempDir(d, "out-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		zp, err := PackageModel(filepath.Join(d, tt.model), out, tt.toOBJ)
+		if err != nil {
+			t.Errorf("PackageModel(%q, %v) error = %v", tt.model, tt.toOBJ, err)
+			continue
+		}
+		if filepath.Base(zp) != tt.zip {
+			t.Errorf("PackageModel(%q, %v) = %q, want %q", tt.model, tt.toOBJ, filepath.Base(zp), tt.zip)
+		}
+		r, err := CheckModelZip(zp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Valid() || len(r.Issues) != 0 {
+			t.Errorf("PackageModel(%q, %v) issues = %v, want none", tt.model, tt.toOBJ, r.Issues)
+		}
+		if !reflect.DeepEqual(r.Models, tt.models) || !reflect.DeepEqual(r.Textures, tt.textures) {
+			t.Errorf("PackageModel(%q, %v) models = %q, textures = %q, want %q, %q", tt.model, tt.toOBJ, r.Models, r.Textures, tt.models, tt.textures)
+		}
+		if r.Vertices != tt.vertices || r.Faces != tt.faces || r.Min != tt.min || r.Max != tt.max {
+			t.Errorf("PackageModel(%q, %v) = %+v", tt.model, tt.toOBJ, r)
+		}
+	}
+}
+
+func TestCheckModelZipFormats(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-model-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	// a binary point cloud of 3 points, truncated after 2
+	var ply bytes.Buffer
+	ply.WriteString("ply\nformat binary_little_endian 1.0\nelement vertex 3\nproperty float x\nproperty float y\nproperty float z\nend_header\n")
+	binary.Write(&ply, binary.LittleEndian, []float32{0, 0, 0, 1, 1, 1})
+
+	var fbx bytes.Buffer
+	fbx.WriteString(fbxBinaryMagic + "\x1a\x00")
+	binary.Write(&fbx, binary.LittleEndian, uint32(7400))
+	for _, ref := range []string{`tex\wall.jpg`, "tex/missing.jpg", `tex\wall.jpg`} {
+		fbx.WriteString("\x00\x00" + fbxRefKey + "S")
+		binary.Write(&fbx, binary.LittleEndian, uint32(len(ref)))
+		fbx.WriteString(ref)
+	}
+
+	p := filepath.Join(d, "formats.zip")
+	writeZip(t, p, map[string]string{
+		"points.ply":   ply.String(),
+		"house.fbx":    fbx.String(),
+		"tex/wall.jpg": "jpg",
+		"bad.gltf":     strings.Replace(testGLTF(), `"byteLength": 44`, `"byteLength": 80`, 1),
+	})
+	r, err := CheckModelZip(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, i := range r.Issues {
+		got = append(got, i.String())
+	}
+	want := []string{
+		`bad.gltf: error: image "tex/color map.png" is not found`,
+		`bad.gltf: error: buffer 0 is truncated, 44 of 80 bytes`,
+		`house.fbx: warning: texture "tex/missing.jpg" is not found, unless it is embedded`,
+		`points.ply: error: file is truncated or corrupted, row 3 of 3 of vertex: unexpected EOF`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CheckModelZip() issues =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if want := []string{"tex/wall.jpg"}; !reflect.DeepEqual(r.Textures, want) {
+		t.Errorf("CheckModelZip() textures = %q, want %q", r.Textures, want)
+	}
+	if r.Vertices != 2 || r.Max != [3]float64{1, 1, 1} {
+		t.Errorf("CheckModelZip() = %+v", r)
+	}
+}
+
+func TestModelFormat(t *testing.T) {
+	tests := []struct {
+		ext  string
+		head string
+		want string
+	}{
+		{".glb", "glTF\x02\x00\x00\x00", ModelGLB},
+		{".bin", "glTF\x02\x00\x00\x00", ModelGLB},
+		{".gltf", "\ufeff {\"asset\"", ModelGLTF},
+		{".gltf", "<html>", ""},
+		{".PLY", "ply\r\nformat ascii 1.0", ModelPLY},
+		{".fbx", fbxBinaryMagic, ModelFBX},
+		{".fbx", "; FBX 7.4.0 project file", ModelFBX},
+		{".OBJ", "v 0 0 0", ModelOBJ},
+		{".zip", "PK\x03\x04", ""},
+	}
+	for _, tt := range tests {
+		if got := modelFormat(tt.ext, []byte(tt.head)); got != tt.want {
+			t.Errorf("modelFormat(%q, %q) = %q, want %q", tt.ext, tt.head, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package file
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// Kinds of perceptual hash.
+const (
+	HashDHash = "dhash"
+	HashPHash = "phash"
+)
+
+// hashMaxSide is the max side of the downsampled image for hashing.
+const hashMaxSide = 256
+
+// IsValidHash tells if kind is a supported kind of perceptual hash.
+func IsValidHash(kind string) bool {
+	return kind == HashDHash || kind == HashPHash
+}
+
+// PerceptualHash computes the 64-bit perceptual hash of the given kind.
+func PerceptualHash(img image.Image, kind string) uint64 {
+	if kind == HashPHash {
+		return PHash(img)
+	}
+	return DHash(img)
+}
+
+// DHash computes the difference hash of img, i.e. whether each pixel of the
+// 9 x 8 grayscale thumbnail is brighter than its right neighbour.
+func DHash(img image.Image) uint64 {
+	gray, w, h := downsampleGray(img, hashMaxSide)
+	pix := resizeGray(gray, w, h, 9, 8)
+
+	var ret uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			ret <<= 1
+			if pix[y*9+x] > pix[y*9+x+1] {
+				ret |= 1
+			}
+		}
+	}
+	return ret
+}
+
+// PHash computes the DCT based hash of img, i.e. whether each of the 8 x 8
+// lowest frequencies of the 32 x 32 grayscale thumbnail is above their median.
+func PHash(img image.Image) uint64 {
+	const n = 32
+	gray, w, h := downsampleGray(img, hashMaxSide)
+	pix := resizeGray(gray, w, h, n, n)
+
+	// separable 2d dct-ii, only the lowest 8 x 8 frequencies are needed
+	var rows [n][8]float64
+	for y := 0; y < n; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < n; x++ {
+				sum += pix[y*n+x] * math.Cos(float64((2*x+1)*u)*math.Pi/(2*n))
+			}
+			rows[y][u] = sum
+		}
+	}
+	var coef [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < n; y++ {
+				sum += rows[y][u] * math.Cos(float64((2*y+1)*v)*math.Pi/(2*n))
+			}
+			coef[v*8+u] = sum
+		}
+	}
+
+	// the dc term is excluded from the median
+	sorted := make([]float64, 63)
+	copy(sorted, coef[1:])
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+
+	var ret uint64
+	for _, c := range coef {
+		ret <<= 1
+		if c > median {
+			ret |= 1
+		}
+	}
+	return ret
+}
+
+// HammingDistance returns the number of differing bits of a and b.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// resizeGray resizes the w x h grayscale pixels to tw x th by averaging the
+// area covered by each target pixel.
+func resizeGray(pix []float64, w, h, tw, th int) []float64 {
+	ret := make([]float64, tw*th)
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := ty*h/th, (ty+1)*h/th
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := tx*w/tw, (tx+1)*w/tw
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var sum float64
+			var cnt int
+			for y := y0; y < y1 && y < h; y++ {
+				for x := x0; x < x1 && x < w; x++ {
+					sum += pix[y*w+x]
+					cnt++
+				}
+			}
+			if cnt > 0 {
+				ret[ty*tw+tx] = sum / float64(cnt)
+			}
+		}
+	}
+	return ret
+}
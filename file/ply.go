@@ -0,0 +1,337 @@
+package file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Formats of ply.
+const (
+	plyASCII     = "ascii"
+	plyLittleEnd = "binary_little_endian"
+	plyBigEnd    = "binary_big_endian"
+)
+
+// plySizes are the sizes of the property types of ply.
+var plySizes = map[string]int{
+	"char": 1, "uchar": 1, "int8": 1, "uint8": 1,
+	"short": 2, "ushort": 2, "int16": 2, "uint16": 2,
+	"int": 4, "uint": 4, "int32": 4, "uint32": 4,
+	"float": 4, "float32": 4, "double": 8, "float64": 8,
+}
+
+// plyProperty is a property of a ply element. CountType is the type of the
+// count of a list property, or empty for a scalar.
+type plyProperty struct {
+	Name      string
+	Type      string
+	CountType string
+}
+
+// plyElement is an element of a ply, e.g. vertex or face.
+type plyElement struct {
+	Name  string
+	Count int64
+	Props []plyProperty
+}
+
+// rowSize returns the number of bytes of a binary row, or 0 if it has any
+// list.
+func (e plyElement) rowSize() int {
+	n := 0
+	for _, p := range e.Props {
+		if p.CountType != "" {
+			return 0
+		}
+		n += plySizes[p.Type]
+	}
+	return n
+}
+
+// prop returns the index of the property of the first of names, or -1.
+func (e plyElement) prop(names ...string) int {
+	for _, n := range names {
+		for i, p := range e.Props {
+			if p.Name == n {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// plyHeader is the header of a ply.
+type plyHeader struct {
+	Format   string
+	Elements []plyElement
+	Comments []string
+	Size     int64 // bytes of the header
+}
+
+// element returns the element of name, or nil.
+func (h *plyHeader) element(name string) *plyElement {
+	for i := range h.Elements {
+		if h.Elements[i].Name == name {
+			return &h.Elements[i]
+		}
+	}
+	return nil
+}
+
+// textures returns the textures of the TextureFile comments.
+func (h *plyHeader) textures() []string {
+	var ret []string
+	for _, c := range h.Comments {
+		if strings.HasPrefix(c, "TextureFile ") {
+			ret = append(ret, strings.TrimSpace(strings.TrimPrefix(c, "TextureFile ")))
+		}
+	}
+	return ret
+}
+
+// dataSize returns the expected bytes of the data of a binary ply, or -1 if
+// it is unknown, i.e. ascii or with lists.
+func (h *plyHeader) dataSize() int64 {
+	if h.Format == plyASCII {
+		return -1
+	}
+	var n int64
+	for _, e := range h.Elements {
+		rs := e.rowSize()
+		if rs == 0 {
+			return -1
+		}
+		n += int64(rs) * e.Count
+	}
+	return n
+}
+
+// readPLYHeader reads and validates the header of a ply from br.
+func readPLYHeader(br *bufio.Reader) (*plyHeader, error) {
+	h := &plyHeader{}
+	for line := 1; ; line++ {
+		l, err := br.ReadString('\n')
+		h.Size += int64(len(l))
+		if err != nil {
+			return nil, fmt.Errorf("ply header is not ended: %v", err)
+		}
+		toks := strings.Fields(l)
+		if line == 1 {
+			if len(toks) != 1 || toks[0] != "ply" {
+				return nil, fmt.Errorf("not a ply")
+			}
+			continue
+		}
+		if len(toks) == 0 {
+			continue
+		}
+		switch toks[0] {
+		case "format":
+			if len(toks) != 3 || (toks[1] != plyASCII && toks[1] != plyLittleEnd && toks[1] != plyBigEnd) {
+				return nil, fmt.Errorf("ply header line %d: invalid format %q", line, strings.TrimSpace(l))
+			}
+			h.Format = toks[1]
+		case "comment", "obj_info":
+			h.Comments = append(h.Comments, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), toks[0])))
+		case "element":
+			if len(toks) != 3 {
+				return nil, fmt.Errorf("ply header line %d: invalid element %q", line, strings.TrimSpace(l))
+			}
+			n, err := strconv.ParseInt(toks[2], 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("ply header line %d: invalid count of element %q", line, toks[1])
+			}
+			h.Elements = append(h.Elements, plyElement{Name: toks[1], Count: n})
+		case "property":
+			if len(h.Elements) == 0 {
+				return nil, fmt.Errorf("ply header line %d: property without element", line)
+			}
+			e := &h.Elements[len(h.Elements)-1]
+			switch {
+			case len(toks) == 3 && plySizes[toks[1]] > 0:
+				e.Props = append(e.Props, plyProperty{Name: toks[2], Type: toks[1]})
+			case len(toks) == 5 && toks[1] == "list" && plySizes[toks[2]] > 0 && plySizes[toks[3]] > 0:
+				e.Props = append(e.Props, plyProperty{Name: toks[4], Type: toks[3], CountType: toks[2]})
+			default:
+				return nil, fmt.Errorf("ply header line %d: invalid property %q", line, strings.TrimSpace(l))
+			}
+		case "end_header":
+			if h.Format == "" {
+				return nil, fmt.Errorf("ply format is not defined")
+			}
+			v := h.element("vertex")
+			if v == nil || v.prop("x") < 0 || v.prop("y") < 0 || v.prop("z") < 0 {
+				return nil, fmt.Errorf("ply has no vertex element of x, y and z")
+			}
+			return h, nil
+		default:
+			return nil, fmt.Errorf("ply header line %d: unknown keyword %q", line, toks[0])
+		}
+	}
+}
+
+// plyReader reads the rows of the elements of a ply after its header.
+type plyReader struct {
+	h     *plyHeader
+	br    *bufio.Reader
+	order binary.ByteOrder
+	buf   [8]byte
+	line  []string // the remaining fields of an ascii row
+}
+
+func newPLYReader(h *plyHeader, br *bufio.Reader) *plyReader {
+	pr := &plyReader{h: h, br: br}
+	switch h.Format {
+	case plyLittleEnd:
+		pr.order = binary.LittleEndian
+	case plyBigEnd:
+		pr.order = binary.BigEndian
+	}
+	return pr
+}
+
+// row reads a row of the element into vals, one slice of values for each
+// property.
+func (pr *plyReader) row(e plyElement, vals [][]float64) error {
+	if pr.order == nil {
+		l, err := pr.br.ReadString('\n')
+		if err != nil && (err != io.EOF || strings.TrimSpace(l) == "") {
+			return io.ErrUnexpectedEOF
+		}
+		pr.line = strings.Fields(l)
+	}
+	for i, p := range e.Props {
+		n := 1
+		if p.CountType != "" {
+			c, err := pr.value(p.CountType)
+			if err != nil {
+				return err
+			}
+			if c < 0 || c > 1<<16 {
+				return fmt.Errorf("invalid list length %v of %s", c, p.Name)
+			}
+			n = int(c)
+		}
+		vals[i] = vals[i][:0]
+		for j := 0; j < n; j++ {
+			v, err := pr.value(p.Type)
+			if err != nil {
+				return err
+			}
+			vals[i] = append(vals[i], v)
+		}
+	}
+	if pr.order == nil && len(pr.line) > 0 {
+		return fmt.Errorf("%d extra values in a row of %s", len(pr.line), e.Name)
+	}
+	return nil
+}
+
+// value reads a value of type t.
+func (pr *plyReader) value(t string) (float64, error) {
+	if pr.order == nil {
+		if len(pr.line) == 0 {
+			return 0, fmt.Errorf("missing values")
+		}
+		v, err := strconv.ParseFloat(pr.line[0], 64)
+		pr.line = pr.line[1:]
+		return v, err
+	}
+	b := pr.buf[:plySizes[t]]
+	if _, err := io.ReadFull(pr.br, b); err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	switch t {
+	case "char", "int8":
+		return float64(int8(b[0])), nil
+	case "uchar", "uint8":
+		return float64(b[0]), nil
+	case "short", "int16":
+		return float64(int16(pr.order.Uint16(b))), nil
+	case "ushort", "uint16":
+		return float64(pr.order.Uint16(b)), nil
+	case "int", "int32":
+		return float64(int32(pr.order.Uint32(b))), nil
+	case "uint", "uint32":
+		return float64(pr.order.Uint32(b)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(pr.order.Uint32(b))), nil
+	}
+	return math.Float64frombits(pr.order.Uint64(b)), nil
+}
+
+// newRow allocates the values of a row of the element.
+func newRow(e plyElement) [][]float64 {
+	vals := make([][]float64, len(e.Props))
+	for i := range vals {
+		vals[i] = make([]float64, 0, 4)
+	}
+	return vals
+}
+
+// plyStats are the counts and the bounding box of the data of a ply.
+type plyStats struct {
+	Vertices int64
+	Faces    int64
+	Min, Max [3]float64
+	Rows     map[string]int64 // rows read of each element
+	Err      error            // error of reading the data, e.g. truncated
+}
+
+// readPLYStats reads the data of the ply after its header, for the bounding
+// box of the vertices and the number of rows of each element.
+func readPLYStats(h *plyHeader, br *bufio.Reader) plyStats {
+	s := plyStats{
+		Min:  [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)},
+		Max:  [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)},
+		Rows: make(map[string]int64),
+	}
+	pr := newPLYReader(h, br)
+rows:
+	for _, e := range h.Elements {
+		vals := newRow(e)
+		xyz := [3]int{e.prop("x"), e.prop("y"), e.prop("z")}
+		for i := int64(0); i < e.Count; i++ {
+			if err := pr.row(e, vals); err != nil {
+				s.Err = fmt.Errorf("row %d of %d of %s: %v", i+1, e.Count, e.Name, err)
+				break rows
+			}
+			s.Rows[e.Name]++
+			if e.Name != "vertex" {
+				continue
+			}
+			for k, j := range xyz {
+				s.Min[k] = math.Min(s.Min[k], vals[j][0])
+				s.Max[k] = math.Max(s.Max[k], vals[j][0])
+			}
+		}
+	}
+	s.Vertices = s.Rows["vertex"]
+	s.Faces = s.Rows["face"]
+	if s.Vertices == 0 {
+		s.Min, s.Max = [3]float64{}, [3]float64{}
+	}
+	return s
+}
+
+// IsPLYPointCloud tells if the ply at p has no face, i.e. it is a point cloud
+// rather than a mesh.
+func IsPLYPointCloud(p string) (bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h, err := readPLYHeader(bufio.NewReader(f))
+	if err != nil {
+		return false, err
+	}
+	face := h.element("face")
+	return face == nil || face.Count == 0, nil
+}
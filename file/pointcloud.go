@@ -0,0 +1,634 @@
+package file
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pointCloudExts are the extensions of the point clouds inspected in a zip.
+var pointCloudExts = map[string]string{".las": ModelLAS, ".laz": ModelLAZ, ".e57": ModelE57, ".ply": ModelPLY}
+
+// lasRecordSizes are the min lengths of the point records of the point
+// formats of las.
+var lasRecordSizes = []int64{20, 28, 26, 34, 57, 63, 30, 36, 38, 59, 67}
+
+// wktAuthority matches the epsg code of a wkt, e.g. AUTHORITY["EPSG","2326"].
+var wktAuthority = regexp.MustCompile(`AUTHORITY\["EPSG",\s*"?(\d+)"?\]`)
+
+// PointCloudReport is the result of inspecting the header of a point cloud.
+type PointCloudReport struct {
+	Name        string // path of the file, or name of the member of a zip
+	Format      string
+	Version     string
+	PointFormat string
+	Points      int64 // declared by the header
+	Actual      int64 // point records found, or -1 if unknown, e.g. compressed
+	Min         [3]float64
+	Max         [3]float64
+	HasBounds   bool
+	CRS         string
+	Issues      // of the header, the point cloud is valid if there is no error
+}
+
+// Summary sums up the format, the points and the crs.
+func (r *PointCloudReport) Summary() string {
+	actual := "unknown"
+	if r.Actual >= 0 {
+		actual = strconv.FormatInt(r.Actual, 10)
+	}
+	crs := r.CRS
+	if crs == "" {
+		crs = "none"
+	}
+	return fmt.Sprintf("%s %s, point format %s, %s of %d points, crs %s", r.Format, r.Version, r.PointFormat, actual, r.Points, crs)
+}
+
+func (r *PointCloudReport) add(level, format string, a ...interface{}) {
+	r.Issues = append(r.Issues, Issue{r.Name, 0, level, fmt.Sprintf(format, a...)})
+}
+
+// bound sets the bounding box, which is an error if min is greater than max.
+func (r *PointCloudReport) bound(min, max [3]float64) {
+	for i := range min {
+		if min[i] > max[i] {
+			r.add(IssueError, "bounding box is invalid, min %v is greater than max %v", min, max)
+			return
+		}
+	}
+	if !r.HasBounds {
+		r.Min, r.Max, r.HasBounds = min, max, true
+		return
+	}
+	for i := range min {
+		r.Min[i] = math.Min(r.Min[i], min[i])
+		r.Max[i] = math.Max(r.Max[i], max[i])
+	}
+}
+
+// InspectPointClouds inspects the header of the point cloud of las, laz, ply
+// or e57 at p, or of every point cloud in the zip at p. A ply with faces is
+// skipped as a mesh.
+func InspectPointClouds(p string) ([]*PointCloudReport, error) {
+	isZip, err := IsZipFile(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !isZip {
+		format, err := DetectModelFormat(p)
+		if err != nil {
+			return nil, err
+		}
+		r, err := inspectPointCloud(filepath.Base(p), format, f, fi.Size())
+		if err != nil || r == nil {
+			return nil, err
+		}
+		return []*PointCloudReport{r}, nil
+	}
+
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+	var ret []*PointCloudReport
+	for _, zf := range zr.File {
+		format := pointCloudExts[strings.ToLower(path.Ext(zf.Name))]
+		if format == "" || zf.FileInfo().IsDir() {
+			continue
+		}
+		r, err := inspectZipPointCloud(f, zf, format)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", zf.Name, err)
+		}
+		if r != nil {
+			ret = append(ret, r)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+// inspectZipPointCloud inspects the member of the zip in f, seeking in it if
+// it is stored without compression.
+func inspectZipPointCloud(f *os.File, zf *zip.File, format string) (*PointCloudReport, error) {
+	size := int64(zf.UncompressedSize64)
+	if zf.Method == zip.Store {
+		if off, err := zf.DataOffset(); err == nil {
+			return inspectPointCloud(zf.Name, format, io.NewSectionReader(f, off, size), size)
+		}
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return inspectPointCloud(zf.Name, format, rc, size)
+}
+
+// inspectPointCloud inspects the point cloud of format read from r of size
+// bytes. It returns nil if it is not a point cloud, e.g. a ply with faces.
+func inspectPointCloud(name, format string, r io.Reader, size int64) (*PointCloudReport, error) {
+	rep := &PointCloudReport{Name: name, Format: format, Actual: -1}
+	pr := &posReader{r: r}
+	var err error
+	switch format {
+	case ModelLAS, ModelLAZ:
+		err = inspectLAS(pr, size, rep)
+	case ModelE57:
+		err = inspectE57(pr, size, rep)
+	case ModelPLY:
+		var mesh bool
+		mesh, err = inspectPLY(pr, size, rep)
+		if mesh {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// posReader tracks the position of a reader for skipping to an offset.
+type posReader struct {
+	r   io.Reader
+	pos int64
+}
+
+func (pr *posReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.pos += int64(n)
+	return n, err
+}
+
+// skipTo skips to the offset, by seeking if the reader could seek.
+func (pr *posReader) skipTo(off int64) error {
+	if off < pr.pos {
+		return fmt.Errorf("offset %d is before the current %d", off, pr.pos)
+	}
+	if s, ok := pr.r.(io.Seeker); ok {
+		if _, err := s.Seek(off, io.SeekStart); err != nil {
+			return err
+		}
+		pr.pos = off
+		return nil
+	}
+	_, err := io.CopyN(ioutil.Discard, pr, off-pr.pos)
+	return err
+}
+
+// cString returns the string of b up to the first nul.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// inspectLAS inspects the header, the variable length records for the crs
+// and the size of the point records of a las or laz.
+func inspectLAS(pr *posReader, size int64, r *PointCloudReport) error {
+	h := make([]byte, 375)
+	if _, err := io.ReadFull(pr, h[:227]); err != nil {
+		r.add(IssueError, "las header is truncated")
+		return nil
+	}
+	if string(h[:4]) != "LASF" {
+		r.add(IssueError, "not a las, no LASF signature")
+		return nil
+	}
+	le := binary.LittleEndian
+	r.Version = fmt.Sprintf("%d.%d", h[24], h[25])
+	global := le.Uint16(h[6:])
+	hdrSize := int64(le.Uint16(h[94:]))
+	offset := int64(le.Uint32(h[96:]))
+	nVLR := le.Uint32(h[100:])
+	pf := h[104]
+	recLen := int64(le.Uint16(h[105:]))
+	r.Points = int64(le.Uint32(h[107:]))
+	f64 := func(i int) float64 { return math.Float64frombits(le.Uint64(h[i:])) }
+	compressed := pf&0x80 != 0
+	pf &= 0x3f
+	r.PointFormat = strconv.Itoa(int(pf))
+	if compressed {
+		r.Format = ModelLAZ
+	}
+
+	var evlrStart int64
+	var nEVLR uint32
+	if hdrSize >= 375 {
+		if _, err := io.ReadFull(pr, h[227:375]); err != nil {
+			r.add(IssueError, "las 1.4 header is truncated")
+			return nil
+		}
+		evlrStart = int64(le.Uint64(h[235:]))
+		nEVLR = le.Uint32(h[243:])
+		if n := int64(le.Uint64(h[247:])); n > 0 {
+			if r.Points != 0 && r.Points != n {
+				r.add(IssueWarning, "legacy number of point records %d differs from %d", r.Points, n)
+			}
+			r.Points = n
+		}
+	}
+	r.bound([3]float64{f64(187), f64(203), f64(219)}, [3]float64{f64(179), f64(195), f64(211)})
+	if int(pf) >= len(lasRecordSizes) {
+		r.add(IssueError, "point format %d is unknown", pf)
+	} else if recLen < lasRecordSizes[pf] {
+		r.add(IssueError, "point record length %d is shorter than %d of point format %d", recLen, lasRecordSizes[pf], pf)
+	}
+	if err := pr.skipTo(hdrSize); err != nil {
+		r.add(IssueError, "las header size %d is invalid", hdrSize)
+		return nil
+	}
+
+	var laszip, wkt bool
+	for i := uint32(0); i < nVLR; i++ {
+		v := make([]byte, 54)
+		if _, err := io.ReadFull(pr, v); err != nil {
+			r.add(IssueError, "variable length record %d of %d is truncated", i+1, nVLR)
+			return nil
+		}
+		data := make([]byte, le.Uint16(v[20:]))
+		if _, err := io.ReadFull(pr, data); err != nil {
+			r.add(IssueError, "variable length record %d of %d is truncated", i+1, nVLR)
+			return nil
+		}
+		user := cString(v[2:18])
+		laszip = laszip || user == "laszip encoded"
+		wkt = r.lasCRS(user, le.Uint16(v[18:]), data) || wkt
+	}
+	if pr.pos > offset {
+		r.add(IssueError, "variable length records end at %d, after the point data at %d", pr.pos, offset)
+		return nil
+	}
+
+	// integrity of the point records
+	end := size
+	if evlrStart > 0 {
+		if evlrStart > size {
+			r.add(IssueError, "file is truncated, extended records at %d are beyond the size %d", evlrStart, size)
+		} else {
+			end = evlrStart
+		}
+	}
+	switch {
+	case compressed:
+		if !laszip {
+			r.add(IssueWarning, "points are compressed without a laszip record")
+		}
+		// the chunk table of laszip is at the end
+		var cto int64
+		if err := pr.skipTo(offset); err == nil && binary.Read(pr, le, &cto) == nil {
+			if cto > size {
+				r.add(IssueError, "file is truncated, chunk table at %d is beyond the size %d", cto, size)
+			}
+		} else {
+			r.add(IssueError, "file is truncated, no point data at %d", offset)
+		}
+	case recLen > 0 && end >= offset:
+		r.Actual = (end - offset) / recLen
+		if r.Actual < r.Points {
+			r.add(IssueError, "file is truncated, %d of %d point records", r.Actual, r.Points)
+		} else if extra := end - offset - r.Points*recLen; extra > 0 {
+			r.add(IssueWarning, "%d bytes after the point records are ignored", extra)
+		}
+	case recLen > 0:
+		r.Actual = 0
+		r.add(IssueError, "file is truncated, point data at %d is beyond the size %d", offset, end)
+	}
+
+	// a wkt may be in the extended records of las 1.4
+	if r.CRS == "" && nEVLR > 0 && evlrStart > 0 && evlrStart < size {
+		if err := pr.skipTo(evlrStart); err == nil {
+			for i := uint32(0); i < nEVLR; i++ {
+				v := make([]byte, 60)
+				if _, err := io.ReadFull(pr, v); err != nil {
+					r.add(IssueError, "extended variable length record %d of %d is truncated", i+1, nEVLR)
+					break
+				}
+				n := int64(le.Uint64(v[20:]))
+				if n < 0 || n > size-pr.pos {
+					r.add(IssueError, "extended variable length record %d of %d is truncated", i+1, nEVLR)
+					break
+				}
+				if n > 1<<20 {
+					if err := pr.skipTo(pr.pos + n); err != nil {
+						break
+					}
+					continue
+				}
+				data := make([]byte, n)
+				if _, err := io.ReadFull(pr, data); err != nil {
+					r.add(IssueError, "extended variable length record %d of %d is truncated", i+1, nEVLR)
+					break
+				}
+				wkt = r.lasCRS(cString(v[2:18]), le.Uint16(v[18:]), data) || wkt
+			}
+		}
+	}
+
+	switch {
+	case r.CRS == "":
+		r.add(IssueWarning, "no crs is defined, the point cloud is not georeferenced")
+	case global&0x10 != 0 && !wkt:
+		r.add(IssueWarning, "global encoding tells a wkt crs, but only geotiff keys are found")
+	case pf >= 6 && !wkt:
+		r.add(IssueWarning, "point format %d should have a wkt crs, not geotiff keys", pf)
+	}
+	return nil
+}
+
+// lasCRS sets the crs by a wkt or geotiff keys record. It returns true for a
+// wkt, which is preferred over the keys.
+func (r *PointCloudReport) lasCRS(user string, id uint16, data []byte) bool {
+	if user != "LASF_Projection" {
+		return false
+	}
+	switch id {
+	case 2112:
+		r.CRS = wktName(cString(data))
+		return true
+	case 34735:
+		if s := geoKeysCRS(data); s != "" && r.CRS == "" {
+			r.CRS = s
+		}
+	}
+	return false
+}
+
+// wktName returns the name and the epsg code of a wkt, e.g.
+// "Hong Kong 1980 Grid System (EPSG:2326)".
+func wktName(wkt string) string {
+	name := ""
+	if i := strings.Index(wkt, `"`); i >= 0 {
+		if j := strings.Index(wkt[i+1:], `"`); j >= 0 {
+			name = wkt[i+1 : i+1+j]
+		}
+	}
+	// the last authority is of the whole crs
+	if m := wktAuthority.FindAllStringSubmatch(wkt, -1); len(m) > 0 {
+		code := "EPSG:" + m[len(m)-1][1]
+		if name == "" {
+			return code
+		}
+		return fmt.Sprintf("%s (%s)", name, code)
+	}
+	if name == "" && wkt != "" {
+		return "wkt"
+	}
+	return name
+}
+
+// geoKeysCRS returns the epsg codes of the projected or geographic and the
+// vertical crs of a geotiff key directory, e.g. "EPSG:2326 + EPSG:5738".
+func geoKeysCRS(data []byte) string {
+	le := binary.LittleEndian
+	if len(data) < 8 {
+		return ""
+	}
+	n := int(le.Uint16(data[6:]))
+	keys := make(map[uint16]uint16)
+	for i := 1; i <= n && (i+1)*8 <= len(data); i++ {
+		k := data[i*8:]
+		// only the keys with their values inline
+		if le.Uint16(k[2:]) == 0 {
+			keys[le.Uint16(k)] = le.Uint16(k[6:])
+		}
+	}
+	code := func(k uint16) string {
+		switch v, ok := keys[k]; {
+		case !ok || v == 0:
+			return ""
+		case v == 32767:
+			return "user-defined"
+		default:
+			return fmt.Sprintf("EPSG:%d", v)
+		}
+	}
+	crs := code(3072) // ProjectedCSTypeGeoKey
+	if crs == "" {
+		crs = code(2048) // GeographicTypeGeoKey
+	}
+	if v := code(4096); v != "" && crs != "" {
+		// VerticalCSTypeGeoKey
+		crs += " + " + v
+	}
+	return crs
+}
+
+// inspectE57 inspects the header and the xml section of an e57, for the
+// number of points, the bounds and the crs of its scans.
+func inspectE57(pr *posReader, size int64, r *PointCloudReport) error {
+	h := make([]byte, 48)
+	if _, err := io.ReadFull(pr, h); err != nil || string(h[:8]) != "ASTM-E57" {
+		r.add(IssueError, "not an e57, no ASTM-E57 header")
+		return nil
+	}
+	le := binary.LittleEndian
+	r.Version = fmt.Sprintf("%d.%d", le.Uint32(h[8:]), le.Uint32(h[12:]))
+	phys := int64(le.Uint64(h[16:]))
+	xmlOff := int64(le.Uint64(h[24:]))
+	xmlLen := int64(le.Uint64(h[32:]))
+	page := int64(le.Uint64(h[40:]))
+	if page <= 4 {
+		page = 1024
+	}
+	if phys < 0 || xmlOff < 0 || xmlLen < 0 {
+		r.add(IssueError, "e57 header is invalid, negative length or offset")
+		return nil
+	}
+	switch {
+	case phys > size:
+		r.add(IssueError, "file is truncated, %d of %d bytes", size, phys)
+	case phys < size:
+		r.add(IssueWarning, "%d bytes after the end are ignored", size-phys)
+	}
+	if xmlOff > size || xmlLen > size-xmlOff || xmlLen > 64<<20 {
+		r.add(IssueError, "xml section at %d of %d bytes is beyond the size %d", xmlOff, xmlLen, size)
+		return nil
+	}
+	if err := pr.skipTo(xmlOff); err != nil {
+		return err
+	}
+
+	// each page ends with its checksum
+	doc := make([]byte, 0, xmlLen)
+	for int64(len(doc)) < xmlLen {
+		off := pr.pos % page
+		if off >= page-4 {
+			if err := pr.skipTo(pr.pos + page - off); err != nil {
+				r.add(IssueError, "xml section is truncated")
+				return nil
+			}
+			continue
+		}
+		n := page - 4 - off
+		if rest := xmlLen - int64(len(doc)); n > rest {
+			n = rest
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(pr, b); err != nil {
+			r.add(IssueError, "xml section is truncated")
+			return nil
+		}
+		doc = append(doc, b...)
+	}
+	r.e57XML(doc, size)
+	return nil
+}
+
+// e57XML reads the points, the bounds and the point fields of the scans and
+// the crs of the xml section of an e57.
+func (r *PointCloudReport) e57XML(doc []byte, size int64) {
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	var stack []string
+	parent := func(n int) string {
+		if len(stack) > n {
+			return stack[len(stack)-1-n]
+		}
+		return ""
+	}
+	scans := 0
+	fields := make(map[string]bool)
+	var min, max [3]float64
+	axes := map[string]int{"xMinimum": 0, "yMinimum": 1, "zMinimum": 2, "xMaximum": 0, "yMaximum": 1, "zMaximum": 2}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			r.add(IssueError, "xml section is invalid: %v", err)
+			return
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			switch {
+			case t.Name.Local == "points" && parent(1) == "vectorChild" && parent(2) == "data3D":
+				scans++
+				for _, a := range t.Attr {
+					switch a.Name.Local {
+					case "recordCount":
+						n, _ := strconv.ParseInt(a.Value, 10, 64)
+						r.Points += n
+					case "fileOffset":
+						if off, err := strconv.ParseInt(a.Value, 10, 64); err == nil && off > size {
+							r.add(IssueError, "file is truncated, points of scan %d at %d are beyond the size %d", scans, off, size)
+						}
+					}
+				}
+			case parent(1) == "prototype":
+				fields[t.Name.Local] = true
+			case t.Name.Local == "cartesianBounds":
+				min = [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+				max = [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "cartesianBounds" && !math.IsInf(min[0], 0) && !math.IsInf(max[0], 0) {
+				r.bound(min, max)
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			s := strings.TrimSpace(string(t))
+			if s == "" {
+				continue
+			}
+			switch i, ok := axes[parent(0)]; {
+			case ok && parent(1) == "cartesianBounds":
+				v, err := strconv.ParseFloat(s, 64)
+				if err != nil {
+					continue
+				}
+				if strings.HasSuffix(parent(0), "Minimum") {
+					min[i] = v
+				} else {
+					max[i] = v
+				}
+			case parent(0) == "coordinateMetadata" && parent(1) == "e57Root":
+				r.CRS = s
+			}
+		}
+	}
+
+	switch {
+	case fields["cartesianX"]:
+		r.PointFormat = "cartesian"
+	case fields["sphericalRange"]:
+		r.PointFormat = "spherical"
+	default:
+		r.PointFormat = "unknown"
+	}
+	for _, f := range []string{"intensity", "colorRed", "timeStamp"} {
+		if fields[f] {
+			r.PointFormat += "+" + strings.TrimPrefix(strings.ToLower(f), "color")
+		}
+	}
+	if scans == 0 {
+		r.add(IssueError, "no scan is found")
+	}
+	if r.CRS == "" {
+		r.add(IssueWarning, "no crs is defined, the point cloud is not georeferenced")
+	}
+}
+
+// inspectPLY inspects the header of a ply and reads its vertices for the
+// bounds and the rows found. It returns true if it is a mesh, with faces.
+func inspectPLY(pr *posReader, size int64, r *PointCloudReport) (bool, error) {
+	br := bufio.NewReaderSize(pr, 64*1024)
+	h, err := readPLYHeader(br)
+	if err != nil {
+		r.add(IssueError, "%v", err)
+		return false, nil
+	}
+	if face := h.element("face"); face != nil && face.Count > 0 {
+		return true, nil
+	}
+	v := h.element("vertex")
+	r.Version = h.Format
+	r.Points = v.Count
+	var props []string
+	for _, p := range v.Props {
+		props = append(props, p.Name)
+	}
+	r.PointFormat = strings.Join(props, ",")
+
+	s := readPLYStats(h, br)
+	r.Actual = s.Rows["vertex"]
+	switch ds := h.dataSize(); {
+	case s.Err != nil:
+		r.add(IssueError, "file is truncated or corrupted, %v", s.Err)
+	case ds >= 0 && size > h.Size+ds:
+		r.add(IssueWarning, "%d bytes after the data are ignored", size-h.Size-ds)
+	}
+	if s.Vertices > 0 {
+		r.bound(s.Min, s.Max)
+	}
+	return false, nil
+}
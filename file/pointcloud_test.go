@@ -0,0 +1,238 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testLAS returns a las of version 1.minor with the vlrs, declaring n points
+// of the point format and writing written of them.
+func testLAS(minor byte, pf byte, recLen uint16, n, written int, vlrs map[uint16][]byte) []byte {
+	le := binary.LittleEndian
+	hdrSize := 227
+	if minor >= 4 {
+		hdrSize = 375
+	}
+	h := make([]byte, hdrSize)
+	copy(h, "LASF")
+	h[24], h[25] = 1, minor
+	var body bytes.Buffer
+	for id, data := range vlrs {
+		v := make([]byte, 54)
+		copy(v[2:], "LASF_Projection")
+		le.PutUint16(v[18:], id)
+		le.PutUint16(v[20:], uint16(len(data)))
+		body.Write(v)
+		body.Write(data)
+	}
+	le.PutUint16(h[94:], uint16(hdrSize))
+	le.PutUint32(h[96:], uint32(hdrSize+body.Len()))
+	le.PutUint32(h[100:], uint32(len(vlrs)))
+	h[104] = pf
+	le.PutUint16(h[105:], recLen)
+	if minor < 4 {
+		le.PutUint32(h[107:], uint32(n))
+	} else {
+		le.PutUint64(h[247:], uint64(n))
+	}
+	for i, f := range []float64{1, 1, 1, 0, 0, 0, 10, 0, 20, 0, 30, -5} {
+		le.PutUint64(h[131+8*i:], math.Float64bits(f))
+	}
+	b := append(h, body.Bytes()...)
+	return append(b, make([]byte, written*int(recLen))...)
+}
+
+// withEVLR appends an extended variable length record of the id to the las 1.4
+// b, declaring n bytes of data.
+func withEVLR(b []byte, id uint16, n uint64, data []byte) []byte {
+	le := binary.LittleEndian
+	le.PutUint64(b[235:], uint64(len(b)))
+	le.PutUint32(b[243:], 1)
+	v := make([]byte, 60)
+	copy(v[2:], "LASF_Projection")
+	le.PutUint16(v[18:], id)
+	le.PutUint64(v[20:], n)
+	return append(append(b, v...), data...)
+}
+
+// testE57 returns an e57 of the xml section, with a checksum after every 1020
+// bytes of a page.
+func testE57(doc string) []byte {
+	le := binary.LittleEndian
+	h := make([]byte, 48)
+	copy(h, "ASTM-E57")
+	le.PutUint32(h[8:], 1)
+	le.PutUint64(h[24:], 48)
+	le.PutUint64(h[32:], uint64(len(doc)))
+	le.PutUint64(h[40:], 1024)
+	logical := append(h, doc...)
+	var b []byte
+	for len(logical) > 0 {
+		n := 1020
+		if n > len(logical) {
+			n = len(logical)
+		}
+		page := make([]byte, 1024)
+		copy(page, logical[:n])
+		b = append(b, page...)
+		logical = logical[n:]
+	}
+	le.PutUint64(b[16:], uint64(len(b)))
+	return b
+}
+
+const testE57XML = `<?xml version="1.0" encoding="UTF-8"?>
+<e57Root type="Structure" xmlns="http://www.astm.org/COMMIT/E57/2010-e57-v1.0">
+  <formatName type="String"><![CDATA[ASTM E57 3D Imaging Data File]]></formatName>
+  <coordinateMetadata type="String"><![CDATA[EPSG:2326]]></coordinateMetadata>
+  <data3D type="Vector" allowHeterogeneousChildren="1">
+    <vectorChild type="Structure">
+      <cartesianBounds type="Structure">
+        <xMinimum type="Float">-1</xMinimum><xMaximum type="Float">1</xMaximum>
+        <yMinimum type="Float">-2</yMinimum><yMaximum type="Float">2</yMaximum>
+        <zMinimum type="Float">0</zMinimum><zMaximum type="Float">3</zMaximum>
+      </cartesianBounds>
+      <points type="CompressedVector" fileOffset="48" recordCount="1000">
+        <prototype type="Structure">
+          <cartesianX type="Float"/><cartesianY type="Float"/><cartesianZ type="Float"/>
+          <intensity type="Float"/>
+        </prototype>
+      </points>
+    </vectorChild>
+    <vectorChild type="Structure">
+      <cartesianBounds type="Structure">
+        <xMinimum type="Float">0</xMinimum><xMaximum type="Float">5</xMaximum>
+        <yMinimum type="Float">0</yMinimum><yMaximum type="Float">1</yMaximum>
+        <zMinimum type="Float">-1</zMinimum><zMaximum type="Float">1</zMaximum>
+      </cartesianBounds>
+      <points type="CompressedVector" fileOffset="99999999" recordCount="500"/>
+    </vectorChild>
+  </data3D>
+</e57Root>
+`
+
+func TestInspectPointClouds(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-pointcloud-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	// geotiff keys of a projected crs and a vertical crs
+	keys := make([]byte, 24)
+	for i, v := range []uint16{1, 1, 0, 2, 3072, 0, 1, 2326, 4096, 0, 1, 5738} {
+		binary.LittleEndian.PutUint16(keys[2*i:], v)
+	}
+	wkt := `PROJCS["Hong Kong 1980 Grid System",GEOGCS["Hong Kong 1980",AUTHORITY["EPSG","4611"]],AUTHORITY["EPSG","2326"]]` + "\x00"
+	laz := testLAS(2, 0x81, 28, 5, 0, map[uint16][]byte{34735: keys})
+	laz = append(laz, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(laz[len(laz)-8:], 1<<20)
+	points := strings.Replace(testPLY, "element face 2", "element face 0", 1)
+	points = points[:strings.Index(points, "end_header\n")+len("end_header\n")] + "0 0 0 1 1 1\n1 2 3 1 1 1\n"
+
+	files := map[string][]byte{
+		"cut.las":    testLAS(2, 0, 20, 3, 2, map[uint16][]byte{34735: keys}),
+		"wkt.las":    testLAS(4, 6, 30, 2, 2, map[uint16][]byte{2112: []byte(wkt)}),
+		"nocrs.las":  testLAS(2, 1, 28, 1, 1, nil),
+		"evlr.las":   withEVLR(testLAS(4, 6, 30, 2, 2, nil), 2112, uint64(len(wkt)), []byte(wkt)),
+		"neg.las":    withEVLR(testLAS(4, 6, 30, 2, 2, nil), 2112, 1<<63, nil),
+		"cut.laz":    laz,
+		"scan.e57":   testE57(testE57XML),
+		"points.ply": []byte(strings.Replace(points, "element vertex 4", "element vertex 2", 1)),
+		"mesh.ply":   []byte(testPLY),
+	}
+	members := make(map[string]string)
+	for name, b := range files {
+		if err := ioutil.WriteFile(filepath.Join(d, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		members["clouds/"+name] = string(b)
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		pf     string
+		points int64
+		actual int64
+		crs    string
+		min    [3]float64
+		max    [3]float64
+		issues []string
+	}{
+		{"cut.las", ModelLAS, "0", 3, 2, "EPSG:2326 + EPSG:5738", [3]float64{0, 0, -5}, [3]float64{10, 20, 30}, []string{
+			"cut.las: error: file is truncated, 2 of 3 point records",
+		}},
+		{"wkt.las", ModelLAS, "6", 2, 2, "Hong Kong 1980 Grid System (EPSG:2326)", [3]float64{0, 0, -5}, [3]float64{10, 20, 30}, nil},
+		{"nocrs.las", ModelLAS, "1", 1, 1, "", [3]float64{0, 0, -5}, [3]float64{10, 20, 30}, []string{
+			"nocrs.las: warning: no crs is defined, the point cloud is not georeferenced",
+		}},
+		{"evlr.las", ModelLAS, "6", 2, 2, "Hong Kong 1980 Grid System (EPSG:2326)", [3]float64{0, 0, -5}, [3]float64{10, 20, 30}, nil},
+		{"neg.las", ModelLAS, "6", 2, 2, "", [3]float64{0, 0, -5}, [3]float64{10, 20, 30}, []string{
+			"neg.las: error: extended variable length record 1 of 1 is truncated",
+			"neg.las: warning: no crs is defined, the point cloud is not georeferenced",
+		}},
+		{"cut.laz", ModelLAZ, "1", 5, -1, "EPSG:2326 + EPSG:5738", [3]float64{0, 0, -5}, [3]float64{10, 20, 30}, []string{
+			"cut.laz: warning: points are compressed without a laszip record",
+			"cut.laz: error: file is truncated, chunk table at 1048576 is beyond the size 313",
+		}},
+		{"scan.e57", ModelE57, "cartesian+intensity", 1500, -1, "EPSG:2326", [3]float64{-1, -2, -1}, [3]float64{5, 2, 3}, []string{
+			"scan.e57: error: file is truncated, points of scan 2 at 99999999 are beyond the size 2048",
+		}},
+		{"points.ply", ModelPLY, "x,y,z,red,green,blue", 2, 2, "", [3]float64{0, 0, 0}, [3]float64{1, 2, 3}, nil},
+	}
+	for _, tt := range tests {
+		rs, err := InspectPointClouds(filepath.Join(d, tt.name))
+		if err != nil || len(rs) != 1 {
+			t.Errorf("InspectPointClouds(%q) = %v, %v, want 1 report", tt.name, rs, err)
+			continue
+		}
+		r := rs[0]
+		var issues []string
+		for _, i := range r.Issues {
+			issues = append(issues, i.String())
+		}
+		if !reflect.DeepEqual(issues, tt.issues) {
+			t.Errorf("InspectPointClouds(%q) issues = %q, want %q", tt.name, issues, tt.issues)
+		}
+		if r.Format != tt.format || r.PointFormat != tt.pf || r.Points != tt.points || r.Actual != tt.actual || r.CRS != tt.crs || r.Min != tt.min || r.Max != tt.max || !r.HasBounds {
+			t.Errorf("InspectPointClouds(%q) = %+v", tt.name, r)
+		}
+	}
+
+	if rs, err := InspectPointClouds(filepath.Join(d, "mesh.ply")); err != nil || len(rs) != 0 {
+		t.Errorf("InspectPointClouds(mesh.ply) = %v, %v, want none", rs, err)
+	}
+
+	// the same in a zip, sorted by name without the mesh
+	p := filepath.Join(d, "clouds.zip")
+	writeZip(t, p, members)
+	rs, err := InspectPointClouds(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rs {
+		got = append(got, r.Name+": "+r.Summary())
+	}
+	want := []string{
+		"clouds/cut.las: las 1.2, point format 0, 2 of 3 points, crs EPSG:2326 + EPSG:5738",
+		"clouds/cut.laz: laz 1.2, point format 1, unknown of 5 points, crs EPSG:2326 + EPSG:5738",
+		"clouds/evlr.las: las 1.4, point format 6, 2 of 2 points, crs Hong Kong 1980 Grid System (EPSG:2326)",
+		"clouds/neg.las: las 1.4, point format 6, 2 of 2 points, crs none",
+		"clouds/nocrs.las: las 1.2, point format 1, 1 of 1 points, crs none",
+		"clouds/points.ply: ply ascii, point format x,y,z,red,green,blue, 2 of 2 points, crs none",
+		"clouds/scan.e57: e57 1.0, point format cartesian+intensity, unknown of 1500 points, crs EPSG:2326",
+		"clouds/wkt.las: las 1.4, point format 6, 2 of 2 points, crs Hong Kong 1980 Grid System (EPSG:2326)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InspectPointClouds(zip) =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,248 @@
+package file
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/gql"
+)
+
+// sha1Regex matches a hex encoded sha1 checksum.
+var sha1Regex = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// sha1MetaHeader is the header of the user metadata of an object in a S3
+// compatible bucket for the sha1 checksum of the object.
+const sha1MetaHeader = "X-Amz-Meta-Sha1"
+
+// URLEntry is the url of a remote image, with its sha1 checksum if known.
+// Name is a stable name of the image if the url is not, e.g. presigned.
+type URLEntry struct {
+	URL  string
+	SHA1 string
+	Name string
+}
+
+// Path returns the name of the entry, or its url if the name is empty.
+func (e URLEntry) Path() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.URL
+}
+
+// ReadURLList reads the image urls from a text file of one url per line, or
+// from a csv file of url and an optional checksum column.
+// Empty lines, lines starting with '#' and a header row are skipped.
+func ReadURLList(p string) ([]URLEntry, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows [][]string
+	if strings.ToLower(filepath.Ext(p)) == ".csv" {
+		r := csv.NewReader(f)
+		r.Comment = '#'
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		rows, err = r.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			rows = append(rows, []string{scanner.Text()})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var ret []URLEntry
+	for i, row := range rows {
+		u := strings.TrimSpace(row[0])
+		if u == "" || strings.HasPrefix(u, "#") {
+			continue
+		}
+		if i == 0 && strings.EqualFold(u, "url") {
+			continue
+		}
+		e := URLEntry{URL: u}
+		if len(row) > 1 {
+			e.SHA1 = strings.ToLower(strings.TrimSpace(row[1]))
+		}
+		if err := e.validate(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", p, i+1, err)
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+// validate checks that the url is absolute http or https, and the checksum,
+// if any, is a sha1.
+func (e URLEntry) validate() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%v: %q", errors.ErrURLInvalid, e.URL)
+	}
+	if e.SHA1 != "" && !sha1Regex.MatchString(e.SHA1) {
+		return fmt.Errorf("%v: %q", errors.ErrFileChecksum, e.SHA1)
+	}
+	return nil
+}
+
+// URLFilename returns the unescaped last element of the path of an url.
+func URLFilename(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	base := path.Base(u.Path)
+	if base == "." || base == "/" {
+		return ""
+	}
+	return base
+}
+
+// URLDigester reads image urls from URLs and streams each of them to get its
+// digest without storing it locally. If the checksum of an url is known, or
+// is given in the sha1 user metadata of a S3 object, only the leading bytes
+// are read for the dimension and metadata.
+// Existing is used in the same way as ImageDigester.
+type URLDigester struct {
+	PID      string
+	Existing map[string]bool
+	Client   *http.Client
+	Done     <-chan struct{}
+	URLs     <-chan URLEntry
+	Result   chan<- ImageDigest
+}
+
+// Digest reads entries from URLs and sends digests of the corresponding
+// images on Result until either URLs or Done is closed.
+func (ud *URLDigester) Digest() {
+	for e := range ud.URLs {
+		select {
+		case ud.Result <- ud.work(e):
+		case <-ud.Done:
+			return
+		}
+	}
+}
+
+// Run starts n number of goroutines to digest image urls.
+// If n is not positive, it will be set to number of CPU cores x 4.
+// Return n.
+func (ud *URLDigester) Run(n int) int {
+	if n <= 0 {
+		n = runtime.NumCPU() * 4
+	}
+	var wg sync.WaitGroup
+	wg.Add(n)
+
+	for i := 0; i < n; i++ {
+		go func() {
+			ud.Digest()
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(ud.Result)
+	}()
+
+	return n
+}
+
+// client returns the http client, or the default one if not set.
+func (ud *URLDigester) client() *http.Client {
+	if ud.Client != nil {
+		return ud.Client
+	}
+	return http.DefaultClient
+}
+
+// work streams the image of the url and get its name, size, width, height,
+// gp, sha1 and metadata.
+func (ud *URLDigester) work(e URLEntry) ImageDigest {
+	ret := ImageDigest{
+		Path:     e.Path(),
+		URL:      e.URL,
+		Filename: URLFilename(e.URL),
+	}
+
+	// a. stream the image
+	res, err := ud.client().Get(e.URL)
+	if err != nil {
+		ret.Error = err
+		return ret
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		ret.Error = errors.NetworkError{Code: res.StatusCode, Message: errors.ErrURLFetch.Error()}
+		return ret
+	}
+
+	// b. type, dimension, metadata and checksum if unknown
+	if sum := strings.ToLower(res.Header.Get(sha1MetaHeader)); e.SHA1 == "" && sha1Regex.MatchString(sum) {
+		e.SHA1 = sum
+	}
+	scan, err := ScanReader(res.Body, false, e.SHA1 == "")
+	ret.IsImage = scan.IsImage
+	ret.Filetype = scan.Filetype
+	if err != nil {
+		ret.Error = err
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			ret.Error = errors.ErrFileImageDim
+		}
+		return ret
+	}
+	if !scan.IsImage {
+		ret.Error = errors.ErrFileNotImage
+		return ret
+	}
+	ret.Width = scan.Width
+	ret.Height = scan.Height
+	ret.GP = DimToGigaPixel(scan.Width, scan.Height)
+	ret.Meta = scan.Meta
+	ret.SHA1 = e.SHA1
+	if ret.SHA1 == "" {
+		ret.SHA1 = scan.SHA1
+	}
+	if ret.Filename == "" {
+		ret.Filename = ret.SHA1
+	}
+
+	// c. size from header, or the number of bytes read
+	ret.Filesize = res.ContentLength
+	if ret.Filesize < 0 {
+		ret.Filesize = scan.Filesize
+	}
+
+	// d. check if already uploaded
+	if ud.Existing != nil {
+		ret.Existed = ud.Existing[ret.SHA1]
+		return ret
+	}
+	ret.Existed, err = gql.HasImage(ud.PID, ret.SHA1)
+	if err != nil {
+		ret.Error = err
+	}
+	return ret
+}
@@ -0,0 +1,93 @@
+package file
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadURLList(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-urls-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	sum := strings.Repeat("a", 40)
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []URLEntry
+		wantErr bool
+	}{
+		{"txt", "list.txt", "# nas\nhttp://nas/a.jpg\n\n  https://nas/b%20c.jpg \n", []URLEntry{{URL: "http://nas/a.jpg"}, {URL: "https://nas/b%20c.jpg"}}, false},
+		{"csv", "list.csv", "url,checksum\nhttp://nas/a.jpg," + strings.ToUpper(sum) + "\nhttp://nas/b.jpg\n", []URLEntry{{URL: "http://nas/a.jpg", SHA1: sum}, {URL: "http://nas/b.jpg"}}, false},
+		{"relative url", "bad.txt", "http://nas/a.jpg\n/b.jpg\n", nil, true},
+		{"bad checksum", "bad.csv", "http://nas/a.jpg,xyz\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(d, tt.file)
+			if err := ioutil.WriteFile(p, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadURLList(p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadURLList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ReadURLList() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ReadURLList()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestURLDigester(t *testing.T) {
+	jpg := testImgDir + "nat.jpg"
+	srv := httptest.NewServer(http.FileServer(http.Dir(testImgDir)))
+	defer srv.Close()
+
+	w, h, err := GetImageSize(jpg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, _ := Sha1sum(jpg)
+	size, _ := Filesize(jpg)
+	known := strings.Repeat("b", 40)
+	ud := URLDigester{Existing: map[string]bool{known: true}}
+
+	got := ud.work(URLEntry{URL: srv.URL + "/nat.jpg"})
+	if got.Error != nil || got.Filename != "nat.jpg" || got.Width != w || got.Height != h || got.SHA1 != sum || got.Filesize != size || got.Existed {
+		t.Errorf("work() = %+v, want %d x %d %s %d", got, w, h, sum, size)
+	}
+
+	got = ud.work(URLEntry{URL: srv.URL + "/nat.jpg", SHA1: known})
+	if got.Error != nil || got.Width != w || got.SHA1 != known || !got.Existed {
+		t.Errorf("work() with checksum = %+v, want existed %s", got, known)
+	}
+
+	meta := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amz-Meta-Sha1", strings.ToUpper(known))
+		http.ServeFile(w, r, jpg)
+	}))
+	defer meta.Close()
+	got = ud.work(URLEntry{URL: meta.URL + "/a.jpg?X-Amz-Signature=x", Name: "s3://bucket/a.jpg"})
+	if got.Error != nil || got.Path != "s3://bucket/a.jpg" || got.Filename != "a.jpg" || got.SHA1 != known || !got.Existed {
+		t.Errorf("work() with checksum metadata = %+v, want existed %s", got, known)
+	}
+
+	got = ud.work(URLEntry{URL: srv.URL + "/none.jpg"})
+	if got.Error == nil {
+		t.Errorf("work() of missing url = %+v, want error", got)
+	}
+}
@@ -0,0 +1,217 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pendingFile is a file being watched until it is settled.
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// dirWatcher finds the new files of a directory tree by fsnotify, or by
+// walking the tree every interval if watcher is nil.
+type dirWatcher struct {
+	root     string
+	filter   *fileFilter
+	settle   time.Duration
+	interval time.Duration
+	watcher  *fsnotify.Watcher
+	seen     map[string]bool
+	pending  map[string]*pendingFile
+	lastScan time.Time
+}
+
+// WatchFiles starts a goroutine to watch the directory tree at root and send
+// the path of each regular file, the existing ones first, once its size and
+// modification time have not changed for settle, i.e. it is copied completely.
+// Each path is sent once, if it is selected by f as WalkFilter, except that
+// symlinks are not followed.
+// New files are noticed by fsnotify, or by walking the tree every interval if
+// poll is set or fsnotify is not available, e.g. on some network shares.
+// It runs until done is closed, then sends the error, if any, on the error
+// channel.
+func WatchFiles(done <-chan struct{}, root string, f Filter, settle, interval time.Duration, poll bool) (<-chan string, <-chan error) {
+	paths := make(chan string)
+	errc := make(chan error, 1)
+
+	ff, err := f.compile(root)
+	if err != nil {
+		errc <- err
+		close(paths)
+		return paths, errc
+	}
+	dw := &dirWatcher{
+		root:     root,
+		filter:   ff,
+		settle:   settle,
+		interval: interval,
+		seen:     make(map[string]bool),
+		pending:  make(map[string]*pendingFile),
+	}
+	if !poll {
+		if w, err := fsnotify.NewWatcher(); err == nil {
+			dw.watcher = w
+		}
+	}
+
+	go func() {
+		defer close(paths)
+		errc <- dw.run(done, paths)
+	}()
+	return paths, errc
+}
+
+// isPolling tells if the tree is walked every interval instead of watched.
+func (dw *dirWatcher) isPolling() bool {
+	return dw.watcher == nil
+}
+
+// fallback stops watching by fsnotify and polls instead.
+func (dw *dirWatcher) fallback() {
+	if dw.watcher != nil {
+		dw.watcher.Close()
+		dw.watcher = nil
+	}
+}
+
+// run scans the tree, then rescans the changes until done is closed.
+func (dw *dirWatcher) run(done <-chan struct{}, paths chan<- string) error {
+	defer dw.fallback()
+	if err := dw.scan(dw.root); err != nil {
+		return err
+	}
+
+	tick := dw.settle / 2
+	if tick < time.Millisecond*100 {
+		tick = time.Millisecond * 100
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		var events <-chan fsnotify.Event
+		var errs <-chan error
+		if !dw.isPolling() {
+			events = dw.watcher.Events
+			errs = dw.watcher.Errors
+		}
+
+		select {
+		case <-done:
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				dw.fallback()
+				continue
+			}
+			if ev.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+				if err := dw.scan(ev.Name); err != nil {
+					return err
+				}
+			}
+		case _, ok := <-errs:
+			// e.g. the event queue overflowed, some files may be missed
+			if !ok {
+				dw.fallback()
+			}
+			if err := dw.scan(dw.root); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if dw.isPolling() && time.Since(dw.lastScan) >= dw.interval {
+				if err := dw.scan(dw.root); err != nil {
+					return err
+				}
+			}
+			for _, p := range dw.settled() {
+				select {
+				case paths <- p:
+				case <-done:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// scan walks the tree at p for new files, and watches each of its
+// directories. It falls back to polling if a directory could not be watched.
+func (dw *dirWatcher) scan(p string) error {
+	if p == dw.root {
+		dw.lastScan = time.Now()
+	}
+	err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// removed while walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if dw.filter.skipDir(path) {
+				return filepath.SkipDir
+			}
+			if !dw.isPolling() && dw.watcher.Add(path) != nil {
+				dw.fallback()
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || dw.seen[path] || dw.pending[path] != nil {
+			return nil
+		}
+		if !dw.filter.matchPath(path, dw.filter.rel(path)) {
+			return nil
+		}
+		dw.pending[path] = &pendingFile{size: info.Size(), modTime: info.ModTime(), since: time.Now()}
+		return nil
+	})
+	if os.IsNotExist(err) && p != dw.root {
+		return nil
+	}
+	return err
+}
+
+// settled returns the sorted paths of the pending files which have not
+// changed for settle and are selected by the filter, and marks them seen.
+func (dw *dirWatcher) settled() []string {
+	var ret []string
+	now := time.Now()
+	for p, pf := range dw.pending {
+		info, err := os.Stat(p)
+		if err != nil {
+			delete(dw.pending, p)
+			continue
+		}
+		if info.Size() != pf.size || !info.ModTime().Equal(pf.modTime) {
+			pf.size = info.Size()
+			pf.modTime = info.ModTime()
+			pf.since = now
+			continue
+		}
+		if now.Sub(pf.since) < dw.settle {
+			continue
+		}
+		delete(dw.pending, p)
+		dw.seen[p] = true
+		ret = append(ret, p)
+	}
+	sort.Strings(ret)
+
+	// the exif is read once the file is complete
+	var selected []string
+	for _, p := range ret {
+		if ok, _ := dw.filter.accept(p, dw.filter.rel(p)); ok {
+			selected = append(selected, p)
+		}
+	}
+	return selected
+}
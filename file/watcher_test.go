@@ -0,0 +1,76 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFiles(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		t.Run(map[bool]string{false: "fsnotify", true: "poll"}[poll], func(t *testing.T) {
+			d, err := ioutil.TempDir("", "alti-cli-watch-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(d)
+
+			old := filepath.Join(d, "old.jpg")
+			if err := ioutil.WriteFile(old, []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan struct{})
+			paths, errc := WatchFiles(done, d, Filter{Skip: `\.txt$`}, time.Millisecond*300, time.Millisecond*100, poll)
+
+			// a file still being copied in a new sub directory
+			sub := filepath.Join(d, "flight-2")
+			if err := os.Mkdir(sub, 0755); err != nil {
+				t.Fatal(err)
+			}
+			grow := filepath.Join(sub, "new.jpg")
+			f, err := os.Create(grow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ioutil.WriteFile(filepath.Join(sub, "log.txt"), []byte("skipped"), 0644)
+			started := time.Now()
+			for i := 0; i < 5; i++ {
+				f.Write([]byte("chunk"))
+				time.Sleep(time.Millisecond * 100)
+			}
+			f.Close()
+			copied := time.Now()
+
+			want := []string{old, grow}
+			for _, w := range want {
+				select {
+				case p := <-paths:
+					if p != w {
+						t.Fatalf("WatchFiles() sent %q, want %q", p, w)
+					}
+					if p == grow && time.Now().Before(copied) {
+						t.Errorf("WatchFiles() sent %q after %s, before it was copied", p, time.Since(started))
+					}
+				case <-time.After(time.Second * 5):
+					t.Fatalf("WatchFiles() timeout waiting for %q", w)
+				}
+			}
+
+			// nothing more, and stops on done
+			select {
+			case p := <-paths:
+				t.Errorf("WatchFiles() sent %q again", p)
+			case <-time.After(time.Millisecond * 500):
+			}
+			close(done)
+			for range paths {
+			}
+			if err := <-errc; err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package gql
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/jackytck/alti-cli/config"
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/types"
+	"github.com/machinebox/graphql"
+)
+
+// checksumPageSize is the number of images fetched per page for indexing.
+const checksumPageSize = 200
+
+// AllImageChecksums queries the ids, states and checksums of the project images by cursor.
+func AllImageChecksums(pid string, first int, after string) ([]types.ProjectImage, *types.PageInfo, error) {
+	config := config.Load()
+	active := config.GetActive()
+	client := graphql.NewClient(active.Endpoint + "/graphql")
+
+	// make a request
+	req := graphql.NewRequest(`
+		query ($id: ID!, $first: Int, $after: String) {
+			project(id: $id) {
+				allImages(first: $first, after: $after) {
+					pageInfo {
+						hasNextPage
+						endCursor
+					}
+					edges {
+						node {
+							id
+							state
+							checksum
+						}
+					}
+				}
+			}
+		}
+	`)
+	req.Var("id", pid)
+	if first > 0 {
+		req.Var("first", first)
+	}
+	req.Var("after", after)
+
+	req.Header.Set("key", active.Key)
+	req.Header.Set("altitoken", active.Token)
+
+	// define a Context for the request
+	ctx := context.Background()
+
+	// run it and capture the response
+	var res allImgsRes
+	if err := client.Run(ctx, req, &res); err != nil {
+		switch err.(type) {
+		case *url.Error:
+			return nil, nil, errors.ErrOffline
+		default:
+			return nil, nil, err
+		}
+	}
+
+	var ret []types.ProjectImage
+	for _, e := range res.Project.AllImages.Edges {
+		ret = append(ret, e.Node)
+	}
+	pi := res.Project.AllImages.PageInfo
+	return ret, &pi, nil
+}
+
+// ImageChecksumSet pages through all of the project images once and returns
+// the set of their checksums, for checking duplicates locally instead of
+// calling HasImage for each image.
+func ImageChecksumSet(pid string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	if pid == "" {
+		return ret, nil
+	}
+
+	after := ""
+	for {
+		imgs, page, err := AllImageChecksums(pid, checksumPageSize, after)
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range imgs {
+			if img.Checksum != "" {
+				ret[img.Checksum] = true
+			}
+		}
+		if page == nil || !page.HasNextPage {
+			break
+		}
+		after = page.EndCursor
+	}
+	return ret, nil
+}
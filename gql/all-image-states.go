@@ -0,0 +1,93 @@
+package gql
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/jackytck/alti-cli/config"
+	"github.com/jackytck/alti-cli/errors"
+	"github.com/jackytck/alti-cli/types"
+	"github.com/machinebox/graphql"
+)
+
+// statePageSize is the number of images fetched per page for polling states.
+const statePageSize = 200
+
+// AllImageStates queries the ids, states and errors of the project images by cursor.
+func AllImageStates(pid string, first int, after string) ([]types.ProjectImage, *types.PageInfo, error) {
+	config := config.Load()
+	active := config.GetActive()
+	client := graphql.NewClient(active.Endpoint + "/graphql")
+
+	// make a request
+	req := graphql.NewRequest(`
+		query ($id: ID!, $first: Int, $after: String) {
+			project(id: $id) {
+				allImages(first: $first, after: $after) {
+					pageInfo {
+						hasNextPage
+						endCursor
+					}
+					edges {
+						node {
+							id
+							state
+							error
+						}
+					}
+				}
+			}
+		}
+	`)
+	req.Var("id", pid)
+	if first > 0 {
+		req.Var("first", first)
+	}
+	req.Var("after", after)
+
+	req.Header.Set("key", active.Key)
+	req.Header.Set("altitoken", active.Token)
+
+	// define a Context for the request
+	ctx := context.Background()
+
+	// run it and capture the response
+	var res allImgsRes
+	if err := client.Run(ctx, req, &res); err != nil {
+		switch err.(type) {
+		case *url.Error:
+			return nil, nil, errors.ErrOffline
+		default:
+			return nil, nil, err
+		}
+	}
+
+	var ret []types.ProjectImage
+	for _, e := range res.Project.AllImages.Edges {
+		ret = append(ret, e.Node)
+	}
+	pi := res.Project.AllImages.PageInfo
+	return ret, &pi, nil
+}
+
+// ImageStates pages through all of the project images once and returns
+// them keyed by image id, for polling the states of many images at once
+// instead of calling ProjectImage for each image.
+func ImageStates(pid string) (map[string]types.ProjectImage, error) {
+	ret := make(map[string]types.ProjectImage)
+	after := ""
+	for {
+		imgs, page, err := AllImageStates(pid, statePageSize, after)
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range imgs {
+			ret[img.ID] = img
+		}
+		if page == nil || !page.HasNextPage {
+			break
+		}
+		after = page.EndCursor
+	}
+	return ret, nil
+}
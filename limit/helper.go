@@ -0,0 +1,131 @@
+package limit
+
+import (
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/jackytck/alti-cli/errors"
+)
+
+// chunkSize is the max number of bytes passed through the limiter at a time.
+const chunkSize = 32 * 1024
+
+// global is the limiter shared by all of the uploads of all methods.
+var global = NewLimiter(0)
+
+// window is the upload window shared by all of the uploads.
+var window *Window
+var windowLock sync.Mutex
+var pausing bool
+
+// ParseRate parses a rate like "20MB/s" or "512KB" into bytes per second.
+// An empty string means unlimited.
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/s"), "ps")
+	var b datasize.ByteSize
+	if err := b.UnmarshalText([]byte(s)); err != nil {
+		return 0, errors.ErrRateInvalid
+	}
+	return int64(b.Bytes()), nil
+}
+
+// SetRate sets the global upload rate in bytes per second.
+// Non-positive means unlimited.
+func SetRate(bytesPerSec int64) {
+	global.SetRate(bytesPerSec)
+}
+
+// SetWindow sets the global upload window. Nil means uploading at any time.
+func SetWindow(w *Window) {
+	windowLock.Lock()
+	defer windowLock.Unlock()
+	window = w
+}
+
+// WaitN blocks until n bytes are allowed by the global limiter.
+func WaitN(n int) {
+	global.WaitN(n)
+}
+
+// WaitWindow blocks until now is within the global upload window, if any.
+func WaitWindow() {
+	for {
+		windowLock.Lock()
+		w := window
+		now := time.Now()
+		if w == nil || w.Contains(now) {
+			if pausing {
+				log.Printf("Upload window %s is open, resuming...\n", w)
+			}
+			pausing = false
+			windowLock.Unlock()
+			return
+		}
+		next := w.Next(now)
+		if !pausing {
+			log.Printf("Outside upload window %s, pausing until %s...\n", w, next.Format("2006-01-02 15:04"))
+		}
+		pausing = true
+		windowLock.Unlock()
+
+		// re-check at least every minute in case the window is changed
+		d := next.Sub(now)
+		if d > time.Minute {
+			d = time.Minute
+		}
+		time.Sleep(d)
+	}
+}
+
+// Reader wraps r so that reading from it is limited by the global limiter.
+func Reader(r io.Reader) io.Reader {
+	return &reader{r}
+}
+
+type reader struct {
+	r io.Reader
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := r.r.Read(p)
+	WaitN(n)
+	return n, err
+}
+
+// Writer wraps w so that writing to it is limited by the global limiter.
+func Writer(w io.Writer) io.Writer {
+	return &writer{w}
+}
+
+type writer struct {
+	w io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		c := p
+		if len(c) > chunkSize {
+			c = c[:chunkSize]
+		}
+		WaitN(len(c))
+		n, err := w.w.Write(c)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(c):]
+	}
+	return written, nil
+}
@@ -0,0 +1,71 @@
+package limit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket limiting the number of bytes per second.
+// It is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second, non-positive means unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a new Limiter allowing bytesPerSec bytes per second.
+// If bytesPerSec is not positive, it is unlimited.
+func NewLimiter(bytesPerSec int64) *Limiter {
+	l := Limiter{}
+	l.SetRate(bytesPerSec)
+	return &l
+}
+
+// SetRate resets the rate of the limiter. The burst is one second worth of bytes.
+func (l *Limiter) SetRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(bytesPerSec)
+	l.burst = l.rate
+	l.tokens = l.burst
+	l.last = time.Now()
+}
+
+// Rate returns the current rate in bytes per second.
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate)
+}
+
+// WaitN blocks until n bytes are allowed to pass.
+func (l *Limiter) WaitN(n int) {
+	if n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	// reserve the tokens and sleep for the deficit, if any
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
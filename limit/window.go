@@ -0,0 +1,82 @@
+package limit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackytck/alti-cli/errors"
+)
+
+// Window is a daily time window, e.g. 22:00-06:00. The end is exclusive.
+// A window with its end before its start spans midnight.
+type Window struct {
+	Start time.Duration // offset from midnight
+	End   time.Duration // offset from midnight
+}
+
+// ParseWindow parses a window in the format of "HH:MM-HH:MM".
+func ParseWindow(s string) (*Window, error) {
+	toks := strings.Split(strings.TrimSpace(s), "-")
+	if len(toks) != 2 {
+		return nil, errors.ErrWindowInvalid
+	}
+	start, err := parseClock(toks[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(toks[1])
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, errors.ErrWindowInvalid
+	}
+	return &Window{Start: start, End: end}, nil
+}
+
+// parseClock parses "HH:MM" into the offset from midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, errors.ErrWindowInvalid
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// offset returns the offset of t from its local midnight.
+func offset(t time.Time) time.Duration {
+	y, m, d := t.Date()
+	return t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+}
+
+// Contains tells if t is within the window.
+func (w *Window) Contains(t time.Time) bool {
+	o := offset(t)
+	if w.Start < w.End {
+		return o >= w.Start && o < w.End
+	}
+	// spans midnight
+	return o >= w.Start || o < w.End
+}
+
+// Next returns the next time the window opens at or after t.
+// Return t itself if it is within the window.
+func (w *Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	y, m, d := t.Date()
+	open := time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(w.Start)
+	if open.Before(t) {
+		open = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Add(w.Start)
+	}
+	return open
+}
+
+func (w *Window) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.Start) + "-" + clock(w.End)
+}
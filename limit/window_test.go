@@ -0,0 +1,91 @@
+package limit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"day", "09:00-17:30", "09:00-17:30", false},
+		{"overnight", " 22:00 - 06:00 ", "22:00-06:00", false},
+		{"empty", "", "", true},
+		{"same", "10:00-10:00", "", true},
+		{"bad hour", "25:00-06:00", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseWindow(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseWindow() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("ParseWindow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowContainsNext(t *testing.T) {
+	w, _ := ParseWindow("22:00-06:00")
+	at := func(h, m int) time.Time {
+		return time.Date(2019, 11, 6, h, m, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name string
+		t    time.Time
+		in   bool
+		next time.Time
+	}{
+		{"before midnight", at(23, 0), true, at(23, 0)},
+		{"after midnight", at(1, 0), true, at(1, 0)},
+		{"end is exclusive", at(6, 0), false, at(22, 0)},
+		{"afternoon", at(15, 0), false, at(22, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := w.Contains(tt.t); got != tt.in {
+				t.Errorf("Contains() = %v, want %v", got, tt.in)
+			}
+			if got := w.Next(tt.t); !got.Equal(tt.next) {
+				t.Errorf("Next() = %v, want %v", got, tt.next)
+			}
+		})
+	}
+
+	d, _ := ParseWindow("09:00-17:00")
+	if got, want := d.Next(at(18, 0)), at(9, 0).AddDate(0, 0, 1); !got.Equal(want) {
+		t.Errorf("Next() = %v, want %v", got, want)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"20MB/s", 20 * 1024 * 1024, false},
+		{"512KB", 512 * 1024, false},
+		{"fast", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseRate(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
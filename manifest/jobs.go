@@ -0,0 +1,147 @@
+package manifest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackytck/alti-cli/errors"
+)
+
+// Job is a row of a jobs file of batch import: a source of images into an
+// existing project of PID, or a new project of Name, Type and Visibility,
+// with an optional meta file and task type.
+type Job struct {
+	Line       int
+	Source     string
+	PID        string
+	Name       string
+	Type       string
+	Visibility string
+	Meta       string
+	Task       string
+}
+
+// JobColumns are the columns of a jobs file, in any order. Only source and
+// one of pid and name are required.
+var JobColumns = []string{"source", "pid", "name", "type", "visibility", "meta", "task"}
+
+// Title returns the project name, or the pid if it is not set.
+func (j Job) Title() string {
+	if j.Name != "" {
+		return j.Name
+	}
+	return j.PID
+}
+
+// ReadJobs reads and validates the jobs file at p, a csv file with a header
+// of JobColumns. The default project type and visibility are free and public.
+// Local paths are relative to the jobs file.
+func ReadJobs(p string) ([]Job, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s: %v", errors.ErrJobsInvalid, p, err)
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if !contains(JobColumns, h) {
+			return nil, fmt.Errorf("%v: %s:1: unknown column %q, should be of %q", errors.ErrJobsInvalid, p, h, JobColumns)
+		}
+		cols[h] = i
+	}
+	if _, ok := cols["source"]; !ok {
+		return nil, fmt.Errorf("%v: %s:1: column \"source\" is required", errors.ErrJobsInvalid, p)
+	}
+
+	dir := filepath.Dir(p)
+	var ret []Job
+	for line := 2; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s: %v", errors.ErrJobsInvalid, p, err)
+		}
+		get := func(c string) string {
+			i, ok := cols[c]
+			if !ok || i >= len(rec) {
+				return ""
+			}
+			return strings.TrimSpace(rec[i])
+		}
+		j := Job{
+			Line:       line,
+			Source:     localPath(dir, get("source")),
+			PID:        get("pid"),
+			Name:       get("name"),
+			Type:       get("type"),
+			Visibility: get("visibility"),
+			Meta:       localPath(dir, get("meta")),
+			Task:       get("task"),
+		}
+		if j.Source == "" && j.PID == "" && j.Name == "" {
+			// blank line
+			continue
+		}
+		if j.Type == "" {
+			j.Type = "free"
+		}
+		if j.Visibility == "" {
+			j.Visibility = "public"
+		}
+		if err := j.Validate(); err != nil {
+			return nil, fmt.Errorf("%v: %s:%d: %v", errors.ErrJobsInvalid, p, line, err)
+		}
+		ret = append(ret, j)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("%v: %s: no job is found", errors.ErrJobsInvalid, p)
+	}
+	return ret, nil
+}
+
+// Validate checks the required fields and the choices.
+func (j Job) Validate() error {
+	if j.Source == "" {
+		return fmt.Errorf("source is required")
+	}
+	if j.PID == "" && j.Name == "" {
+		return fmt.Errorf("one of pid and name is required")
+	}
+	if !contains(projectTypes, j.Type) {
+		return fmt.Errorf("type should be one of %q", projectTypes)
+	}
+	if !contains(visibilities, j.Visibility) {
+		return fmt.Errorf("visibility should be one of %q", visibilities)
+	}
+	return nil
+}
+
+// WriteJobs writes the jobs to w in the format of ReadJobs.
+func WriteJobs(w io.Writer, jobs []Job) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(JobColumns); err != nil {
+		return err
+	}
+	for _, j := range jobs {
+		if err := writer.Write([]string{j.Source, j.PID, j.Name, j.Type, j.Visibility, j.Meta, j.Task}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
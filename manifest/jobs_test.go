@@ -0,0 +1,74 @@
+package manifest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadJobs(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-jobs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", "\ufeffName,Source,Meta,Task,PID\nSite A,site-a,site-a/pose.txt,Native,\n,,,,\n,/data/site-b.zip,,,5d37e\n", ""},
+		{"no source", "pid,name\n5d37e,a\n", `"source" is required`},
+		{"unknown column", "source,name,tpye\na,a,pro\n", `unknown column "tpye"`},
+		{"no project", "source,name,pid\na,a,\nb,,\n", "jobs.csv:3: one of pid and name"},
+		{"bad visibility", "source,name,visibility\na,a,hidden\n", "jobs.csv:2: visibility"},
+		{"empty", "source,name\n", "no job"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(d, "jobs.csv")
+			if err := ioutil.WriteFile(p, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			jobs, err := ReadJobs(p)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadJobs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadJobs() error = %v", err)
+			}
+			want := []Job{
+				{Line: 2, Source: filepath.Join(d, "site-a"), Name: "Site A", Type: "free", Visibility: "public", Meta: filepath.Join(d, "site-a/pose.txt"), Task: "Native"},
+				{Line: 4, Source: "/data/site-b.zip", PID: "5d37e", Type: "free", Visibility: "public"},
+			}
+			if !reflect.DeepEqual(jobs, want) {
+				t.Fatalf("ReadJobs() = %+v, want %+v", jobs, want)
+			}
+
+			// round trip
+			var buf bytes.Buffer
+			if err := WriteJobs(&buf, jobs); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(p, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadJobs(p)
+			if err != nil {
+				t.Fatalf("ReadJobs() of WriteJobs() error = %v", err)
+			}
+			want[1].Line = 3
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ReadJobs() of WriteJobs() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackytck/alti-cli/errors"
+	homedir "github.com/mitchellh/go-homedir"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Manifest describes a reconstruction project, its image sources, meta files
+// and task, to be reconciled idempotently.
+type Manifest struct {
+	Project Project  `yaml:"project"`
+	Images  []Source `yaml:"images"`
+	Meta    []string `yaml:"meta"`
+	Upload  Upload   `yaml:"upload"`
+	// Task is the task type to start when everything is ready, e.g. Native.
+	// No task is started if empty.
+	Task string `yaml:"task"`
+
+	path string
+}
+
+// Project is the project to be created if missing.
+type Project struct {
+	Name       string `yaml:"name"`
+	Type       string `yaml:"type"`
+	Visibility string `yaml:"visibility"`
+}
+
+// Source is a source of images, exactly one of Dir, URLs and From is set,
+// which are the same as the flags of `import image`. Endpoint, Region and
+// Profile are for From only.
+type Source struct {
+	Dir      string `yaml:"dir"`
+	URLs     string `yaml:"urls"`
+	From     string `yaml:"from"`
+	Skip     string `yaml:"skip"`
+	Endpoint string `yaml:"endpoint"`
+	Region   string `yaml:"region"`
+	Profile  string `yaml:"profile"`
+}
+
+// Name returns the dir, urls or from of the source, whichever is set.
+func (s Source) Name() string {
+	return s.Dir + s.URLs + s.From
+}
+
+// Upload is the upload method and bucket, suggested if empty.
+type Upload struct {
+	Method string `yaml:"method"`
+	Bucket string `yaml:"bucket"`
+}
+
+// Lock records the project created for a manifest.
+type Lock struct {
+	PID     string    `yaml:"pid"`
+	Name    string    `yaml:"name"`
+	Updated time.Time `yaml:"updated"`
+}
+
+var projectTypes = []string{"free", "pro"}
+var visibilities = []string{"public", "unlisted", "private"}
+
+// Load reads and validates the manifest at p. The default project type and
+// visibility are free and public. Local paths are relative to the manifest.
+func Load(p string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	m := Manifest{path: p}
+	if err := yaml.UnmarshalStrict(b, &m); err != nil {
+		return nil, fmt.Errorf("%v: %s: %v", errors.ErrManifestInvalid, p, err)
+	}
+	if m.Project.Type == "" {
+		m.Project.Type = "free"
+	}
+	if m.Project.Visibility == "" {
+		m.Project.Visibility = "public"
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %s: %v", errors.ErrManifestInvalid, p, err)
+	}
+
+	dir := filepath.Dir(p)
+	for i, s := range m.Images {
+		m.Images[i].Dir = localPath(dir, s.Dir)
+		m.Images[i].URLs = localPath(dir, s.URLs)
+	}
+	for i, f := range m.Meta {
+		m.Meta[i] = localPath(dir, f)
+	}
+	return &m, nil
+}
+
+// Validate checks the required fields and the choices.
+func (m *Manifest) Validate() error {
+	if strings.TrimSpace(m.Project.Name) == "" {
+		return fmt.Errorf("project.name is required")
+	}
+	if !contains(projectTypes, m.Project.Type) {
+		return fmt.Errorf("project.type should be one of %q", projectTypes)
+	}
+	if !contains(visibilities, m.Project.Visibility) {
+		return fmt.Errorf("project.visibility should be one of %q", visibilities)
+	}
+	if len(m.Images) == 0 {
+		return fmt.Errorf("at least one of images is required")
+	}
+	for i, s := range m.Images {
+		n := 0
+		for _, v := range []string{s.Dir, s.URLs, s.From} {
+			if v != "" {
+				n++
+			}
+		}
+		if n != 1 {
+			return fmt.Errorf("images[%d] should have exactly one of dir, urls and from", i)
+		}
+	}
+	for i, f := range m.Meta {
+		if f == "" {
+			return fmt.Errorf("meta[%d] is empty", i)
+		}
+	}
+	return nil
+}
+
+// Path returns the path of the manifest.
+func (m *Manifest) Path() string {
+	return m.path
+}
+
+// LockPath returns the path of the lock file, which is the manifest path with
+// the extension replaced by .lock.
+func (m *Manifest) LockPath() string {
+	return strings.TrimSuffix(m.path, filepath.Ext(m.path)) + ".lock"
+}
+
+// ReadLock reads the lock of the manifest. An empty lock is returned if it
+// does not exist yet.
+func (m *Manifest) ReadLock() (Lock, error) {
+	var l Lock
+	b, err := ioutil.ReadFile(m.LockPath())
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return l, err
+	}
+	err = yaml.Unmarshal(b, &l)
+	return l, err
+}
+
+// WriteLock records the pid of the project of the manifest.
+func (m *Manifest) WriteLock(pid string) error {
+	l := Lock{PID: pid, Name: m.Project.Name, Updated: time.Now()}
+	b, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(m.LockPath(), b, 0644)
+}
+
+// localPath expands ~ of p, and resolves it relative to dir.
+func localPath(dir, p string) string {
+	if p == "" {
+		return p
+	}
+	if e, err := homedir.Expand(p); err == nil {
+		p = e
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	return p
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
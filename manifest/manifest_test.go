@@ -0,0 +1,85 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-manifest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"valid", `
+project:
+  name: Site A
+  visibility: private
+images:
+  - dir: flight-01
+    skip: .small
+  - from: s3://survey/site-a/
+meta:
+  - flight-01/pose.txt
+upload:
+  method: s3
+task: Native
+`, false},
+		{"no name", "images:\n  - dir: a\n", true},
+		{"bad type", "project:\n  name: a\n  type: gold\nimages:\n  - dir: a\n", true},
+		{"no images", "project:\n  name: a\n", true},
+		{"two sources", "project:\n  name: a\nimages:\n  - dir: a\n    urls: b.csv\n", true},
+		{"unknown field", "project:\n  name: a\n  tpye: pro\nimages:\n  - dir: a\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(d, "site.yaml")
+			if err := ioutil.WriteFile(p, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			m, err := Load(p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if m.Project.Type != "free" || m.Project.Visibility != "private" {
+				t.Errorf("Load() project = %+v, want free and private", m.Project)
+			}
+			if m.Images[0].Dir != filepath.Join(d, "flight-01") || m.Images[1].From != "s3://survey/site-a/" || m.Meta[0] != filepath.Join(d, "flight-01/pose.txt") {
+				t.Errorf("Load() paths = %+v %q, want relative to manifest", m.Images, m.Meta)
+			}
+		})
+	}
+}
+
+func TestLock(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-manifest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	m := Manifest{Project: Project{Name: "Site A"}, path: filepath.Join(d, "site.yaml")}
+	if m.LockPath() != filepath.Join(d, "site.lock") {
+		t.Errorf("LockPath() = %q", m.LockPath())
+	}
+	if l, err := m.ReadLock(); err != nil || l.PID != "" {
+		t.Fatalf("ReadLock() before apply = %+v, %v, want empty", l, err)
+	}
+	if err := m.WriteLock("5d37e"); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := m.ReadLock(); err != nil || l.PID != "5d37e" || l.Name != "Site A" {
+		t.Errorf("ReadLock() = %+v, %v, want pid 5d37e", l, err)
+	}
+}
@@ -0,0 +1,259 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Modes of rendering the progress.
+const (
+	ModeAuto = "auto" // bar if stdout is a terminal, otherwise json if it has a stream
+	ModeBar  = "bar"  // aggregate progress bar
+	ModeJSON = "json" // newline-delimited json events
+	ModeNone = "none" // nothing
+)
+
+// barWidth is the number of characters of the progress bar.
+const barWidth = 30
+
+// Tracker aggregates the progress of all of the transfers and renders it
+// periodically.
+type Tracker struct {
+	Verb string // e.g. "Uploading" or "Downloading"
+	Mode string // either ModeBar or ModeJSON
+	Out  io.Writer
+
+	mu        sync.Mutex
+	total     int64 // total bytes of known size
+	bytes     int64 // transferred bytes
+	files     int
+	filesDone int
+	errors    int
+	start     time.Time
+	last      Event // last rendered progress
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// global is the tracker of the current command, nil if disabled.
+var global *Tracker
+var globalLock sync.Mutex
+
+// Start starts rendering the progress of all of the transfers of the
+// current command, a bar to stdout or json events to events. The events are
+// kept apart from stdout, which has the tables and prompts of the command, so
+// json is not rendered without events. verb describes the transfers, e.g.
+// "Uploading". Return a func for stopping the rendering.
+func Start(mode, verb string, events io.Writer) func() {
+	if mode == "" || mode == ModeAuto {
+		mode = ModeJSON
+		if terminal.IsTerminal(int(os.Stdout.Fd())) {
+			mode = ModeBar
+		}
+	}
+	out := io.Writer(os.Stdout)
+	if mode == ModeJSON {
+		out = events
+	}
+	if (mode != ModeBar && mode != ModeJSON) || out == nil {
+		return func() {}
+	}
+
+	t := &Tracker{
+		Verb:    verb,
+		Mode:    mode,
+		Out:     out,
+		start:   time.Now(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	globalLock.Lock()
+	prev := global
+	global = t
+	globalLock.Unlock()
+	if prev != nil {
+		// e.g. quick start calls import image and then import meta
+		prev.Stop()
+	}
+
+	go t.loop()
+	return func() {
+		globalLock.Lock()
+		if global == t {
+			global = nil
+		}
+		globalLock.Unlock()
+		t.Stop()
+	}
+}
+
+// IsValidMode tells if mode is one of the rendering modes.
+func IsValidMode(mode string) bool {
+	switch mode {
+	case ModeAuto, ModeBar, ModeJSON, ModeNone:
+		return true
+	}
+	return false
+}
+
+// Begin begins tracking the transfer of a file of size bytes. Size is
+// negative if unknown. Return nil if progress tracking is disabled.
+func Begin(name string, size int64) *Transfer {
+	globalLock.Lock()
+	t := global
+	globalLock.Unlock()
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	t.files++
+	if size > 0 {
+		t.total += size
+	}
+	t.mu.Unlock()
+	t.emit(Event{Event: "start", File: name, Size: size})
+	return &Transfer{tracker: t, name: name, size: size}
+}
+
+// Stop stops rendering and renders the final progress.
+func (t *Tracker) Stop() {
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+	close(t.stop)
+	<-t.stopped
+}
+
+// loop renders the progress periodically until stopped.
+func (t *Tracker) loop() {
+	defer close(t.stopped)
+	interval := time.Millisecond * 200
+	if t.Mode == ModeJSON {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.render(false)
+		case <-t.stop:
+			t.render(true)
+			return
+		}
+	}
+}
+
+// Snapshot returns the current aggregate progress.
+func (t *Tracker) Snapshot() Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := Event{
+		Event:     "progress",
+		Bytes:     t.bytes,
+		Total:     t.total,
+		Files:     t.files,
+		FilesDone: t.filesDone,
+		Errors:    t.errors,
+	}
+	elapsed := time.Since(t.start).Seconds()
+	if elapsed > 0 {
+		e.Rate = float64(t.bytes) / elapsed
+	}
+	if e.Rate > 0 && t.total > t.bytes {
+		e.ETA = float64(t.total-t.bytes) / e.Rate
+	}
+	return e
+}
+
+// render renders the aggregate progress once.
+func (t *Tracker) render(final bool) {
+	e := t.Snapshot()
+	if e.Files == 0 {
+		return
+	}
+	// skip if nothing has changed since last render
+	if !final && e.Bytes == t.last.Bytes && e.Files == t.last.Files &&
+		e.FilesDone == t.last.FilesDone && e.Errors == t.last.Errors {
+		return
+	}
+	t.last = e
+	if t.Mode == ModeJSON {
+		if final {
+			e.Event = "summary"
+		}
+		t.emit(e)
+		return
+	}
+	line := fmt.Sprintf("\r%s %s %s", t.Verb, bar(e.Bytes, e.Total), summary(e))
+	if final {
+		line += "\n"
+	}
+	t.mu.Lock()
+	fmt.Fprint(t.Out, line)
+	t.mu.Unlock()
+}
+
+// emit writes e as a json line in json mode.
+func (t *Tracker) emit(e Event) {
+	if t.Mode != ModeJSON {
+		return
+	}
+	e.Time = time.Now()
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintln(t.Out, string(b))
+}
+
+// bar draws a progress bar of bytes out of total.
+func bar(bytes, total int64) string {
+	if total <= 0 {
+		return "[" + strings.Repeat("-", barWidth) + "]"
+	}
+	n := int(bytes * barWidth / total)
+	if n > barWidth {
+		n = barWidth
+	}
+	return "[" + strings.Repeat("=", n) + strings.Repeat(" ", barWidth-n) + "]"
+}
+
+// summary describes the percentage, bytes, throughput, eta and files.
+func summary(e Event) string {
+	var pct float64
+	if e.Total > 0 {
+		pct = float64(e.Bytes) * 100 / float64(e.Total)
+	}
+	eta := "--"
+	if e.ETA > 0 {
+		eta = (time.Duration(e.ETA) * time.Second).String()
+	}
+	s := fmt.Sprintf("%5.1f%% %s/%s %s/s ETA %s (%d/%d files)",
+		pct, hr(e.Bytes), hr(e.Total), hr(int64(e.Rate)), eta, e.FilesDone, e.Files)
+	if e.Errors > 0 {
+		s += fmt.Sprintf(" %d errors", e.Errors)
+	}
+	// pad to overwrite the longer previous line
+	return s + "   "
+}
+
+// hr returns the human readable size.
+func hr(n int64) string {
+	if n < 0 {
+		n = 0
+	}
+	return datasize.ByteSize(n).HR()
+}
@@ -0,0 +1,79 @@
+package progress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/jackytck/alti-cli/errors"
+)
+
+func TestTransfer(t *testing.T) {
+	// disabled
+	var nilTr *Transfer
+	r := strings.NewReader("abc")
+	if nilTr.Reader(r) != io.Reader(r) {
+		t.Errorf("Reader() of nil Transfer should return the same reader")
+	}
+	nilTr.End(nil)
+
+	var out bytes.Buffer
+	tk := &Tracker{Mode: ModeJSON, Out: &out}
+	global = tk
+	defer func() { global = nil }()
+
+	// a failed attempt is discounted
+	tr := Begin("a.jpg", 10)
+	io.Copy(ioutil.Discard, tr.Reader(strings.NewReader("01234")))
+	tr.End(errors.ErrS3Error)
+
+	// then retried successfully
+	tr = Begin("a.jpg", 10)
+	io.Copy(ioutil.Discard, tr.Reader(strings.NewReader("0123456789")))
+	tr.End(nil)
+
+	// unknown size
+	tr = Begin("b.jpg", -1)
+	io.Copy(ioutil.Discard, tr.Reader(strings.NewReader("012")))
+	tr.End(nil)
+
+	e := tk.Snapshot()
+	if e.Bytes != 13 || e.Total != 13 || e.Files != 2 || e.FilesDone != 2 || e.Errors != 1 {
+		t.Errorf("Snapshot() = %+v", e)
+	}
+
+	// json events
+	var events []string
+	sc := bufio.NewScanner(&out)
+	for sc.Scan() {
+		var ev Event
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev.Event)
+	}
+	if got, want := strings.Join(events, ","), "start,done,start,done,start,done"; got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
+
+func TestBar(t *testing.T) {
+	tests := []struct {
+		bytes, total int64
+		want         string
+	}{
+		{0, 0, "[" + strings.Repeat("-", barWidth) + "]"},
+		{0, 10, "[" + strings.Repeat(" ", barWidth) + "]"},
+		{5, 10, "[" + strings.Repeat("=", barWidth/2) + strings.Repeat(" ", barWidth/2) + "]"},
+		{12, 10, "[" + strings.Repeat("=", barWidth) + "]"},
+	}
+	for _, tt := range tests {
+		if got := bar(tt.bytes, tt.total); got != tt.want {
+			t.Errorf("bar(%d, %d) = %q, want %q", tt.bytes, tt.total, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package progress
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Event represents a progress event in json mode.
+type Event struct {
+	Event     string    `json:"event"` // start, done, progress or summary
+	File      string    `json:"file,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Bytes     int64     `json:"bytes,omitempty"`
+	Total     int64     `json:"total,omitempty"`
+	Files     int       `json:"files,omitempty"`
+	FilesDone int       `json:"filesDone,omitempty"`
+	Errors    int       `json:"errors,omitempty"` // failed attempts, including retried ones
+	Rate      float64   `json:"rate,omitempty"`   // bytes per second
+	ETA       float64   `json:"eta,omitempty"`    // seconds
+	Time      time.Time `json:"time"`
+}
+
+// Transfer tracks the progress of transferring a single file.
+// A nil Transfer tracks nothing.
+type Transfer struct {
+	tracker *Tracker
+	name    string
+	size    int64
+	bytes   int64
+}
+
+// Reader wraps r so that the bytes read are counted.
+func (tr *Transfer) Reader(r io.Reader) io.Reader {
+	if tr == nil {
+		return r
+	}
+	return &reader{r, tr}
+}
+
+// add counts n more transferred bytes.
+func (tr *Transfer) add(n int) {
+	atomic.AddInt64(&tr.bytes, int64(n))
+	tr.tracker.mu.Lock()
+	tr.tracker.bytes += int64(n)
+	tr.tracker.mu.Unlock()
+}
+
+// End ends the transfer. If err is not nil, its transferred bytes are
+// discounted, so that retrying it would not be double counted.
+func (tr *Transfer) End(err error) {
+	if tr == nil {
+		return
+	}
+	t := tr.tracker
+	e := Event{Event: "done", File: tr.name, Size: tr.size}
+	t.mu.Lock()
+	if err != nil {
+		t.bytes -= atomic.LoadInt64(&tr.bytes)
+		if tr.size > 0 {
+			t.total -= tr.size
+		}
+		t.files--
+		t.errors++
+		e.Error = err.Error()
+	} else {
+		t.filesDone++
+		if tr.size <= 0 {
+			// size was unknown
+			t.total += atomic.LoadInt64(&tr.bytes)
+		}
+	}
+	t.mu.Unlock()
+	t.emit(e)
+}
+
+type reader struct {
+	r  io.Reader
+	tr *Transfer
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.tr.add(n)
+	return n, err
+}
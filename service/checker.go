@@ -121,6 +121,18 @@ func CheckUploadMethod(kind, method, ip, port string, skip bool) CheckFn {
 	}
 }
 
+// CheckURLUpload checks if the supplied upload method could be used for
+// importing by urls, which are pulled by the api server directly.
+func CheckURLUpload(method string) CheckFn {
+	return func(logger LogFn) error {
+		if method != "" && strings.ToLower(method) != DirectUploadMethod {
+			logger("Only %q upload is supported for importing by urls!", DirectUploadMethod)
+			return errors.ErrUploadMethodInvalid
+		}
+		return nil
+	}
+}
+
 // CheckDirectUpload checks if direct upload is supported.
 func CheckDirectUpload(verbose bool, logger LogFn) error {
 	if logger == nil {
@@ -232,6 +244,18 @@ func CheckDirOrZip(p string) CheckFn {
 	}
 }
 
+// CheckDirOrArchive checks if the input is a directory, or a zip, tar or
+// gzipped tar archive.
+func CheckDirOrArchive(p string) CheckFn {
+	cd := CheckDir(p)
+	return func(logger LogFn) error {
+		if cd(QuietLog) == nil || file.IsArchive(p) {
+			return nil
+		}
+		return errors.ErrFileNotDirOrArchive
+	}
+}
+
 // CheckDir checks if the file is a directory.
 func CheckDir(d string) CheckFn {
 	return func(logger LogFn) error {
@@ -1,16 +1,15 @@
 package types
 
-// ConvertToImageType converts file extension type to gql image type.
+// ConvertToImageType converts the sniffed content type to gql image type,
+// which is JPEG unless it is png, tiff or webp.
 func ConvertToImageType(filetype string) string {
-	ret := "JPEG"
 	switch filetype {
 	case "image/png":
-		ret = "PNG"
+		return "PNG"
 	case "image/tiff":
-		ret = "TIFF"
-	case "image/jpge":
-	default:
-		ret = "JPEG"
+		return "TIFF"
+	case "image/webp":
+		return "WEBP"
 	}
-	return ret
+	return "JPEG"
 }
@@ -8,4 +8,6 @@ type ProjectImage struct {
 	Grounded bool
 	Name     string
 	Filename string
+	Checksum string
+	Error    []string
 }
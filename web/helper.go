@@ -21,6 +21,12 @@ import (
 // If ip is not provided, non-local ip will be used.
 // If port is not provided, a random port will be used.
 func StartLocalServer(dir, ip, port string, verbose bool) (string, func(), error) {
+	return StartLocalServerWithCache(dir, nil, ip, port, verbose)
+}
+
+// StartLocalServerWithCache is StartLocalServer, which also serves the cache
+// files under CachePrefix if it is not nil.
+func StartLocalServerWithCache(dir string, cache *CacheFiles, ip, port string, verbose bool) (string, func(), error) {
 	var address string
 
 	// use preferred ip if not provided
@@ -34,7 +40,7 @@ func StartLocalServer(dir, ip, port string, verbose bool) (string, func(), error
 		address = ip + ":" + port
 	}
 
-	s := Server{Directory: dir, Address: address}
+	s := Server{Directory: dir, Address: address, Cache: cache}
 	hs, p, err := s.ServeStatic(verbose)
 	if err != nil {
 		return "", nil, err
@@ -1,25 +1,77 @@
 package web
 
 import (
+	"io"
 	"log"
+	"mime"
 	"net"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/jackytck/alti-cli/file"
+	"github.com/jackytck/alti-cli/limit"
 )
 
+// CachePrefix is the url path prefix of the files of `Cache`.
+const CachePrefix = "/.alti-cli-cache/"
+
 // Server represents a local web server.
 // Format of `Address` is `ip:port`, or `ip:` to get random port.
+// `Directory` could also be a zip, tar or gzipped tar, whose members are
+// served at their names, e.g. `/DCIM/0001.JPG`.
+// `Cache` is an optional set of files served under `CachePrefix`, e.g. the
+// downscaled images.
 type Server struct {
 	Directory string
 	Address   string
+	Cache     *CacheFiles
+}
+
+// CacheFiles are the files served under CachePrefix by their names. Only the
+// added files are served, not the other files of their directories.
+type CacheFiles struct {
+	mu    sync.RWMutex
+	paths map[string]string
+}
+
+// NewCacheFiles returns an empty set of cache files.
+func NewCacheFiles() *CacheFiles {
+	return &CacheFiles{paths: make(map[string]string)}
+}
+
+// Add serves the file at p as name.
+func (c *CacheFiles) Add(name, p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths[name] = p
+}
+
+func (c *CacheFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	p, ok := c.paths[strings.TrimPrefix(r.URL.Path, CachePrefix)]
+	c.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, p)
 }
 
 // ServeStatic starts a static server serving the contents of the `directory`
 // over `address`. It returns the http.Server and random port number with error.
 func (s *Server) ServeStatic(verbose bool) (*http.Server, int, error) {
 	fs := http.FileServer(http.Dir(s.Directory))
+	if file.IsArchive(s.Directory) {
+		fs = archiveHandler(s.Directory)
+	}
 	mux := http.NewServeMux()
-	mux.Handle("/", fs)
+	mux.Handle("/", limitHandler(fs))
+	if s.Cache != nil {
+		mux.Handle(CachePrefix, limitHandler(s.Cache))
+	}
 
 	srv := &http.Server{Handler: mux}
 
@@ -50,3 +102,52 @@ func (s *Server) ServeStatic(verbose bool) (*http.Server, int, error) {
 
 	return srv, p, nil
 }
+
+// archiveHandler serves the members of the archive at p.
+func archiveHandler(p string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		vp := file.ArchivePath(p, strings.TrimPrefix(path.Clean(r.URL.Path), "/"))
+		info, err := file.StatPath(vp)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if t := mime.TypeByExtension(path.Ext(vp)); t != "" {
+			w.Header().Set("Content-Type", t)
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodHead {
+			return
+		}
+		f, err := file.OpenPath(vp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		io.Copy(w, f)
+	})
+}
+
+// limitHandler wraps h so that its responses are rate limited by the global
+// upload limiter.
+func limitHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&limitedWriter{w, limit.Writer(w)}, r)
+	})
+}
+
+// limitedWriter is a http.ResponseWriter whose body is rate limited.
+type limitedWriter struct {
+	http.ResponseWriter
+	body io.Writer
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	return lw.body.Write(p)
+}
@@ -3,6 +3,12 @@ package web
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
 	"time"
 )
 
@@ -24,3 +30,35 @@ func ExampleServer_ServeStatic() {
 	// Output:
 	// Done
 }
+
+func TestCacheFiles(t *testing.T) {
+	d, err := ioutil.TempDir("", "alti-cli-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(d, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := NewCacheFiles()
+	c.Add("a.jpg", filepath.Join(d, "a.jpg"))
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{CachePrefix + "a.jpg", http.StatusOK, "a.jpg"},
+		{CachePrefix + "b.jpg", http.StatusNotFound, ""},
+		{CachePrefix, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+}